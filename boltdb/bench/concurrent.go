@@ -0,0 +1,132 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/search"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"log/slog"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loading is a Reader onto a store still being written, where nodes not
+// written yet have no neighbors rather than being an error.
+type loading struct{ r storage.Reader }
+
+func (l loading) Get(key string) ([]string, error) {
+	value, err := l.r.Get(key)
+	if err == storage.ErrNotFound {
+		return nil, nil
+	}
+	return value, err
+}
+
+// timedQuery is a query run during the load and how long it took.
+type timedQuery struct {
+	from, to string
+	took     time.Duration
+}
+
+// LoadSearchTest writes the grid to mybolt like WriteTest while searchers
+// goroutines run A* queries between the nodes written so far, as a store
+// serving queries while the rest of its data is still loading would. Then
+// it runs the same queries again on the loaded store, and reports the
+// latencies of both and how much the load slowed the queries down.
+func LoadSearchTest(ctx context.Context, mybolt *storage.Bolt, size, searchers int, seed int64) (written int, duration time.Duration) {
+	var loaded atomic.Int64
+	done := make(chan struct{})
+	during := make([][]timedQuery, searchers)
+	h := GridHeuristic(size)
+	r := loading{mybolt}
+	var wg sync.WaitGroup
+	for s := 0; s < searchers; s++ {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed + int64(s)))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				n := loaded.Load()
+				if n == 0 {
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				from, to := strconv.Itoa(rnd.Intn(int(n))), strconv.Itoa(rnd.Intn(int(n)))
+				start := time.Now()
+				_, _, err := search.AStar(ctx, r, from, to, h, nil, 0)
+				if run.Done(ctx) {
+					return
+				}
+				if err != nil && !errors.Is(err, search.ErrNoPath) {
+					run.Fatal(err.Error(), "from", from, "to", to)
+				}
+				during[s] = append(during[s], timedQuery{from, to, time.Since(start)})
+			}
+		}(s)
+	}
+
+	start := time.Now()
+	p := run.NewProgress("write bolt while searching", size)
+	for ; written < size && !run.Done(ctx); written++ {
+		key, value := GridKeyValue(written, size)
+		mybolt.Writer(key, value)
+		loaded.Store(int64(written + 1))
+		p.Update(written)
+	}
+	mybolt.Flush()
+	duration = time.Since(start)
+	close(done)
+	wg.Wait()
+	if run.Done(ctx) {
+		return written, duration
+	}
+
+	// the same queries, searcher for searcher, with the load out of the way
+	var before, after []time.Duration
+	var mu sync.Mutex
+	for s := range during {
+		wg.Add(1)
+		go func(queries []timedQuery) {
+			defer wg.Done()
+			took := make([]time.Duration, 0, len(queries))
+			for _, q := range queries {
+				start := time.Now()
+				_, _, err := search.AStar(ctx, mybolt, q.from, q.to, h, nil, 0)
+				if run.Done(ctx) {
+					return
+				}
+				if err != nil {
+					run.Fatal(err.Error(), "from", q.from, "to", q.to)
+				}
+				took = append(took, time.Since(start))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, q := range queries {
+				before = append(before, q.took)
+			}
+			after = append(after, took...)
+		}(during[s])
+	}
+	wg.Wait()
+	if run.Done(ctx) {
+		return written, duration
+	}
+	p50, p99 := run.Percentile(before, 0.5), run.Percentile(before, 0.99)
+	idle50, idle99 := run.Percentile(after, 0.5), run.Percentile(after, 0.99)
+	slog.Info("search during load", "searchers", searchers, "queries", len(before), "p50", p50, "p99", p99,
+		"max", run.Percentile(before, 1))
+	slog.Info("search after load", "queries", len(after), "p50", idle50, "p99", idle99,
+		"max", run.Percentile(after, 1))
+	slog.Info("search during/after load", "p50_ratio", run.Ratio(p50, idle50), "p99_ratio", run.Ratio(p99, idle99))
+	return written, duration
+}
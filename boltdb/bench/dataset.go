@@ -0,0 +1,109 @@
+// Package bench holds the benchmarks run against the storage backends:
+// generated datasets, write, read, scan and search tests.
+package bench
+
+import (
+	"github.com/jogo/goplayground/boltdb/search"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// KeyValue is the i-th key/value of RepeatDataset.
+func KeyValue(i int) (key string, value []string) {
+	key = strconv.Itoa(i)
+	value = make([]string, 5)
+	for i := range value {
+		value[i] = strings.Repeat(key, i)
+	}
+	return key, value
+}
+
+// Datasets
+const (
+	// RepeatDataset is KeyValue, values that look nothing like a graph
+	RepeatDataset = "repeat"
+	// GridDataset is GridKeyValue, a graph A* can be run on
+	GridDataset = "grid"
+	// LoadedDataset marks a db loaded from files, holding whatever keys
+	// the file did
+	LoadedDataset = "load"
+)
+
+// Generate returns the i-th key/value of dataset with size entries.
+// Datasets are pure functions of i and size, so every run and backend
+// loads identical bytes; a randomized one would have to draw from a seed.
+func Generate(dataset string, i, size int) (key string, value []string) {
+	if dataset == GridDataset {
+		return GridKeyValue(i, size)
+	}
+	return KeyValue(i)
+}
+
+// GridWidth is the width of the square grid holding size nodes.
+func GridWidth(size int) int {
+	return int(math.Ceil(math.Sqrt(float64(size))))
+}
+
+// GridKeyValue lays out size nodes row by row on a square grid, each
+// linked to the nodes above, below, left and right of it, like a
+// simplified road network.
+func GridKeyValue(i, size int) (key string, value []string) {
+	w := GridWidth(size)
+	key = strconv.Itoa(i)
+	value = make([]string, 0, 4)
+	x := i % w
+	if x > 0 {
+		value = append(value, strconv.Itoa(i-1))
+	}
+	if x < w-1 && i+1 < size {
+		value = append(value, strconv.Itoa(i+1))
+	}
+	if i >= w {
+		value = append(value, strconv.Itoa(i-w))
+	}
+	if i+w < size {
+		value = append(value, strconv.Itoa(i+w))
+	}
+	return key, value
+}
+
+// GridCoords is the column and row of the i-th GridKeyValue node, its
+// storage.XY position.
+func GridCoords(i, size int) [2]float64 {
+	w := GridWidth(size)
+	return [2]float64{float64(i % w), float64(i / w)}
+}
+
+// GridHeuristic is the manhattan distance between two GridKeyValue
+// nodes, exact on a full grid.
+func GridHeuristic(size int) search.Heuristic {
+	w := GridWidth(size)
+	return func(a, b string) float64 {
+		i, _ := strconv.Atoi(a)
+		j, _ := strconv.Atoi(b)
+		dx, dy := i%w-j%w, i/w-j/w
+		if dx < 0 {
+			dx = -dx
+		}
+		if dy < 0 {
+			dy = -dy
+		}
+		return float64(dx + dy)
+	}
+}
+
+// DatasetBytes is the bytes of the keys and value items of the first n
+// entries of dataset with size entries, what a backend holding them
+// would store before any overhead of its own.
+func DatasetBytes(dataset string, n, size int) int64 {
+	var total int64
+	for i := 0; i < n; i++ {
+		key, value := Generate(dataset, i, size)
+		total += int64(len(key))
+		for _, item := range value {
+			total += int64(len(item))
+		}
+	}
+	return total
+}
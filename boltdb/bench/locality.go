@@ -0,0 +1,86 @@
+package bench
+
+import (
+	"container/list"
+	"context"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/search"
+	"log/slog"
+	"time"
+)
+
+// pageCache stands in for the page cache, an LRU of maxPages pages, so
+// the hit rate of a run of reads depends on nothing but the pages they
+// land on.
+type pageCache struct {
+	maxPages     int
+	lru          *list.List
+	items        map[int]*list.Element
+	hits, misses int
+}
+
+func newPageCache(maxPages int) *pageCache {
+	return &pageCache{maxPages: maxPages, lru: list.New(), items: make(map[int]*list.Element)}
+}
+
+func (c *pageCache) read(page int) {
+	if e, ok := c.items[page]; ok {
+		c.lru.MoveToFront(e)
+		c.hits++
+		return
+	}
+	c.misses++
+	c.items[page] = c.lru.PushFront(page)
+	if c.lru.Len() > c.maxPages {
+		delete(c.items, c.lru.Remove(c.lru.Back()).(int))
+	}
+}
+
+// pageReader reads through to a Reader, reading the page each key is on
+// from a pageCache. A* only sees its Get.
+type pageReader struct {
+	r     search.Reader
+	pages map[string]int
+	cache *pageCache
+	// touched are the pages the query so far read
+	touched map[int]bool
+}
+
+func (p *pageReader) Get(key string) ([]string, error) {
+	if page, ok := p.pages[key]; ok {
+		p.cache.read(page)
+		p.touched[page] = true
+	}
+	return p.r.Get(key)
+}
+
+// LocalityTest runs the queries of pairs against r, whose keys are on
+// the pages of pages, see storage.Bolt.Pages, and reports how often the
+// pages read were already in a page cache of cachePages pages and how
+// many pages each query read, which the order the keys were written in
+// decides. It returns the hit rate.
+func LocalityTest(ctx context.Context, r search.Reader, pages map[string]int, pairs [][2]string, h search.Heuristic, cachePages int, order string) (hitRate float64) {
+	p := &pageReader{r: r, pages: pages, cache: newPageCache(cachePages)}
+	start := time.Now()
+	expanded, touched := 0, 0
+	for _, pair := range pairs {
+		p.touched = make(map[int]bool)
+		_, n, err := search.AStar(ctx, p, pair[0], pair[1], h, nil, 0)
+		if run.Done(ctx) {
+			return 0
+		}
+		if err != nil && err != search.ErrNoPath {
+			run.Fatal(err.Error(), "order", order)
+		}
+		expanded += n
+		touched += len(p.touched)
+	}
+	reads := p.cache.hits + p.cache.misses
+	if reads > 0 {
+		hitRate = float64(p.cache.hits) / float64(reads)
+	}
+	slog.Info("search locality", "order", order, "took", time.Since(start), "queries", len(pairs),
+		"expansions", expanded, "cache_pages", cachePages, "reads", reads, "misses", p.cache.misses,
+		"hit_rate", run.Round(hitRate), "pages_per_query", touched/max(len(pairs), 1))
+	return hitRate
+}
@@ -0,0 +1,155 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/boltdb/bolt"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Edge record layouts compared by PrefixTest
+const (
+	// CompositeKeys is SplitSchema's edges bucket, every record under
+	// node + 0x00 + index, repeating the node in each
+	CompositeKeys = "composite"
+	// NestedKeys keeps a node's records in a bucket of its own named for
+	// the node, keyed by index alone: the shared prefix is stored once, a
+	// one level trie
+	NestedKeys = "nested"
+)
+
+var prefixBucket = []byte("edges")
+
+// PrefixTest writes the edges of dataset to fresh bolt files in each
+// record layout, node keys encoded as keys says, and reports the file
+// sizes and how fast each reads back every edge of reads random nodes.
+func PrefixTest(ctx context.Context, dataset string, size, reads int, keys string, seed int64) {
+	dir, err := os.MkdirTemp("", "prefix-")
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	var base int64
+	for _, layout := range []string{CompositeKeys, NestedKeys} {
+		db, err := bolt.Open(filepath.Join(dir, layout+".db"), 0600, nil)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		db.NoSync = true
+		// only for its key encoding
+		enc := storage.WrapBolt(db, storage.SplitSchema, keys)
+		start := time.Now()
+		for i := 0; i < size && !run.Done(ctx); {
+			err = db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucketIfNotExists(prefixBucket)
+				if err != nil {
+					return err
+				}
+				// bolt only splits the pages it appends to this full
+				b.FillPercent = 1
+				for end := min(size, i+10000); i < end; i++ {
+					_, value := Generate(dataset, i, size)
+					if err := putEdges(b, layout, enc.IntKey(i), value); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				run.Fatal(err.Error())
+			}
+		}
+		if err := db.Sync(); err != nil {
+			run.Fatal(err.Error())
+		}
+		written := time.Since(start)
+		fi, err := os.Stat(db.Path())
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		if base == 0 {
+			base = fi.Size()
+		}
+
+		rnd := rand.New(rand.NewSource(seed))
+		edges := 0
+		start = time.Now()
+		err = db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(prefixBucket)
+			for n := 0; n < reads && !run.Done(ctx); n++ {
+				err := eachEdge(b, layout, enc.IntKey(rnd.Intn(size)), func(v []byte) {
+					edges++
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		took := time.Since(start)
+		db.Close()
+		if run.Done(ctx) {
+			return
+		}
+		slog.Info("prefix layout", "layout", layout, "keys", keys, "bytes", fi.Size(), "size_ratio", run.Round(float64(fi.Size())/float64(base)),
+			"write", written, "read", took, "edges", edges, "reads_per_sec", math.Round(float64(reads)/took.Seconds()))
+	}
+}
+
+// putEdges stores the edges of node k in b laid out as layout says.
+func putEdges(b *bolt.Bucket, layout string, k []byte, edges []string) error {
+	if layout == NestedKeys {
+		nb, err := b.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		nb.FillPercent = 1
+		b = nb
+	}
+	for i, edge := range edges {
+		var ek []byte
+		if layout == CompositeKeys {
+			// bolt holds on to keys until the commit, so a fresh one each
+			ek = make([]byte, len(k)+5)
+			copy(ek, k)
+			binary.BigEndian.PutUint32(ek[len(k)+1:], uint32(i))
+		} else {
+			ek = binary.BigEndian.AppendUint32(nil, uint32(i))
+		}
+		if err := b.Put(ek, []byte(edge)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachEdge calls fn with the edges of node k stored in b as layout says.
+func eachEdge(b *bolt.Bucket, layout string, k []byte, fn func(v []byte)) error {
+	if layout == NestedKeys {
+		nb := b.Bucket(k)
+		if nb == nil {
+			return storage.ErrNotFound
+		}
+		return nb.ForEach(func(_, v []byte) error {
+			fn(v)
+			return nil
+		})
+	}
+	prefix := append(k[:len(k):len(k)], 0)
+	c := b.Cursor()
+	for ek, v := c.Seek(prefix); ek != nil && bytes.HasPrefix(ek, prefix); ek, v = c.Next() {
+		fn(v)
+	}
+	return nil
+}
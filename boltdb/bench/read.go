@@ -0,0 +1,339 @@
+package bench
+
+import (
+	"bufio"
+	"context"
+	"github.com/boltdb/bolt"
+	"github.com/jogo/goplayground/boltdb/codec"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reader is what the random read tests read from
+type Reader interface {
+	Get(key string) ([]string, error)
+}
+
+// ReadTest reads back every key below size in one transaction. Like the
+// other tests here it stops early once ctx is done.
+func ReadTest(ctx context.Context, mybolt *storage.Bolt, size int) (duration time.Duration) {
+	start := time.Now()
+	p := run.NewProgress("read bolt", size)
+	mybolt.Db.View(func(tx *bolt.Tx) error {
+		for i := 0; i < size && !run.Done(ctx); i++ {
+			storedValue, err := mybolt.GetKey(tx, mybolt.IntKey(i))
+			if err != nil {
+				run.Fatal(err.Error())
+			}
+			p.Update(i)
+			if i == 1 {
+				slog.Debug("stored value", "key", i, "value", storedValue)
+			}
+		}
+		return nil
+	})
+	return time.Since(start)
+}
+
+// PooledReadTest is ReadTest reusing one key buffer and pooled value
+// slices instead of allocating them for every key.
+func PooledReadTest(ctx context.Context, mybolt *storage.Bolt, size int) (duration time.Duration) {
+	start := time.Now()
+	p := run.NewProgress("pooled read bolt", size)
+	mybolt.Db.View(func(tx *bolt.Tx) error {
+		var k []byte
+		for i := 0; i < size && !run.Done(ctx); i++ {
+			p.Update(i)
+			k = mybolt.AppendIntKey(k[:0], i)
+			dst := storage.ValuePool.Get().(*[]string)
+			value, err := mybolt.GetKeyInto(tx, k, *dst)
+			if err != nil {
+				run.Fatal(err.Error())
+			}
+			*dst = value
+			storage.ValuePool.Put(dst)
+		}
+		return nil
+	})
+	return time.Since(start)
+}
+
+// GetTest reads every key below size through Get, each in its own
+// transaction as a search does, or through GetInto reusing one slice.
+func GetTest(ctx context.Context, r storage.IntoReader, size int, into bool) (duration time.Duration) {
+	start := time.Now()
+	name := "get bolt"
+	if into {
+		name = "get into bolt"
+	}
+	p := run.NewProgress(name, size)
+	var value []string
+	var err error
+	for i := 0; i < size && !run.Done(ctx); i++ {
+		p.Update(i)
+		if into {
+			err = r.GetInto(strconv.Itoa(i), &value)
+		} else {
+			value, err = r.Get(strconv.Itoa(i))
+		}
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+	}
+	return time.Since(start)
+}
+
+// ZeroCopyReadTest reads every key below size through View. With a
+// codec.Ranger the values are walked in place instead of being decoded.
+func ZeroCopyReadTest(ctx context.Context, mybolt *storage.Bolt, size int) (duration time.Duration) {
+	raw, inPlace := mybolt.Codec.(codec.Ranger)
+	start := time.Now()
+	p := run.NewProgress("zero-copy read bolt", size)
+	err := mybolt.View(func(v *storage.RawView) error {
+		var k []byte
+		items := 0
+		count := func(item []byte) bool {
+			items++
+			return true
+		}
+		for i := 0; i < size && !run.Done(ctx); i++ {
+			p.Update(i)
+			k = mybolt.AppendIntKey(k[:0], i)
+			data, err := v.GetKey(k)
+			if err != nil {
+				return err
+			}
+			if !inPlace {
+				value, err := mybolt.Codec.Unmarshal(data)
+				if err != nil {
+					return err
+				}
+				items += len(value)
+				continue
+			}
+			err = raw.Range(data, count)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	return time.Since(start)
+}
+
+// Mallocs returns the number of heap allocations made while running f.
+func Mallocs(f func()) uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	before := m.Mallocs
+	f()
+	runtime.ReadMemStats(&m)
+	return m.Mallocs - before
+}
+
+// HeapBytes returns how much the live heap grew by while running f,
+// after collecting before and after. What f allocates and drops doesn't
+// count, what it leaves reachable does.
+func HeapBytes(f func()) int64 {
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	before := m.HeapAlloc
+	f()
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	return int64(m.HeapAlloc) - int64(before)
+}
+
+// InternTest holds every value below size in memory, as the in-memory
+// tier would, once as decoded and once interned, and logs the heap each
+// takes and what interning saved. The interning table counts against it.
+func InternTest(ctx context.Context, mybolt *storage.Bolt, size int) {
+	hold := func() int64 {
+		var values [][]string
+		heap := HeapBytes(func() {
+			values = make([][]string, 0, size)
+			for i := 0; i < size && !run.Done(ctx); i++ {
+				value, err := mybolt.Get(strconv.Itoa(i))
+				if err != nil {
+					run.Fatal(err.Error())
+				}
+				values = append(values, value)
+			}
+		})
+		runtime.KeepAlive(values)
+		return heap
+	}
+	old := mybolt.Interner
+	defer func() { mybolt.Interner = old }()
+	mybolt.Interner = nil
+	plain := hold()
+	in := codec.NewInterner()
+	mybolt.Interner = in
+	interned := hold()
+	if run.Done(ctx) {
+		return
+	}
+	distinct, hits, _ := in.Stats()
+	slog.Info("interned values", "plain_heap", plain, "interned_heap", interned, "saved", plain-interned,
+		"saved_pct", run.Round(100*float64(plain-interned)/float64(plain)), "distinct", distinct, "hits", hits)
+}
+
+// ScanTest reads every key with a cursor instead of point Gets.
+func ScanTest(ctx context.Context, mybolt *storage.Bolt) (n int, duration time.Duration) {
+	start := time.Now()
+	p := run.NewProgress("scan bolt", 0)
+	err := mybolt.Db.View(func(tx *bolt.Tx) error {
+		return mybolt.Scan(tx, func(k []byte, value []string) error {
+			if run.Done(ctx) {
+				return ctx.Err()
+			}
+			n++
+			p.Update(n)
+			return nil
+		})
+	})
+	if err != nil && !run.Done(ctx) {
+		run.Fatal(err.Error())
+	}
+	return n, time.Since(start)
+}
+
+// Warm touches fraction of the db before the read tests, either by
+// walking that fraction of every bucket with a cursor (by "scan") or by
+// reading that fraction of the hot keys (by "hot", see HotKeys), so the
+// tests run against a known warm page cache instead of whatever the
+// previous run left behind.
+func Warm(ctx context.Context, mybolt *storage.Bolt, size int, by string, fraction float64, hotKeysPath string) {
+	start := time.Now()
+	keys, touched := 0, 0
+	switch by {
+	case "scan":
+		var err error
+		keys, touched, err = mybolt.Touch(fraction)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+	case "hot":
+		hot := HotKeys(hotKeysPath, size)
+		for _, key := range hot[:int(fraction*float64(len(hot)))] {
+			if run.Done(ctx) {
+				break
+			}
+			value, err := mybolt.Get(key)
+			if err != nil {
+				run.Fatal(err.Error())
+			}
+			keys++
+			for _, s := range value {
+				touched += len(s)
+			}
+		}
+	default:
+		run.Fatal("unknown way to warm", "by", by)
+	}
+	slog.Info("warm", "by", by, "fraction", fraction, "took", time.Since(start),
+		"keys", keys, "bytes", touched)
+}
+
+// HotKeys returns the keys listed in the file at path, one per line, or
+// for no path every key in order of popularity under RandomReadTest.
+func HotKeys(path string, size int) []string {
+	if path == "" {
+		keys := make([]string, size)
+		for i := range keys {
+			keys[i] = strconv.Itoa(i)
+		}
+		return keys
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	defer f.Close()
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			keys = append(keys, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		run.Fatal(err.Error())
+	}
+	return keys
+}
+
+// RandomReadTest issues reads Gets of keys below size, skewed towards low
+// keys like the hot nodes of a search workload.
+func RandomReadTest(ctx context.Context, r Reader, size, reads int, seed int64) (duration time.Duration) {
+	start := time.Now()
+	RandomReads(ctx, r, size, reads, seed)
+	return time.Since(start)
+}
+
+func RandomReads(ctx context.Context, r Reader, size, reads int, seed int64) {
+	zipf := rand.NewZipf(rand.New(rand.NewSource(seed)), 1.1, 1, uint64(size-1))
+	for i := 0; i < reads && !run.Done(ctx); i++ {
+		_, err := r.Get(strconv.FormatUint(zipf.Uint64(), 10))
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+	}
+}
+
+// ParallelReadTest splits reads random Gets over k goroutines sharing r.
+func ParallelReadTest(ctx context.Context, r Reader, size, reads, k int, seed int64) (duration time.Duration) {
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < k; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			n := reads / k
+			if w < reads%k {
+				n++
+			}
+			RandomReads(ctx, r, size, n, seed+int64(w))
+		}(w)
+	}
+	wg.Wait()
+	return time.Since(start)
+}
+
+// ReaderScaling runs ParallelReadTest for each reader count in the comma
+// separated list counts and prints aggregate throughput.
+func ReaderScaling(ctx context.Context, r Reader, size int, counts string, seed int64) {
+	if counts == "" {
+		return
+	}
+	var base float64
+	for _, field := range strings.Split(counts, ",") {
+		k, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || k < 1 {
+			run.Fatal("invalid reader count", "readers", field)
+		}
+		d := ParallelReadTest(ctx, r, size, size, k, seed)
+		if run.Done(ctx) {
+			return
+		}
+		rate := float64(size) / d.Seconds()
+		if base == 0 {
+			base = rate
+		}
+		slog.Info("parallel read bolt", "readers", k, "took", d,
+			"gets_per_sec", math.Round(rate), "speedup", run.Round(rate/base))
+	}
+}
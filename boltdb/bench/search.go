@@ -0,0 +1,358 @@
+package bench
+
+import (
+	"context"
+	"github.com/jogo/goplayground/boltdb/cache"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/search"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SearchOptions configures SearchTest.
+type SearchOptions struct {
+	Dataset string
+	// Seed draws the queries and the sketch's hashes, not the dataset,
+	// which only depends on its size, see Generate
+	Seed int64
+	// CacheBytes is the size of the cache in front of bolt
+	CacheBytes int
+	// PrefetchDepth open set entries are prefetched by PrefetchWorkers
+	// goroutines after each expansion
+	PrefetchDepth   int
+	PrefetchWorkers int
+	// PathCache, if set, also runs the queries again skewed towards a
+	// few hot routes through a cache.Paths of that many paths
+	PathCache int
+	// Search, if not astar, is also run against bolt, see search.Parse
+	Search   string
+	MaxNodes int
+	// Spill, if set, also runs A* keeping only that many closed nodes in
+	// memory and the rest in a temporary file
+	Spill int
+	// CheckHeuristic, if set, compares the heuristic to the true
+	// distances along that many of the queries' paths first
+	CheckHeuristic int
+	// Layouts, if set, also runs the queries against the grid written
+	// to fresh flat and split files, see LayoutSearchTest
+	Layouts bool
+	// TopKeys, if set, counts the keys the cached pass reads, which it
+	// slows a little, and returns that many of the most read
+	TopKeys int
+}
+
+// SearchTest runs the same random queries against r directly, through a
+// cache and through a cache with a prefetcher, and then repeated routes
+// through a path cache. A Recorder records the queries once. It returns
+// the opts.TopKeys keys read most, see cache.Sketch.
+func SearchTest(ctx context.Context, r storage.Reader, size, queries int, opts SearchOptions) (hot []cache.HotKey) {
+	if opts.Dataset != GridDataset {
+		slog.Info("search test skipped, it needs the grid dataset")
+		return
+	}
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	pairs := make([][2]string, queries)
+	for i := range pairs {
+		pairs[i][0] = strconv.Itoa(rnd.Intn(size))
+		pairs[i][1] = strconv.Itoa(rnd.Intn(size))
+	}
+	if rec, ok := r.(*Recorder); ok {
+		// the queries, not the reads of each pass over them
+		for _, pair := range pairs {
+			rec.record(OpSearch, nil, pair[0], pair[1])
+		}
+		r = rec.Reader
+	}
+	h := GridHeuristic(size)
+	if opts.CheckHeuristic > 0 {
+		HeuristicTest(ctx, r, pairs[:min(opts.CheckHeuristic, len(pairs))], h)
+		if run.Done(ctx) {
+			return
+		}
+	}
+	query := func(via search.Reader, prefetch func(string)) (time.Duration, int) {
+		start := time.Now()
+		total := 0
+		for _, pair := range pairs {
+			_, expanded, err := search.AStar(ctx, via, pair[0], pair[1], h, prefetch, opts.PrefetchDepth)
+			if run.Done(ctx) {
+				break
+			}
+			if err != nil {
+				run.Fatal(err.Error())
+			}
+			total += expanded
+		}
+		return time.Since(start), total
+	}
+
+	stop := run.Watch(time.Millisecond)
+	d, expanded := query(r, nil)
+	usage := stop()
+	if run.Done(ctx) {
+		return
+	}
+	slog.Info("search bolt", "took", d, "queries", queries, "expansions", expanded, "peak_heap", usage.PeakHeap)
+
+	if l, ok := r.(search.Lister); ok {
+		if _, ok := r.(storage.TxnReader); ok {
+			// the same reads, each in a transaction of its own
+			perCall, _ := query(lister{l}, nil)
+			if run.Done(ctx) {
+				return
+			}
+			slog.Info("search bolt per-read txn", "took", perCall, "ratio", run.Ratio(perCall, d))
+		}
+	}
+
+	if opts.Layouts {
+		LayoutSearchTest(ctx, size, pairs, h)
+		if run.Done(ctx) {
+			return
+		}
+	}
+	if opts.Search != "" && opts.Search != "astar" {
+		BoundedSearchTest(ctx, r, pairs, h, opts)
+		if run.Done(ctx) {
+			return
+		}
+	}
+	if opts.Spill > 0 {
+		SpillTest(ctx, r, pairs, h, opts, d)
+		if run.Done(ctx) {
+			return
+		}
+	}
+
+	cached := cache.Wrap(r, opts.CacheBytes)
+	var via search.Reader = cached
+	var sketch *cache.Sketch
+	if opts.TopKeys > 0 {
+		// what the searches ask the cache for, the keys it should hold
+		sketch = cache.NewSketch(opts.TopKeys, opts.Seed)
+		via = cache.Count(cached, sketch)
+	}
+	d, _ = query(via, nil)
+	if run.Done(ctx) {
+		return
+	}
+	hits, misses := cached.Stats()
+	slog.Info("search cached bolt", "took", d, "hits", hits, "misses", misses)
+	if sketch != nil {
+		hot = sketch.Top()
+		logHotKeys(hot, sketch.Total())
+	}
+
+	cached = cache.Wrap(r, opts.CacheBytes)
+	prefetcher := cache.NewPrefetcher(cached, opts.PrefetchWorkers, 4*opts.PrefetchDepth)
+	d, _ = query(cached, prefetcher.Prefetch)
+	prefetcher.Close()
+	if run.Done(ctx) {
+		return
+	}
+	hits, misses = cached.Stats()
+	slog.Info("search prefetched bolt", "took", d, "hits", hits, "misses", misses,
+		"prefetched", cached.Prefetches(), "dropped", prefetcher.Dropped())
+
+	if opts.PathCache > 0 {
+		PathCacheTest(ctx, r, pairs, h, opts)
+	}
+	return hot
+}
+
+// logHotKeys reports the most read keys and the share of the reads they
+// took, what a cache holding just them would hit at best.
+func logHotKeys(hot []cache.HotKey, total uint64) {
+	var top uint64
+	keys := make([]string, len(hot))
+	for i, k := range hot {
+		top += k.Reads
+		keys[i] = k.Key + ":" + strconv.FormatUint(k.Reads, 10)
+	}
+	if total == 0 {
+		return
+	}
+	slog.Info("search hot keys", "top", len(hot), "reads", total,
+		"top_pct", run.Round(100*float64(min(top, total))/float64(total)), "keys", strings.Join(keys, " "))
+}
+
+// BoundedSearchTest runs the queries of pairs with opts.Search, which
+// trades expansions for memory, and reports what it took of both.
+func BoundedSearchTest(ctx context.Context, r search.Reader, pairs [][2]string, h search.Heuristic, opts SearchOptions) {
+	find, err := search.Parse(opts.Search, opts.MaxNodes)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	stop := run.Watch(time.Millisecond)
+	start := time.Now()
+	total := 0
+	for _, pair := range pairs {
+		_, expanded, err := find(ctx, r, pair[0], pair[1], h)
+		if run.Done(ctx) {
+			return
+		}
+		if err != nil {
+			run.Fatal(err.Error(), "search", opts.Search, "from", pair[0], "to", pair[1])
+		}
+		total += expanded
+	}
+	usage := stop()
+	slog.Info("search bolt bounded", "search", opts.Search, "max_nodes", opts.MaxNodes, "took", time.Since(start),
+		"expansions", total, "peak_heap", usage.PeakHeap)
+}
+
+// getter hides a Reader's EachItem, so A* reads whole adjacency lists
+// with Get, through one transaction for the whole search as it would
+// otherwise.
+type getter struct{ search.Reader }
+
+// Begin begins a transaction of the Reader, hiding its EachItem too.
+func (g getter) Begin() (storage.ReadTxn, error) {
+	txn, err := storage.Begin(g.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return getterTxn{txn}, nil
+}
+
+type getterTxn struct{ storage.ReadTxn }
+
+// lister hides a Lister's Begin, so A* reads each node's neighbors in a
+// transaction of their own rather than one for the whole search.
+type lister struct{ search.Lister }
+
+// LayoutSearchTest writes the grid to fresh flat and split bolt files
+// and runs the queries of pairs against each, reading neighbors with a
+// point Get of the whole list and, from split, also streaming them from
+// a range scan over the node's edge keys, see storage.Bolt.EachItem.
+// Either way each search reads through one transaction.
+func LayoutSearchTest(ctx context.Context, size int, pairs [][2]string, h search.Heuristic) {
+	dir, err := os.MkdirTemp("", "layouts-")
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	for _, schema := range []string{storage.FlatSchema, storage.SplitSchema} {
+		mybolt := storage.NewBolt(schema, storage.Uint64Keys, storage.WithPath(filepath.Join(dir, schema+".db")))
+		written, _ := WriteTest(ctx, schema, mybolt, GridDataset, size)
+		if written < size {
+			mybolt.Close()
+			return
+		}
+		reads := map[string]search.Reader{"get": getter{mybolt}}
+		if schema == storage.SplitSchema {
+			reads["scan"] = mybolt
+		}
+		for _, read := range []string{"get", "scan"} {
+			r, ok := reads[read]
+			if !ok {
+				continue
+			}
+			start := time.Now()
+			total := 0
+			for _, pair := range pairs {
+				_, expanded, err := search.AStar(ctx, r, pair[0], pair[1], h, nil, 0)
+				if run.Done(ctx) {
+					mybolt.Close()
+					return
+				}
+				if err != nil {
+					run.Fatal(err.Error(), "schema", schema, "read", read)
+				}
+				total += expanded
+			}
+			d := time.Since(start)
+			slog.Info("search layout", "schema", schema, "read", read, "took", d, "expansions", total,
+				"per_sec", math.Round(float64(total)/d.Seconds()))
+		}
+		mybolt.Close()
+	}
+}
+
+// HeuristicTest reports how h's estimates compare to the true distances
+// left along the shortest paths of pairs, warning if it overestimates,
+// when A*'s paths can't be trusted to be shortest.
+func HeuristicTest(ctx context.Context, r search.Reader, pairs [][2]string, h search.Heuristic) {
+	start := time.Now()
+	a, err := search.CheckHeuristic(ctx, r, pairs, h)
+	if run.Done(ctx) {
+		return
+	}
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	slog.Info("heuristic", "took", time.Since(start), "queries", a.Queries, "nodes", a.Nodes,
+		"mean_ratio", run.Round(a.MeanRatio), "min_ratio", run.Round(a.MinRatio))
+	if a.Over > 0 {
+		slog.Warn("heuristic overestimates, A* paths may not be shortest", "over", a.Over, "nodes", a.Nodes,
+			"max_over", a.MaxOver)
+	}
+}
+
+// SpillFind is A* spilling its closed set past front nodes to a file in
+// dir, see storage.Spill. spilled, if set, is called with the nodes each
+// search spilled.
+func SpillFind(dir string, front int, spilled func(n int)) search.Func {
+	return func(ctx context.Context, r search.Reader, from, to string, h search.Heuristic) ([]string, int, error) {
+		closed := storage.NewSpill(dir, front)
+		defer closed.Close()
+		path, expanded, err := search.AStarClosed(ctx, r, from, to, h, closed)
+		if spilled != nil {
+			spilled(closed.Spilled())
+		}
+		return path, expanded, err
+	}
+}
+
+// SpillTest runs the queries of pairs with A* spilling its closed set
+// and reports how much slower that is than plain, the time the same
+// queries took in memory.
+func SpillTest(ctx context.Context, r search.Reader, pairs [][2]string, h search.Heuristic, opts SearchOptions, plain time.Duration) {
+	total := 0
+	find := SpillFind("", opts.Spill, func(n int) { total += n })
+	stop := run.Watch(time.Millisecond)
+	start := time.Now()
+	for _, pair := range pairs {
+		_, _, err := find(ctx, r, pair[0], pair[1], h)
+		if run.Done(ctx) {
+			return
+		}
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+	}
+	d := time.Since(start)
+	usage := stop()
+	slog.Info("search bolt spilled", "front", opts.Spill, "took", d, "spilled", total,
+		"slowdown", run.Ratio(d, plain), "peak_heap", usage.PeakHeap)
+}
+
+// PathCacheTest draws len(pairs) queries from pairs, Zipf distributed
+// like the repeated routes of a real workload, and answers them through a
+// cache.Paths in front of r.
+func PathCacheTest(ctx context.Context, r search.Reader, pairs [][2]string, h search.Heuristic, opts SearchOptions) {
+	paths := cache.NewPaths(opts.PathCache)
+	find := paths.Wrap(nil)
+	zipf := rand.NewZipf(rand.New(rand.NewSource(opts.Seed)), 1.1, 1, uint64(len(pairs)-1))
+	start := time.Now()
+	for i := 0; i < len(pairs) && !run.Done(ctx); i++ {
+		pair := pairs[zipf.Uint64()]
+		_, _, err := find(ctx, r, pair[0], pair[1], h)
+		if run.Done(ctx) {
+			return
+		}
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+	}
+	hits, misses := paths.Stats()
+	slog.Info("search path cache", "took", time.Since(start), "hits", hits, "misses", misses,
+		"hit_rate", float64(hits)/float64(hits+misses), "paths", paths.Len())
+}
@@ -0,0 +1,356 @@
+package bench
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/search"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"hash"
+	"hash/fnv"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// The operations of a workload log
+const (
+	OpPut    = 'p'
+	OpGet    = 'g'
+	OpDelete = 'd'
+	OpFlush  = 'f'
+	OpSearch = 's'
+)
+
+// workloadMagic starts every workload log, followed by the dataset name
+// and size the workload ran against, which replay needs for the grid
+// heuristic.
+const workloadMagic = "bdbw1"
+
+// Recorder is a storage.Reader appending every operation issued through
+// it to a workload log before passing it on, so Replay can issue exactly
+// the same stream against another backend or build. Each operation is
+// its kind and its strings, each length prefixed with a uvarint, a put's
+// value preceded by its length.
+//
+// Writes go to the wrapped reader, which must then also be a storage.DB.
+// Searches are recorded by Find, as one operation rather than the Gets
+// they make. Safe for concurrent use if the wrapped reader is.
+type Recorder struct {
+	storage.Reader
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	buf []byte
+	ops int
+	err error
+}
+
+// NewRecorder starts the workload log at path for operations on r, a
+// dataset of size keys. An existing log is appended to, so that a load
+// and the searches run after it replay as one stream.
+func NewRecorder(r storage.Reader, path, dataset string, size int) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	rec := &Recorder{Reader: r, f: f, w: bufio.NewWriter(f)}
+	fi, err := f.Stat()
+	if err == nil && fi.Size() > 0 {
+		magic := make([]byte, len(workloadMagic))
+		_, err = f.ReadAt(magic, 0)
+		if err == nil && string(magic) != workloadMagic {
+			err = fmt.Errorf("%s: not a workload log", path)
+		}
+	} else if err == nil {
+		rec.buf = append(rec.buf, workloadMagic...)
+		rec.buf = appendWorkloadString(rec.buf, dataset)
+		rec.buf = binary.AppendUvarint(rec.buf, uint64(size))
+		_, err = rec.w.Write(rec.buf)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return rec, nil
+}
+
+func appendWorkloadString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// record appends one operation, a put's value being nil for the others.
+// Writers can't report errors, the first one is kept for Close.
+func (rec *Recorder) record(op byte, value []string, strs ...string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.buf = append(rec.buf[:0], op)
+	for _, s := range strs {
+		rec.buf = appendWorkloadString(rec.buf, s)
+	}
+	if op == OpPut {
+		rec.buf = binary.AppendUvarint(rec.buf, uint64(len(value)))
+		for _, s := range value {
+			rec.buf = appendWorkloadString(rec.buf, s)
+		}
+	}
+	_, err := rec.w.Write(rec.buf)
+	if err != nil && rec.err == nil {
+		rec.err = err
+	}
+	rec.ops++
+}
+
+func (rec *Recorder) Get(key string) ([]string, error) {
+	rec.record(OpGet, nil, key)
+	return rec.Reader.Get(key)
+}
+
+func (rec *Recorder) Writer(key string, value []string) {
+	rec.record(OpPut, value, key)
+	rec.Reader.(storage.DB).Writer(key, value)
+}
+
+func (rec *Recorder) Delete(key string) {
+	rec.record(OpDelete, nil, key)
+	rec.Reader.(storage.Store).Delete(key)
+}
+
+func (rec *Recorder) Flush() {
+	rec.record(OpFlush, nil)
+	rec.Reader.(storage.DB).Flush()
+}
+
+// Find returns find recording each search it runs. Given the Recorder
+// itself as the reader, the search reads the wrapped reader instead, so
+// its Gets aren't recorded.
+func (rec *Recorder) Find(find search.Func) search.Func {
+	return func(ctx context.Context, r search.Reader, from, to string, h search.Heuristic) ([]string, int, error) {
+		rec.record(OpSearch, nil, from, to)
+		if r == search.Reader(rec) {
+			r = rec.Reader
+		}
+		return find(ctx, r, from, to, h)
+	}
+}
+
+// Ops is the number of operations recorded so far.
+func (rec *Recorder) Ops() int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.ops
+}
+
+// Close writes out the log, the wrapped reader stays open.
+func (rec *Recorder) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	err := rec.w.Flush()
+	if rec.err == nil {
+		rec.err = err
+	}
+	err = rec.f.Close()
+	if rec.err == nil {
+		rec.err = err
+	}
+	return rec.err
+}
+
+// OpStats are the operations of one kind a replay issued.
+type OpStats struct {
+	Count int
+	Took  time.Duration
+	// Misses are Gets of missing keys and searches that found no path
+	Misses int
+	// Errors are the operations failed by storage.ErrInjected, which
+	// the replay carries on past
+	Errors int
+}
+
+// Replayed is what Replay did.
+type Replayed struct {
+	Dataset                                string
+	Size                                   int
+	Puts, Gets, Deletes, Flushes, Searches OpStats
+	// Expanded is the number of nodes the searches expanded
+	Expanded int
+	// Skipped is the number of writes skipped for want of a store
+	Skipped int
+	// Digest hashes what the Gets and searches returned, two replays of
+	// a log that got the same answers have the same digest
+	Digest uint64
+}
+
+var errWorkloadCorrupt = errors.New("corrupt workload log")
+
+// Replay issues the operations logged at path by a Recorder, in order
+// and one at a time: the Gets and searches, with find, to r and the
+// writes to w. A nil w skips the writes, for a db already holding what
+// they wrote. A torn operation at the end of the log, as a killed
+// recording leaves, ends the replay. So does ctx being done. Searches
+// estimate with h unless the log is of the grid, which has a heuristic
+// of its own, and a nil h makes them Dijkstra's.
+func Replay(ctx context.Context, path string, r storage.Reader, w storage.Store, find search.Func, h search.Heuristic) (Replayed, error) {
+	var replayed Replayed
+	f, err := os.Open(path)
+	if err != nil {
+		return replayed, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	magic := make([]byte, len(workloadMagic))
+	_, err = io.ReadFull(br, magic)
+	if err != nil || string(magic) != workloadMagic {
+		return replayed, fmt.Errorf("%s: not a workload log", path)
+	}
+	replayed.Dataset, err = readWorkloadString(br)
+	if err != nil {
+		return replayed, err
+	}
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return replayed, err
+	}
+	replayed.Size = int(size)
+	if replayed.Dataset == GridDataset {
+		h = GridHeuristic(replayed.Size)
+	} else if h == nil {
+		// without a better estimate A* is Dijkstra
+		h = func(a, b string) float64 { return 0 }
+	}
+	digest := fnv.New64a()
+
+	for !run.Done(ctx) {
+		op, args, value, err := readOp(br)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return replayed, err
+		}
+		if w == nil && (op == OpPut || op == OpDelete || op == OpFlush) {
+			replayed.Skipped++
+			continue
+		}
+
+		start := time.Now()
+		var stats *OpStats
+		switch op {
+		case OpPut:
+			stats = &replayed.Puts
+			w.Writer(args[0], value)
+		case OpDelete:
+			stats = &replayed.Deletes
+			w.Delete(args[0])
+		case OpFlush:
+			stats = &replayed.Flushes
+			w.Flush()
+		case OpGet:
+			stats = &replayed.Gets
+			value, err := r.Get(args[0])
+			if errors.Is(err, storage.ErrNotFound) {
+				stats.Misses++
+			} else if errors.Is(err, storage.ErrInjected) {
+				stats.Errors++
+			} else if err != nil {
+				return replayed, fmt.Errorf("get %s: %w", args[0], err)
+			}
+			digestStrings(digest, args[0], value)
+		case OpSearch:
+			stats = &replayed.Searches
+			path, expanded, err := find(ctx, r, args[0], args[1], h)
+			if errors.Is(err, search.ErrNoPath) || errors.Is(err, storage.ErrNotFound) {
+				stats.Misses++
+			} else if errors.Is(err, storage.ErrInjected) {
+				stats.Errors++
+			} else if err != nil && !run.Done(ctx) {
+				return replayed, fmt.Errorf("search %s to %s: %w", args[0], args[1], err)
+			}
+			replayed.Expanded += expanded
+			digestStrings(digest, args[0]+"\x00"+args[1], path)
+		}
+		stats.Took += time.Since(start)
+		stats.Count++
+	}
+	replayed.Digest = digest.Sum64()
+	return replayed, nil
+}
+
+// readOp reads the next operation off the log: its kind, its one or two
+// strings and a put's value. The error is io.EOF at the end of the log
+// and io.ErrUnexpectedEOF for an operation cut short there, as a killed
+// recording leaves.
+func readOp(br *bufio.Reader) (op byte, args [2]string, value []string, err error) {
+	op, err = br.ReadByte()
+	if err != nil {
+		return op, args, nil, err
+	}
+	strs := 1
+	switch op {
+	case OpFlush:
+		strs = 0
+	case OpSearch:
+		strs = 2
+	case OpPut, OpGet, OpDelete:
+	default:
+		return op, args, nil, fmt.Errorf("%w: operation %q", errWorkloadCorrupt, op)
+	}
+	for i := 0; i < strs && err == nil; i++ {
+		args[i], err = readWorkloadString(br)
+	}
+	if op == OpPut && err == nil {
+		value, err = readWorkloadValue(br)
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return op, args, value, err
+}
+
+func readWorkloadString(br *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return "", err
+	}
+	if n > 1<<20 {
+		return "", errWorkloadCorrupt
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(br, b)
+	return string(b), err
+}
+
+func readWorkloadValue(br *bufio.Reader) ([]string, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if n > 1<<20 {
+		return nil, errWorkloadCorrupt
+	}
+	value := make([]string, n)
+	for i := range value {
+		value[i], err = readWorkloadString(br)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// digestStrings adds key and what it returned to the digest, each
+// string zero terminated.
+func digestStrings(h hash.Hash64, key string, value []string) {
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	for _, s := range value {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	h.Write([]byte{0})
+}
@@ -0,0 +1,232 @@
+package bench
+
+import (
+	"context"
+	"github.com/jogo/goplayground/boltdb/codec"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+// WriteTest writes size generated key/values to myDb, stopping early
+// once ctx is done. Whatever was written, including a partial batch, is
+// flushed either way.
+func WriteTest(ctx context.Context, name string, myDb storage.DB, dataset string, size int) (written int, duration time.Duration) {
+	start := time.Now()
+	p := run.NewProgress("write "+name, size)
+	var key string
+	var value []string
+	for ; written < size && !run.Done(ctx); written++ {
+		key, value = Generate(dataset, written, size)
+		myDb.Writer(key, value)
+		p.Update(written)
+	}
+	myDb.Flush()
+	return written, time.Since(start)
+}
+
+// PipelineWriteTest is WriteTest for bolt split into stages connected by
+// channels: generating key/values, encoding them and committing batches.
+// The first two run on several goroutines each, so encoding overlaps
+// with the commits, of which bolt only allows one at a time.
+func PipelineWriteTest(ctx context.Context, mybolt *storage.Bolt, dataset string, size, parseWorkers, encodeWorkers int) (written int, duration time.Duration) {
+	type record struct {
+		key   string
+		value []string
+	}
+	start := time.Now()
+	records := make(chan record, mybolt.BatchSize)
+	encoded := make(chan storage.Entry, mybolt.BatchSize)
+
+	var parsers sync.WaitGroup
+	for w := 0; w < parseWorkers; w++ {
+		parsers.Add(1)
+		go func(w int) {
+			defer parsers.Done()
+			for i := w; i < size && !run.Done(ctx); i += parseWorkers {
+				key, value := Generate(dataset, i, size)
+				records <- record{key, value}
+			}
+		}(w)
+	}
+	go func() {
+		parsers.Wait()
+		close(records)
+	}()
+
+	var encoders sync.WaitGroup
+	for w := 0; w < encodeWorkers; w++ {
+		encoders.Add(1)
+		go func() {
+			defer encoders.Done()
+			for record := range records {
+				entry, err := mybolt.Encode(record.key, record.value)
+				if err != nil {
+					run.Fatal(err.Error())
+				}
+				encoded <- entry
+			}
+		}()
+	}
+	go func() {
+		encoders.Wait()
+		close(encoded)
+	}()
+
+	// once ctx is done the parsers stop, and whatever is already in
+	// flight drains into the last batch
+	p := run.NewProgress("pipelined write bolt", size)
+	batch := make([]storage.Entry, 0, mybolt.BatchSize)
+	for entry := range encoded {
+		batch = append(batch, entry)
+		written++
+		p.Update(written)
+		if len(batch) >= mybolt.BatchSize {
+			mybolt.Commit(batch)
+			batch = batch[:0]
+		}
+	}
+	mybolt.Commit(batch)
+	return written, time.Since(start)
+}
+
+// ChecksumOverhead times encoding and decoding every value of the
+// dataset with c's inner codec and with c itself.
+func ChecksumOverhead(ctx context.Context, c codec.Codec, dataset string, size int) {
+	checksum, ok := c.(interface{ Unwrap() codec.Codec })
+	if !ok {
+		return
+	}
+	inner := checksum.Unwrap()
+	roundTrip := func(c codec.Codec) time.Duration {
+		start := time.Now()
+		for i := 0; i < size && !run.Done(ctx); i++ {
+			_, value := Generate(dataset, i, size)
+			data, err := c.Marshal(value)
+			if err != nil {
+				run.Fatal(err.Error())
+			}
+			_, err = c.Unmarshal(data)
+			if err != nil {
+				run.Fatal(err.Error())
+			}
+		}
+		return time.Since(start)
+	}
+	plain, checked := roundTrip(inner), roundTrip(c)
+	if run.Done(ctx) {
+		return
+	}
+	slog.Info("codec round trip", "plain", plain, "checksummed", checked,
+		"overhead_pct", run.Round(100*(float64(checked)/float64(plain)-1)))
+}
+
+// AllocTest round trips every value of the dataset through c with each
+// storage.Alloc, the codec allocating, pooled encode buffers and decoded
+// slices, and decoding into an arena, and logs allocations per value and
+// values per second, and the speedup over the default.
+func AllocTest(ctx context.Context, c codec.Codec, dataset string, size int) {
+	pool := sync.Pool{New: func() any { return new([]byte) }}
+	roundTrip := func(alloc storage.Alloc, value []string) {
+		if alloc == storage.AllocDefault {
+			data, err := c.Marshal(value)
+			if err == nil {
+				_, err = c.Unmarshal(data)
+			}
+			if err != nil {
+				run.Fatal(err.Error())
+			}
+			return
+		}
+		buf := pool.Get().(*[]byte)
+		dst := storage.ValuePool.Get().(*[]string)
+		data, err := codec.MarshalAppend(c, (*buf)[:0], value)
+		if err == nil && alloc == storage.AllocArena {
+			*dst, err = codec.UnmarshalArena(c, data, *dst)
+		} else if err == nil {
+			*dst, err = c.UnmarshalInto(data, *dst)
+		}
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		*buf = data
+		pool.Put(buf)
+		storage.ValuePool.Put(dst)
+	}
+
+	var base float64
+	for _, alloc := range []storage.Alloc{storage.AllocDefault, storage.AllocPool, storage.AllocArena} {
+		var took time.Duration
+		allocs := Mallocs(func() {
+			start := time.Now()
+			for i := 0; i < size && !run.Done(ctx); i++ {
+				_, value := Generate(dataset, i, size)
+				roundTrip(alloc, value)
+			}
+			took = time.Since(start)
+		})
+		if run.Done(ctx) {
+			return
+		}
+		// Generate's own allocations are in every mode alike
+		perSec := float64(size) / took.Seconds()
+		if alloc == storage.AllocDefault {
+			base = perSec
+		}
+		slog.Info("codec alloc", "alloc", alloc, "took", took, "allocs_per_op", run.Round(float64(allocs)/float64(size)),
+			"per_sec", math.Round(perSec), "speedup", run.Round(perSec/base))
+	}
+}
+
+// SpawnWriteTest is PipelineWriteTest with a goroutine started to encode
+// each batch instead of a fixed pool of encoders, so nothing holds the
+// generator back when the commits fall behind: encoded batches, and the
+// goroutines holding them, pile up until they are committed.
+func SpawnWriteTest(ctx context.Context, mybolt *storage.Bolt, dataset string, size int) (written int, duration time.Duration) {
+	type record struct {
+		key   string
+		value []string
+	}
+	start := time.Now()
+	encoded := make(chan []storage.Entry)
+	go func() {
+		var encoders sync.WaitGroup
+		encode := func(batch []record) {
+			defer encoders.Done()
+			entries := make([]storage.Entry, 0, len(batch))
+			for _, record := range batch {
+				entry, err := mybolt.Encode(record.key, record.value)
+				if err != nil {
+					run.Fatal(err.Error())
+				}
+				entries = append(entries, entry)
+			}
+			encoded <- entries
+		}
+		batch := make([]record, 0, mybolt.BatchSize)
+		for i := 0; i < size && !run.Done(ctx); i++ {
+			key, value := Generate(dataset, i, size)
+			batch = append(batch, record{key, value})
+			if len(batch) == mybolt.BatchSize {
+				encoders.Add(1)
+				go encode(batch)
+				batch = make([]record, 0, mybolt.BatchSize)
+			}
+		}
+		encoders.Add(1)
+		go encode(batch)
+		encoders.Wait()
+		close(encoded)
+	}()
+
+	p := run.NewProgress("spawned write bolt", size)
+	for entries := range encoded {
+		mybolt.Commit(entries)
+		written += len(entries)
+		p.Update(written)
+	}
+	return written, time.Since(start)
+}
@@ -0,0 +1,170 @@
+// Package cache is a read-through LRU cache that can sit in front of any
+// storage backend, standing in for the in-memory tier a search process
+// would keep in front of the disk.
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Backend is the read side of a storage backend.
+type Backend interface {
+	Get(key string) ([]string, error)
+}
+
+// per entry bookkeeping, a rough guess at the list element, map entry
+// and slice/string headers
+const entryOverhead = 128
+
+type entry struct {
+	key   string
+	value []string
+	size  int
+}
+
+// Cache serves repeated Gets from memory, evicting the least recently
+// used values once maxBytes is reached. It is safe for concurrent use.
+type Cache struct {
+	backend  Backend
+	maxBytes int
+
+	mu    sync.Mutex
+	used  int
+	lru   *list.List
+	items map[string]*list.Element
+	// gen counts invalidations, a value read before one doesn't get
+	// cached
+	gen        uint64
+	hits       uint64
+	misses     uint64
+	prefetches uint64
+}
+
+// Wrap returns a Cache in front of backend holding at most maxBytes of
+// keys and values.
+func Wrap(backend Backend, maxBytes int) *Cache {
+	return &Cache{
+		backend:  backend,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value for key, only asking the backend on a miss.
+// Errors are passed through and not cached.
+func (c *Cache) Get(key string) ([]string, error) {
+	c.mu.Lock()
+	if e, ok := c.items[key]; ok {
+		c.lru.MoveToFront(e)
+		c.hits++
+		value := e.Value.(*entry).value
+		c.mu.Unlock()
+		return value, nil
+	}
+	c.misses++
+	gen := c.gen
+	c.mu.Unlock()
+
+	value, err := c.backend.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	c.add(key, value, gen)
+	return value, nil
+}
+
+// Prefetch loads key into the cache if it isn't there already. Unlike
+// Get it doesn't count as a hit or miss or refresh the key's position.
+func (c *Cache) Prefetch(key string) error {
+	c.mu.Lock()
+	_, ok := c.items[key]
+	if !ok {
+		c.prefetches++
+	}
+	gen := c.gen
+	c.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	value, err := c.backend.Get(key)
+	if err != nil {
+		return err
+	}
+	c.add(key, value, gen)
+	return nil
+}
+
+// Contains reports whether key is cached, without counting as a hit or
+// miss.
+func (c *Cache) Contains(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.items[key]
+	return ok
+}
+
+// Invalidate drops key, call it when the backend value changes.
+func (c *Cache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+}
+
+// Stats returns the hit and miss counts so far.
+func (c *Cache) Stats() (hits, misses uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// Prefetches returns the number of values loaded by Prefetch.
+func (c *Cache) Prefetches() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.prefetches
+}
+
+// Len returns the number of cached values.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// add caches value for key unless it was read at an older gen.
+func (c *Cache) add(key string, value []string, gen uint64) {
+	size := entryOverhead + len(key)
+	for _, s := range value {
+		size += len(s) + 16
+	}
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if e, ok := c.items[key]; ok {
+		// another goroutine missed on the same key
+		c.remove(e)
+	}
+	c.items[key] = c.lru.PushFront(&entry{key, value, size})
+	c.used += size
+	for c.used > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) remove(e *list.Element) {
+	ent := c.lru.Remove(e).(*entry)
+	delete(c.items, ent.key)
+	c.used -= ent.size
+}
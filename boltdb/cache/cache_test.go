@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+)
+
+var errMissing = errors.New("missing")
+
+// backend is a map counting its Gets. A read of a key in block waits
+// for the channel to be closed.
+type backend struct {
+	values map[string][]string
+	gets   int
+	block  map[string]chan struct{}
+}
+
+func (b *backend) Get(key string) ([]string, error) {
+	if ch := b.block[key]; ch != nil {
+		<-ch
+	}
+	b.gets++
+	value, ok := b.values[key]
+	if !ok {
+		return nil, errMissing
+	}
+	return value, nil
+}
+
+func TestCache(t *testing.T) {
+	b := &backend{values: map[string][]string{
+		"a": {"x"}, "b": {"x"}, "c": {"x"}, "d": {"x"},
+		"big": {string(make([]byte, 1000))},
+	}}
+	// each of a to d is entryOverhead, its key and the item with its
+	// header, room for three
+	c := Wrap(b, 3*(entryOverhead+1+1+16))
+	for _, key := range []string{"a", "b", "c", "a", "d"} {
+		if value, err := c.Get(key); err != nil || value[0] != "x" {
+			t.Fatalf("%s: %v, %v", key, value, err)
+		}
+	}
+	// a was read again, so b went first
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if c.Contains(key) != want {
+			t.Errorf("%s cached: %v, want %v", key, !want, want)
+		}
+	}
+	if hits, misses := c.Stats(); hits != 1 || misses != 4 || b.gets != 4 || c.Len() != 3 {
+		t.Errorf("%d hits, %d misses, %d gets, %d cached", hits, misses, b.gets, c.Len())
+	}
+	// c is least recent now, and goes for b
+	c.Get("b")
+	if c.Contains("c") || !c.Contains("b") {
+		t.Error("c wasn't evicted for b")
+	}
+
+	// errors and values over maxBytes aren't cached
+	if _, err := c.Get("nope"); err != errMissing {
+		t.Errorf("nope: %v", err)
+	}
+	c.Get("big")
+	if c.Contains("nope") || c.Contains("big") || c.Len() != 3 {
+		t.Errorf("%d cached after an error and a big value", c.Len())
+	}
+
+	c.Invalidate("a")
+	c.Invalidate("missing")
+	gets := b.gets
+	if c.Contains("a") || c.Len() != 2 {
+		t.Error("a still cached")
+	}
+	c.Get("a")
+	if b.gets != gets+1 || !c.Contains("a") {
+		t.Error("a wasn't read again after Invalidate")
+	}
+}
+
+func TestInvalidateDuringMiss(t *testing.T) {
+	release := make(chan struct{})
+	b := &backend{values: map[string][]string{"a": {"old"}}, block: map[string]chan struct{}{"a": release}}
+	c := Wrap(b, 1<<20)
+	done := make(chan []string)
+	go func() {
+		value, _ := c.Get("a")
+		done <- value
+	}()
+	// the Get has missed and is reading "old" when the value changes
+	for {
+		if _, misses := c.Stats(); misses > 0 {
+			break
+		}
+	}
+	c.Invalidate("a")
+	close(release)
+	if value := <-done; value[0] != "old" {
+		t.Fatalf("got %v", value)
+	}
+	if c.Contains("a") {
+		t.Error("a value read before an Invalidate was cached")
+	}
+	b.values["a"] = []string{"new"}
+	if value, _ := c.Get("a"); value[0] != "new" {
+		t.Errorf("got %v after the Invalidate", value)
+	}
+}
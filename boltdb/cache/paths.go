@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"github.com/jogo/goplayground/boltdb/search"
+	"sync"
+)
+
+type pathEntry struct {
+	ends     [2]string
+	path     []string
+	expanded int
+}
+
+// Paths is an LRU of completed shortest paths by their ends, for servers
+// answering the same route queries over and over. Only found paths are
+// kept. It is safe for concurrent use.
+type Paths struct {
+	maxPaths int
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[[2]string]*list.Element
+	// gen counts invalidations, a search that started before one
+	// doesn't get cached
+	gen          uint64
+	hits, misses uint64
+}
+
+// NewPaths returns a Paths holding at most maxPaths paths.
+func NewPaths(maxPaths int) *Paths {
+	return &Paths{maxPaths: maxPaths, lru: list.New(), items: make(map[[2]string]*list.Element)}
+}
+
+// Wrap returns find answered from p when it can, with the number of
+// nodes expanded by the search that found the path. Paths are shared,
+// callers must not modify them. A nil find is search.Find, a nil p
+// leaves find as it is.
+func (p *Paths) Wrap(find search.Func) search.Func {
+	if find == nil {
+		find = search.Find
+	}
+	if p == nil {
+		return find
+	}
+	return func(ctx context.Context, r search.Reader, from, to string, h search.Heuristic) ([]string, int, error) {
+		return p.find(ctx, find, r, from, to, h)
+	}
+}
+
+func (p *Paths) find(ctx context.Context, find search.Func, r search.Reader, from, to string, h search.Heuristic) (path []string, expanded int, err error) {
+	ends := [2]string{from, to}
+	p.mu.Lock()
+	if e, ok := p.items[ends]; ok {
+		p.lru.MoveToFront(e)
+		p.hits++
+		ent := e.Value.(*pathEntry)
+		p.mu.Unlock()
+		return ent.path, ent.expanded, nil
+	}
+	p.misses++
+	gen := p.gen
+	p.mu.Unlock()
+
+	path, expanded, err = find(ctx, r, from, to, h)
+	if err != nil {
+		return nil, expanded, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if gen != p.gen {
+		return path, expanded, nil
+	}
+	if e, ok := p.items[ends]; ok {
+		// another goroutine missed on the same query
+		p.remove(e)
+	}
+	p.items[ends] = p.lru.PushFront(&pathEntry{ends, path, expanded})
+	for p.lru.Len() > p.maxPaths {
+		p.remove(p.lru.Back())
+	}
+	return path, expanded, nil
+}
+
+// Invalidate drops every path, call it when the graph changes. It takes
+// the key that changed to share a hook with Cache.Invalidate, but a
+// changed adjacency list can shorten paths that never went through it, so
+// none are kept.
+func (p *Paths) Invalidate(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gen++
+	p.lru.Init()
+	clear(p.items)
+}
+
+// Stats returns the number of queries answered from p and searched.
+func (p *Paths) Stats() (hits, misses uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hits, p.misses
+}
+
+// Len is the number of paths held.
+func (p *Paths) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru.Len()
+}
+
+func (p *Paths) remove(e *list.Element) {
+	delete(p.items, p.lru.Remove(e).(*pathEntry).ends)
+}
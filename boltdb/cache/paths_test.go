@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/jogo/goplayground/boltdb/search"
+	"testing"
+)
+
+// finder is a search.Func counting its searches, with the path from,
+// to. A search to a node in block waits for the channel to be closed.
+type finder struct {
+	searches int
+	block    map[string]chan struct{}
+}
+
+func (f *finder) find(ctx context.Context, r search.Reader, from, to string, h search.Heuristic) ([]string, int, error) {
+	if ch := f.block[to]; ch != nil {
+		<-ch
+	}
+	f.searches++
+	if to == "nowhere" {
+		return nil, 1, search.ErrNoPath
+	}
+	return []string{from, to}, 2, nil
+}
+
+func TestPaths(t *testing.T) {
+	f := &finder{}
+	p := NewPaths(2)
+	find := p.Wrap(f.find)
+	ctx := context.Background()
+	for _, q := range [][2]string{{"a", "b"}, {"a", "c"}, {"a", "b"}, {"a", "d"}, {"a", "b"}, {"a", "c"}} {
+		path, expanded, err := find(ctx, nil, q[0], q[1], nil)
+		if err != nil || len(path) != 2 || path[1] != q[1] || expanded != 2 {
+			t.Fatalf("%v: %v, %d, %v", q, path, expanded, err)
+		}
+	}
+	// a-b was found again each time before a-c, which d replaced
+	if hits, misses := p.Stats(); hits != 2 || misses != 4 || f.searches != 4 || p.Len() != 2 {
+		t.Errorf("%d hits, %d misses, %d searches, %d held", hits, misses, f.searches, p.Len())
+	}
+	// no path isn't kept
+	for i := 0; i < 2; i++ {
+		if _, _, err := find(ctx, nil, "a", "nowhere", nil); !errors.Is(err, search.ErrNoPath) {
+			t.Errorf("a to nowhere: %v", err)
+		}
+	}
+	if f.searches != 6 {
+		t.Errorf("%d searches after two for no path, want 6", f.searches)
+	}
+
+	p.Invalidate("x")
+	if p.Len() != 0 {
+		t.Errorf("%d paths held after Invalidate", p.Len())
+	}
+	find(ctx, nil, "a", "b", nil)
+	if f.searches != 7 {
+		t.Error("a-b answered from the cache after Invalidate")
+	}
+
+	var none *Paths
+	if none.Wrap(nil) == nil || none.Wrap(f.find) == nil {
+		t.Error("a nil Paths wrapped to nil")
+	}
+}
+
+func TestPathsInvalidateDuringSearch(t *testing.T) {
+	release := make(chan struct{})
+	f := &finder{block: map[string]chan struct{}{"b": release}}
+	p := NewPaths(2)
+	find := p.Wrap(f.find)
+	done := make(chan error)
+	go func() {
+		_, _, err := find(context.Background(), nil, "a", "b", nil)
+		done <- err
+	}()
+	// the search is running when the graph changes
+	for {
+		if _, misses := p.Stats(); misses > 0 {
+			break
+		}
+	}
+	p.Invalidate("b")
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 0 {
+		t.Error("a path searched before an Invalidate was kept")
+	}
+}
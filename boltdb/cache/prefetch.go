@@ -0,0 +1,75 @@
+package cache
+
+import "sync"
+
+// Prefetcher loads keys into a Cache on background goroutines, so a
+// caller that can guess what it will read next (like a search expanding
+// the best nodes of its open set) overlaps the backend reads with its
+// own work.
+type Prefetcher struct {
+	cache *Cache
+	keys  chan string
+	wg    sync.WaitGroup
+
+	mu      sync.Mutex
+	pending map[string]bool
+	dropped uint64
+}
+
+// NewPrefetcher starts workers goroutines filling c, with up to queue
+// keys waiting.
+func NewPrefetcher(c *Cache, workers, queue int) *Prefetcher {
+	p := &Prefetcher{
+		cache:   c,
+		keys:    make(chan string, queue),
+		pending: make(map[string]bool),
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for key := range p.keys {
+				// a failed guess is not an error, the real read will
+				// report it
+				p.cache.Prefetch(key)
+				p.mu.Lock()
+				delete(p.pending, key)
+				p.mu.Unlock()
+			}
+		}()
+	}
+	return p
+}
+
+// Prefetch queues key without blocking, dropping it if the queue is full.
+// Keys already cached or queued are ignored.
+func (p *Prefetcher) Prefetch(key string) {
+	if p.cache.Contains(key) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending[key] {
+		return
+	}
+	select {
+	case p.keys <- key:
+		p.pending[key] = true
+	default:
+		p.dropped++
+	}
+}
+
+// Dropped returns the number of keys not queued because the queue was
+// full.
+func (p *Prefetcher) Dropped() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dropped
+}
+
+// Close waits for queued keys to be loaded and stops the workers.
+func (p *Prefetcher) Close() {
+	close(p.keys)
+	p.wg.Wait()
+}
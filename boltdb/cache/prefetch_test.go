@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestPrefetcher(t *testing.T) {
+	b := &backend{values: map[string][]string{}}
+	for i := 0; i < 10; i++ {
+		b.values[strconv.Itoa(i)] = []string{"x"}
+	}
+	c := Wrap(b, 1<<20)
+	c.Get("0")
+	p := NewPrefetcher(c, 1, 10)
+	for i := 0; i < 10; i++ {
+		p.Prefetch(strconv.Itoa(i))
+	}
+	// a miss is only a miss to the backend
+	p.Prefetch("nope")
+	p.Close()
+	for i := 0; i < 10; i++ {
+		if !c.Contains(strconv.Itoa(i)) {
+			t.Errorf("%d not prefetched", i)
+		}
+	}
+	// 0 was cached already, and prefetches aren't hits or misses
+	if hits, misses := c.Stats(); hits != 0 || misses != 1 || c.Prefetches() != 10 || p.Dropped() != 0 {
+		t.Errorf("%d hits, %d misses, %d prefetched, %d dropped", hits, misses, c.Prefetches(), p.Dropped())
+	}
+}
+
+func TestPrefetcherFull(t *testing.T) {
+	release := make(chan struct{})
+	b := &backend{values: map[string][]string{"a": {"x"}, "b": {"x"}, "c": {"x"}, "d": {"x"}},
+		block: map[string]chan struct{}{"a": release}}
+	c := Wrap(b, 1<<20)
+	p := NewPrefetcher(c, 1, 1)
+	// the worker holds a, if it has taken it yet, and one key waits
+	for _, key := range []string{"a", "a", "b", "c", "d"} {
+		p.Prefetch(key)
+	}
+	close(release)
+	p.Close()
+	if p.Dropped() < 2 || p.Dropped() > 3 {
+		t.Errorf("%d of 4 keys dropped behind a blocked worker and a queue of 1", p.Dropped())
+	}
+	if !c.Contains("a") {
+		t.Error("a not prefetched")
+	}
+}
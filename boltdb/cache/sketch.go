@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// sketchDepth and sketchWidth size a Sketch's counters, 1MB of them,
+// which over-count a key by at most e/sketchWidth of all reads with
+// probability 1-e^-sketchDepth.
+const (
+	sketchDepth = 4
+	sketchWidth = 1 << 16
+)
+
+// HotKey is a key and how many times it was read, as estimated by a
+// Sketch.
+type HotKey struct {
+	Key   string `json:"key"`
+	Reads uint64 `json:"reads"`
+}
+
+// Sketch estimates how often keys are read in a fixed amount of memory, a
+// count-min sketch, and keeps the n keys it estimates highest in a heap,
+// the keys worth holding in a cache or on the fast tier. Estimates only
+// ever err high. It is safe for concurrent use.
+type Sketch struct {
+	// basis starts each key's hash, set by the seed
+	basis uint64
+	n     int
+
+	mu     sync.Mutex
+	counts [sketchDepth][]uint32
+	total  uint64
+	top    hotHeap
+	// index is each key in top's position in it
+	index map[string]int
+}
+
+// NewSketch returns a Sketch keeping the n most read keys, hashing them
+// as seed says, so runs with the same seed over the same reads collide,
+// and report, the same way.
+func NewSketch(n int, seed int64) *Sketch {
+	s := &Sketch{basis: fnvOffset ^ mix(uint64(seed)), n: n, index: make(map[string]int, n)}
+	for i := range s.counts {
+		s.counts[i] = make([]uint32, sketchWidth)
+	}
+	s.top.index = s.index
+	return s
+}
+
+// The 64-bit FNV-1a constants.
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+// hash is FNV-1a of key from s's basis, mixed so short keys such as node
+// IDs differ in every bit.
+func (s *Sketch) hash(key string) uint64 {
+	h := s.basis
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= fnvPrime
+	}
+	return mix(h)
+}
+
+// mix is the finalizer of MurmurHash3, spreading each bit of h over all
+// of them.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// Add counts a read of key.
+func (s *Sketch) Add(key string) {
+	h := s.hash(key)
+	// the rows' positions from two halves of one hash
+	h1, h2 := uint32(h), uint32(h>>32)|1
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total++
+	estimate := ^uint32(0)
+	for i := range s.counts {
+		c := &s.counts[i][(h1+uint32(i)*h2)%sketchWidth]
+		if *c < ^uint32(0) {
+			*c++
+		}
+		estimate = min(estimate, *c)
+	}
+	reads := uint64(estimate)
+	if i, ok := s.index[key]; ok {
+		s.top.keys[i].Reads = reads
+		heap.Fix(&s.top, i)
+		return
+	}
+	switch {
+	case len(s.top.keys) < s.n:
+		heap.Push(&s.top, HotKey{key, reads})
+	case s.n > 0 && reads > s.top.keys[0].Reads:
+		delete(s.index, s.top.keys[0].Key)
+		s.top.keys[0] = HotKey{key, reads}
+		s.index[key] = 0
+		heap.Fix(&s.top, 0)
+	}
+}
+
+// Top returns the most read keys, most read first.
+func (s *Sketch) Top() []HotKey {
+	s.mu.Lock()
+	top := append([]HotKey(nil), s.top.keys...)
+	s.mu.Unlock()
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Reads == top[j].Reads {
+			return top[i].Key < top[j].Key
+		}
+		return top[i].Reads > top[j].Reads
+	})
+	return top
+}
+
+// Total is the number of reads counted.
+func (s *Sketch) Total() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total
+}
+
+// hotHeap is a min-heap of the most read keys, the least read on top to
+// be replaced, tracking where each key is in index.
+type hotHeap struct {
+	keys  []HotKey
+	index map[string]int
+}
+
+func (h hotHeap) Len() int           { return len(h.keys) }
+func (h hotHeap) Less(i, j int) bool { return h.keys[i].Reads < h.keys[j].Reads }
+func (h hotHeap) Swap(i, j int) {
+	h.keys[i], h.keys[j] = h.keys[j], h.keys[i]
+	h.index[h.keys[i].Key] = i
+	h.index[h.keys[j].Key] = j
+}
+func (h *hotHeap) Push(x interface{}) {
+	k := x.(HotKey)
+	h.index[k.Key] = len(h.keys)
+	h.keys = append(h.keys, k)
+}
+func (h *hotHeap) Pop() interface{} {
+	old := h.keys
+	k := old[len(old)-1]
+	h.keys = old[:len(old)-1]
+	delete(h.index, k.Key)
+	return k
+}
+
+// Counted is a Backend counting the keys read through it in a Sketch.
+type Counted struct {
+	backend Backend
+	sketch  *Sketch
+}
+
+// Count returns backend with its reads counted in s.
+func Count(backend Backend, s *Sketch) *Counted {
+	return &Counted{backend: backend, sketch: s}
+}
+
+func (c *Counted) Get(key string) ([]string, error) {
+	c.sketch.Add(key)
+	return c.backend.Get(key)
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// skewed is n reads of keys 0 to keys-1, the low ones read most.
+func skewed(n, keys int) []string {
+	rnd := rand.New(rand.NewSource(1))
+	z := rand.NewZipf(rnd, 1.2, 1, uint64(keys-1))
+	reads := make([]string, n)
+	for i := range reads {
+		reads[i] = strconv.FormatUint(z.Uint64(), 10)
+	}
+	return reads
+}
+
+func TestSketch(t *testing.T) {
+	reads := skewed(200000, 100000)
+	exact := make(map[string]uint64)
+	a, b := NewSketch(1000, 7), NewSketch(1000, 7)
+	for _, key := range reads {
+		exact[key]++
+		a.Add(key)
+		b.Add(key)
+	}
+	top := a.Top()
+	if !reflect.DeepEqual(top, b.Top()) {
+		t.Error("two sketches of one seed disagree")
+	}
+	if len(top) != 1000 || a.Total() != uint64(len(reads)) {
+		t.Fatalf("%d keys on top of %d reads", len(top), a.Total())
+	}
+	for _, hot := range top {
+		if hot.Reads < exact[hot.Key] {
+			t.Errorf("key %s: estimated %d reads of %d", hot.Key, hot.Reads, exact[hot.Key])
+		}
+	}
+	// the most read keys stand well clear of the collisions
+	for i, hot := range top[:10] {
+		if hot.Key != strconv.Itoa(i) {
+			t.Errorf("top %d is %s, %d reads, want %d", i, hot.Key, hot.Reads, i)
+		}
+	}
+	// another seed collides elsewhere but finds the same keys on top
+	other := NewSketch(10, 8)
+	for _, key := range reads {
+		other.Add(key)
+	}
+	for i, hot := range other.Top() {
+		if hot.Key != top[i].Key {
+			t.Errorf("seed 8's top %d is %s, seed 7's %s", i, hot.Key, top[i].Key)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/jogo/goplayground/boltdb/bench"
+	"github.com/jogo/goplayground/boltdb/cache"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Experiment declares a matrix of runs: every backend with every codec,
+// size and batch size, each written and then put through the workloads.
+// It is read from a TOML or YAML file, e.g.
+//
+//	dataset = "grid"
+//	backends = ["map", "bolt/flat/string", "bolt/split/uint64"]
+//	codecs = ["json", "binary"]
+//	sizes = [100000, 1000000]
+//	batch_sizes = [10000, 100000]
+//	workloads = ["read", "random", "scan", "search"]
+//	gogc = [100, 400]
+//	ballast = [0, 1073741824]
+//	ingest = ["serial", "pool", "spawn"]
+//	no_grow_sync = [false, true]
+//	initial_mmap_sizes = [0, 1073741824]
+//	alloc_sizes = [0, 134217728]
+//	dir = "runs"
+//	report = "runs/report.json"
+type Experiment struct {
+	Name    string `toml:"name" yaml:"name"`
+	Dataset string `toml:"dataset" yaml:"dataset"`
+	// Seed, --seed if 0, draws the random reads and search queries; the
+	// dataset is the same for every seed
+	Seed int64 `toml:"seed" yaml:"seed"`
+	// Backends are storage.Open specs, Codecs fill in the codec of the
+	// bolt ones that leave it out
+	Backends   []string `toml:"backends" yaml:"backends"`
+	Codecs     []string `toml:"codecs" yaml:"codecs"`
+	Sizes      []int    `toml:"sizes" yaml:"sizes"`
+	BatchSizes []int    `toml:"batch_sizes" yaml:"batch_sizes"`
+	// Workloads run after the write, in order: read, random, scan and
+	// search
+	Workloads []string `toml:"workloads" yaml:"workloads"`
+	Searches  int      `toml:"searches" yaml:"searches"`
+	// TopKeys is how many of the keys the search workload reads most
+	// each run reports
+	TopKeys int `toml:"top_keys" yaml:"top_keys"`
+	// GOGC and Ballast, in bytes, are set for each run in turn, see
+	// run.SetGC. A GOGC of 0 leaves the default and -1 turns the
+	// collector off.
+	GOGC    []int `toml:"gogc" yaml:"gogc"`
+	Ballast []int `toml:"ballast" yaml:"ballast"`
+	// Ingest is how bolt backends are written: serial, a pool of
+	// EncodeWorkers encoding for one committer (pool), or a goroutine
+	// encoding each batch (spawn)
+	Ingest        []string `toml:"ingest" yaml:"ingest"`
+	EncodeWorkers int      `toml:"encode_workers" yaml:"encode_workers"`
+	// NoGrowSync, InitialMmapSizes and AllocSizes are the bolt settings
+	// tried, see storage.Tuning
+	NoGrowSync       []bool `toml:"no_grow_sync" yaml:"no_grow_sync"`
+	InitialMmapSizes []int  `toml:"initial_mmap_sizes" yaml:"initial_mmap_sizes"`
+	AllocSizes       []int  `toml:"alloc_sizes" yaml:"alloc_sizes"`
+	// Dir holds the db files, which are removed after each run unless
+	// Keep is set
+	Dir    string `toml:"dir" yaml:"dir"`
+	Keep   bool   `toml:"keep" yaml:"keep"`
+	Report string `toml:"report" yaml:"report"`
+}
+
+var workloads = []string{"read", "random", "scan", "search"}
+
+var ingestModes = []string{"serial", "pool", "spawn"}
+
+// experimentRun is one cell of the matrix.
+type experimentRun struct {
+	Backend   string `json:"backend"`
+	Size      int    `json:"size"`
+	BatchSize int    `json:"batch_size,omitempty"`
+	GOGC      int    `json:"gogc,omitempty"`
+	Ballast   int    `json:"ballast,omitempty"`
+	Ingest    string `json:"ingest,omitempty"`
+	storage.Tuning
+}
+
+// experimentResult is a run's timings in seconds, garbage collections
+// and time the world was stopped for them by phase, "write" and then the
+// workloads, and what the write took from the runtime, see run.Usage.
+type experimentResult struct {
+	experimentRun
+	Written          int                `json:"written"`
+	WritesPerSec     float64            `json:"writes_per_sec"`
+	PeakHeapBytes    uint64             `json:"peak_heap_bytes"`
+	PeakGoroutines   int                `json:"peak_goroutines"`
+	SchedWaitSeconds float64            `json:"sched_wait_seconds"`
+	Seconds          map[string]float64 `json:"seconds"`
+	GCs              map[string]uint32  `json:"gcs"`
+	GCPauseSeconds   map[string]float64 `json:"gc_pause_seconds"`
+	Bytes            int64              `json:"bytes,omitempty"`
+	// IO is each phase's I/O where the OS counts it, see run.IO, and
+	// Amplification the bytes it moved to or from the disk over the bytes
+	// of keys and values it wrote or read
+	IO            map[string]run.IO  `json:"io,omitempty"`
+	Amplification map[string]float64 `json:"amplification,omitempty"`
+	// HotKeys are the keys the search workload read most, see
+	// cache.Sketch
+	HotKeys []cache.HotKey `json:"hot_keys,omitempty"`
+}
+
+type experimentReport struct {
+	Name     string    `json:"name,omitempty"`
+	Revision string    `json:"revision,omitempty"`
+	Started  time.Time `json:"started"`
+	// Disk is the --disk the db files were on, empty for Dir
+	Disk string `json:"disk,omitempty"`
+	// Config is the experiment file as written
+	Config string             `json:"config"`
+	Runs   []experimentResult `json:"runs"`
+}
+
+// parseExperiment reads an experiment from data, TOML or YAML by the
+// extension of path, and fills in what it leaves out from the flags.
+func parseExperiment(path string, data []byte) (*Experiment, error) {
+	e := &Experiment{}
+	var err error
+	switch filepath.Ext(path) {
+	case ".toml":
+		_, err = toml.Decode(string(data), e)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, e)
+	default:
+		return nil, fmt.Errorf("experiment %s: want a .toml, .yaml or .yml file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("experiment %s: %w", path, err)
+	}
+	if len(e.Backends) == 0 {
+		return nil, fmt.Errorf("experiment %s: no backends", path)
+	}
+	for _, w := range e.Workloads {
+		if !slices.Contains(workloads, w) {
+			return nil, fmt.Errorf("experiment %s: unknown workload %q, want one of %s",
+				path, w, strings.Join(workloads, ", "))
+		}
+	}
+	for _, mode := range e.Ingest {
+		if !slices.Contains(ingestModes, mode) {
+			return nil, fmt.Errorf("experiment %s: unknown ingest %q, want one of %s",
+				path, mode, strings.Join(ingestModes, ", "))
+		}
+	}
+	if e.Dataset == "" {
+		e.Dataset = dataset
+	}
+	if e.Seed == 0 {
+		e.Seed = seed
+	}
+	if len(e.Codecs) == 0 {
+		e.Codecs = []string{codecName}
+	}
+	if len(e.Sizes) == 0 {
+		e.Sizes = []int{1000000}
+	}
+	if len(e.BatchSizes) == 0 {
+		e.BatchSizes = []int{10000}
+	}
+	if e.Searches == 0 {
+		e.Searches = 100
+	}
+	if len(e.GOGC) == 0 {
+		e.GOGC = []int{0}
+	}
+	if len(e.Ballast) == 0 {
+		e.Ballast = []int{0}
+	}
+	if len(e.Ingest) == 0 {
+		e.Ingest = []string{"serial"}
+	}
+	if len(e.NoGrowSync) == 0 {
+		e.NoGrowSync = []bool{noGrowSync}
+	}
+	if len(e.InitialMmapSizes) == 0 {
+		e.InitialMmapSizes = []int{initialMmapSize}
+	}
+	if len(e.AllocSizes) == 0 {
+		e.AllocSizes = []int{allocSize}
+	}
+	if e.EncodeWorkers == 0 {
+		e.EncodeWorkers = runtime.NumCPU()
+	}
+	if e.Dir == "" {
+		e.Dir = "."
+	}
+	if e.Report == "" {
+		e.Report = filepath.Join(e.Dir, "report.json")
+	}
+	return e, nil
+}
+
+// tunings are the combinations of bolt settings to try.
+func (e *Experiment) tunings() []storage.Tuning {
+	var tunings []storage.Tuning
+	for _, noGrowSync := range e.NoGrowSync {
+		for _, mmap := range e.InitialMmapSizes {
+			for _, alloc := range e.AllocSizes {
+				tunings = append(tunings, storage.Tuning{NoGrowSync: noGrowSync, InitialMmapSize: mmap, AllocSize: alloc})
+			}
+		}
+	}
+	return tunings
+}
+
+// runs expands the matrix. Backend specs are spelled out in full so the
+// same layout reached through different specs runs once, map, which has
+// neither codecs nor batches, runs once per size and GC setting, and only
+// bolt is written in each Ingest mode and with each of its tunings.
+func (e *Experiment) runs() []experimentRun {
+	var runs []experimentRun
+	for _, backend := range e.Backends {
+		for _, c := range e.Codecs {
+			spec := backend
+			batches := e.BatchSizes
+			ingest := []string{""}
+			tunings := []storage.Tuning{{}}
+			switch name := strings.Split(backend, "/")[0]; name {
+			case "map":
+				batches = []int{0}
+			case "bolt":
+				l := storage.ParseLayout(backend, storage.Layout{Schema: schema, Keys: keyEncoding, Codec: c})
+				spec = strings.Join([]string{name, l.Schema, l.Keys, l.Codec}, "/")
+				ingest = e.Ingest
+				tunings = e.tunings()
+			default:
+				spec = name + "/" + storage.ParseCodec(backend, storage.Layout{Codec: c})
+			}
+			for _, size := range e.Sizes {
+				for _, batch := range batches {
+					for _, gogc := range e.GOGC {
+						for _, ballast := range e.Ballast {
+							for _, mode := range ingest {
+								for _, t := range tunings {
+									r := experimentRun{Backend: spec, Size: size, BatchSize: batch, GOGC: gogc, Ballast: ballast,
+										Ingest: mode, Tuning: t}
+									if !slices.Contains(runs, r) {
+										runs = append(runs, r)
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return runs
+}
+
+// experiment runs every cell of the matrix in the file at path and
+// writes the report, with the file embedded, even if interrupted.
+func experiment(ctx context.Context, path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	e, err := parseExperiment(path, data)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	if diskDir != "" {
+		// the report stays where the experiment says
+		e.Dir = diskDir
+	}
+	err = os.MkdirAll(e.Dir, 0755)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	report := experimentReport{
+		Name:     e.Name,
+		Revision: buildRevision(),
+		Disk:     disk,
+		Started:  time.Now().UTC(),
+		Config:   string(data),
+	}
+	runs := e.runs()
+	slog.Info("experiment", "name", e.Name, "runs", len(runs), "dataset", e.Dataset, "dir", e.Dir)
+	for i, r := range runs {
+		if stopped(ctx) {
+			break
+		}
+		slog.Info("run", "run", i+1, "of", len(runs), "backend", r.Backend, "size", r.Size, "batch", r.BatchSize,
+			"gogc", r.GOGC, "ballast", r.Ballast, "ingest", r.Ingest, "no_grow_sync", r.NoGrowSync,
+			"initial_mmap", r.InitialMmapSize, "alloc_size", r.AllocSize)
+		report.Runs = append(report.Runs, e.run(ctx, r, filepath.Join(e.Dir, fmt.Sprintf("run-%d.db", i+1))))
+	}
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	err = os.WriteFile(e.Report, append(out, '\n'), 0644)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	slog.Info("report written", "path", e.Report, "runs", len(report.Runs))
+}
+
+// run writes one cell's backend at path and times the workloads on it.
+func (e *Experiment) run(ctx context.Context, r experimentRun, path string) experimentResult {
+	res := experimentResult{experimentRun: r, Seconds: map[string]float64{}, GCs: map[string]uint32{},
+		GCPauseSeconds: map[string]float64{}, IO: map[string]run.IO{}, Amplification: map[string]float64{}}
+	restore := run.SetGC(r.GOGC, r.Ballast)
+	defer restore()
+	// leave the previous run's garbage out of this one's
+	runtime.GC()
+	gcSince := func(phase string, before run.GC) {
+		gc := run.ReadGC().Since(before)
+		res.GCs[phase] = gc.Cycles
+		res.GCPauseSeconds[phase] = gc.Pause.Seconds()
+	}
+	ioSince := func(phase string, before run.IO, logical int64) {
+		after, ok := run.ReadIO()
+		if !ok {
+			return
+		}
+		io := after.Since(before)
+		res.IO[phase] = io
+		moved := io.ReadBytes
+		if phase == "write" {
+			moved = io.WriteBytes
+		}
+		if logical > 0 {
+			res.Amplification[phase] = run.Amplification(moved, logical)
+		}
+	}
+	s := storage.Open(r.Backend, path, layout(), storage.WithBatchSize(r.BatchSize), storage.WithTuning(r.Tuning),
+		storage.WithRetry(netRetry))
+	defer func() {
+		storage.Close(s)
+		if !e.Keep {
+			os.RemoveAll(path)
+		}
+	}()
+	mybolt, isBolt := s.(*storage.Bolt)
+	if isBolt {
+		setWriteFlags(mybolt)
+		mybolt.Seed = e.Seed
+		mybolt.WriteMetadata(strings.Split(r.Backend, "/")[3], e.Dataset, r.Size)
+	}
+
+	var d time.Duration
+	gc := run.ReadGC()
+	io, _ := run.ReadIO()
+	stop := run.Watch(10 * time.Millisecond)
+	switch r.Ingest {
+	case "pool":
+		res.Written, d = bench.PipelineWriteTest(ctx, mybolt, e.Dataset, r.Size, 1, e.EncodeWorkers)
+	case "spawn":
+		res.Written, d = bench.SpawnWriteTest(ctx, mybolt, e.Dataset, r.Size)
+	default:
+		res.Written, d = bench.WriteTest(ctx, r.Backend, s, e.Dataset, r.Size)
+	}
+	usage := stop()
+	res.Seconds["write"] = d.Seconds()
+	res.WritesPerSec = math.Round(float64(res.Written) / d.Seconds())
+	res.PeakHeapBytes, res.PeakGoroutines = usage.PeakHeap, usage.PeakGoroutines
+	res.SchedWaitSeconds = usage.SchedWait.Seconds()
+	gcSince("write", gc)
+	if isBolt {
+		// count the final sync's writes too
+		mybolt.Checkpoint()
+	}
+	logical := bench.DatasetBytes(e.Dataset, res.Written, r.Size)
+	ioSince("write", io, logical)
+	slog.Info("write", "backend", r.Backend, "took", d, "written", res.Written, "gcs", res.GCs["write"],
+		"peak_heap", usage.PeakHeap, "peak_goroutines", usage.PeakGoroutines, "sched_wait", usage.SchedWait,
+		"write_calls", res.IO["write"].WriteCalls, "write_amplification", res.Amplification["write"])
+	if res.Written < r.Size {
+		return res
+	}
+	if isBolt {
+		if fi, err := os.Stat(path); err == nil {
+			res.Bytes = fi.Size()
+		}
+	}
+
+	for _, w := range e.Workloads {
+		if run.Done(ctx) {
+			break
+		}
+		gc := run.ReadGC()
+		io, _ := run.ReadIO()
+		start := time.Now()
+		switch {
+		case w == "read" && isBolt:
+			d = bench.ReadTest(ctx, mybolt, r.Size)
+		case w == "read":
+			for i := 0; i < r.Size; i++ {
+				key, _ := bench.Generate(e.Dataset, i, r.Size)
+				if _, err := s.Get(key); err != nil {
+					run.Fatal(err.Error())
+				}
+			}
+			d = time.Since(start)
+		case w == "random":
+			d = bench.RandomReadTest(ctx, s, r.Size, r.Size, e.Seed)
+		case w == "scan" && isBolt:
+			_, d = bench.ScanTest(ctx, mybolt)
+		case w == "scan":
+			err := s.Iterate(func(key string, value []string) error { return nil })
+			if err != nil {
+				run.Fatal(err.Error())
+			}
+			d = time.Since(start)
+		case w == "search" && isBolt:
+			res.HotKeys = bench.SearchTest(ctx, mybolt, r.Size, e.Searches, bench.SearchOptions{
+				Dataset:         e.Dataset,
+				Seed:            e.Seed,
+				CacheBytes:      cacheBytes,
+				PrefetchDepth:   prefetchDepth,
+				PrefetchWorkers: prefetchWorkers,
+				TopKeys:         e.TopKeys,
+			})
+			d = time.Since(start)
+		default:
+			slog.Info("workload skipped, it needs bolt", "workload", w, "backend", r.Backend)
+			continue
+		}
+		res.Seconds[w] = d.Seconds()
+		gcSince(w, gc)
+		// each of the other workloads reads about the whole dataset once
+		if w == "search" {
+			ioSince(w, io, 0)
+		} else {
+			ioSince(w, io, logical)
+		}
+		slog.Info(w, "backend", r.Backend, "took", d, "gcs", res.GCs[w], "read_bytes", res.IO[w].ReadBytes,
+			"read_amplification", res.Amplification[w])
+	}
+	return res
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// journalBucket holds one JSON journalRun per run, keyed by its
+// big-endian ID.
+var journalBucket = []byte("runs")
+
+// journaled are the commands that measure something, whose runs are
+// appended to the --journal.
+var journaled = []*cobra.Command{loadCmd, benchWriteCmd, benchReadCmd, searchCmd, experimentCmd, replayCmd, reorderCmd}
+
+// journalRun is a run of a command as the journal keeps it: what was run
+// and everything it logged at info level or above, the results.
+type journalRun struct {
+	ID      int      `json:"id"`
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	// Flags are the ones set on the command line, the rest had their
+	// defaults
+	Flags    map[string]string `json:"flags,omitempty"`
+	Revision string            `json:"revision,omitempty"`
+	Started  time.Time         `json:"started"`
+	Seconds  float64           `json:"seconds"`
+	Failed   bool              `json:"failed,omitempty"`
+	Results  []journalRecord   `json:"results"`
+}
+
+type journalRecord struct {
+	Msg   string         `json:"msg"`
+	Attrs map[string]any `json:"attrs,omitempty"`
+}
+
+// journal collects the run in progress.
+type journal struct {
+	path string
+	once sync.Once
+
+	mu  sync.Mutex
+	run journalRun
+}
+
+// activeJournal is the journal of the command running, if it has one.
+var activeJournal *journal
+
+// startJournal starts collecting the run of cmd for the --journal, if it
+// is a journaled command, by teeing the default logger into it. The run
+// is appended once the command returns, or exits, failed.
+func startJournal(cmd *cobra.Command, args []string) {
+	if journalPath == "" || !slices.Contains(journaled, cmd) {
+		return
+	}
+	flags := make(map[string]string)
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	j := &journal{path: journalPath, run: journalRun{
+		Command:  strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" "),
+		Args:     args,
+		Flags:    flags,
+		Revision: buildRevision(),
+		Started:  time.Now(),
+	}}
+	slog.SetDefault(slog.New(&journalHandler{Handler: slog.Default().Handler(), j: j}))
+	run.AtExit(func() { j.finish(true) })
+	activeJournal = j
+}
+
+// finish appends the run to the journal file, once.
+func (j *journal) finish(failed bool) {
+	j.once.Do(func() {
+		j.mu.Lock()
+		r := j.run
+		j.mu.Unlock()
+		r.Seconds = time.Since(r.Started).Seconds()
+		r.Failed = failed
+		id, err := appendRun(j.path, r)
+		if err != nil {
+			// after the fact, the run itself went fine
+			slog.Warn("run not journaled", "journal", j.path, "err", err)
+			return
+		}
+		slog.Debug("journaled", "journal", j.path, "id", id)
+	})
+}
+
+// journalHandler hands the records logged at info level or above to the
+// journal as well as to the handler printing them, whatever its level.
+type journalHandler struct {
+	slog.Handler
+	j *journal
+	// attrs were added by With, groups are flattened
+	attrs []slog.Attr
+}
+
+func (h *journalHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo || h.Handler.Enabled(ctx, level)
+}
+
+func (h *journalHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelInfo {
+		rec := journalRecord{Msg: r.Message, Attrs: make(map[string]any, len(h.attrs)+r.NumAttrs())}
+		for _, a := range h.attrs {
+			rec.Attrs[a.Key] = journalValue(a.Value)
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			rec.Attrs[a.Key] = journalValue(a.Value)
+			return true
+		})
+		h.j.mu.Lock()
+		h.j.run.Results = append(h.j.run.Results, rec)
+		h.j.mu.Unlock()
+	}
+	if !h.Handler.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &journalHandler{h.Handler.WithAttrs(attrs), h.j, append(slices.Clip(h.attrs), attrs...)}
+}
+
+func (h *journalHandler) WithGroup(name string) slog.Handler {
+	return &journalHandler{h.Handler.WithGroup(name), h.j, h.attrs}
+}
+
+// journalValue is v as it is stored in the journal's JSON: durations as
+// strings such as 1.5s, and values of other types as they print.
+func journalValue(v slog.Value) any {
+	v = v.Resolve()
+	switch v.Kind() {
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindGroup:
+		m := make(map[string]any)
+		for _, a := range v.Group() {
+			m[a.Key] = journalValue(a.Value)
+		}
+		return m
+	case slog.KindAny:
+		return fmt.Sprint(v.Any())
+	}
+	return v.Any()
+}
+
+// appendRun adds r to the journal at path, creating it if need be, and
+// returns the ID it was given.
+func appendRun(path string, r journalRun) (id int, err error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(journalBucket)
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		r.ID = int(seq)
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return b.Put(binary.BigEndian.AppendUint64(nil, seq), data)
+	})
+	return r.ID, err
+}
+
+// readRuns returns every run in the journal at path, oldest first.
+func readRuns(path string) ([]journalRun, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	var runs []journalRun
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(journalBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var r journalRun
+			// numbers as logged, not as float64s
+			dec := json.NewDecoder(bytes.NewReader(v))
+			dec.UseNumber()
+			if err := dec.Decode(&r); err != nil {
+				return fmt.Errorf("run %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+			runs = append(runs, r)
+			return nil
+		})
+	})
+	return runs, err
+}
+
+// line is the command line of the run, its flags in name order.
+func (r journalRun) line() string {
+	parts := append([]string{r.Command}, r.Args...)
+	names := make([]string, 0, len(r.Flags))
+	for name := range r.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, "--"+name+"="+r.Flags[name])
+	}
+	return strings.Join(parts, " ")
+}
+
+// status is ok, or failed for a run that exited.
+func (r journalRun) status() string {
+	if r.Failed {
+		return "failed"
+	}
+	return "ok"
+}
+
+// String is the record as key=value pairs in key order.
+func (rec journalRecord) String() string {
+	keys := make([]string, 0, len(rec.Attrs))
+	for key := range rec.Attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{rec.Msg}
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%v", key, rec.Attrs[key]))
+	}
+	return strings.Join(parts, " ")
+}
+
+var errNoRun = errors.New("no such run in the journal")
+
+// history prints the last runs of the journal at path, one a line, or
+// with match the results of theirs whose message holds it, such as
+// "search bolt", one a line to follow a number across runs. A non-zero
+// id prints just that run with all it logged.
+func history(w io.Writer, path string, id, last int, match string) error {
+	runs, err := readRuns(path)
+	if err != nil {
+		return err
+	}
+	if id != 0 {
+		i := slices.IndexFunc(runs, func(r journalRun) bool { return r.ID == id })
+		if i < 0 {
+			return fmt.Errorf("%w: %d", errNoRun, id)
+		}
+		r := runs[i]
+		fmt.Fprintf(w, "run %d: %s\nstarted %s, took %.3fs, %s", r.ID, r.line(),
+			r.Started.Format(time.DateTime), r.Seconds, r.status())
+		if r.Revision != "" {
+			fmt.Fprintf(w, ", revision %s", r.Revision)
+		}
+		fmt.Fprintln(w)
+		for _, rec := range r.Results {
+			fmt.Fprintf(w, "  %s\n", rec)
+		}
+		return nil
+	}
+	if last > 0 && len(runs) > last {
+		runs = runs[len(runs)-last:]
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if match == "" {
+		fmt.Fprintln(tw, "id\tstarted\ttook\tstatus\tcommand")
+	} else {
+		fmt.Fprintln(tw, "id\tstarted\tcommand\tresult")
+	}
+	for _, r := range runs {
+		started := r.Started.Format(time.DateTime)
+		if match == "" {
+			fmt.Fprintf(tw, "%d\t%s\t%.3fs\t%s\t%s\n", r.ID, started, r.Seconds, r.status(), r.line())
+			continue
+		}
+		for _, rec := range r.Results {
+			if strings.Contains(rec.Msg, match) {
+				fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", r.ID, started, r.line(), rec)
+			}
+		}
+	}
+	return tw.Flush()
+}
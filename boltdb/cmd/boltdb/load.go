@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"github.com/jogo/goplayground/boltdb/bench"
+	"github.com/jogo/goplayground/boltdb/graph"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/loader"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"log/slog"
+	"math"
+	"runtime/debug"
+	"time"
+)
+
+// load fills a fresh bolt db, or one per --shards file, from the load
+// input, see loader.Load, instead of a generated dataset, then reports on
+// it the way main does.
+func load(ctx context.Context, path string, policy storage.SyncPolicy) {
+	paths := dbPaths()
+	shards := make([]storage.Store, len(paths))
+	bolts := make([]*storage.Bolt, len(paths))
+	for i, p := range paths {
+		bolts[i] = newBolt(policy, p)
+		bolts[i].WriteMetadata(codecName, bench.LoadedDataset, 0)
+		defer bolts[i].Close()
+		shards[i] = bolts[i]
+	}
+	var s storage.Store = bolts[0]
+	if len(shards) > 1 {
+		s = storage.NewSharded(shards...)
+	}
+
+	res, err := loader.Load(ctx, path, s, loader.Options{
+		Format:  inputFormat,
+		Rows:    rowFormat,
+		Header:  header,
+		Columns: edgeColumns,
+		Retries: retries,
+		Workers: loadWorkers,
+		Progress: func(p loader.Progress) {
+			if p.Done != nil {
+				slog.Info("load file", "path", p.Done.Path, "rows", p.Done.Rows, "took", p.Done.Took,
+					"rows_per_sec", math.Round(float64(p.Done.Rows)/p.Done.Took.Seconds()),
+					"files", p.Files, "of", p.Total)
+			}
+		},
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	keys, dups := 0, 0
+	for _, mybolt := range bolts {
+		keys += count(mybolt)
+		dups += mybolt.Duplicates()
+	}
+	args := []any{"path", path, "files", len(res.Files), "rows", res.Rows, "keys", keys, "took", res.Took,
+		"rows_per_sec", math.Round(float64(res.Rows) / res.Took.Seconds())}
+	if run.Done(ctx) {
+		slog.Warn("load interrupted", args...)
+	} else {
+		slog.Info("load", args...)
+	}
+	if duplicates != storage.Overwrite {
+		slog.Info("duplicate keys", "policy", duplicates, "writes", dups)
+	}
+	man := storage.Manifest{Importer: graph.ImporterVersion, Revision: buildRevision()}
+	for _, f := range res.Files {
+		man.Sources = append(man.Sources, f.Source)
+	}
+	for i, mybolt := range bolts {
+		logWriteLimit(mybolt)
+		mybolt.WriteMetadata(codecName, bench.LoadedDataset, count(mybolt))
+		if len(bolts) > 1 {
+			mybolt.WriteShard(i, len(bolts))
+		}
+		mybolt.WriteManifest(man)
+		start := time.Now()
+		mybolt.Checkpoint()
+		slog.Info("final bolt sync", "path", paths[i], "sync", policy.String(), "took", time.Since(start))
+		logCheckpoints(mybolt, policy)
+		mybolt.PageReport()
+		scanned, scanTime := bench.ScanTest(ctx, mybolt)
+		slog.Info("scan bolt", "path", paths[i], "took", scanTime, "keys", scanned)
+	}
+}
+
+// buildRevision is the commit this binary was built from, if go build
+// recorded one.
+func buildRevision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	revision, dirty := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			dirty = setting.Value == "true"
+		}
+	}
+	if revision != "" && dirty {
+		revision += "-dirty"
+	}
+	return revision
+}
@@ -0,0 +1,1379 @@
+/*
+Testing out boltdb for the following use case:
+
+* Load several million key/value pairs in as quickly as possible
+* Data forms a graph, that will be searched using A*
+* Load once, search many times
+* Data is too big to be all be in memory
+
+Issues so far:
+
+* Writing to boltdb involves 2 writes to disk, so performance is terrible if writes aren't batched
+
+Ideas to try out:
+
+* Built test suite with a regular map  [DONE]
+* Swap in boltdb backend and compare.  [DONE]
+* Try out boltdb transaction coalescer [DONE]
+  https://github.com/boltdb/coalescer
+* Rerun on SSD                         [DONE]
+* Separate test to measure how long it takes to read all the values back. [DONE]
+* Retry with backoff around flushes and reads of networked backends. [DONE]
+
+
+Findings. What they were measured at is in the --journal, see boltdb
+history for the runs and boltdb report diff to compare two experiments:
+
+* Overhead of db.Update for single key/value write is massive, still
+  several times slower than the map with a million keys per db.Update.
+
+coalescer -- Not working well even on an SSD, but works. Go back to home built solution.
+ (Found issue with coalescer logic)
+
+* Reading back, as expected is faster then writing.
+
+* Bolt's leaf pages end up only about half used (see the page report),
+  since inserts arrive in key order and bolt splits nodes at its default
+  FillPercent of 0.5. That accounts for most of the file size, --fill 1
+  packs them full.
+
+* Interning (--intern) doesn't pay for the grid's short node IDs: the
+  table's map entry per ID costs more than the few bytes each shared
+  copy saves, so the heap grows.
+
+* Ingest (experiment ingest = [pool, spawn]): a goroutine per batch
+  writes no faster than the bounded encoder pool, but peaks at several
+  times the heap as encoded batches queue up behind the commits. Loaders
+  should stick to the bounded pool.
+
+* Bounded searches (--algo ida, sma): on the unweighted grid IDA*'s
+  first bound is already the path length and it walks straight there,
+  faster than A*. SMA* finds the same paths when --maxnodes holds them,
+  but a budget shorter than the path only gives up after trying every
+  shorter one, which blows up fast.
+
+* Spilling A*'s closed set (--spill) to a bolt file costs a multiple of
+  the search time once most expanded nodes are spilled, mostly the read
+  transaction per closed check. Worth it only once the closed set
+  wouldn't fit.
+
+* Neighbors by range scan (--layouts): A* expands nodes fastest
+  streaming split's edge keys off one cursor, ahead of getting and
+  decoding flat's packed lists, with split read whole last, each search
+  reading through one transaction.
+
+* Searching while loading (bench write --searchers): median query
+  latency is unchanged but the tail is much longer than for the same
+  queries after the load, as Gets wait out the writer's commits, which
+  hold the buffer's lock, and bolt's remaps as the file grows.
+
+* Key prefixes (bench write --prefix): storing the node once, as the
+  name of a bucket of its edges, doesn't shrink the file, it grows, more
+  with string keys than uint64 ones, as each inline bucket's header costs
+  more than the prefix saved. Reading a node's edges is a little faster
+  from its own bucket than off a cursor over composite keys.
+
+* Decoding into a reused slice (GetInto, bench read) saves the value's
+  allocations per Get; the rest are the transaction and the decoded
+  strings. Searches on bolt read neighbors with EachItem, which saves the
+  same by decoding into a slice the search's transaction reuses.
+
+* One read transaction per search (storage.ReadTxn) instead of one per
+  node expanded makes A* faster. The transaction is a snapshot, a writer
+  growing the file waits for the search to finish.
+
+* A snapshot (the snapshot command, a sorted key index and packed edge
+  strings read straight from the mapping) is a fraction of the db's size
+  and answers A* queries faster with less peak heap, as nothing is
+  decoded or copied.
+
+
+*/
+
+package main
+
+import (
+	"context"
+	"github.com/boltdb/bolt"
+	"github.com/jogo/goplayground/boltdb/bench"
+	"github.com/jogo/goplayground/boltdb/cache"
+	"github.com/jogo/goplayground/boltdb/codec"
+	"github.com/jogo/goplayground/boltdb/graph"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/search"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"github.com/spf13/cobra"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// checkMetadata exits rather than let the --schema, --keys, --codec and
+// --dataset flags misread a db written with different ones.
+func checkMetadata(db *bolt.DB) storage.Metadata {
+	m, err := storage.ReadMetadata(db)
+	if err != nil {
+		run.Fatal("can't use the db", "path", db.Path(), "err", err)
+	}
+	if m.Version != storage.FormatVersion || m.Upgrade != nil {
+		run.Fatal("db format version mismatch or upgrade in progress, see boltdb upgrade", "path", db.Path(),
+			"version", m.Version, "want", storage.FormatVersion)
+	}
+	if m.Schema != schema || m.Keys != keyEncoding || m.Codec != codecName || m.Dataset != dataset {
+		run.Fatal("db was written with different flags, boltdb upgrade can change its layout", "path", db.Path(),
+			"schema", m.Schema, "keys", m.Keys, "codec", m.Codec, "dataset", m.Dataset)
+	}
+	slog.Info("metadata", "path", db.Path(), "version", m.Version, "dataset", m.Dataset,
+		"size", m.Size, "created", m.Created)
+	man, ok, err := storage.ReadManifest(db)
+	if err != nil {
+		run.Fatal("bad manifest", "path", db.Path(), "err", err)
+	}
+	if ok {
+		for _, src := range man.Sources {
+			slog.Info("loaded from", "source", src.Path, "format", src.Format, "bytes", src.Bytes,
+				"sha256", src.SHA256, "importer", man.Importer, "revision", man.Revision)
+		}
+	}
+	return m
+}
+
+func hellobolt() {
+	db := storage.FreshFile(dbPath)
+	defer db.Close()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(storage.Bucket)
+		err := b.Put([]byte("answer"), []byte("42"))
+		return err
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(storage.Bucket)
+		v := b.Get([]byte("answer"))
+		slog.Debug("hellobolt", "value", string(v))
+		return nil
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+}
+
+// handleSignals makes the first SIGINT or SIGTERM cancel the command's
+// context, stopping the running test once its current batch is flushed,
+// so the command can sync and close the db and report what it got
+// through. A second signal abandons the batch and exits at once, leaving
+// the db as of its last commit.
+func handleSignals(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		slog.Warn("stopping after the current batch, signal again to abandon it", "signal", sig.String())
+		cancel()
+		sig = <-signals
+		slog.Warn("abandoning the current batch", "signal", sig.String())
+		run.Exit(130)
+	}()
+}
+
+// stopped reports whether the command should skip the remaining tests.
+func stopped(ctx context.Context) bool {
+	if !run.Done(ctx) {
+		return false
+	}
+	slog.Warn("interrupted, skipping the remaining tests")
+	return true
+}
+
+// dbPath is the db the commands work on, moved to a tmpfs by --disk.
+var dbPath = storage.DefaultPath
+
+// Flags, see init for which commands take them
+var (
+	schema      string
+	keyEncoding string
+	codecName   string
+	dataset     string
+	seed        int64
+
+	logFormat     string
+	logLevel      string
+	progressEvery time.Duration
+	gogc          int
+	ballast       int
+	disk          string
+	netRetry      storage.Retry
+
+	syncFlag       string
+	batchSize      int
+	fillPercent    float64
+	blockSize      int
+	walPath        string
+	crashAfter     int
+	noGrowSync     bool
+	allocSize      int
+	pageStats      bool
+	sampleFraction float64
+	writeLimit     storage.WriteLimit
+	allocFlag      string
+	intern         bool
+	unsafeKeys     bool
+
+	size          int
+	pipeline      bool
+	parseWorkers  int
+	encodeWorkers int
+	loadSearchers int
+	prefixTest    bool
+	allocTest     bool
+
+	readOnly        bool
+	mmapFlags       int
+	initialMmapSize int
+	cacheBytes      int
+	pathCache       int
+	algo            string
+	maxNodes        int
+	spillFront      int
+	readers         string
+	warmFraction    float64
+	warmBy          string
+	pageCacheMode   string
+	hotKeysPath     string
+
+	searches        int
+	checkHeuristic  int
+	layouts         bool
+	topKeys         int
+	prefetchDepth   int
+	prefetchWorkers int
+
+	retries     int
+	loadWorkers int
+	inputFormat string
+	rowFormat   string
+	header      bool
+	edgeColumns string
+	duplicates  string
+
+	migratePath  string
+	samplePath   string
+	sampleNodes  int
+	reorderPath  string
+	reorderBy    string
+	reorderPages int
+	shardPaths   string
+	snapshotPath string
+	serveAddr    string
+	grpcAddr     string
+	writable     bool
+
+	recordPath   string
+	replayPath   string
+	faultSpec    string
+	queryTimeout time.Duration
+
+	compactTxMax int64
+	reachHops    int
+	targets      string
+
+	journalPath  string
+	historyLast  int
+	historyMatch string
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "boltdb",
+	Short: "Benchmark bolt as the store of a graph searched with A*",
+	Long: `Each command runs one phase of the experiment against my.db: fill it
+with load or bench write, then run bench read, search, stats and the other
+commands against it as many times as needed.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		toStdout := (cmd.Name() == "dump" || cmd.Name() == "backup") && len(args) > 0 && args[0] == "-"
+		setupLogging(toStdout)
+		startJournal(cmd, args)
+		gcSettings = run.SetGC(gogc, ballast)
+		setupDisk(cmd)
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		if diskDir != "" {
+			os.RemoveAll(diskDir)
+		}
+		if activeJournal != nil {
+			activeJournal.finish(false)
+		}
+	},
+}
+
+// gcSettings holds on to the --ballast for the life of the process.
+var gcSettings func()
+
+// diskDir is the tmpfs directory of --disk tmpfs, removed after the
+// command, even one that failed.
+var diskDir string
+
+// setupDisk moves dbPath to a fresh tmpfs directory for --disk tmpfs,
+// copying the db there first unless cmd writes a fresh one, so a run
+// measures bolt without the disk under it.
+func setupDisk(cmd *cobra.Command) {
+	switch disk {
+	case "":
+		return
+	case "tmpfs":
+	default:
+		run.Fatal("unknown --disk, want tmpfs", "disk", disk)
+	}
+	dir, err := run.TmpfsDir()
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	diskDir = dir
+	run.AtExit(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, storage.DefaultPath)
+	fresh := cmd == loadCmd || cmd == benchWriteCmd || cmd == verifyCmd || cmd == experimentCmd
+	if _, err := os.Stat(dbPath); err == nil && !fresh {
+		start := time.Now()
+		err := copyFile(path, dbPath)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		slog.Info("copied db to tmpfs", "from", dbPath, "took", time.Since(start))
+	}
+	dbPath = path
+	slog.Info("disk", "disk", disk, "path", dbPath)
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+var loadCmd = &cobra.Command{
+	Use:   "load path",
+	Short: "Load a file, directory, glob, URL (http, https or s3) or - for stdin into a fresh db",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		policy := parseSyncFlag()
+		hellobolt()
+		load(cmd.Context(), args[0], policy)
+	},
+}
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Write or read a generated dataset",
+}
+
+var benchWriteCmd = &cobra.Command{
+	Use:   "write",
+	Short: "Write the dataset to a map and to a fresh db and compare",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		policy := parseSyncFlag()
+		hellobolt()
+		benchWrite(cmd.Context(), policy)
+	},
+}
+
+var benchReadCmd = &cobra.Command{
+	Use:   "read",
+	Short: "Read the db back with point, pooled, zero-copy, random and parallel reads and a scan",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if readOnly {
+			readOnlyTest(cmd.Context())
+			return
+		}
+		benchRead(cmd.Context())
+	},
+}
+
+var searchCmd = &cobra.Command{
+	Use:   "search",
+	Short: "Run random A* queries against a grid db, directly, cached and prefetched",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		searchDb(cmd.Context())
+	},
+}
+
+var dumpCmd = &cobra.Command{
+	Use:   "dump path",
+	Short: "Write the db to a .csv, .jsonl or GraphSON file, optionally .gz, or - for stdout",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dump(cmd.Context(), args[0])
+	},
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify spec spec",
+	Short: "Write the dataset to two backends, e.g. map and bolt/split/binary, and compare them",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		verify(cmd.Context(), args, size)
+	},
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Report the db's metadata, key count and how its pages are used",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		stats()
+	},
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the db's pages and decode every value",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		check(dbPath)
+	},
+}
+
+var backupCmd = &cobra.Command{
+	Use:   "backup path",
+	Short: "Copy a consistent snapshot of the db to a file, or - for stdout",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		backup(args[0])
+	},
+}
+
+var compactCmd = &cobra.Command{
+	Use:   "compact path",
+	Short: "Copy the db into a fresh file at path with every page packed full, dropping free pages",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		compact(cmd.Context(), args[0])
+	},
+}
+
+var reachCmd = &cobra.Command{
+	Use:   "reach node",
+	Short: "Count the nodes reachable from node, or within --hops of it, with bitmap unions over a roaring db",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		reach(cmd.Context(), args[0], reachHops)
+	},
+}
+
+var distancesCmd = &cobra.Command{
+	Use:   "distances source...",
+	Short: "Store the distances from each source to --targets, or to every node it reaches, for lookups and landmark heuristics",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var to []string
+		if targets != "" {
+			to = strings.Split(targets, ",")
+		}
+		distances(cmd.Context(), args, to)
+	},
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff path",
+	Short: "Compare the db with another, exit with status 1 if any keys differ",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		diff(cmd.Context(), args[0])
+	},
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate spec",
+	Short: "Copy the db into a fresh store opened from a spec, e.g. bolt/split/uint64/binary or lmdb/binary",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		migrate(cmd.Context(), args[0], migratePath)
+	},
+}
+
+var sampleCmd = &cobra.Command{
+	Use:   "sample node",
+	Short: "Copy the --nodes nodes nearest node, breadth first, and the edges among them into a small fresh db to iterate on",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sample(cmd.Context(), args[0], samplePath, sampleNodes)
+	},
+}
+
+var shellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "Explore the db interactively, read-only: get keys, scan a prefix, list a node's neighbors, route between nodes and show stats",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runShell(cmd.Context())
+	},
+}
+
+var reorderCmd = &cobra.Command{
+	Use:   "reorder",
+	Short: "Renumber the nodes breadth first or along a Hilbert curve into a fresh db, so neighbors share pages, and compare the page cache hit rate of --searches queries on both",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		reorder(cmd.Context(), reorderBy, reorderPath, searches, reorderPages)
+	},
+}
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade [spec]",
+	Short: "Rewrite the db in place to the current format and a bolt spec's layout, e.g. bolt/uint64/binary, or finish an interrupted upgrade",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		spec := ""
+		if len(args) > 0 {
+			spec = args[0]
+		}
+		upgrade(cmd.Context(), spec)
+	},
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Answer GET /node/{id} and GET /path?from=X&to=Y over HTTP, and optionally gRPC, from the db, cached and read-only unless --writable",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		serve(cmd.Context(), serveAddr, grpcAddr)
+	},
+}
+
+var experimentCmd = &cobra.Command{
+	Use:   "experiment file",
+	Short: "Run the matrix of backends, codecs, sizes and workloads declared in a TOML or YAML file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		experiment(cmd.Context(), args[0])
+	},
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay log [spec]",
+	Short: "Issue the operations recorded with --record, in order, to the db or to a fresh backend for spec, e.g. bolt/split",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		spec := ""
+		if len(args) > 1 {
+			spec = args[1]
+		}
+		replay(cmd.Context(), args[0], spec)
+	},
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history [id]",
+	Short: "List the runs in the --journal, or what one of them logged, or with --match a result across runs",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := 0
+		if len(args) > 0 {
+			var err error
+			id, err = strconv.Atoi(args[0])
+			if err != nil {
+				run.Fatal("history needs a run ID", "id", args[0])
+			}
+		}
+		err := history(os.Stdout, journalPath, id, historyLast, historyMatch)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+	},
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Work with the reports experiment writes",
+}
+
+var reportDiffCmd = &cobra.Command{
+	Use:   "diff old.json new.json",
+	Short: "Print what each phase of every run the two reports share took in each, with the difference",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := reportDiff(os.Stdout, args[0], args[1])
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+	},
+}
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot path",
+	Short: "Export the db to a read-only file searched straight from its memory mapping, see --snapshot",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		snapshot(args[0])
+	},
+}
+
+var recoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: "Crash test: replay the write-ahead log into the db",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		recoverBolt(dbPath, walPath)
+	},
+}
+
+func init() {
+	f := rootCmd.PersistentFlags()
+	f.StringVar(&schema, "schema", storage.FlatSchema, "bolt key layout: flat or split")
+	f.StringVar(&keyEncoding, "keys", storage.StringKeys, "bolt key encoding: string or uint64")
+	f.StringVar(&codecName, "codec", "json", "bolt value codec: json, binary or roaring (unweighted uint32 node IDs), add +crc to checksum every value")
+	f.StringVar(&dataset, "dataset", bench.RepeatDataset, "generated data, the same for every --seed: repeat or grid (a graph for the search test), load for a loaded db")
+	f.Int64Var(&seed, "seed", 1, "seed for the random reads and search queries; datasets only depend on their size")
+	f.StringVar(&logFormat, "log", "text", "log format: text or json")
+	f.StringVar(&logLevel, "loglevel", "info", "least severe log level shown: debug, info, warn or error")
+	f.IntVar(&gogc, "gogc", 0, "GOGC for the run, 0 for the default or $GOGC, -1 to turn the collector off")
+	f.IntVar(&ballast, "ballast", 0, "bytes of never touched heap to allocate, raising the heap size the collector aims for")
+	f.StringVar(&disk, "disk", "", "where the db lives: empty for the working directory, tmpfs for a RAM-backed directory (Linux) set up and removed by the command")
+	f.DurationVar(&progressEvery, "progress", 10*time.Second, "how often to log progress during loads, reads and scans, 0 for never")
+	f.IntVar(&netRetry.Attempts, "netretries", 3, "times the networked backends, redis and postgres, retry a failed flush or read before giving up")
+	f.DurationVar(&netRetry.Backoff, "netbackoff", 100*time.Millisecond, "wait before the networked backends' first retry, doubling for each next one")
+	f.DurationVar(&netRetry.MaxBackoff, "netmaxbackoff", 5*time.Second, "longest wait between the networked backends' retries, 0 for no limit")
+	f.StringVar(&journalPath, "journal", "results.db", "bolt file the runs of load, bench, search, experiment and replay are appended to, see history; empty to keep no journal")
+
+	for _, cmd := range []*cobra.Command{loadCmd, benchWriteCmd} {
+		f := cmd.Flags()
+		f.StringVar(&syncFlag, "sync", "close", "when to fsync bolt: flush, close or every N flushes, recording in the file what each fsync made durable")
+		f.IntVar(&batchSize, "batch", 10000, "bolt writes committed per transaction")
+		f.Float64Var(&fillPercent, "fill", 0.5, "how full bolt packs pages before splitting them, 0.1 to 1")
+		f.IntVar(&blockSize, "blocksize", 0, "flat schema: store values of more items than this in blocks of this many, read one at a time by search, 0 to store them whole")
+		f.IntVar(&crashAfter, "crashafter", 0, "crash test: exit without closing after this many bolt flushes")
+		f.BoolVar(&noGrowSync, "nogrowsync", false, "don't grow bolt's file ahead of its pages and fsync it after")
+		f.IntVar(&allocSize, "allocsize", 0, "bytes bolt's file grows ahead of its pages once mapped past them, 0 for bolt's 16MB")
+		f.IntVar(&initialMmapSize, "initialmmap", 0, "bytes to map up front, so bolt doesn't remap the file while it grows to that")
+		f.BoolVar(&pageStats, "pagestats", false, "print bolt's page and timing stats for every flush")
+		f.Float64Var(&sampleFraction, "sample", 0, "fraction of each bolt flush to read back and compare right after committing, e.g. 0.001")
+		f.Float64Var(&writeLimit.KeysPerSec, "keyrate", 0, "most keys a second each bolt file admits, to load in the background of searches, 0 for no limit")
+		f.Float64Var(&writeLimit.BytesPerSec, "byterate", 0, "most bytes of keys and values a second each bolt file admits, 0 for no limit")
+	}
+	for _, cmd := range []*cobra.Command{benchWriteCmd, benchReadCmd, searchCmd, serveCmd} {
+		cmd.Flags().BoolVar(&intern, "intern", false, "share one copy of each distinct value item, e.g. node ID, across the values held in memory and report the bytes saved")
+	}
+	for _, cmd := range []*cobra.Command{loadCmd, benchWriteCmd, benchReadCmd} {
+		cmd.Flags().BoolVar(&unsafeKeys, "unsafekeys", false, "convert string keys to and from bolt's bytes without copying them")
+		cmd.Flags().StringVar(&allocFlag, "alloc", "default", "how flat values are encoded and decoded: default, pool (pooled buffers) or arena (pooled, and one string per decoded value)")
+	}
+
+	f = loadCmd.Flags()
+	f.IntVar(&retries, "retries", 5, "times to retry a failed download before giving up")
+	f.IntVar(&loadWorkers, "workers", runtime.NumCPU(), "files of a directory or glob parsed at once")
+	f.StringVar(&inputFormat, "format", "", "csv, jsonl, tsv (an edge list), graphml, parquet (an edge table) or pbf (OpenStreetMap roads); default is the file's extension")
+	f.StringVar(&rowFormat, "rows", "kv", "csv rows: kv (key then value items) or edges (node,neighbor[,weight])")
+	f.BoolVar(&header, "header", false, "skip the file's first row")
+	f.StringVar(&edgeColumns, "columns", "src,dst,weight", "parquet source, destination and optional weight columns, nested ones as a.b")
+	f.StringVar(&duplicates, "duplicates", storage.Overwrite, "what a key written again does: overwrite, merge (append the items its value lacks) or error")
+
+	for _, cmd := range []*cobra.Command{benchWriteCmd, searchCmd, serveCmd} {
+		cmd.Flags().StringVar(&recordPath, "record", "", "workload log to record the puts, gets and searches issued to bolt in, or append them to, for replay")
+	}
+	for _, cmd := range []*cobra.Command{benchWriteCmd, serveCmd, replayCmd} {
+		cmd.Flags().StringVar(&faultSpec, "faults", "", "faults to inject, e.g. spike=0.01:50ms,error=0.001,short=0.0001,seed=2: latency spikes and errors into reads, spikes and short writes into the --wal")
+	}
+	for _, cmd := range []*cobra.Command{loadCmd, searchCmd, serveCmd, replayCmd, shellCmd} {
+		cmd.Flags().StringVar(&shardPaths, "shards", "", "comma separated db files to split the keyspace across instead of "+dbPath+", e.g. /disk1/my.db,/disk2/my.db")
+	}
+	for _, cmd := range []*cobra.Command{searchCmd, serveCmd, replayCmd, shellCmd} {
+		cmd.Flags().StringVar(&snapshotPath, "snapshot", "", "read a file written by the snapshot command instead of the db")
+	}
+
+	f = benchWriteCmd.Flags()
+	f.IntVar(&size, "size", 1000000, "number of entries to write")
+	f.StringVar(&walPath, "wal", "", "write-ahead log file, lets bolt run with NoSync safely")
+	f.BoolVar(&pipeline, "pipeline", false, "write bolt through the staged parse/encode/commit pipeline")
+	f.IntVar(&parseWorkers, "parseworkers", 1, "pipeline: goroutines generating key/values")
+	f.IntVar(&encodeWorkers, "encodeworkers", runtime.NumCPU(), "pipeline: goroutines encoding values")
+	f.BoolVar(&prefixTest, "prefix", false, "also compare storing each edge under node+index keys with a bucket per node holding them by index, the node stored once")
+	f.BoolVar(&allocTest, "alloctest", false, "also round trip the dataset through the codec with each --alloc mode and compare their allocations and speed")
+	f.IntVar(&loadSearchers, "searchers", 0, "grid: goroutines running A* queries against bolt while it is written, compared with the same queries after, 0 for none")
+
+	for _, cmd := range []*cobra.Command{benchReadCmd, searchCmd, serveCmd} {
+		f := cmd.Flags()
+		f.IntVar(&cacheBytes, "cache", 64<<20, "size of the LRU cache in front of bolt, 0 to skip the cached tests")
+		f.Float64Var(&warmFraction, "warm", 0, "fraction of the db to read into the page cache first")
+		f.StringVar(&warmBy, "warmby", "scan", "how to --warm: scan (cursor over each bucket) or hot (Get the hottest keys)")
+		f.StringVar(&hotKeysPath, "hotkeys", "", "file of hot keys, one per line, for --warmby=hot; default is the lowest keys")
+		f.StringVar(&pageCacheMode, "pagecache", "keep", "page cache before each read phase: keep it as it is or drop the db's pages (Linux), either way logging how much of the db it holds")
+		f.IntVar(&pathCache, "paths", 0, "shortest paths kept in an LRU by their ends, 0 for none")
+		f.StringVar(&algo, "algo", "astar", "shortest path search: astar, or ida or sma to bound its memory")
+		f.IntVar(&maxNodes, "maxnodes", 100000, "nodes --algo=sma holds at most, it has to fit a whole path")
+		f.IntVar(&spillFront, "spill", 0, "closed nodes A* keeps in memory before moving them to a temporary bolt file, 0 to keep all")
+	}
+
+	f = benchReadCmd.Flags()
+	f.StringVar(&readers, "readers", "1,2,4,8", "comma separated reader goroutine counts for the parallel read test, empty to skip it")
+	f.BoolVar(&readOnly, "readonly", false, "compare reads through a writable and a read-only handle, searching through each")
+	f.IntVar(&mmapFlags, "mmapflags", 0, "extra mmap flags for --readonly, e.g. 0x8000 for MAP_POPULATE on Linux")
+	f.IntVar(&initialMmapSize, "initialmmap", 0, "initial mmap size in bytes for --readonly")
+
+	for _, cmd := range []*cobra.Command{benchReadCmd, searchCmd} {
+		f := cmd.Flags()
+		f.IntVar(&searches, "searches", 100, "number of random A* queries, 0 to skip them")
+		f.IntVar(&checkHeuristic, "checkh", 0, "queries to check the heuristic against the true distances of their paths first, 0 for none")
+		f.BoolVar(&layouts, "layouts", false, "also run the queries against the grid written to fresh flat and split files, reading neighbors by Get and by range scan")
+		f.IntVar(&topKeys, "topkeys", 0, "most read keys of the cached search pass to report, 0 for none")
+		f.IntVar(&prefetchDepth, "prefetch", 8, "open set entries to prefetch after each expansion")
+		f.IntVar(&prefetchWorkers, "prefetchworkers", 4, "goroutines prefetching adjacency lists")
+	}
+
+	f = experimentCmd.Flags()
+	f.IntVar(&cacheBytes, "cache", 64<<20, "size of the LRU cache in front of bolt in the search workload")
+	f.IntVar(&prefetchDepth, "prefetch", 8, "open set entries to prefetch after each expansion")
+	f.IntVar(&prefetchWorkers, "prefetchworkers", 4, "goroutines prefetching adjacency lists")
+
+	dumpCmd.Flags().StringVar(&inputFormat, "format", "", "csv, jsonl or graphson; default is the file's extension")
+	verifyCmd.Flags().IntVar(&size, "size", 1000000, "number of entries to write")
+	migrateCmd.Flags().StringVar(&migratePath, "to", "migrated.db", "file to create")
+	historyCmd.Flags().IntVar(&historyLast, "last", 20, "runs to list, 0 for all")
+	historyCmd.Flags().StringVar(&historyMatch, "match", "", "list the results whose message holds this, e.g. \"search bolt\", rather than the runs")
+	sampleCmd.Flags().StringVar(&samplePath, "to", "sample.db", "file to create")
+	sampleCmd.Flags().IntVar(&sampleNodes, "nodes", 10000, "nodes to copy, 0 for every one reachable")
+	f = reorderCmd.Flags()
+	f.StringVar(&reorderPath, "to", "reordered.db", "file to create")
+	f.StringVar(&reorderBy, "by", graph.BFSOrder, "order: bfs, or hilbert along the nodes' coordinates")
+	f.IntVar(&searches, "searches", 100, "queries to compare the orders with")
+	f.IntVar(&reorderPages, "pages", 256, "pages the page cache the queries read through holds")
+	compactCmd.Flags().Int64Var(&compactTxMax, "txmax", 64<<20, "bytes of keys and values copied per transaction, 0 for one transaction")
+	reachCmd.Flags().IntVar(&reachHops, "hops", 0, "stop after this many hops, 0 for every reachable node")
+	distancesCmd.Flags().StringVar(&targets, "targets", "", "comma separated nodes to find the distances to, empty for every reachable node")
+	serveCmd.Flags().StringVar(&serveAddr, "addr", ":8080", "address to listen on")
+	serveCmd.Flags().DurationVar(&queryTimeout, "timeout", 0, "give up on a /path search after this long, 0 for never")
+	serveCmd.Flags().StringVar(&grpcAddr, "grpc", "", "address to answer the gRPC Graph service on, see rpc/graph.proto, empty for none")
+	serveCmd.Flags().BoolVar(&writable, "writable", false, "open the db writable and take PUT /node/{id}, a JSON array value, and DELETE /node/{id}, dropping what they make stale from the caches")
+	upgradeCmd.Flags().IntVar(&batchSize, "batch", 10000, "keys moved per transaction")
+	f = replayCmd.Flags()
+	f.StringVar(&replayPath, "to", "replay.db", "file to create for a spec's fresh backend")
+	f.StringVar(&algo, "algo", "astar", "shortest path search: astar, or ida or sma to bound its memory")
+	f.IntVar(&maxNodes, "maxnodes", 100000, "nodes --algo=sma holds at most, it has to fit a whole path")
+	f = shellCmd.Flags()
+	f.StringVar(&algo, "algo", "astar", "route's shortest path search: astar, or ida or sma to bound its memory")
+	f.IntVar(&maxNodes, "maxnodes", 100000, "nodes --algo=sma holds at most, it has to fit a whole path")
+	recoverCmd.Flags().StringVar(&walPath, "wal", "", "write-ahead log to replay")
+	recoverCmd.MarkFlagRequired("wal")
+
+	benchCmd.AddCommand(benchWriteCmd, benchReadCmd)
+	reportCmd.AddCommand(reportDiffCmd)
+	rootCmd.AddCommand(loadCmd, benchCmd, searchCmd, dumpCmd, verifyCmd, statsCmd,
+		checkCmd, backupCmd, compactCmd, diffCmd, reachCmd, distancesCmd, migrateCmd, sampleCmd, reorderCmd, upgradeCmd, serveCmd, shellCmd, experimentCmd,
+		reportCmd, replayCmd, snapshotCmd, recoverCmd, historyCmd)
+}
+
+// parseSyncFlag is the --sync policy.
+func parseSyncFlag() storage.SyncPolicy {
+	policy, err := storage.ParseSyncPolicy(syncFlag)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	return policy
+}
+
+// parseSearchFlag is the --algo search, spilling past --spill nodes.
+func parseSearchFlag() search.Func {
+	if spillFront > 0 {
+		if algo != "astar" {
+			run.Fatal("only astar spills", "algo", algo)
+		}
+		return bench.SpillFind("", spillFront, nil)
+	}
+	find, err := search.Parse(algo, maxNodes)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	return find
+}
+
+// parseAllocFlag is the --alloc mode.
+func parseAllocFlag() storage.Alloc {
+	alloc, err := storage.ParseAlloc(allocFlag)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	return alloc
+}
+
+// benchWrite writes the dataset to a map and then to a fresh bolt db,
+// compares the two and reports how bolt laid the file out.
+func benchWrite(ctx context.Context, policy storage.SyncPolicy) {
+	t := tuning()
+	slog.Info("start", "entries", size, "dataset", dataset, "seed", seed, "fill", fillPercent,
+		"no_grow_sync", t.NoGrowSync, "initial_mmap", t.InitialMmapSize, "alloc_size", t.AllocSize)
+	mapDb := storage.NewMap()
+	if intern {
+		mapDb.Interner = codec.NewInterner()
+	}
+	gc := run.ReadGC()
+	_, mapTime := bench.WriteTest(ctx, "map", mapDb, dataset, size)
+	mapGC := run.ReadGC().Since(gc)
+	slog.Info("write map", "took", mapTime, "gcs", mapGC.Cycles, "gc_pause", mapGC.Pause)
+	if intern {
+		distinct, hits, saved := mapDb.Interner.Stats()
+		slog.Info("interned map", "distinct", distinct, "hits", hits, "saved_bytes", saved)
+	}
+	if stopped(ctx) {
+		return
+	}
+
+	mapBolt := newBolt(policy, dbPath)
+	mapBolt.WriteMetadata(codecName, dataset, size)
+	bench.ChecksumOverhead(ctx, mapBolt.Codec, dataset, size)
+	if allocTest {
+		bench.AllocTest(ctx, mapBolt.Codec, dataset, size)
+	}
+	if prefixTest {
+		bench.PrefixTest(ctx, dataset, size, size, keyEncoding, seed)
+	}
+	if walPath != "" {
+		mapBolt.WAL = storage.OpenWAL(walPath)
+		// anything left over belongs to the previous, fresh db
+		err := mapBolt.WAL.Reset()
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+	}
+	if f, ok := parseFaultsFlag(); ok {
+		if mapBolt.WAL == nil {
+			run.Fatal("bench write only injects --faults into the --wal")
+		}
+		mapBolt.WAL.Inject(f)
+	}
+	defer mapBolt.Close()
+	var written int
+	var boltTime time.Duration
+	gc = run.ReadGC()
+	io, ioOK := run.ReadIO()
+	if recordPath != "" && (loadSearchers > 0 || pipeline) {
+		run.Fatal("--record only records the plain write, not --searchers or --pipeline")
+	}
+	switch {
+	case loadSearchers > 0:
+		if dataset != bench.GridDataset || pipeline {
+			run.Fatal("--searchers needs the grid dataset and no --pipeline")
+		}
+		written, boltTime = bench.LoadSearchTest(ctx, mapBolt, size, loadSearchers, seed)
+	case pipeline:
+		written, boltTime = bench.PipelineWriteTest(ctx, mapBolt, dataset, size, parseWorkers, encodeWorkers)
+	case recordPath != "":
+		rec := newRecorder(mapBolt, size)
+		written, boltTime = bench.WriteTest(ctx, "bolt", rec, dataset, size)
+		closeRecorder(rec)
+	default:
+		written, boltTime = bench.WriteTest(ctx, "bolt", mapBolt, dataset, size)
+	}
+	boltGC := run.ReadGC().Since(gc)
+	if written < size {
+		slog.Warn("write bolt interrupted", "written", written, "size", size, "took", boltTime)
+	} else {
+		slog.Info("write bolt", "took", boltTime, "gcs", boltGC.Cycles, "gc_pause", boltGC.Pause)
+	}
+	logWriteLimit(mapBolt)
+	if sampleFraction > 0 {
+		slog.Info("read-after-write samples ok", "keys", mapBolt.Sampled())
+	}
+	if dataset == bench.GridDataset && written == size {
+		writeGridCoords(mapBolt, size)
+	}
+	start := time.Now()
+	mapBolt.Checkpoint()
+	slog.Info("final bolt sync", "sync", policy.String(), "took", time.Since(start))
+	logCheckpoints(mapBolt, policy)
+	if after, ok := run.ReadIO(); ok && ioOK {
+		io = after.Since(io)
+		slog.Info("write bolt io", "write_calls", io.WriteCalls, "write_bytes", io.WriteBytes,
+			"calls_per_key", run.Round(float64(io.WriteCalls)/float64(written)),
+			"amplification", run.Amplification(io.WriteBytes, bench.DatasetBytes(dataset, written, size)))
+	}
+	mapBolt.PageReport()
+	slog.Info("write bolt/map", "ratio", run.Ratio(boltTime, mapTime))
+}
+
+// logWriteLimit reports the rates --keyrate and --byterate let mybolt
+// write at after the burst they allow, if they were set.
+func logWriteLimit(mybolt *storage.Bolt) {
+	s, ok := mybolt.WriteLimited()
+	if !ok {
+		return
+	}
+	slog.Info("write limit", "path", mybolt.Db.Path(), "keys_per_sec", math.Round(s.KeysPerSec()), "keyrate", writeLimit.KeysPerSec,
+		"bytes_per_sec", math.Round(s.BytesPerSec()), "byterate", writeLimit.BytesPerSec, "waited", s.Waited,
+		"burst_keys", s.BurstKeys, "burst_bytes", s.BurstBytes)
+}
+
+// logCheckpoints reports what the fsyncs of --sync N took, next to the
+// most entries one made durable, what a crash just before it would have
+// lost without a write-ahead log: the two sides of choosing N.
+func logCheckpoints(mybolt *storage.Bolt, policy storage.SyncPolicy) {
+	s := mybolt.Checkpoints()
+	if s.Checkpoints == 0 {
+		return
+	}
+	slog.Info("checkpoints", "path", mybolt.Db.Path(), "sync", policy.String(), "checkpoints", s.Checkpoints,
+		"sync_time", s.Synced, "max_unsynced", s.MaxUnsynced)
+}
+
+// writeGridCoords stores the position of every node of the grid, a chunk
+// at a time, so searches of the file find a heuristic in it.
+func writeGridCoords(mybolt *storage.Bolt, size int) {
+	const chunk = 1 << 16
+	start := time.Now()
+	coords := make(map[string][2]float64, min(size, chunk))
+	for i := 0; i < size; i++ {
+		coords[strconv.Itoa(i)] = bench.GridCoords(i, size)
+		if len(coords) == chunk || i == size-1 {
+			mybolt.WriteCoords(storage.XY, coords)
+			clear(coords)
+		}
+	}
+	slog.Info("grid coordinates", "nodes", size, "took", time.Since(start))
+}
+
+// dbPaths are the --shards files, or else just dbPath.
+func dbPaths() []string {
+	if shardPaths == "" {
+		return []string{dbPath}
+	}
+	return strings.Split(shardPaths, ",")
+}
+
+// openDb opens the existing db file at path for reading, failing unless
+// its metadata matches the layout flags.
+func openDb(path string, readOnly bool) *storage.Bolt {
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout:         time.Second,
+		ReadOnly:        readOnly,
+		MmapFlags:       mmapFlags,
+		InitialMmapSize: initialMmapSize,
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	checkMetadata(db)
+	mybolt := storage.WrapBolt(db, schema, keyEncoding)
+	mybolt.Codec = storage.NewCodec(codecName)
+	return mybolt
+}
+
+// count is the number of keys in mybolt.
+func count(mybolt *storage.Bolt) int {
+	n, err := mybolt.Count()
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	return n
+}
+
+// benchRead runs the read tests against the existing db, which must hold
+// a generated dataset.
+func benchRead(ctx context.Context) {
+	mybolt := openDb(dbPath, false)
+	defer mybolt.Db.Close()
+	mybolt.Alloc = parseAllocFlag()
+	mybolt.UnsafeKeys = unsafeKeys
+	size := count(mybolt)
+	if warmFraction > 0 {
+		bench.Warm(ctx, mybolt, size, warmBy, warmFraction, hotKeysPath)
+	}
+
+	var readTime, pooledTime time.Duration
+	pageCache(mybolt, "read")
+	readAllocs := bench.Mallocs(func() { readTime = bench.ReadTest(ctx, mybolt, size) })
+	slog.Info("read bolt", "took", readTime, "allocs_per_op", run.Round(float64(readAllocs)/float64(size)))
+	pageCache(mybolt, "pooled read")
+	pooledAllocs := bench.Mallocs(func() { pooledTime = bench.PooledReadTest(ctx, mybolt, size) })
+	slog.Info("pooled read bolt", "alloc", mybolt.Alloc, "took", pooledTime, "allocs_per_op", run.Round(float64(pooledAllocs)/float64(size)))
+	var getTime, intoTime time.Duration
+	pageCache(mybolt, "get")
+	getAllocs := bench.Mallocs(func() { getTime = bench.GetTest(ctx, mybolt, size, false) })
+	pageCache(mybolt, "get into")
+	intoAllocs := bench.Mallocs(func() { intoTime = bench.GetTest(ctx, mybolt, size, true) })
+	slog.Info("get into bolt", "get_took", getTime, "into_took", intoTime,
+		"get_allocs_per_op", run.Round(float64(getAllocs)/float64(size)), "into_allocs_per_op", run.Round(float64(intoAllocs)/float64(size)),
+		"saved_pct", run.Round(100*(float64(getAllocs)-float64(intoAllocs))/float64(getAllocs)))
+	if schema == storage.FlatSchema {
+		var zeroCopyTime time.Duration
+		pageCache(mybolt, "zero-copy read")
+		zeroCopyAllocs := bench.Mallocs(func() { zeroCopyTime = bench.ZeroCopyReadTest(ctx, mybolt, size) })
+		slog.Info("zero-copy read bolt", "codec", codecName, "took", zeroCopyTime,
+			"allocs_per_op", run.Round(float64(zeroCopyAllocs)/float64(size)))
+	}
+	pageCache(mybolt, "scan")
+	scanned, scanTime := bench.ScanTest(ctx, mybolt)
+	slog.Info("scan bolt", "took", scanTime, "keys", scanned)
+	slog.Info("read/scan", "ratio", run.Ratio(readTime, scanTime))
+	if intern {
+		bench.InternTest(ctx, mybolt, size)
+	}
+	if stopped(ctx) {
+		return
+	}
+
+	if cacheBytes > 0 {
+		reads := size
+		pageCache(mybolt, "random read")
+		randomTime := bench.RandomReadTest(ctx, mybolt, size, reads, seed)
+		slog.Info("random read bolt", "took", randomTime)
+		cached := cache.Wrap(mybolt, cacheBytes)
+		pageCache(mybolt, "random read cached")
+		cachedTime := bench.RandomReadTest(ctx, cached, size, reads, seed)
+		hits, misses := cached.Stats()
+		slog.Info("random read cached bolt", "took", cachedTime, "hits", hits, "misses", misses,
+			"cached", cached.Len())
+		slog.Info("random read bolt/cached", "ratio", run.Ratio(randomTime, cachedTime))
+	}
+	pageCache(mybolt, "parallel read")
+	bench.ReaderScaling(ctx, mybolt, size, readers, seed)
+}
+
+// searchDb runs the search test through a read-only handle, so several
+// can run against the same db at once, or through one per --shards file.
+func searchDb(ctx context.Context) {
+	r, size, closeAll := openReadOnly(ctx)
+	defer closeAll()
+	if mybolt, ok := r.(*storage.Bolt); ok {
+		pageCache(mybolt, "search")
+	}
+	if recordPath != "" {
+		rec := newRecorder(r, size)
+		defer closeRecorder(rec)
+		r = rec
+	}
+	bench.SearchTest(ctx, r, size, searches, searchOptions())
+}
+
+// parseFaultsFlag is --faults, ok is false without it.
+func parseFaultsFlag() (f storage.Faults, ok bool) {
+	if faultSpec == "" {
+		return f, false
+	}
+	f, err := storage.ParseFaults(faultSpec)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	return f, true
+}
+
+// injectFaults puts r, a store, behind a storage.Faulty for --faults.
+func injectFaults(r storage.Reader) storage.Reader {
+	f, ok := parseFaultsFlag()
+	if !ok {
+		return r
+	}
+	return storage.NewFaulty(r.(storage.Store), f)
+}
+
+// logInjected reports the faults injected into r, if any.
+func logInjected(r storage.Reader) {
+	if f, ok := r.(*storage.Faulty); ok {
+		spikes, failed, short := f.Injected()
+		slog.Info("injected faults", "spikes", spikes, "errors", failed, "short_writes", short)
+	}
+}
+
+// newRecorder starts recording the operations on r, a db of size keys,
+// to --record.
+func newRecorder(r storage.Reader, size int) *bench.Recorder {
+	rec, err := bench.NewRecorder(r, recordPath, dataset, size)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	return rec
+}
+
+// closeRecorder writes out the workload log of rec.
+func closeRecorder(rec *bench.Recorder) {
+	err := rec.Close()
+	if err != nil {
+		run.Fatal("recording failed", "path", recordPath, "err", err)
+	}
+	slog.Info("recorded", "path", recordPath, "ops", rec.Ops())
+}
+
+// openReadOnly opens the existing db, or the --shards files as one
+// store, read-only and warmed as the flags say, or else the --snapshot.
+// Returns the number of keys and a func closing it all.
+func openReadOnly(ctx context.Context) (r storage.Reader, size int, closeAll func()) {
+	return openExisting(ctx, true)
+}
+
+// openExisting is openReadOnly, opening the db writable unless readOnly.
+// A snapshot is always read-only.
+func openExisting(ctx context.Context, readOnly bool) (r storage.Reader, size int, closeAll func()) {
+	if snapshotPath != "" {
+		snap, err := storage.OpenSnapshot(snapshotPath)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		slog.Info("snapshot", "path", snapshotPath, "keys", snap.Len(), "bytes", snap.Bytes())
+		return snap, snap.Len(), func() { snap.Close() }
+	}
+	paths := dbPaths()
+	if len(paths) > 1 && warmFraction > 0 && warmBy != "scan" {
+		run.Fatal("shards can only be warmed by scan", "warmby", warmBy)
+	}
+	shards := make([]storage.Store, len(paths))
+	var in *codec.Interner
+	if intern {
+		// one table, the shards' values link to each other's keys
+		in = codec.NewInterner()
+	}
+	for i, p := range paths {
+		mybolt := openDb(p, readOnly)
+		mybolt.Interner = in
+		m, err := storage.ReadMetadata(mybolt.Db)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		if len(paths) > 1 && (m.Shard != i || m.Shards != len(paths)) {
+			run.Fatal("not that shard, give --shards in the order they were loaded", "path", p,
+				"shard", m.Shard, "of", m.Shards, "want", i)
+		}
+		n := count(mybolt)
+		if warmFraction > 0 {
+			bench.Warm(ctx, mybolt, n, warmBy, warmFraction, hotKeysPath)
+		}
+		size += n
+		shards[i] = mybolt
+	}
+	closeAll = func() {
+		for _, shard := range shards {
+			shard.(*storage.Bolt).Db.Close()
+		}
+		if in != nil {
+			distinct, hits, saved := in.Stats()
+			slog.Info("interned", "distinct", distinct, "hits", hits, "saved_bytes", saved)
+		}
+	}
+	if len(shards) == 1 {
+		return shards[0], size, closeAll
+	}
+	return storage.NewSharded(shards...), size, closeAll
+}
+
+// coordsHeuristic is the heuristic for the positions r keeps, see
+// storage.CoordsBucket, nil if it keeps none or not one for each of its
+// nodes.
+func coordsHeuristic(r storage.Reader, nodes int) search.Heuristic {
+	cr, ok := r.(interface {
+		ReadCoords() (string, map[string][2]float64, error)
+	})
+	if !ok {
+		return nil
+	}
+	kind, coords, err := cr.ReadCoords()
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	if coords == nil {
+		return nil
+	}
+	h, missing := search.CoordsHeuristic(kind, coords, nodes)
+	if missing > 0 {
+		slog.Warn("coordinates missing, searching without a heuristic", "kind", kind, "nodes", len(coords), "missing", missing)
+		return nil
+	}
+	slog.Info("coordinates", "kind", kind, "nodes", len(coords), "heuristic", h != nil)
+	return h
+}
+
+// stats reports what the existing db holds and how its pages are used.
+func stats() {
+	mybolt := openDb(dbPath, true)
+	defer mybolt.Db.Close()
+	fi, err := os.Stat(dbPath)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	slog.Info("stats", "path", dbPath, "keys", count(mybolt), "bytes", fi.Size())
+	mybolt.PageReport()
+}
+
+// readOnlyTest runs the read tests against the existing db file, first
+// through a writable handle and then through a read-only one. Read-only
+// handles only take a shared lock, so several search processes can have
+// the file open at once.
+func readOnlyTest(ctx context.Context) {
+	var times [2]time.Duration
+	for i, readOnly := range []bool{false, true} {
+		mybolt := openDb(dbPath, readOnly)
+		size := count(mybolt)
+		mode := "writable"
+		if readOnly {
+			mode = "read-only"
+		}
+		slog.Info("opened", "handle", mode, "entries", size)
+		if i == 0 && warmFraction > 0 {
+			// the page cache outlives the handle, warm it once
+			bench.Warm(ctx, mybolt, size, warmBy, warmFraction, hotKeysPath)
+		}
+		pageCache(mybolt, "read "+mode)
+		times[i] = bench.ReadTest(ctx, mybolt, size)
+		slog.Info("read bolt", "handle", mode, "took", times[i])
+		slog.Info("random read bolt", "handle", mode, "took", bench.RandomReadTest(ctx, mybolt, size, size, seed))
+		bench.ReaderScaling(ctx, mybolt, size, readers, seed)
+		if searches > 0 && cacheBytes > 0 {
+			bench.SearchTest(ctx, mybolt, size, searches, searchOptions())
+		}
+		mybolt.Db.Close()
+	}
+	slog.Info("read writable/read-only", "ratio", run.Ratio(times[0], times[1]))
+}
+
+// pageCache readies mybolt's file for a read phase as --pagecache says
+// and logs how much of it the page cache holds going in, without which
+// the phase's time says little.
+func pageCache(mybolt *storage.Bolt, phase string) {
+	switch pageCacheMode {
+	case "keep":
+	case "drop":
+		if warmFraction > 0 {
+			run.Fatal("--pagecache=drop undoes --warm")
+		}
+		if err := mybolt.DropCache(); err != nil {
+			run.Fatal(err.Error())
+		}
+	default:
+		run.Fatal("unknown --pagecache, want keep or drop", "pagecache", pageCacheMode)
+	}
+	cached, err := mybolt.Cached()
+	if err != nil {
+		slog.Debug("page cache unknown", "phase", phase, "err", err)
+		return
+	}
+	slog.Info("page cache", "phase", phase, "mode", pageCacheMode, "cached", run.Round(cached))
+}
+
+// layout is the bolt layout the flags ask for.
+func layout() storage.Layout {
+	return storage.Layout{Schema: schema, Keys: keyEncoding, Codec: codecName}
+}
+
+// openStore is storage.Open with unset bolt options from the flags.
+func openStore(spec, path string) storage.Store {
+	s := storage.Open(spec, path, layout(), boltOptions(storage.SyncAtClose)...)
+	if mybolt, ok := s.(*storage.Bolt); ok {
+		setWriteFlags(mybolt)
+	}
+	return s
+}
+
+// searchOptions are the search test flags.
+func searchOptions() bench.SearchOptions {
+	return bench.SearchOptions{
+		Dataset:         dataset,
+		Seed:            seed,
+		CacheBytes:      cacheBytes,
+		PrefetchDepth:   prefetchDepth,
+		PrefetchWorkers: prefetchWorkers,
+		PathCache:       pathCache,
+		Search:          algo,
+		MaxNodes:        maxNodes,
+		Spill:           spillFront,
+		CheckHeuristic:  checkHeuristic,
+		Layouts:         layouts,
+		TopKeys:         topKeys,
+	}
+}
+
+// setupLogging points the default slog logger at stdout, or stderr if
+// the command writes its data there, in the --log format and at the
+// --loglevel.
+func setupLogging(toStdout bool) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(logLevel))
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	out := os.Stdout
+	if toStdout {
+		// keep stdout for the data
+		out = os.Stderr
+	}
+	switch logFormat {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(out, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(out, opts)))
+	default:
+		run.Fatal("unknown --log format", "log", logFormat)
+	}
+	run.ProgressEvery = progressEvery
+}
+
+// boltOptions are the options the flags set, syncing by policy.
+func boltOptions(policy storage.SyncPolicy) []storage.Option {
+	opts := []storage.Option{storage.WithSyncPolicy(policy)}
+	if batchSize > 0 {
+		opts = append(opts, storage.WithBatchSize(batchSize))
+	}
+	if fillPercent > 0 {
+		opts = append(opts, storage.WithFillPercent(fillPercent))
+	}
+	if blockSize > 0 {
+		opts = append(opts, storage.WithBlockSize(blockSize))
+	}
+	if duplicates != "" {
+		opts = append(opts, storage.WithDuplicates(duplicates))
+	}
+	if writeLimit != (storage.WriteLimit{}) {
+		opts = append(opts, storage.WithWriteLimit(writeLimit))
+	}
+	return append(opts, storage.WithTuning(tuning()), storage.WithRetry(netRetry))
+}
+
+// tuning is the bolt settings the flags set, see storage.Tuning.
+func tuning() storage.Tuning {
+	return storage.Tuning{NoGrowSync: noGrowSync, InitialMmapSize: initialMmapSize, AllocSize: allocSize}
+}
+
+// newBolt creates a fresh bolt db at path set up as the flags say.
+func newBolt(policy storage.SyncPolicy, path string) *storage.Bolt {
+	mybolt := storage.NewBolt(schema, keyEncoding, append(boltOptions(policy), storage.WithPath(path))...)
+	mybolt.Codec = storage.NewCodec(codecName)
+	setWriteFlags(mybolt)
+	return mybolt
+}
+
+// setWriteFlags applies the flags about how mybolt flushes.
+func setWriteFlags(mybolt *storage.Bolt) {
+	mybolt.PageStats = pageStats
+	mybolt.CrashAfter = crashAfter
+	mybolt.SampleFraction = sampleFraction
+	mybolt.Seed = seed
+	mybolt.Alloc = parseAllocFlag()
+	mybolt.UnsafeKeys = unsafeKeys
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	handleSignals(cancel)
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		run.Exit(1)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jogo/goplayground/boltdb/bench"
+	"github.com/jogo/goplayground/boltdb/cache"
+	"github.com/jogo/goplayground/boltdb/search"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExperimentRuns(t *testing.T) {
+	schema, keyEncoding, codecName, dataset = "flat", "string", "json", "repeat"
+	configs := map[string]string{
+		"e.toml": `
+backends = ["map", "bolt", "bolt/split/uint64/binary"]
+codecs = ["json", "binary"]
+sizes = [10, 20]
+batch_sizes = [5]
+workloads = ["read", "scan"]
+`,
+		"e.yaml": `
+backends: [map, bolt, bolt/split/uint64/binary]
+codecs: [json, binary]
+sizes: [10, 20]
+batch_sizes: [5]
+workloads: [read, scan]
+`,
+	}
+	for path, config := range configs {
+		e, err := parseExperiment(path, []byte(config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		runs := e.runs()
+		// map once per size, bolt once per codec and size, the fully
+		// specified bolt once per size
+		if len(runs) != 2+4+2 {
+			t.Fatalf("%s: %d runs: %v", path, len(runs), runs)
+		}
+		if runs[2].Backend != "bolt/flat/string/json" || runs[2].BatchSize != 5 || runs[0].BatchSize != 0 {
+			t.Errorf("%s: runs %v", path, runs)
+		}
+		if e.Dataset != "repeat" || e.Report != "report.json" {
+			t.Errorf("%s: defaults not filled in: %+v", path, e)
+		}
+	}
+	e, err := parseExperiment("e.yaml", []byte(`backends: [map]
+gogc: [100, -1]
+ballast: [0, 1024]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := e.runs(); len(runs) != 4 || runs[3].GOGC != -1 || runs[3].Ballast != 1024 {
+		t.Errorf("gc runs %v", runs)
+	}
+	e, err = parseExperiment("e.yaml", []byte(`backends: [map, bolt]
+ingest: [pool, spawn]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// map is only written one way
+	if runs := e.runs(); len(runs) != 3 || runs[0].Ingest != "" || runs[2].Ingest != "spawn" {
+		t.Errorf("ingest runs %v", runs)
+	}
+	e, err = parseExperiment("e.toml", []byte(`backends = ["map", "bolt"]
+no_grow_sync = [false, true]
+alloc_sizes = [0, 1048576]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// map has no bolt settings to tune
+	if runs := e.runs(); len(runs) != 5 || runs[0].Tuning != (storage.Tuning{}) ||
+		runs[4].Tuning != (storage.Tuning{NoGrowSync: true, AllocSize: 1048576}) {
+		t.Errorf("tuning runs %v", runs)
+	}
+	_, err = parseExperiment("e.toml", []byte(`backends = ["bolt"]
+ingest = ["threads"]`))
+	if err == nil {
+		t.Error("unknown ingest accepted")
+	}
+	_, err = parseExperiment("e.toml", []byte(`backends = ["map"]
+workloads = ["fly"]`))
+	if err == nil {
+		t.Error("unknown workload accepted")
+	}
+}
+
+func TestReportDiff(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, runs ...experimentResult) string {
+		data, err := json.Marshal(experimentReport{Name: name, Runs: runs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	bolt := experimentRun{Backend: "bolt/flat/string/json", Size: 10, BatchSize: 5}
+	mapRun := experimentRun{Backend: "map", Size: 10}
+	oldPath := write("old",
+		experimentResult{experimentRun: bolt, WritesPerSec: 100, Seconds: map[string]float64{"write": 2, "read": 1}},
+		experimentResult{experimentRun: mapRun, Seconds: map[string]float64{"write": 0.5}})
+	bolt.Ingest = "pool"
+	newPath := write("new",
+		experimentResult{experimentRun: bolt, Seconds: map[string]float64{"write": 1}},
+		experimentResult{experimentRun: mapRun, Seconds: map[string]float64{"write": 0.25}})
+
+	var out strings.Builder
+	if err := reportDiff(&out, oldPath, newPath); err != nil {
+		t.Fatal(err)
+	}
+	fields := func(line string) string { return strings.Join(strings.Fields(line), " ") }
+	var lines []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		lines = append(lines, fields(line))
+	}
+	for _, want := range []string{
+		"map size=10 write s 0.500 0.250 -0.250 -50.0%",
+		"map size=10 writes/s 0 0 +0 -",
+		"only in old: bolt/flat/string/json size=10 batch=5",
+		"only in new: bolt/flat/string/json size=10 batch=5 ingest=pool",
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("no line %q in\n%s", want, out.String())
+		}
+	}
+}
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.db")
+	// printing warnings only, the journal still gets the results
+	printer := slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelWarn})
+	for i, took := range []time.Duration{2 * time.Second, time.Second} {
+		j := &journal{path: path, run: journalRun{
+			Command: "search",
+			Flags:   map[string]string{"searches": "10", "dataset": "grid"},
+			Started: time.Now(),
+		}}
+		log := slog.New(&journalHandler{Handler: printer, j: j}).With("backend", "bolt")
+		log.Debug("ignored")
+		log.Info("search bolt", "took", took, "expansions", 40+i)
+		log.Info("search cached bolt", "hits", 3)
+		j.finish(i == 1)
+		j.finish(false)
+	}
+
+	var out strings.Builder
+	if err := history(&out, path, 0, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 ||
+		!strings.HasPrefix(lines[1], "2 ") || !strings.Contains(lines[1], "failed  search --dataset=grid --searches=10") {
+		t.Errorf("last run:\n%s", out.String())
+	}
+	out.Reset()
+	if err := history(&out, path, 0, 0, "search bolt"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"search bolt backend=bolt expansions=40 took=2s", "search bolt backend=bolt expansions=41 took=1s"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("no %q in\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "cached") {
+		t.Errorf("unmatched results listed:\n%s", out.String())
+	}
+	out.Reset()
+	if err := history(&out, path, 1, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "run 1: search") || !strings.Contains(out.String(), "  search cached bolt backend=bolt hits=3\n") ||
+		strings.Contains(out.String(), "ignored") {
+		t.Errorf("run 1:\n%s", out.String())
+	}
+	if err := history(&out, path, 3, 0, ""); !errors.Is(err, errNoRun) {
+		t.Errorf("missing run: %v", err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	const size = 9
+	m := storage.NewMap()
+	for i := 0; i < size; i++ {
+		m.Writer(bench.GridKeyValue(i, size))
+	}
+	// a node nothing links to
+	m.Writer("island", nil)
+	paths := cache.NewPaths(8)
+	srv := httptest.NewServer(newHandler(m, nil, bench.GridHeuristic(size), paths.Wrap(nil), 0))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		url    string
+		status int
+		want   any
+	}{
+		{"/node/4", http.StatusOK, &nodeResponse{Key: "4", Value: []string{"3", "5", "1", "7"}}},
+		{"/node/nope", http.StatusNotFound, nil},
+		{"/path?from=0&to=8", http.StatusOK, nil},
+		{"/path?from=0&to=8", http.StatusOK, nil},
+		{"/path?from=0&to=island", http.StatusNotFound, nil},
+		{"/path?from=nope&to=1", http.StatusNotFound, nil},
+		{"/path?from=0", http.StatusBadRequest, nil},
+	} {
+		resp, err := http.Get(srv.URL + tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.url, resp.StatusCode, tt.status, body)
+			continue
+		}
+		if tt.want != nil {
+			got := &nodeResponse{}
+			if err := json.Unmarshal(body, got); err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: got %s, want %+v", tt.url, body, tt.want)
+			}
+		}
+		if strings.HasPrefix(tt.url, "/path") && tt.status == http.StatusOK {
+			var p pathResponse
+			if err := json.Unmarshal(body, &p); err != nil || len(p.Path) != 5 || p.Path[0] != "0" || p.Path[4] != "8" {
+				t.Errorf("%s: got %s, want a 4 step path", tt.url, body)
+			}
+		}
+	}
+	if hits, _ := paths.Stats(); hits != 1 {
+		t.Errorf("%d paths answered from the cache, want 1", hits)
+	}
+}
+
+func TestServeWrites(t *testing.T) {
+	const size = 9
+	m := storage.NewMap()
+	for i := 0; i < size; i++ {
+		m.Writer(bench.GridKeyValue(i, size))
+	}
+	c := cache.Wrap(m, 1<<20)
+	paths := cache.NewPaths(8)
+	n := &storage.Notifier{Store: m, OnChange: []func(string){c.Invalidate, paths.Invalidate}}
+	srv := httptest.NewServer(newHandler(c, n, bench.GridHeuristic(size), paths.Wrap(nil), 0))
+	defer srv.Close()
+
+	do := func(method, url, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		got, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(got)
+	}
+	// cached, then changed
+	for _, url := range []string{"/node/4", "/path?from=0&to=8"} {
+		if status, body := do("GET", url, ""); status != http.StatusOK {
+			t.Fatalf("%s: %d %s", url, status, body)
+		}
+	}
+	if status, body := do("PUT", "/node/4", `["5"]`); status != http.StatusNoContent {
+		t.Fatalf("put: %d %s", status, body)
+	}
+	if _, body := do("GET", "/node/4", ""); !strings.Contains(body, `"value":["5"]`) {
+		t.Errorf("node 4 after the put: %s", body)
+	}
+	if status, body := do("DELETE", "/node/0", ""); status != http.StatusNoContent {
+		t.Fatalf("delete: %d %s", status, body)
+	}
+	if status, body := do("GET", "/path?from=0&to=8", ""); status != http.StatusNotFound {
+		t.Errorf("path from a deleted node: %d %s", status, body)
+	}
+	if status, _ := do("PUT", "/node/1", "nope"); status != http.StatusBadRequest {
+		t.Errorf("put of a bad value: %d", status)
+	}
+	// read-only without a store to write to
+	ro := httptest.NewServer(newHandler(m, nil, bench.GridHeuristic(size), search.Find, 0))
+	defer ro.Close()
+	req, _ := http.NewRequest("PUT", ro.URL+"/node/1", strings.NewReader(`["2"]`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("put to a read-only server: %d", resp.StatusCode)
+	}
+}
+
+func TestServeFaults(t *testing.T) {
+	const size = 9
+	m := storage.NewMap()
+	for i := 0; i < size; i++ {
+		m.Writer(bench.GridKeyValue(i, size))
+	}
+	for _, tt := range []struct {
+		faults  storage.Faults
+		timeout time.Duration
+		status  int
+	}{
+		{storage.Faults{ErrorRate: 1}, 0, http.StatusServiceUnavailable},
+		{storage.Faults{SpikeRate: 1, Spike: 20 * time.Millisecond}, 10 * time.Millisecond, http.StatusGatewayTimeout},
+		{storage.Faults{SpikeRate: 1, Spike: time.Millisecond}, time.Second, http.StatusOK},
+	} {
+		faulty := storage.NewFaulty(m, tt.faults)
+		srv := httptest.NewServer(newHandler(faulty, nil, bench.GridHeuristic(size), search.Find, tt.timeout))
+		resp, err := http.Get(srv.URL + "/path?from=0&to=8")
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%+v, timeout %v: status %d, want %d", tt.faults, tt.timeout, resp.StatusCode, tt.status)
+		}
+	}
+}
+
+func TestShell(t *testing.T) {
+	const size = 9
+	m := storage.NewMap()
+	for i := 0; i < size; i++ {
+		m.Writer(bench.GridKeyValue(i, size))
+	}
+	m.Writer("island", []string{"0:2.5"})
+	sh := &shell{r: m, size: size + 1, h: bench.GridHeuristic(size), find: search.Find}
+	in := "help\nget 4\n\nneighbors island\nroute 0 8\nroute 0 island\nget\nscan\nstats\nfly\nquit\nget 0\n"
+	var out strings.Builder
+	sh.run(context.Background(), strings.NewReader(in), &out)
+	for _, want := range []string{
+		"> get <key>  ", // help
+		"\nexit  ",
+		"> 3 5 1 7\n",
+		"> 0  2.5\n(1 neighbors)\n",
+		"(4 hops, cost 4, ",
+		"> error: no path from 0 to island\n",
+		"> usage: get <key>\n",
+		"> error: scan needs a single bolt db\n",
+		"> keys: 10\n",
+		"> error: unknown command fly, try help\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("no %q in\n%s", want, out.String())
+		}
+	}
+	// nothing after quit
+	if !strings.HasSuffix(out.String(), "try help\n> ") {
+		t.Errorf("ran on after quit:\n%s", out.String())
+	}
+	out.Reset()
+	sh.run(context.Background(), strings.NewReader("exit\nget 0\n"), &out)
+	if out.String() != "> " {
+		t.Errorf("ran on after exit:\n%s", out.String())
+	}
+}
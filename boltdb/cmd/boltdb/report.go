@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// readReport reads an experiment's report.
+func readReport(path string) (experimentReport, error) {
+	var report experimentReport
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return report, err
+	}
+	err = json.Unmarshal(data, &report)
+	if err != nil {
+		return report, fmt.Errorf("report %s: %w", path, err)
+	}
+	return report, nil
+}
+
+// describe is the report read from path in a line.
+func (r experimentReport) describe(path string) string {
+	parts := []string{path}
+	if r.Name != "" {
+		parts = append(parts, r.Name)
+	}
+	if r.Revision != "" {
+		parts = append(parts, "revision "+r.Revision)
+	}
+	parts = append(parts, "started "+r.Started.Format(time.DateTime), fmt.Sprintf("%d runs", len(r.Runs)))
+	return strings.Join(parts, ", ")
+}
+
+// label names the run by its backend and whatever else of the matrix
+// it set.
+func (r experimentRun) label() string {
+	parts := []string{r.Backend, fmt.Sprintf("size=%d", r.Size)}
+	add := func(name string, value any, set bool) {
+		if set {
+			parts = append(parts, fmt.Sprintf("%s=%v", name, value))
+		}
+	}
+	add("batch", r.BatchSize, r.BatchSize != 0)
+	add("gogc", r.GOGC, r.GOGC != 0)
+	add("ballast", r.Ballast, r.Ballast != 0)
+	add("ingest", r.Ingest, r.Ingest != "")
+	add("no_grow_sync", r.NoGrowSync, r.NoGrowSync)
+	add("initial_mmap", r.InitialMmapSize, r.InitialMmapSize != 0)
+	add("alloc_size", r.AllocSize, r.AllocSize != 0)
+	return strings.Join(parts, " ")
+}
+
+// reportMetric is one number of a run the diff compares, format
+// printing it.
+type reportMetric struct {
+	name          string
+	before, after float64
+	format        string
+}
+
+// metrics are the numbers of a run before and after worth comparing:
+// every phase's time, in the order the phases ran, then the write rate,
+// peak heap and file size.
+func metrics(before, after experimentResult) []reportMetric {
+	var ms []reportMetric
+	for _, phase := range append([]string{"write"}, workloads...) {
+		b, inBefore := before.Seconds[phase]
+		a, inAfter := after.Seconds[phase]
+		if inBefore || inAfter {
+			ms = append(ms, reportMetric{phase + " s", b, a, "%.3f"})
+		}
+	}
+	ms = append(ms,
+		reportMetric{"writes/s", before.WritesPerSec, after.WritesPerSec, "%.0f"},
+		reportMetric{"peak heap", float64(before.PeakHeapBytes), float64(after.PeakHeapBytes), "%.0f"})
+	if before.Bytes != 0 || after.Bytes != 0 {
+		ms = append(ms, reportMetric{"bytes", float64(before.Bytes), float64(after.Bytes), "%.0f"})
+	}
+	return ms
+}
+
+// reportDiff prints a table of what changed from the experiment report
+// at oldPath to the one at newPath: for each run in both, each metric
+// before and after, with the difference and the difference as a
+// percentage of before. Runs only one report has are listed after it.
+func reportDiff(w io.Writer, oldPath, newPath string) error {
+	before, err := readReport(oldPath)
+	if err != nil {
+		return err
+	}
+	after, err := readReport(newPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "old: %s\nnew: %s\n\n", before.describe(oldPath), after.describe(newPath))
+
+	unmatched := make(map[experimentRun]experimentResult, len(after.Runs))
+	for _, res := range after.Runs {
+		unmatched[res.experimentRun] = res
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "run\tmetric\told\tnew\tdelta\tdelta %")
+	var onlyOld []string
+	for _, b := range before.Runs {
+		a, ok := unmatched[b.experimentRun]
+		if !ok {
+			onlyOld = append(onlyOld, b.label())
+			continue
+		}
+		delete(unmatched, b.experimentRun)
+		for _, m := range metrics(b, a) {
+			pct := "-"
+			if m.before != 0 {
+				pct = fmt.Sprintf("%+.1f%%", (m.after-m.before)/m.before*100)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t"+m.format+"\t"+m.format+"\t%+"+m.format[1:]+"\t%s\n",
+				b.label(), m.name, m.before, m.after, m.after-m.before, pct)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, label := range onlyOld {
+		fmt.Fprintf(w, "only in old: %s\n", label)
+	}
+	for _, a := range after.Runs {
+		if _, ok := unmatched[a.experimentRun]; ok {
+			fmt.Fprintf(w, "only in new: %s\n", a.label())
+		}
+	}
+	return nil
+}
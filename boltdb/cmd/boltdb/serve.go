@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jogo/goplayground/boltdb/bench"
+	"github.com/jogo/goplayground/boltdb/cache"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/rpc"
+	"github.com/jogo/goplayground/boltdb/search"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"google.golang.org/grpc"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// nodeResponse is what GET /node/{id} returns.
+type nodeResponse struct {
+	Key   string   `json:"key"`
+	Value []string `json:"value"`
+}
+
+// pathResponse is what GET /path returns.
+type pathResponse struct {
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	Path     []string `json:"path"`
+	Expanded int      `json:"expanded"`
+	Millis   float64  `json:"took_ms"`
+}
+
+// serve answers queries over HTTP, and gRPC if grpcAddr is set, until
+// ctx is done, from the existing db opened read-only with a cache in
+// front, the search half of the use case as a load generator such as wrk
+// would see it. With --writable it takes writes too, which drop the
+// values and paths they change from the caches.
+func serve(ctx context.Context, addr, grpcAddr string) {
+	r, size, closeAll := openExisting(ctx, !writable)
+	defer closeAll()
+	var n *storage.Notifier
+	if writable {
+		s, ok := r.(storage.Store)
+		if !ok {
+			run.Fatal("a snapshot can't be written", "snapshot", snapshotPath)
+		}
+		n = &storage.Notifier{Store: s}
+	}
+	var h search.Heuristic
+	if dataset == bench.GridDataset {
+		// exact, where the straight line between positions is not
+		h = bench.GridHeuristic(size)
+	} else if h = coordsHeuristic(r, size); h == nil {
+		// without a better estimate A* is Dijkstra
+		h = func(a, b string) float64 { return 0 }
+	}
+	r = injectFaults(r)
+	defer logInjected(r)
+	if cacheBytes > 0 {
+		c := cache.Wrap(r, cacheBytes)
+		if n != nil {
+			n.OnChange = append(n.OnChange, c.Invalidate)
+		}
+		r = c
+	}
+	var rec *bench.Recorder
+	if recordPath != "" {
+		// outside the cache, recording what clients asked for
+		rec = newRecorder(r, size)
+		defer closeRecorder(rec)
+		r = rec
+	}
+	var paths *cache.Paths
+	if pathCache > 0 {
+		paths = cache.NewPaths(pathCache)
+		if n != nil {
+			n.OnChange = append(n.OnChange, paths.Invalidate)
+		}
+	}
+	find := paths.Wrap(parseSearchFlag())
+	if rec != nil {
+		find = rec.Find(find)
+	}
+
+	if grpcAddr != "" {
+		lis, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		gs := grpc.NewServer()
+		rs := rpc.NewServer(r, h)
+		rs.Find = find
+		rpc.RegisterGraphServer(gs, rs)
+		go gs.Serve(lis)
+		defer gs.GracefulStop()
+		slog.Info("serving grpc", "addr", grpcAddr)
+	}
+	var w storage.Store
+	if n != nil {
+		w = n
+	}
+	srv := &http.Server{Addr: addr, Handler: newHandler(r, w, h, find, queryTimeout)}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	slog.Info("serving", "addr", addr, "keys", size, "cache", cacheBytes, "paths", pathCache, "search", algo, "writable", writable)
+	err := srv.ListenAndServe()
+	if err != http.ErrServerClosed {
+		run.Fatal(err.Error())
+	}
+	if c, ok := r.(*cache.Cache); ok {
+		hits, misses := c.Stats()
+		slog.Info("served", "hits", hits, "misses", misses)
+	}
+	if paths != nil {
+		hits, misses := paths.Stats()
+		slog.Info("served paths", "hits", hits, "misses", misses)
+	}
+}
+
+// newHandler serves GET /node/{id}, a node's value, and
+// GET /path?from=X&to=Y, the shortest path between two nodes, as JSON.
+// A search still running after timeout, if set, gives up. Unless w is
+// nil, PUT /node/{id} writes the JSON array in the body as the node's
+// value to w, and DELETE /node/{id} deletes the node, each flushed before
+// the response.
+func newHandler(r storage.Reader, w storage.Store, h search.Heuristic, find search.Func, timeout time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /node/{id}", func(w http.ResponseWriter, req *http.Request) {
+		key := req.PathValue("id")
+		value, err := r.Get(key)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		writeJSON(w, nodeResponse{Key: key, Value: value})
+	})
+	mux.HandleFunc("GET /path", func(w http.ResponseWriter, req *http.Request) {
+		from, to := req.URL.Query().Get("from"), req.URL.Query().Get("to")
+		if from == "" || to == "" {
+			http.Error(w, "want from and to", http.StatusBadRequest)
+			return
+		}
+		ctx := req.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		start := time.Now()
+		path, expanded, err := find(ctx, r, from, to, h)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		writeJSON(w, pathResponse{From: from, To: to, Path: path, Expanded: expanded,
+			Millis: float64(time.Since(start).Microseconds()) / 1000})
+	})
+	if w == nil {
+		return mux
+	}
+	mux.HandleFunc("PUT /node/{id}", func(rw http.ResponseWriter, req *http.Request) {
+		var value []string
+		if err := json.NewDecoder(req.Body).Decode(&value); err != nil {
+			http.Error(rw, "want a JSON array of strings: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Writer(req.PathValue("id"), value)
+		w.Flush()
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /node/{id}", func(rw http.ResponseWriter, req *http.Request) {
+		w.Delete(req.PathValue("id"))
+		w.Flush()
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+func httpError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, storage.ErrNotFound) || errors.Is(err, search.ErrNoPath):
+		status = http.StatusNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	case errors.Is(err, storage.ErrInjected):
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		slog.Debug("write response", "err", err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jogo/goplayground/boltdb/bench"
+	"github.com/jogo/goplayground/boltdb/graph"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/search"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// shellCommand is a command of the shell, taking at least min and at
+// most max arguments.
+type shellCommand struct {
+	name, usage, help string
+	min, max          int
+}
+
+var shellCommands = []shellCommand{
+	{"get", "get <key>", "the key's value", 1, 1},
+	{"scan", "scan [prefix] [n]", "the first n keys starting with prefix and their values, 10 by default", 0, 2},
+	{"neighbors", "neighbors <node>", "the node's edges with their weights", 1, 1},
+	{"route", "route <from> <to>", "the shortest path between two nodes, with --algo", 2, 2},
+	{"stats", "stats", "what the db holds", 0, 0},
+	{"help", "help", "this", 0, 0},
+	{"quit", "quit", "leave, as does the end of the input", 0, 0},
+	{"exit", "exit", "the same as quit", 0, 0},
+}
+
+// errUsage is a command the shell can't run as given.
+var errUsage = errors.New("usage:")
+
+// shell answers commands read a line at a time about a db opened
+// read-only, to look into a freshly built file without writing code.
+type shell struct {
+	r    storage.Reader
+	size int
+	h    search.Heuristic
+	find search.Func
+}
+
+// runShell opens the existing db read-only, as serve does, and answers
+// commands from stdin until it ends or ctx is done.
+func runShell(ctx context.Context) {
+	r, size, closeAll := openReadOnly(ctx)
+	defer closeAll()
+	var h search.Heuristic
+	if dataset == bench.GridDataset {
+		h = bench.GridHeuristic(size)
+	} else if h = coordsHeuristic(r, size); h == nil {
+		h = func(a, b string) float64 { return 0 }
+	}
+	sh := &shell{r: r, size: size, h: h, find: parseSearchFlag()}
+	sh.run(ctx, os.Stdin, os.Stdout)
+}
+
+// run answers the commands of in on out, prompting for each, until in
+// ends, a quit, or ctx is done. A command that fails says why and the
+// shell goes on.
+func (sh *shell) run(ctx context.Context, in io.Reader, out io.Writer) {
+	lines := bufio.NewScanner(in)
+	for !run.Done(ctx) {
+		fmt.Fprint(out, "> ")
+		if !lines.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		args := strings.Fields(lines.Text())
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "quit" || args[0] == "exit" {
+			return
+		}
+		err := sh.do(ctx, out, args[0], args[1:])
+		if errors.Is(err, errUsage) {
+			fmt.Fprintln(out, err)
+		} else if err != nil {
+			fmt.Fprintf(out, "error: %s\n", err)
+		}
+	}
+}
+
+func (sh *shell) do(ctx context.Context, out io.Writer, cmd string, args []string) error {
+	i := slices.IndexFunc(shellCommands, func(c shellCommand) bool { return c.name == cmd })
+	if i < 0 {
+		return fmt.Errorf("unknown command %s, try help", cmd)
+	}
+	if c := shellCommands[i]; len(args) < c.min || len(args) > c.max {
+		return fmt.Errorf("%w %s", errUsage, c.usage)
+	}
+	switch cmd {
+	case "get":
+		value, err := sh.r.Get(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s\n", strings.Join(value, " "))
+	case "scan":
+		return sh.scan(out, args)
+	case "neighbors":
+		return sh.neighbors(out, args[0])
+	case "route":
+		return sh.route(ctx, out, args[0], args[1])
+	case "stats":
+		sh.stats(out)
+	case "help":
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		for _, c := range shellCommands {
+			fmt.Fprintf(tw, "%s\t%s\n", c.usage, c.help)
+		}
+		tw.Flush()
+	}
+	return nil
+}
+
+func (sh *shell) scan(out io.Writer, args []string) error {
+	s, ok := sh.r.(interface {
+		ScanPrefix(prefix string, n int, fn func(key string, value []string) error) error
+	})
+	if !ok {
+		return errors.New("scan needs a single bolt db")
+	}
+	prefix, n := "", 10
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+			return fmt.Errorf("%w scan [prefix] [n], n a number of keys", errUsage)
+		}
+	}
+	keys := 0
+	err := s.ScanPrefix(prefix, n, func(key string, value []string) error {
+		keys++
+		fmt.Fprintf(out, "%s: %s\n", key, strings.Join(value, " "))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "(%d keys)\n", keys)
+	return nil
+}
+
+func (sh *shell) neighbors(out io.Writer, node string) error {
+	value, err := sh.r.Get(node)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, edge := range value {
+		dst, weight, err := graph.ParseEdge(edge)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%g\n", dst, weight)
+	}
+	tw.Flush()
+	fmt.Fprintf(out, "(%d neighbors)\n", len(value))
+	return nil
+}
+
+func (sh *shell) route(ctx context.Context, out io.Writer, from, to string) error {
+	start := time.Now()
+	path, expanded, err := sh.find(ctx, sh.r, from, to, sh.h)
+	if err != nil {
+		return err
+	}
+	took := time.Since(start)
+	cost, err := search.PathCost(sh.r, path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s\n(%d hops, cost %g, %d expanded, took %v)\n", strings.Join(path, " "),
+		len(path)-1, cost, expanded, took.Round(time.Microsecond))
+	return nil
+}
+
+// stats prints the number of keys and, for a bolt file, what its
+// metadata and last checkpoint say.
+func (sh *shell) stats(out io.Writer) {
+	fmt.Fprintf(out, "keys: %d\n", sh.size)
+	mybolt, ok := sh.r.(*storage.Bolt)
+	if !ok {
+		return
+	}
+	if fi, err := os.Stat(mybolt.Db.Path()); err == nil {
+		fmt.Fprintf(out, "file: %s, %d bytes\n", mybolt.Db.Path(), fi.Size())
+	}
+	if m, err := storage.ReadMetadata(mybolt.Db); err == nil {
+		fmt.Fprintf(out, "dataset: %s, size %d, written %s\n", m.Dataset, m.Size, m.Created.Format(time.DateTime))
+		fmt.Fprintf(out, "layout: %s/%s/%s, format version %d\n", m.Schema, m.Keys, m.Codec, m.Version)
+	}
+	if c, ok, err := storage.ReadCheckpoint(mybolt.Db); err == nil && ok {
+		fmt.Fprintf(out, "last checkpoint: flush %d, %d keys, %s\n", c.Flushes, c.Keys, c.Time.Format(time.DateTime))
+	}
+}
@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/RoaringBitmap/roaring/v2"
+	"github.com/boltdb/bolt"
+	"github.com/jogo/goplayground/boltdb/bench"
+	"github.com/jogo/goplayground/boltdb/codec"
+	"github.com/jogo/goplayground/boltdb/graph"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/search"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"log/slog"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dump writes every key/value of the existing db to path, or stdout for
+// "-", as CSV or JSON Lines in the layout load reads back, or as a
+// GraphSON graph for TinkerPop. A .gz suffix compresses the output, with
+// the format taken from the extension before it.
+func dump(ctx context.Context, path string) {
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	defer db.Close()
+	checkMetadata(db)
+	mybolt := storage.WrapBolt(db, schema, keyEncoding)
+	mybolt.Codec = storage.NewCodec(codecName)
+
+	out := os.Stdout
+	if path != "-" {
+		out, err = os.Create(path)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+	}
+	var zw *gzip.Writer
+	bw := bufio.NewWriter(out)
+	if strings.HasSuffix(path, ".gz") {
+		zw = gzip.NewWriter(out)
+		bw = bufio.NewWriter(zw)
+	}
+
+	var write func(key string, value []string) error
+	var flush func() error
+	switch format := fileFormat(strings.TrimSuffix(path, ".gz")); format {
+	case "csv":
+		c := csv.NewWriter(bw)
+		var row []string
+		write = func(key string, value []string) error {
+			row = append(append(row[:0], key), value...)
+			return c.Write(row)
+		}
+		flush = func() error {
+			c.Flush()
+			return c.Error()
+		}
+	case "jsonl", "ndjson":
+		e := json.NewEncoder(bw)
+		write = func(key string, value []string) error {
+			if value == nil {
+				value = []string{}
+			}
+			return e.Encode(jsonRecordOut{key, value})
+		}
+		flush = func() error { return nil }
+	case "graphson":
+		e := json.NewEncoder(bw)
+		var edgeID int64
+		write = func(key string, value []string) error {
+			v, err := graph.NewGraphSONVertex(key, value, &edgeID)
+			if err != nil {
+				return err
+			}
+			return e.Encode(v)
+		}
+		flush = func() error { return nil }
+	default:
+		run.Fatal("unknown --format", "format", format)
+	}
+
+	start := time.Now()
+	keys := 0
+	p := run.NewProgress("dump", 0)
+	err = mybolt.Iterate(func(key string, value []string) error {
+		if run.Done(ctx) {
+			return ctx.Err()
+		}
+		keys++
+		p.Update(keys)
+		return write(key, value)
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	// flush each layer into the next, innermost first
+	err = flush()
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if err == nil && out != os.Stdout {
+		err = out.Close()
+	}
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	slog.Info("dump", "path", path, "keys", keys, "took", time.Since(start))
+}
+
+// fileFormat is --format, or failing that the extension of path.
+func fileFormat(path string) string {
+	if inputFormat != "" {
+		return inputFormat
+	}
+	return strings.TrimPrefix(filepath.Ext(path), ".")
+}
+
+// backup writes a consistent snapshot of the existing db to path, or
+// stdout for "-". It only needs a read transaction, so it can run next to
+// --readonly searchers.
+func backup(path string) {
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	defer db.Close()
+	out := os.Stdout
+	if path != "-" {
+		out, err = os.Create(path)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+	}
+	start := time.Now()
+	var n int64
+	err = db.View(func(tx *bolt.Tx) error {
+		n, err = tx.WriteTo(out)
+		return err
+	})
+	if err == nil && out != os.Stdout {
+		err = out.Sync()
+		if err == nil {
+			err = out.Close()
+		}
+	}
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	slog.Info("backup", "path", path, "bytes", n, "took", time.Since(start))
+}
+
+// compact copies the existing db into a fresh, compacted file at path,
+// see storage.Compact, and reports how much smaller it is.
+func compact(ctx context.Context, path string) {
+	src, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	defer src.Close()
+	os.Remove(path)
+	dst, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	defer dst.Close()
+	// one fsync at the end, a crash only loses the copy
+	dst.NoSync = true
+	start := time.Now()
+	err = storage.Compact(ctx, dst, src, compactTxMax)
+	if run.Done(ctx) {
+		slog.Warn("compact interrupted, the copy is incomplete", "path", path)
+		return
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	var sizes [2]int64
+	for i, p := range []string{dbPath, path} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		sizes[i] = fi.Size()
+	}
+	slog.Info("compact", "from", dbPath, "to", path, "bytes_before", sizes[0], "bytes_after", sizes[1],
+		"ratio", run.Round(float64(sizes[1])/float64(sizes[0])), "took", time.Since(start))
+}
+
+// snapshot exports the existing db to a snapshot file at path, see
+// storage.Snapshot, and reports how big it is next to the db.
+func snapshot(path string) {
+	mybolt := openDb(dbPath, true)
+	defer mybolt.Db.Close()
+	start := time.Now()
+	keys, items, err := storage.WriteSnapshot(mybolt, path)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	var sizes [2]int64
+	for i, p := range []string{dbPath, path} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		sizes[i] = fi.Size()
+	}
+	slog.Info("snapshot", "path", path, "keys", keys, "items", items, "bytes", sizes[1],
+		"db_bytes", sizes[0], "ratio", run.Round(float64(sizes[1])/float64(sizes[0])), "took", time.Since(start))
+}
+
+// diff compares the existing db with the one at path, each read with the
+// layout in its own metadata so they can differ in codec, schema or key
+// encoding, and logs the keys only in one of them and those whose values
+// differ. Exits with status 1 if there are any.
+func diff(ctx context.Context, path string) {
+	var stores [2]storage.Store
+	for i, p := range []string{dbPath, path} {
+		db, err := bolt.Open(p, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		defer db.Close()
+		m, err := storage.ReadMetadata(db)
+		if err != nil {
+			run.Fatal("can't use the db", "path", p, "err", err)
+		}
+		if m.Upgrade != nil {
+			run.Fatal("can't use the db, finish its upgrade first", "path", p)
+		}
+		b := storage.WrapBolt(db, m.Schema, m.Keys)
+		b.Codec = storage.NewCodec(m.Codec)
+		stores[i] = b
+	}
+	start := time.Now()
+	samples := map[string]int{}
+	onlyA, onlyB, differ, err := storage.Diff(ctx, stores[0], stores[1], func(kind, key string, a, b []string) {
+		samples[kind]++
+		if samples[kind] <= 10 {
+			slog.Warn(kind, "key", key, "a", a, "b", b)
+		}
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	slog.Info("diff", "a", dbPath, "b", path, "only_a", onlyA, "only_b", onlyB, "differ", differ, "took", time.Since(start))
+	if onlyA+onlyB+differ > 0 {
+		run.Exit(1)
+	}
+}
+
+// jsonRecordOut is a line of JSON Lines as graph.LoadJSONL reads it.
+type jsonRecordOut struct {
+	Key   string   `json:"key"`
+	Value []string `json:"value"`
+}
+
+// migrate streams every key/value of the existing db, read with the
+// layout flags, into a fresh store opened from spec at path, e.g. to try
+// another codec or schema without regenerating the dataset, or to load
+// the dataset into another backend. A bolt target gets the metadata,
+// and the node positions come along to those that keep them.
+func migrate(ctx context.Context, spec, path string) {
+	if strings.Split(spec, "/")[0] == "map" {
+		run.Fatal("migrate needs a target that persists", "spec", spec)
+	}
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	defer db.Close()
+	m := checkMetadata(db)
+	from := storage.WrapBolt(db, schema, keyEncoding)
+	from.Codec = storage.NewCodec(codecName)
+
+	to := openStore(spec, path)
+	defer storage.Close(to)
+	if mybolt, ok := to.(*storage.Bolt); ok {
+		mybolt.WriteMetadata(storage.ParseLayout(spec, layout()).Codec, m.Dataset, m.Size)
+	}
+	start := time.Now()
+	keys, err := storage.Copy(ctx, from, to, m.Size)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	kind, coords, err := from.ReadCoords()
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	if c, ok := to.(interface {
+		WriteCoords(kind string, coords map[string][2]float64)
+	}); ok && coords != nil {
+		c.WriteCoords(kind, coords)
+	}
+	slog.Info("migrate", "from", dbPath, "to", path, "spec", spec, "keys", keys, "took", time.Since(start))
+}
+
+// sample copies the nodes nearest node, see graph.Sample, from the
+// existing db into a fresh one at path laid out the same, with the
+// positions of those that have one. It is a loaded db, read with
+// --dataset load.
+func sample(ctx context.Context, node, path string, k int) {
+	mybolt := openDb(dbPath, true)
+	defer mybolt.Db.Close()
+	to := openStore("bolt", path).(*storage.Bolt)
+	start := time.Now()
+	nodes, kept, dropped, err := graph.Sample(ctx, mybolt, to, node, k)
+	if err != nil {
+		to.Close()
+		os.Remove(path)
+		run.Fatal(err.Error(), "node", node)
+	}
+	to.WriteMetadata(codecName, bench.LoadedDataset, len(nodes))
+	kind, coords, err := mybolt.CoordsOf(nodes)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	if coords != nil {
+		to.WriteCoords(kind, coords)
+	}
+	to.Close()
+	slog.Info("sample", "from", dbPath, "to", path, "node", node, "nodes", len(nodes),
+		"edges", kept, "dropped_edges", dropped, "coords", len(coords), "took", time.Since(start))
+}
+
+// reorder renumbers the nodes of the existing db in the order by, see
+// graph.Order, into a fresh db at path with uint64 keys, which bolt keeps
+// in numeric order, so nodes near each other in the graph share pages.
+// It then runs the same queries against both with a page cache of pages
+// pages, to see what the order saves a search on a db larger than memory.
+func reorder(ctx context.Context, by, path string, queries, pages int) {
+	mybolt := openDb(dbPath, true)
+	defer mybolt.Db.Close()
+	kind, coords, err := mybolt.ReadCoords()
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	start := time.Now()
+	order, err := graph.Order(ctx, mybolt, by, coords)
+	if run.Done(ctx) {
+		return
+	}
+	if err != nil {
+		run.Fatal(err.Error(), "by", by)
+	}
+	slog.Info("order", "by", by, "nodes", len(order), "took", time.Since(start))
+	to := openStore("bolt/"+storage.Uint64Keys, path).(*storage.Bolt)
+	ids, err := graph.Renumber(ctx, mybolt, to, order)
+	if err != nil {
+		to.Close()
+		os.Remove(path)
+		if run.Done(ctx) {
+			return
+		}
+		run.Fatal(err.Error())
+	}
+	defer to.Close()
+	to.WriteMetadata(codecName, bench.LoadedDataset, len(order))
+	if coords != nil {
+		renamed := make(map[string][2]float64, len(coords))
+		for node, c := range coords {
+			if id, ok := ids[node]; ok {
+				renamed[id] = c
+			}
+		}
+		to.WriteCoords(kind, renamed)
+	}
+	slog.Info("reorder", "from", dbPath, "to", path, "by", by, "nodes", len(order), "ids", len(ids),
+		"took", time.Since(start))
+
+	// the same queries, with the heuristic of the original IDs, so both
+	// expand the same nodes and only the pages they are on differ
+	var h search.Heuristic
+	if dataset == bench.GridDataset {
+		h = bench.GridHeuristic(len(order))
+	} else if h = coordsHeuristic(mybolt, len(order)); h == nil {
+		h = func(a, b string) float64 { return 0 }
+	}
+	old := make(map[string]string, len(ids))
+	for node, id := range ids {
+		old[id] = node
+	}
+	renumbered := func(a, b string) float64 { return h(old[a], old[b]) }
+	// drawn from the nodes in key order, the same queries whatever by
+	nodes := slices.Clone(order)
+	slices.Sort(nodes)
+	rnd := rand.New(rand.NewSource(seed))
+	pairs := make([][2]string, queries)
+	renamed := make([][2]string, queries)
+	for i := range pairs {
+		pairs[i] = [2]string{nodes[rnd.Intn(len(nodes))], nodes[rnd.Intn(len(nodes))]}
+		renamed[i] = [2]string{ids[pairs[i][0]], ids[pairs[i][1]]}
+	}
+	var hitRates [2]float64
+	for i, db := range []*storage.Bolt{mybolt, to} {
+		onPages, err := db.Pages()
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		if i == 0 {
+			hitRates[i] = bench.LocalityTest(ctx, db, onPages, pairs, h, pages, "original")
+		} else {
+			hitRates[i] = bench.LocalityTest(ctx, db, onPages, renamed, renumbered, pages, by)
+		}
+		if run.Done(ctx) {
+			return
+		}
+	}
+	// the misses of the original order for each of by's
+	slog.Info("reorder locality", "by", by, "cache_pages", pages, "original_hit_rate", run.Round(hitRates[0]),
+		"hit_rate", run.Round(hitRates[1]), "miss_ratio", run.Round((1-hitRates[0])/(1-hitRates[1])))
+}
+
+// upgrade rewrites the existing db in place to the current format and the
+// layout of spec, which defaults to the db's own, see storage.Upgrade.
+// Run again after an interruption to pick up where it stopped.
+func upgrade(ctx context.Context, spec string) {
+	if spec != "" && !strings.HasPrefix(spec, "bolt") {
+		run.Fatal("upgrade needs a bolt spec", "spec", spec)
+	}
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	defer db.Close()
+	start := time.Now()
+	m, err := storage.Upgrade(ctx, db, spec, storage.WithBatchSize(batchSize))
+	if run.Done(ctx) {
+		slog.Warn("upgrade interrupted, run it again to finish", "path", dbPath)
+		return
+	}
+	if err != nil {
+		run.Fatal("upgrade failed", "path", dbPath, "err", err)
+	}
+	slog.Info("upgrade", "path", dbPath, "version", m.Version, "schema", m.Schema, "keys", m.Keys,
+		"codec", m.Codec, "took", time.Since(start))
+}
+
+// verify loads the same dataset into the two backends named and
+// checks that every key reads back the same from both, that iterating
+// each yields every key exactly once with the same value as Get, and
+// that two bolt backends with the same key encoding iterate in the same
+// order.
+func verify(ctx context.Context, names []string, size int) {
+	stores := make([]storage.Store, 2)
+	for i, name := range names {
+		stores[i] = openStore(name, fmt.Sprintf("verify-%c.db", 'a'+i))
+		defer storage.Close(stores[i])
+		written, writeTime := bench.WriteTest(ctx, name, stores[i], dataset, size)
+		slog.Info("write", "backend", name, "took", writeTime)
+		if written < size {
+			slog.Warn("interrupted, not verifying", "written", written, "size", size)
+			return
+		}
+	}
+
+	mismatches := 0
+	mismatch := func(format string, args ...interface{}) {
+		mismatches++
+		if mismatches <= 10 {
+			slog.Warn("mismatch", "detail", fmt.Sprintf(format, args...))
+		}
+	}
+	for i := 0; i < size; i++ {
+		key, want := bench.Generate(dataset, i, size)
+		for j, s := range stores {
+			got, err := s.Get(key)
+			if err != nil {
+				mismatch("%s: get %s: %s", names[j], key, err)
+			} else if !storage.SameValue(got, want) {
+				mismatch("%s: key %s is %q, wrote %q", names[j], key, got, want)
+			}
+		}
+	}
+
+	orders := make([][]string, 2)
+	for j, s := range stores {
+		seen := make(map[string]bool, size)
+		err := s.Iterate(func(key string, value []string) error {
+			if seen[key] {
+				mismatch("%s: iterated %s twice", names[j], key)
+			}
+			seen[key] = true
+			got, err := s.Get(key)
+			if err != nil || !storage.SameValue(got, value) {
+				mismatch("%s: iterated %s as %q, get returns %q (%v)", names[j], key, value, got, err)
+			}
+			if _, ok := s.(*storage.Bolt); ok {
+				orders[j] = append(orders[j], key)
+			}
+			return nil
+		})
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		if len(seen) != size {
+			mismatch("%s: iterated %d keys, wrote %d", names[j], len(seen), size)
+		}
+	}
+	a, aOk := stores[0].(*storage.Bolt)
+	b, bOk := stores[1].(*storage.Bolt)
+	if aOk && bOk && a.Keys() == b.Keys() {
+		if !reflect.DeepEqual(orders[0], orders[1]) {
+			mismatch("%s and %s iterate in different orders", names[0], names[1])
+		}
+	}
+
+	if mismatches > 0 {
+		run.Fatal("verify failed", "backends", names, "mismatches", mismatches)
+	}
+	slog.Info("verify ok", "backends", names, "keys", size)
+}
+
+// check audits the existing db file: bolt's own consistency check of the
+// page structure, then a pass decoding every value with the --codec and
+// --schema the file is expected to have.
+func check(path string) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	defer db.Close()
+	checkMetadata(db)
+	mybolt := storage.WrapBolt(db, schema, keyEncoding)
+	mybolt.Codec = storage.NewCodec(codecName)
+	pageErrors, badValues, _, err := mybolt.Check()
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	if pageErrors+badValues > 0 {
+		run.Fatal("check failed", "path", path, "page_errors", pageErrors, "undecodable", badValues)
+	}
+	slog.Info("check ok", "path", path)
+}
+
+// recoverBolt replays the write-ahead log into the existing db file,
+// checks every replayed key reads back as logged and checkpoints the log.
+func recoverBolt(path, walPath string) {
+	db := storage.OpenFile(path)
+	checkMetadata(db)
+	// what survives a crash even without the log
+	c, ok, err := storage.ReadCheckpoint(db)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	if ok {
+		slog.Info("last checkpoint", "flushes", c.Flushes, "keys", c.Keys, "batches", c.Batches,
+			"unsynced", c.Unsynced, "first", c.First, "last", c.Last, "time", c.Time)
+	}
+	mybolt := storage.WrapBolt(db, schema, keyEncoding)
+	mybolt.Codec = storage.NewCodec(codecName)
+	storage.Recover(mybolt, walPath)
+}
+
+// reach logs how many nodes are within --hops of node, frontier by
+// frontier, combining the stored bitmaps of a roaring db without decoding
+// them to lists.
+func reach(ctx context.Context, node string, hops int) {
+	from, err := strconv.ParseUint(node, 10, 32)
+	if err != nil {
+		run.Fatal("reach needs a uint32 node ID", "node", node)
+	}
+	mybolt := openDb(dbPath, true)
+	defer mybolt.Db.Close()
+	start := time.Now()
+	var reached *roaring.Bitmap
+	var frontiers []uint64
+	err = mybolt.View(func(v *storage.RawView) error {
+		var k []byte
+		neighbors := func(id uint32) (*roaring.Bitmap, error) {
+			k = mybolt.AppendIntKey(k[:0], int(id))
+			data, err := v.GetKey(k)
+			if err == storage.ErrNotFound {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return codec.Bitmap(mybolt.Codec, data)
+		}
+		var err error
+		reached, frontiers, err = search.Reach(ctx, neighbors, uint32(from), hops)
+		return err
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	slog.Info("reach", "from", node, "hops", len(frontiers)-1, "reached", reached.GetCardinality(),
+		"widest", slices.Max(frontiers), "bitmap_bytes", reached.GetSizeInBytes(), "took", time.Since(start))
+	slog.Debug("reach frontiers", "sizes", frontiers)
+}
+
+// distances fills a row of the distance table in storage.DistancesBucket
+// for each of sources, the distance to every node of targets, or to every
+// node reachable if targets is empty, each one Dijkstra pass.
+func distances(ctx context.Context, sources, targets []string) {
+	mybolt := openDb(dbPath, false)
+	defer mybolt.Db.Close()
+	wanted := make(map[string]bool, len(targets))
+	for _, to := range targets {
+		wanted[to] = true
+	}
+	for _, from := range sources {
+		start := time.Now()
+		dist := make(map[string]float64)
+		expanded, err := search.Distances(ctx, mybolt, from, targets, func(node string, d float64) error {
+			if len(targets) == 0 || wanted[node] {
+				dist[node] = d
+			}
+			return nil
+		})
+		if run.Done(ctx) {
+			slog.Warn("interrupted, skipping the remaining sources", "source", from)
+			return
+		}
+		if err != nil {
+			run.Fatal(err.Error(), "source", from)
+		}
+		if err := mybolt.WriteDistances(from, dist); err != nil {
+			run.Fatal(err.Error())
+		}
+		slog.Info("distances", "source", from, "reached", len(dist), "expansions", expanded, "took", time.Since(start))
+		for _, to := range targets {
+			if d, ok := dist[to]; ok {
+				slog.Debug("distance", "from", from, "to", to, "distance", d)
+			} else {
+				slog.Debug("no path", "from", from, "to", to)
+			}
+		}
+	}
+}
+
+// replay issues the operations of the workload log at path, see
+// bench.Recorder, to a fresh backend for spec, or else to the existing
+// db read-only, skipping the writes, and reports what each kind of
+// operation took. The digest tells whether two replays got the same
+// answers.
+func replay(ctx context.Context, path, spec string) {
+	var r storage.Reader
+	var w storage.Store
+	var h search.Heuristic
+	if spec == "" {
+		var closeAll func()
+		var size int
+		r, size, closeAll = openReadOnly(ctx)
+		defer closeAll()
+		h = coordsHeuristic(r, size)
+		r = injectFaults(r)
+	} else {
+		w = openStore(spec, replayPath)
+		defer storage.Close(w)
+		if f, ok := parseFaultsFlag(); ok {
+			w = storage.NewFaulty(w, f)
+		}
+		r = w
+	}
+	defer logInjected(r)
+	replayed, err := bench.Replay(ctx, path, r, w, parseSearchFlag(), h)
+	if err != nil {
+		run.Fatal(err.Error(), "log", path)
+	}
+	for _, op := range []struct {
+		name  string
+		stats bench.OpStats
+	}{
+		{"put", replayed.Puts}, {"get", replayed.Gets}, {"delete", replayed.Deletes},
+		{"flush", replayed.Flushes}, {"search", replayed.Searches},
+	} {
+		if op.stats.Count == 0 {
+			continue
+		}
+		slog.Info("replayed", "op", op.name, "count", op.stats.Count, "took", op.stats.Took,
+			"per_op", op.stats.Took/time.Duration(op.stats.Count), "misses", op.stats.Misses, "errors", op.stats.Errors)
+	}
+	slog.Info("replay", "log", path, "spec", spec, "dataset", replayed.Dataset, "size", replayed.Size,
+		"expansions", replayed.Expanded, "skipped_writes", replayed.Skipped, "digest", fmt.Sprintf("%016x", replayed.Digest))
+}
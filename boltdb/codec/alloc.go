@@ -0,0 +1,49 @@
+package codec
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// MarshalAppend is c.Marshal appending to dst, so the caller can reuse
+// buffers. Codecs other than Binary, checksummed or not, still allocate
+// their own and copy it.
+func MarshalAppend(c Codec, dst []byte, value []string) ([]byte, error) {
+	switch c := c.(type) {
+	case Binary:
+		return c.append(dst, value), nil
+	case Checksum:
+		return appendChecksummed(c.Inner, dst, value)
+	case rangeChecksum:
+		return appendChecksummed(c.Inner, dst, value)
+	}
+	data, err := c.Marshal(value)
+	return append(dst, data...), err
+}
+
+func appendChecksummed(inner Codec, dst []byte, value []string) ([]byte, error) {
+	data, err := MarshalAppend(inner, dst, value)
+	if err != nil {
+		return nil, err
+	}
+	return binary.BigEndian.AppendUint32(data, crc32.Checksum(data[len(dst):], crcTable)), nil
+}
+
+// UnmarshalArena is c.UnmarshalInto with the items of a Ranger's values
+// cut out of one string holding the whole value, one allocation per
+// value instead of one per item. The items keep the whole value alive.
+func UnmarshalArena(c Codec, data []byte, dst []string) ([]string, error) {
+	r, ok := c.(Ranger)
+	if !ok {
+		return c.UnmarshalInto(data, dst)
+	}
+	arena := string(data)
+	value := dst[:0]
+	err := r.Range(data, func(item []byte) bool {
+		// item is a slice of data, its offset is the capacity it lost
+		start := cap(data) - cap(item)
+		value = append(value, arena[start:start+len(item)])
+		return true
+	})
+	return value, err
+}
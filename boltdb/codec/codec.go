@@ -0,0 +1,193 @@
+// Package codec converts values, lists of strings such as adjacency
+// lists, to and from the bytes a storage backend keeps.
+package codec
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+// Codec converts values to and from their stored form
+type Codec interface {
+	Marshal(value []string) ([]byte, error)
+	Unmarshal(data []byte) ([]string, error)
+	// UnmarshalInto decodes into dst[:0], reusing its backing array
+	UnmarshalInto(data []byte, dst []string) ([]string, error)
+}
+
+type JSON struct{}
+
+func (JSON) Marshal(value []string) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSON) Unmarshal(data []byte) ([]string, error) {
+	var value []string
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+func (JSON) UnmarshalInto(data []byte, dst []string) ([]string, error) {
+	value := dst[:0]
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// Binary stores a value as a uvarint count followed by each string
+// prefixed with its uvarint length. Unlike JSON it can be walked in place
+// without decoding, see Ranger.
+type Binary struct{}
+
+var ErrBinaryValue = errors.New("corrupt binary value")
+
+func (Binary) Marshal(value []string) ([]byte, error) {
+	n := binary.MaxVarintLen64
+	for _, s := range value {
+		n += binary.MaxVarintLen64 + len(s)
+	}
+	return Binary{}.append(make([]byte, 0, n), value), nil
+}
+
+func (Binary) append(dst []byte, value []string) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(value)))
+	for _, s := range value {
+		dst = binary.AppendUvarint(dst, uint64(len(s)))
+		dst = append(dst, s...)
+	}
+	return dst
+}
+
+func (c Binary) Unmarshal(data []byte) ([]string, error) {
+	return c.UnmarshalInto(data, nil)
+}
+
+func (c Binary) UnmarshalInto(data []byte, dst []string) ([]string, error) {
+	value := dst[:0]
+	err := c.Range(data, func(item []byte) bool {
+		value = append(value, string(item))
+		return true
+	})
+	return value, err
+}
+
+func (Binary) Range(data []byte, fn func(item []byte) bool) error {
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return ErrBinaryValue
+	}
+	data = data[n:]
+	for ; count > 0; count-- {
+		l, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < l {
+			return ErrBinaryValue
+		}
+		if !fn(data[n : n+int(l)]) {
+			return nil
+		}
+		data = data[n+int(l):]
+	}
+	return nil
+}
+
+// Ranger is implemented by codecs that can walk the items of an encoded
+// value without decoding it, e.g. straight out of bolt's mmap. item is
+// only valid until fn returns, fn returns false to stop.
+type Ranger interface {
+	Codec
+	Range(data []byte, fn func(item []byte) bool) error
+}
+
+// Checksum appends a CRC32 of the encoded value and checks it on decode,
+// so values damaged on disk (say by a NoSync crash) are reported instead
+// of silently decoded.
+type Checksum struct {
+	Inner Codec
+}
+
+var (
+	crcTable      = crc32.MakeTable(crc32.Castagnoli)
+	ErrChecksum   = errors.New("value checksum mismatch")
+	ErrNoChecksum = errors.New("value too short for a checksum")
+)
+
+func (c Checksum) Marshal(value []string) ([]byte, error) {
+	data, err := c.Inner.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return binary.BigEndian.AppendUint32(data, crc32.Checksum(data, crcTable)), nil
+}
+
+func (c Checksum) Unmarshal(data []byte) ([]string, error) {
+	data, err := c.check(data)
+	if err != nil {
+		return nil, err
+	}
+	return c.Inner.Unmarshal(data)
+}
+
+func (c Checksum) UnmarshalInto(data []byte, dst []string) ([]string, error) {
+	data, err := c.check(data)
+	if err != nil {
+		return nil, err
+	}
+	return c.Inner.UnmarshalInto(data, dst)
+}
+
+// Unwrap returns the checksummed codec.
+func (c Checksum) Unwrap() Codec {
+	return c.Inner
+}
+
+// check verifies and strips the checksum.
+func (c Checksum) check(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, ErrNoChecksum
+	}
+	n := len(data) - 4
+	if crc32.Checksum(data[:n], crcTable) != binary.BigEndian.Uint32(data[n:]) {
+		return nil, ErrChecksum
+	}
+	return data[:n], nil
+}
+
+// rangeChecksum is Checksum around a Ranger.
+type rangeChecksum struct {
+	Checksum
+}
+
+func (c rangeChecksum) Range(data []byte, fn func(item []byte) bool) error {
+	data, err := c.check(data)
+	if err != nil {
+		return err
+	}
+	return c.Inner.(Ranger).Range(data, fn)
+}
+
+// Parse accepts json, binary or roaring, with a +crc suffix for
+// checksums.
+func Parse(name string) (Codec, error) {
+	if inner := strings.TrimSuffix(name, "+crc"); inner != name {
+		c, err := Parse(inner)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := c.(Ranger); ok {
+			return rangeChecksum{Checksum{c}}, nil
+		}
+		return Checksum{c}, nil
+	}
+	switch name {
+	case "json":
+		return JSON{}, nil
+	case "binary":
+		return Binary{}, nil
+	case "roaring":
+		return Roaring{}, nil
+	}
+	return nil, fmt.Errorf("unknown codec: %q", name)
+}
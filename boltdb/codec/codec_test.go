@@ -0,0 +1,129 @@
+package codec
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+// sameValue compares values the way they round trip, nil and empty are
+// the same.
+func sameValue(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// fuzzCodec checks that no input makes the named codec panic, that the
+// decoding methods agree with each other and that whatever decodes
+// survives a round trip.
+func fuzzCodec(f *testing.F, name string) {
+	c, err := Parse(name)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, value := range [][]string{nil, {""}, {"a", "bb", "世"}, {"\x00", "\"\\"}} {
+		data, err := c.Marshal(value)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+		f.Add(data[:len(data)/2])
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		value, err := c.Unmarshal(data)
+		into, intoErr := c.UnmarshalInto(data, make([]string, 3))
+		if (err == nil) != (intoErr == nil) || (err == nil && !sameValue(value, into)) {
+			t.Fatalf("Unmarshal = %q, %v but UnmarshalInto = %q, %v", value, err, into, intoErr)
+		}
+		if raw, ok := c.(Ranger); ok {
+			var items []string
+			rangeErr := raw.Range(data, func(item []byte) bool {
+				items = append(items, string(item))
+				return true
+			})
+			if (err == nil) != (rangeErr == nil) || (err == nil && !sameValue(value, items)) {
+				t.Fatalf("Unmarshal = %q, %v but Range = %q, %v", value, err, items, rangeErr)
+			}
+		}
+		arena, arenaErr := UnmarshalArena(c, data, make([]string, 3))
+		if (err == nil) != (arenaErr == nil) || (err == nil && !sameValue(value, arena)) {
+			t.Fatalf("Unmarshal = %q, %v but UnmarshalArena = %q, %v", value, err, arena, arenaErr)
+		}
+		if err != nil {
+			return
+		}
+		again, err := c.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := c.Unmarshal(again)
+		if err != nil || !sameValue(value, decoded) {
+			t.Fatalf("%q round trips to %q, %v", value, decoded, err)
+		}
+		appended, err := MarshalAppend(c, []byte("dst"), value)
+		if err != nil || string(appended) != "dst"+string(again) {
+			t.Fatalf("MarshalAppend of %q = %q, %v, want dst%q", value, appended, err, again)
+		}
+	})
+}
+
+func FuzzJSONCodec(f *testing.F)           { fuzzCodec(f, "json") }
+func FuzzBinaryCodec(f *testing.F)         { fuzzCodec(f, "binary") }
+func FuzzJSONChecksumCodec(f *testing.F)   { fuzzCodec(f, "json+crc") }
+func FuzzBinaryChecksumCodec(f *testing.F) { fuzzCodec(f, "binary+crc") }
+
+func TestUnmarshalInterned(t *testing.T) {
+	for _, name := range []string{"json", "binary", "binary+crc"} {
+		c, err := Parse(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := NewInterner()
+		a, _ := c.Marshal([]string{"1", "2", "3"})
+		b, _ := c.Marshal([]string{"3", "4", "1"})
+		first, err := UnmarshalInterned(c, a, nil, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := UnmarshalInterned(c, b, nil, in)
+		if err != nil || !sameValue(second, []string{"3", "4", "1"}) {
+			t.Fatalf("%s: decoded %q, %v", name, second, err)
+		}
+		if unsafe.StringData(first[0]) != unsafe.StringData(second[2]) {
+			t.Errorf("%s: %q decoded twice isn't shared", name, first[0])
+		}
+		if distinct, hits, saved := in.Stats(); distinct != 4 || hits != 2 || saved != 2 {
+			t.Errorf("%s: stats %d, %d, %d, want 4, 2, 2", name, distinct, hits, saved)
+		}
+	}
+}
+
+func TestRoaring(t *testing.T) {
+	c, err := Parse("roaring+crc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.Marshal([]string{"7", "1", "4294967295", "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := c.Unmarshal(data)
+	if want := []string{"1", "7", "4294967295"}; err != nil || !sameValue(value, want) {
+		t.Errorf("decoded %q, %v, want %q", value, err, want)
+	}
+	b, err := Bitmap(c, data)
+	if err != nil || b.GetCardinality() != 3 || !b.Contains(7) {
+		t.Errorf("bitmap %v, %v", b, err)
+	}
+	for _, bad := range []string{"2:1.5", "a", "-1", "01", "4294967296", ""} {
+		if _, err := c.Marshal([]string{bad}); !errors.Is(err, ErrRoaringValue) {
+			t.Errorf("%q: got %v, want ErrRoaringValue", bad, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package codec
+
+import "sync"
+
+// Interner hands out one copy of each distinct string, so the node IDs
+// repeated across many decoded adjacency lists share their bytes. Items
+// are interned whole, a weighted edge only shares with the same edge.
+// The table only grows, it suits a bounded set of IDs. It is safe for
+// concurrent use.
+type Interner struct {
+	mu    sync.Mutex
+	table map[string]string
+	hits  int
+	saved int64
+}
+
+func NewInterner() *Interner {
+	return &Interner{table: make(map[string]string)}
+}
+
+// Bytes returns the interned copy of b, only allocating the first time
+// b is seen.
+func (in *Interner) Bytes(b []byte) string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	// the conversion in the index doesn't allocate
+	if s, ok := in.table[string(b)]; ok {
+		in.hits++
+		in.saved += int64(len(s))
+		return s
+	}
+	s := string(b)
+	in.table[s] = s
+	return s
+}
+
+// String returns the interned copy of s, s itself the first time.
+func (in *Interner) String(s string) string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if t, ok := in.table[s]; ok {
+		in.hits++
+		in.saved += int64(len(t))
+		return t
+	}
+	in.table[s] = s
+	return s
+}
+
+// Stats returns the number of distinct strings held, the lookups that
+// found one and the bytes of string data those didn't copy.
+func (in *Interner) Stats() (distinct, hits int, saved int64) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return len(in.table), in.hits, in.saved
+}
+
+// UnmarshalInterned is c.UnmarshalInto with every item interned. A
+// Ranger's items are looked up in place, so only new ones are copied.
+func UnmarshalInterned(c Codec, data []byte, dst []string, in *Interner) ([]string, error) {
+	r, ok := c.(Ranger)
+	if !ok {
+		value, err := c.UnmarshalInto(data, dst)
+		for i, s := range value {
+			value[i] = in.String(s)
+		}
+		return value, err
+	}
+	value := dst[:0]
+	err := r.Range(data, func(item []byte) bool {
+		value = append(value, in.Bytes(item))
+		return true
+	})
+	return value, err
+}
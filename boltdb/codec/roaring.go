@@ -0,0 +1,66 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"github.com/RoaringBitmap/roaring/v2"
+	"strconv"
+)
+
+// Roaring stores the neighbors of unweighted graphs with uint32 node IDs
+// as a roaring bitmap, which is far smaller than a list of decimal
+// strings for dense IDs and can be combined with other values without
+// decoding them, see Bitmap. It stores a set: values decode in ascending
+// order without duplicates, so verify and --sample only pass for values
+// written that way.
+type Roaring struct{}
+
+var ErrRoaringValue = errors.New("roaring values hold uint32 node IDs without weights")
+
+func (Roaring) Marshal(value []string) ([]byte, error) {
+	b := roaring.New()
+	for _, s := range value {
+		id, err := strconv.ParseUint(s, 10, 32)
+		// "01" would come back as "1"
+		if err != nil || (s[0] == '0' && len(s) > 1) {
+			return nil, fmt.Errorf("%w: %q", ErrRoaringValue, s)
+		}
+		b.Add(uint32(id))
+	}
+	b.RunOptimize()
+	return b.ToBytes()
+}
+
+func (c Roaring) Unmarshal(data []byte) ([]string, error) {
+	return c.UnmarshalInto(data, nil)
+}
+
+func (Roaring) UnmarshalInto(data []byte, dst []string) ([]string, error) {
+	b := roaring.New()
+	if err := b.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	value := dst[:0]
+	it := b.Iterator()
+	for it.HasNext() {
+		value = append(value, strconv.FormatUint(uint64(it.Next()), 10))
+	}
+	return value, nil
+}
+
+// Bitmap decodes a value stored by c, Roaring or Roaring with a
+// checksum, to its bitmap.
+func Bitmap(c Codec, data []byte) (*roaring.Bitmap, error) {
+	switch c := c.(type) {
+	case Roaring:
+		b := roaring.New()
+		return b, b.UnmarshalBinary(data)
+	case Checksum:
+		data, err := c.check(data)
+		if err != nil {
+			return nil, err
+		}
+		return Bitmap(c.Inner, data)
+	}
+	return nil, fmt.Errorf("codec %T has no bitmaps, want roaring", c)
+}
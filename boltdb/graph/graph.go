@@ -0,0 +1,124 @@
+// Package graph turns files of nodes and edges into adjacency lists in a
+// storage.Store. An adjacency list entry is a neighbor key, optionally
+// followed by a colon and the edge's weight, see FormatEdge. The loaders
+// stop early, returning what they read, once their context is done.
+package graph
+
+import (
+	"fmt"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ImporterVersion changes whenever a loader starts turning the same input
+// into different keys or values.
+const ImporterVersion = 1
+
+// FormatEdge builds an adjacency list entry, "dst" for an edge of weight 1
+// or "dst:weight".
+func FormatEdge(dst, weight string) string {
+	if weight == "" || weight == "1" {
+		return dst
+	}
+	return dst + ":" + weight
+}
+
+// ParseEdge splits an adjacency list entry made by FormatEdge.
+func ParseEdge(edge string) (dst string, weight float64, err error) {
+	i := strings.LastIndexByte(edge, ':')
+	if i < 0 {
+		return edge, 1, nil
+	}
+	weight, err = strconv.ParseFloat(edge[i+1:], 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("edge %q: %s", edge, err)
+	}
+	return edge[:i], weight, nil
+}
+
+// AppendStore is a Store that adjacency lists can be written to in
+// pieces, by one loader or by several at once: the edges of a node that
+// was already written are appended to what is there.
+type AppendStore struct {
+	storage.Store
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func NewAppendStore(s storage.Store) *AppendStore {
+	return &AppendStore{Store: s, seen: make(map[string]bool)}
+}
+
+// Append adds edges to node's adjacency list, an empty edges makes sure
+// node is written even if it never gets any.
+func (s *AppendStore) Append(node string, edges []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen[node] {
+		if len(edges) == 0 {
+			return
+		}
+		old, err := s.Get(node)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		edges = append(old[:len(old):len(old)], edges...)
+	}
+	if edges == nil {
+		edges = []string{}
+	}
+	if r, ok := s.Store.(storage.Rewriter); ok && s.seen[node] {
+		// appending isn't writing node twice, whatever the store's
+		// duplicate policy
+		r.Rewrite(node, edges)
+		return
+	}
+	s.seen[node] = true
+	s.Writer(node, edges)
+}
+
+// Aggregator turns a stream of edges into adjacency lists. Edges of
+// a node usually come together, so it only holds the current node's; if
+// a node shows up again later its new edges are appended to what was
+// already written.
+type Aggregator struct {
+	s     *AppendStore
+	node  string
+	edges []string
+}
+
+// NewAggregator writes to s, which loaders sharing it pass in as an
+// *AppendStore.
+func NewAggregator(s storage.Store) *Aggregator {
+	a, ok := s.(*AppendStore)
+	if !ok {
+		a = NewAppendStore(s)
+	}
+	return &Aggregator{s: a}
+}
+
+func (a *Aggregator) Add(node, edge string) {
+	if node != a.node {
+		a.Flush()
+		a.node = node
+	}
+	a.edges = append(a.edges, edge)
+}
+
+// Flush writes the current node's edges.
+func (a *Aggregator) Flush() {
+	if a.edges == nil {
+		return
+	}
+	a.s.Append(a.node, a.edges)
+	a.edges = nil
+}
+
+// AddNode writes node with no edges unless it already has some.
+func (a *Aggregator) AddNode(node string) {
+	a.Flush()
+	a.s.Append(node, nil)
+}
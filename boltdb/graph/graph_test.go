@@ -0,0 +1,308 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"github.com/parquet-go/parquet-go"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// specs are the backends the loaders are tested against, see storage.Open.
+var specs = []string{"map", "bolt/flat/string/json", "bolt/split/uint64/binary"}
+
+// checkStore compares everything in s to want.
+func checkStore(s storage.Store, want map[string][]string) error {
+	n := 0
+	err := s.Iterate(func(key string, value []string) error {
+		n++
+		if w, ok := want[key]; !ok || !storage.SameValue(value, w) {
+			return fmt.Errorf("%s = %q, want %q", key, value, w)
+		}
+		return nil
+	})
+	if err == nil && n != len(want) {
+		err = fmt.Errorf("%d keys, want %d", n, len(want))
+	}
+	return err
+}
+
+func TestLoadCSVEdges(t *testing.T) {
+	input := "# node,neighbor,weight\n1,2\n1,3,2.5\n2,1\n1,4,1\n"
+	for _, spec := range specs {
+		t.Run(spec, func(t *testing.T) {
+			s := storage.Open(spec, filepath.Join(t.TempDir(), "load.db"), storage.Layout{Schema: storage.FlatSchema, Keys: storage.StringKeys, Codec: "json"})
+			if mybolt, ok := s.(*storage.Bolt); ok {
+				defer mybolt.Close()
+			}
+			if rows := LoadCSV(context.Background(), strings.NewReader(input), s, "edges", false); rows != 4 {
+				t.Errorf("loaded %d rows, want 4", rows)
+			}
+			err := checkStore(s, map[string][]string{
+				"1": {"2", "3:2.5", "4"},
+				"2": {"1"},
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestLoadJSONL(t *testing.T) {
+	input := `{"key": "a", "value": ["x", "y"]}
+{"key": 7, "value": [1, {"b": true}, null]}
+
+{"key": "c", "value": "z"}
+{"key": "d"}
+{"key": "a", "value": []}
+`
+	s := storage.NewMap()
+	if lines := LoadJSONL(context.Background(), strings.NewReader(input), s); lines != 6 {
+		t.Errorf("loaded %d lines, want 6", lines)
+	}
+	err := checkStore(s, map[string][]string{
+		"a": {},
+		"7": {"1", `{"b": true}`, "null"},
+		"c": {"z"},
+		"d": {},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadEdgeList(t *testing.T) {
+	input := "# Directed graph\n# FromNodeId\tToNodeId\n0\t1\n0\t2\t0.5\n1 0\n\n2\t0\n0\t3\n"
+	s := storage.NewMap()
+	if lines := LoadEdgeList(context.Background(), strings.NewReader(input), s); lines != 8 {
+		t.Errorf("loaded %d lines, want 8", lines)
+	}
+	err := checkStore(s, map[string][]string{
+		"0": {"1", "2:0.5", "3"},
+		"1": {"0"},
+		"2": {"0"},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := storage.NewMap()
+	if lines := LoadEdgeList(ctx, strings.NewReader("0\t1\n1\t0\n"), s); lines != 0 {
+		t.Errorf("cancelled load read %d lines", lines)
+	}
+}
+
+func TestLoadEdgeListsConcurrently(t *testing.T) {
+	inputs := []string{"0\t1\n1\t2\n", "0\t2\n2\t0\n", "1\t0\n0\t3\n"}
+	s := NewAppendStore(storage.NewMap())
+	var wg sync.WaitGroup
+	for _, input := range inputs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			LoadEdgeList(context.Background(), strings.NewReader(input), s)
+		}()
+	}
+	wg.Wait()
+	want := map[string][]string{"0": {"1", "2", "3"}, "1": {"0", "2"}, "2": {"0"}}
+	for node, edges := range want {
+		got, err := s.Get(node)
+		sort.Strings(got)
+		if err != nil || !reflect.DeepEqual(got, edges) {
+			t.Errorf("%s: got %v, %v, want %v", node, got, err, edges)
+		}
+	}
+}
+
+func TestLoadParquet(t *testing.T) {
+	type edge struct {
+		Src    int64    `parquet:"src"`
+		Dst    int64    `parquet:"dst"`
+		Weight *float64 `parquet:"weight,optional"`
+	}
+	half := 0.5
+	var buf bytes.Buffer
+	w := parquet.NewGenericWriter[edge](&buf)
+	_, err := w.Write([]edge{{0, 1, nil}, {1, 0, &half}, {0, 2, &half}})
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := storage.NewMap()
+	if rows := LoadParquet(context.Background(), &buf, s, "src,dst,weight"); rows != 3 {
+		t.Errorf("loaded %d rows, want 3", rows)
+	}
+	err = checkStore(s, map[string][]string{
+		"0": {"1", "2:0.5"},
+		"1": {"0:0.5"},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadGraphML(t *testing.T) {
+	input := `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="d0" for="node" attr.name="label" attr.type="string"/>
+  <key id="d1" for="edge" attr.name="weight" attr.type="double"/>
+  <graph id="G" edgedefault="undirected">
+    <node id="a"><data key="d0">A</data></node>
+    <node id="b"/>
+    <node id="c"/>
+    <node id="lonely"/>
+    <edge source="a" target="b"><data key="d1">2.5</data></edge>
+    <edge source="a" target="c"/>
+    <edge source="c" target="b" directed="true"><data key="d1">1</data></edge>
+  </graph>
+</graphml>`
+	s := storage.NewMap()
+	if elements := LoadGraphML(context.Background(), strings.NewReader(input), s); elements != 7 {
+		t.Errorf("loaded %d nodes and edges, want 7", elements)
+	}
+	err := checkStore(s, map[string][]string{
+		"a":      {"b:2.5", "c"},
+		"b":      {"a:2.5"},
+		"c":      {"a", "b"},
+		"lonely": {},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGraphSONVertex(t *testing.T) {
+	var edgeID int64 = 7
+	v, err := NewGraphSONVertex("1", []string{"2", "3:0.5"}, &edgeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(v)
+	want := `{"id":"1","label":"vertex","outE":{"edge":[` +
+		`{"id":{"@type":"g:Int64","@value":7},"inV":"2","properties":{"weight":{"@type":"g:Double","@value":1}}},` +
+		`{"id":{"@type":"g:Int64","@value":8},"inV":"3","properties":{"weight":{"@type":"g:Double","@value":0.5}}}]}}`
+	if string(got) != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+	v, _ = NewGraphSONVertex("lonely", nil, &edgeID)
+	if got, _ := json.Marshal(v); string(got) != `{"id":"lonely","label":"vertex"}` || edgeID != 9 {
+		t.Errorf("got %s, next edge id %d", got, edgeID)
+	}
+}
+
+func TestSample(t *testing.T) {
+	def := storage.Layout{Schema: storage.FlatSchema, Keys: storage.StringKeys, Codec: "json"}
+	src := storage.Open("map", "", def)
+	for node, edges := range map[string][]string{
+		"a": {"b", "c:2"},
+		"b": {"a", "d"},
+		"c": {"e"},
+		"d": {"a"},
+		"x": {"a"},
+	} {
+		src.Writer(node, edges)
+	}
+	src.Flush()
+	ctx := context.Background()
+
+	dst := storage.Open("map", "", def)
+	nodes, kept, dropped, err := Sample(ctx, src, dst, "a", 3)
+	if err != nil || !reflect.DeepEqual(nodes, []string{"a", "b", "c"}) || kept != 3 || dropped != 2 {
+		t.Errorf("sampled %v, kept %d, dropped %d, %v", nodes, kept, dropped, err)
+	}
+	if err := checkStore(dst, map[string][]string{"a": {"b", "c:2"}, "b": {"a"}, "c": {}}); err != nil {
+		t.Error(err)
+	}
+
+	// every node reachable, e holding no list as in src
+	dst = storage.Open("map", "", def)
+	nodes, kept, dropped, err = Sample(ctx, src, dst, "a", 0)
+	if err != nil || len(nodes) != 5 || kept != 6 || dropped != 0 {
+		t.Errorf("sampled %v, kept %d, dropped %d, %v", nodes, kept, dropped, err)
+	}
+	if err := checkStore(dst, map[string][]string{"a": {"b", "c:2"}, "b": {"a", "d"}, "c": {"e"}, "d": {"a"}}); err != nil {
+		t.Error(err)
+	}
+
+	if _, _, _, err := Sample(ctx, src, storage.Open("map", "", def), "missing", 3); err != storage.ErrNotFound {
+		t.Errorf("sample from a missing node: %v", err)
+	}
+}
+
+func TestOrder(t *testing.T) {
+	s := storage.Open("bolt/flat/string/json", filepath.Join(t.TempDir(), "order.db"),
+		storage.Layout{Schema: storage.FlatSchema, Keys: storage.StringKeys, Codec: "json"}).(*storage.Bolt)
+	defer s.Close()
+	for node, edges := range map[string][]string{
+		"a": {"d", "z:2"},
+		"b": {"c"},
+		"c": {"b"},
+		"d": {"a"},
+	} {
+		s.Writer(node, edges)
+	}
+	s.Flush()
+	ctx := context.Background()
+
+	// z is an edge end without a list of its own
+	order, err := Order(ctx, s, BFSOrder, nil)
+	if err != nil || !reflect.DeepEqual(order, []string{"a", "d", "b", "c"}) {
+		t.Errorf("bfs order %v, %v", order, err)
+	}
+	coords := map[string][2]float64{"a": {1, 1}, "b": {0, 0}, "c": {0, 1}}
+	order, err = Order(ctx, s, HilbertOrder, coords)
+	if err != nil || !reflect.DeepEqual(order, []string{"b", "c", "a", "d"}) {
+		t.Errorf("hilbert order %v, %v", order, err)
+	}
+	if _, err := Order(ctx, s, HilbertOrder, nil); err != errNoCoords {
+		t.Errorf("hilbert order without coordinates: %v", err)
+	}
+
+	dst := storage.Open("map", "", storage.Layout{})
+	ids, err := Renumber(ctx, s, dst, order)
+	want := map[string]string{"b": "0", "c": "1", "a": "2", "d": "3", "z": "4"}
+	if err != nil || !reflect.DeepEqual(ids, want) {
+		t.Errorf("renumbered %v, %v", ids, err)
+	}
+	if err := checkStore(dst, map[string][]string{"0": {"1"}, "1": {"0"}, "2": {"3", "4:2"}, "3": {"2"}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHilbert(t *testing.T) {
+	// every cell once, each next to the one before
+	const side = 1 << hilbertBits
+	seen := make(map[uint64]bool)
+	var cells [16][2]uint32
+	for x := uint32(0); x < 4; x++ {
+		for y := uint32(0); y < 4; y++ {
+			d := hilbert(x*side/4, y*side/4)
+			seen[d] = true
+			cells[d/(side*side/16)] = [2]uint32{x, y}
+		}
+	}
+	if len(seen) != 16 {
+		t.Fatalf("%d distinct distances for 16 cells", len(seen))
+	}
+	for i := 1; i < len(cells); i++ {
+		dx := int(cells[i][0]) - int(cells[i-1][0])
+		dy := int(cells[i][1]) - int(cells[i-1][1])
+		if dx*dx+dy*dy != 1 {
+			t.Errorf("cell %d at %v after %v", i, cells[i], cells[i-1])
+		}
+	}
+}
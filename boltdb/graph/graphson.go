@@ -0,0 +1,44 @@
+package graph
+
+// GraphSONVertex is a vertex and its out edges in TinkerPop's GraphSON
+// 3.0, one per line as Gremlin's io().read() expects. Vertex ids are the
+// keys, as strings.
+type GraphSONVertex struct {
+	ID    string                    `json:"id"`
+	Label string                    `json:"label"`
+	OutE  map[string][]GraphSONEdge `json:"outE,omitempty"`
+}
+
+type GraphSONEdge struct {
+	ID         GraphSONValue            `json:"id"`
+	InV        string                   `json:"inV"`
+	Properties map[string]GraphSONValue `json:"properties"`
+}
+
+// GraphSONValue is a typed GraphSON value, e.g. {"@type": "g:Double", "@value": 1}.
+type GraphSONValue struct {
+	Type  string `json:"@type"`
+	Value any    `json:"@value"`
+}
+
+// NewGraphSONVertex turns a node's adjacency list into a vertex with an
+// edge per entry, numbering the edges from *edgeID.
+func NewGraphSONVertex(key string, value []string, edgeID *int64) (GraphSONVertex, error) {
+	v := GraphSONVertex{ID: key, Label: "vertex"}
+	if len(value) > 0 {
+		v.OutE = map[string][]GraphSONEdge{"edge": make([]GraphSONEdge, len(value))}
+	}
+	for i, edge := range value {
+		dst, weight, err := ParseEdge(edge)
+		if err != nil {
+			return v, err
+		}
+		v.OutE["edge"][i] = GraphSONEdge{
+			ID:         GraphSONValue{"g:Int64", *edgeID},
+			InV:        dst,
+			Properties: map[string]GraphSONValue{"weight": {"g:Double", weight}},
+		}
+		*edgeID++
+	}
+	return v, nil
+}
@@ -0,0 +1,314 @@
+package graph
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"github.com/parquet-go/parquet-go"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// LoadCSV writes the rows of r to s, either one key per row followed by
+// its value items (rowFormat kv) or one edge per row as node, neighbor and
+// an optional weight (rowFormat edges), returning the number of rows read.
+// With header the first row is skipped.
+func LoadCSV(ctx context.Context, r io.Reader, s storage.Store, rowFormat string, header bool) (rows int) {
+	c := csv.NewReader(r)
+	c.FieldsPerRecord = -1
+	c.ReuseRecord = true
+	c.Comment = '#'
+	var agg *Aggregator
+	switch rowFormat {
+	case "kv":
+	case "edges":
+		agg = NewAggregator(s)
+	default:
+		run.Fatal("unknown row format", "rows", rowFormat)
+	}
+	p := run.NewProgress("load", 0)
+	for !run.Done(ctx) {
+		record, err := c.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		rows++
+		if rows == 1 && header {
+			continue
+		}
+		p.Update(rows)
+		if agg != nil {
+			if len(record) < 2 || len(record) > 3 {
+				run.Fatal("edge rows are node,neighbor[,weight]", "row", rows, "fields", len(record))
+			}
+			weight := ""
+			if len(record) == 3 {
+				weight = record[2]
+			}
+			agg.Add(record[0], FormatEdge(record[1], weight))
+			continue
+		}
+		s.Writer(record[0], append([]string(nil), record[1:]...))
+	}
+	if agg != nil {
+		agg.Flush()
+	}
+	return rows
+}
+
+// LoadEdgeList writes the adjacency lists of an edge list, one
+// "src<TAB>dst[<TAB>weight]" edge per line as in the SNAP datasets, to s
+// and returns the number of lines read. Any whitespace separates fields
+// and lines starting with # or % are comments.
+func LoadEdgeList(ctx context.Context, r io.Reader, s storage.Store) (lines int) {
+	scanner := bufio.NewScanner(r)
+	agg := NewAggregator(s)
+	p := run.NewProgress("load", 0)
+	for !run.Done(ctx) && scanner.Scan() {
+		lines++
+		p.Update(lines)
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "%") {
+			continue
+		}
+		if len(fields) > 3 || len(fields) < 2 {
+			run.Fatal("edge list lines are src, dst and an optional weight", "line", lines, "fields", len(fields))
+		}
+		weight := ""
+		if len(fields) == 3 {
+			weight = fields[2]
+		}
+		agg.Add(fields[0], FormatEdge(fields[1], weight))
+	}
+	if err := scanner.Err(); err != nil {
+		run.Fatal(err.Error())
+	}
+	agg.Flush()
+	return lines
+}
+
+// graphMLEdge is the <edge> being read by LoadGraphML.
+type graphMLEdge struct {
+	source, target, weight string
+	directed               bool
+}
+
+// LoadGraphML writes the adjacency lists of a GraphML graph, as exported
+// by Gephi or NetworkX, to s and returns the number of nodes and edges
+// read. Edge weights come from the edge attribute named weight, edges of
+// undirected graphs are stored in both directions, and nodes without
+// edges get an empty adjacency list. Other attributes are dropped.
+func LoadGraphML(ctx context.Context, r io.Reader, s storage.Store) (elements int) {
+	d := xml.NewDecoder(r)
+	agg := NewAggregator(s)
+	nodes := make(map[string]bool)
+	weightKey, undirected := "", false
+	var edge *graphMLEdge
+	p := run.NewProgress("load", 0)
+	for !run.Done(ctx) {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "key":
+				if f := xmlAttr(t, "for"); (f == "edge" || f == "all") && strings.EqualFold(xmlAttr(t, "attr.name"), "weight") {
+					weightKey = xmlAttr(t, "id")
+				}
+			case "graph":
+				undirected = xmlAttr(t, "edgedefault") == "undirected"
+			case "node":
+				nodes[xmlAttr(t, "id")] = true
+				elements++
+			case "edge":
+				edge = &graphMLEdge{source: xmlAttr(t, "source"), target: xmlAttr(t, "target"), directed: !undirected}
+				if directed := xmlAttr(t, "directed"); directed != "" {
+					edge.directed = directed == "true"
+				}
+				elements++
+			case "data":
+				if edge == nil || weightKey == "" || xmlAttr(t, "key") != weightKey {
+					continue
+				}
+				var data struct {
+					Value string `xml:",chardata"`
+				}
+				err = d.DecodeElement(&data, &t)
+				if err != nil {
+					run.Fatal(err.Error())
+				}
+				edge.weight = strings.TrimSpace(data.Value)
+			}
+		case xml.EndElement:
+			if t.Name.Local != "edge" || edge == nil {
+				continue
+			}
+			agg.Add(edge.source, FormatEdge(edge.target, edge.weight))
+			if !edge.directed {
+				agg.Add(edge.target, FormatEdge(edge.source, edge.weight))
+			}
+			edge = nil
+			p.Update(elements)
+		}
+	}
+	agg.Flush()
+	for node := range nodes {
+		agg.AddNode(node)
+	}
+	return elements
+}
+
+func xmlAttr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// LoadParquet writes the edges of a Parquet table to s, one per row in
+// edgeColumns, a comma separated source, destination and optional weight
+// column, nested ones as a.b. Returns the number of rows read. Parquet
+// keeps its index at the end of the file, so r is spooled to a temporary
+// file first.
+func LoadParquet(ctx context.Context, r io.Reader, s storage.Store, edgeColumns string) (rows int) {
+	tmp, err := os.CreateTemp("", "load-*.parquet")
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	f, err := parquet.OpenFile(tmp, size)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+
+	names := strings.Split(edgeColumns, ",")
+	if len(names) != 2 && len(names) != 3 {
+		run.Fatal("want source, destination and an optional weight column", "columns", edgeColumns)
+	}
+	columns := make(map[int]int) // parquet column index to src, dst or weight
+	for i, name := range names {
+		leaf, ok := f.Schema().Lookup(strings.Split(strings.TrimSpace(name), ".")...)
+		if !ok && i == 2 {
+			slog.Warn("no weight column, loading unweighted edges", "column", name)
+			continue
+		}
+		if !ok {
+			run.Fatal("no such parquet column", "column", name)
+		}
+		columns[leaf.ColumnIndex] = i
+	}
+
+	agg := NewAggregator(s)
+	p := run.NewProgress("load", int(f.NumRows()))
+	reader := parquet.NewReader(f)
+	defer reader.Close()
+	batch := make([]parquet.Row, 1024)
+	for !run.Done(ctx) {
+		n, err := reader.ReadRows(batch)
+		for _, row := range batch[:n] {
+			rows++
+			p.Update(rows)
+			var fields [3]string
+			for _, v := range row {
+				if i, ok := columns[v.Column()]; ok && !v.IsNull() {
+					fields[i] = v.String()
+				}
+			}
+			if fields[0] == "" || fields[1] == "" {
+				continue
+			}
+			agg.Add(fields[0], FormatEdge(fields[1], fields[2]))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+	}
+	agg.Flush()
+	return rows
+}
+
+// jsonRecord is a line of a JSON Lines file, e.g.
+// {"key": "1", "value": ["2", "3"]}.
+type jsonRecord struct {
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// LoadJSONL writes each line of r, a jsonRecord, to s and returns the
+// number of lines read. Keys may be strings or numbers. A value array
+// becomes the value's items and anything else a single item; strings are
+// stored unquoted and other JSON values as their JSON text.
+func LoadJSONL(ctx context.Context, r io.Reader, s storage.Store) (lines int) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	p := run.NewProgress("load", 0)
+	for !run.Done(ctx) && scanner.Scan() {
+		lines++
+		p.Update(lines)
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record jsonRecord
+		err := json.Unmarshal(line, &record)
+		if err != nil {
+			run.Fatal("bad json line", "line", lines, "err", err)
+		}
+		if record.Key == nil {
+			run.Fatal("json line without a key", "line", lines)
+		}
+		key := jsonText(record.Key)
+		var items []json.RawMessage
+		if bytes.HasPrefix(record.Value, []byte("[")) {
+			err = json.Unmarshal(record.Value, &items)
+			if err != nil {
+				run.Fatal("bad json value", "line", lines, "err", err)
+			}
+		} else if record.Value != nil {
+			items = []json.RawMessage{record.Value}
+		}
+		value := make([]string, len(items))
+		for i, item := range items {
+			value[i] = jsonText(item)
+		}
+		s.Writer(key, value)
+	}
+	if err := scanner.Err(); err != nil {
+		run.Fatal(err.Error())
+	}
+	return lines
+}
+
+// jsonText is a JSON string's contents, or any other value's JSON text.
+func jsonText(raw json.RawMessage) string {
+	var s string
+	if bytes.HasPrefix(raw, []byte(`"`)) && json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return string(raw)
+}
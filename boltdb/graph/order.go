@@ -0,0 +1,188 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// The orders Order puts nodes in, each meant to put neighbors next to
+// each other and so, once renumbered, on the same pages of a store that
+// keeps its keys sorted.
+const (
+	// BFSOrder is breadth first from each node not yet reached, in the
+	// store's key order
+	BFSOrder = "bfs"
+	// HilbertOrder is along a Hilbert curve through the nodes'
+	// coordinates, those without any coming last
+	HilbertOrder = "hilbert"
+)
+
+var errNoCoords = errors.New("hilbert order needs the nodes' coordinates")
+
+// Order returns the nodes of s in the order by, BFSOrder or HilbertOrder,
+// the latter from coords. Once ctx is done it gives up with ctx's error.
+func Order(ctx context.Context, s storage.Store, by string, coords map[string][2]float64) ([]string, error) {
+	var keys []string
+	err := s.Iterate(func(key string, value []string) error {
+		if run.Done(ctx) {
+			return ctx.Err()
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch by {
+	case BFSOrder:
+		return bfsOrder(ctx, s, keys)
+	case HilbertOrder:
+		if len(coords) == 0 {
+			return nil, errNoCoords
+		}
+		return hilbertOrder(keys, coords), nil
+	}
+	return nil, fmt.Errorf("unknown order %q, want bfs or hilbert", by)
+}
+
+func bfsOrder(ctx context.Context, r storage.Reader, keys []string) ([]string, error) {
+	held := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		held[key] = true
+	}
+	reached := make(map[string]bool, len(keys))
+	order := make([]string, 0, len(keys))
+	for _, root := range keys {
+		if reached[root] {
+			continue
+		}
+		reached[root] = true
+		order = append(order, root)
+		for i := len(order) - 1; i < len(order); i++ {
+			if run.Done(ctx) {
+				return nil, ctx.Err()
+			}
+			value, err := r.Get(order[i])
+			if err != nil {
+				return nil, err
+			}
+			for _, edge := range value {
+				dst, _, err := ParseEdge(edge)
+				if err != nil {
+					return nil, err
+				}
+				// edge ends without edges of their own aren't keys
+				if held[dst] && !reached[dst] {
+					reached[dst] = true
+					order = append(order, dst)
+				}
+			}
+		}
+	}
+	return order, nil
+}
+
+// hilbertBits is the side of the grid hilbertOrder snaps coordinates to,
+// in bits.
+const hilbertBits = 16
+
+func hilbertOrder(keys []string, coords map[string][2]float64) []string {
+	lo := [2]float64{math.Inf(1), math.Inf(1)}
+	hi := [2]float64{math.Inf(-1), math.Inf(-1)}
+	for _, c := range coords {
+		for i := range c {
+			lo[i], hi[i] = min(lo[i], c[i]), max(hi[i], c[i])
+		}
+	}
+	const side = 1<<hilbertBits - 1
+	index := make(map[string]uint64, len(keys))
+	for _, key := range keys {
+		c, ok := coords[key]
+		if !ok {
+			index[key] = math.MaxUint64
+			continue
+		}
+		var cell [2]uint32
+		for i := range c {
+			if hi[i] > lo[i] {
+				cell[i] = uint32(math.Round((c[i] - lo[i]) / (hi[i] - lo[i]) * side))
+			}
+		}
+		index[key] = hilbert(cell[0], cell[1])
+	}
+	order := append([]string(nil), keys...)
+	// stable, so nodes in the same cell and those without coordinates
+	// keep their key order
+	sort.SliceStable(order, func(i, j int) bool { return index[order[i]] < index[order[j]] })
+	return order
+}
+
+// hilbert is the distance along a Hilbert curve filling the grid of side
+// 1<<hilbertBits to the cell x, y.
+func hilbert(x, y uint32) uint64 {
+	var d uint64
+	for s := uint32(1) << (hilbertBits - 1); s > 0; s /= 2 {
+		var rx, ry uint32
+		if x&s != 0 {
+			rx = 1
+		}
+		if y&s != 0 {
+			ry = 1
+		}
+		d += uint64(s) * uint64(s) * uint64((3*rx)^ry)
+		// rotate the quadrant so the curve runs on through it
+		if ry == 0 {
+			if rx == 1 {
+				x, y = s-1-x%s, s-1-y%s
+			}
+			x, y = y%s, x%s
+		} else {
+			x, y = x%s, y%s
+		}
+	}
+	return d
+}
+
+// Renumber writes the nodes of r to s under new IDs, 0 for order[0] and
+// so on, with their edges renamed to match, so a store keeping integer
+// keys sorted lays them out in that order. Edge ends that aren't in order
+// are numbered after it. It returns the new ID of every node, or once ctx
+// is done gives up with ctx's error.
+func Renumber(ctx context.Context, r storage.Reader, s storage.DB, order []string) (ids map[string]string, err error) {
+	ids = make(map[string]string, len(order))
+	for i, node := range order {
+		ids[node] = strconv.Itoa(i)
+	}
+	for _, node := range order {
+		if run.Done(ctx) {
+			return nil, ctx.Err()
+		}
+		value, err := r.Get(node)
+		if err != nil {
+			return nil, err
+		}
+		renamed := make([]string, len(value))
+		for i, edge := range value {
+			dst, _, err := ParseEdge(edge)
+			if err != nil {
+				return nil, err
+			}
+			id, ok := ids[dst]
+			if !ok {
+				id = strconv.Itoa(len(ids))
+				ids[dst] = id
+			}
+			// the weight as written
+			renamed[i] = id + edge[len(dst):]
+		}
+		s.Writer(ids[node], renamed)
+	}
+	s.Flush()
+	return ids, nil
+}
@@ -0,0 +1,112 @@
+package graph
+
+import (
+	"context"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"github.com/qedus/osmpbf"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"strconv"
+)
+
+// osmEdge is a road segment between two OSM nodes.
+type osmEdge struct {
+	src, dst int64
+	meters   float32
+}
+
+// LoadOSM extracts the road graph of an OpenStreetMap .pbf file into
+// s: every way tagged highway becomes weighted edges between its
+// consecutive nodes, in both directions unless it is one way, with the
+// distance in meters as the weight. Returns the number of OSM elements
+// read and the positions of the road nodes, for storage.CoordsBucket.
+//
+// Ways only list node ids, so the positions of every node are kept in
+// memory while reading, which limits this to regional extracts.
+func LoadOSM(ctx context.Context, r io.Reader, s storage.Store) (elements int, coords map[string][2]float64) {
+	d := osmpbf.NewDecoder(r)
+	d.SetBufferSize(osmpbf.MaxBlobSize)
+	err := d.Start(runtime.GOMAXPROCS(0))
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	positions := make(map[int64][2]float64)
+	var edges []osmEdge
+	p := run.NewProgress("load", 0)
+	for !run.Done(ctx) {
+		v, err := d.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		elements++
+		p.Update(elements)
+		switch v := v.(type) {
+		case *osmpbf.Node:
+			positions[v.ID] = [2]float64{v.Lat, v.Lon}
+		case *osmpbf.Way:
+			if v.Tags["highway"] == "" {
+				continue
+			}
+			forward, backward := true, true
+			switch v.Tags["oneway"] {
+			case "yes", "true", "1":
+				backward = false
+			case "-1", "reverse":
+				forward = false
+			case "":
+				backward = v.Tags["junction"] != "roundabout"
+			}
+			for i := 1; i < len(v.NodeIDs); i++ {
+				a, b := v.NodeIDs[i-1], v.NodeIDs[i]
+				pa, okA := positions[a]
+				pb, okB := positions[b]
+				if !okA || !okB {
+					// cut off by the edge of the extract
+					continue
+				}
+				meters := float32(Haversine(pa, pb))
+				if forward {
+					edges = append(edges, osmEdge{a, b, meters})
+				}
+				if backward {
+					edges = append(edges, osmEdge{b, a, meters})
+				}
+			}
+		}
+	}
+
+	// sorted, each node's edges reach the aggregator together
+	sort.Slice(edges, func(i, j int) bool {
+		return edges[i].src < edges[j].src
+	})
+	agg := NewAggregator(s)
+	coords = make(map[string][2]float64)
+	for _, e := range edges {
+		src, dst := strconv.FormatInt(e.src, 10), strconv.FormatInt(e.dst, 10)
+		agg.Add(src, FormatEdge(dst, strconv.FormatFloat(float64(e.meters), 'f', 1, 32)))
+		coords[src] = positions[e.src]
+		coords[dst] = positions[e.dst]
+	}
+	agg.Flush()
+	// dead ends of one way streets have no edges of their own
+	for node := range coords {
+		agg.AddNode(node)
+	}
+	return elements, coords
+}
+
+// Haversine is the great circle distance in meters between two
+// latitude/longitude positions in degrees.
+func Haversine(a, b [2]float64) float64 {
+	const earthRadius = 6371008.8
+	lat1, lat2 := a[0]*math.Pi/180, b[0]*math.Pi/180
+	dLat, dLon := lat2-lat1, (b[1]-a[1])*math.Pi/180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
@@ -0,0 +1,65 @@
+package graph
+
+import (
+	"context"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/storage"
+)
+
+// Sample copies a connected piece of the graph in r to s: the first k
+// nodes reached breadth first from the node from, or every node reachable
+// if there are fewer or k is 0, each with just its edges to the others, so searches
+// of s never read a node it doesn't hold. It returns the nodes in the
+// order they were reached and how many edges it kept and dropped. Once
+// ctx is done it gives up with ctx's error, having written nothing, and
+// so it does with storage.ErrNotFound if r hasn't from.
+func Sample(ctx context.Context, r storage.Reader, s storage.DB, from string, k int) (nodes []string, kept, dropped int, err error) {
+	values := make(map[string][]string)
+	reached := map[string]bool{from: true}
+	nodes = []string{from}
+	for i := 0; i < len(nodes); i++ {
+		if run.Done(ctx) {
+			return nil, 0, 0, ctx.Err()
+		}
+		value, err := r.Get(nodes[i])
+		if err == storage.ErrNotFound && i > 0 {
+			// an edge's end without edges of its own, left out as in r
+			continue
+		}
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		values[nodes[i]] = value
+		for _, edge := range value {
+			if len(nodes) == k {
+				break
+			}
+			dst, _, err := ParseEdge(edge)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			if !reached[dst] {
+				reached[dst] = true
+				nodes = append(nodes, dst)
+			}
+		}
+	}
+	for _, node := range nodes {
+		value, ok := values[node]
+		if !ok {
+			continue
+		}
+		inside := make([]string, 0, len(value))
+		for _, edge := range value {
+			dst, _, _ := ParseEdge(edge)
+			if reached[dst] {
+				inside = append(inside, edge)
+			}
+		}
+		kept += len(inside)
+		dropped += len(value) - len(inside)
+		s.Writer(node, inside)
+	}
+	s.Flush()
+	return nodes, kept, dropped, nil
+}
@@ -0,0 +1,43 @@
+package run
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// GC is a snapshot of the garbage collector's counters.
+type GC struct {
+	Cycles uint32
+	Pause  time.Duration
+}
+
+// ReadGC reads the counters. It stops the world, so call it between
+// phases rather than inside them.
+func ReadGC() GC {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return GC{Cycles: m.NumGC, Pause: time.Duration(m.PauseTotalNs)}
+}
+
+// Since is what the collector did between before and g.
+func (g GC) Since(before GC) GC {
+	return GC{Cycles: g.Cycles - before.Cycles, Pause: g.Pause - before.Pause}
+}
+
+// SetGC sets GOGC to gogc, unless it is 0, and allocates a ballast of
+// that many bytes, which the heap target grows by but which is never
+// touched, so never paged in. It returns a func undoing both.
+func SetGC(gogc, ballast int) (restore func()) {
+	var old int
+	if gogc != 0 {
+		old = debug.SetGCPercent(gogc)
+	}
+	b := make([]byte, ballast)
+	return func() {
+		runtime.KeepAlive(b)
+		if gogc != 0 {
+			debug.SetGCPercent(old)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strconv"
+)
+
+// IO is a snapshot of the process's I/O counters: read and write calls
+// and the bytes they moved from and to the disk, as opposed to the page
+// cache. Bytes bolt reads through its mapping only show up as ReadBytes.
+type IO struct {
+	ReadCalls  uint64 `json:"read_calls"`
+	WriteCalls uint64 `json:"write_calls"`
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+}
+
+// ReadIO reads the counters from Linux's /proc/self/io, ok is false
+// where there is none. Reading it takes a couple of read calls itself.
+func ReadIO() (io IO, ok bool) {
+	data, err := os.ReadFile("/proc/self/io")
+	if err != nil {
+		return io, false
+	}
+	fields := map[string]*uint64{
+		"syscr":       &io.ReadCalls,
+		"syscw":       &io.WriteCalls,
+		"read_bytes":  &io.ReadBytes,
+		"write_bytes": &io.WriteBytes,
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		name, value, found := bytes.Cut(s.Bytes(), []byte(": "))
+		if dst := fields[string(name)]; found && dst != nil {
+			*dst, _ = strconv.ParseUint(string(value), 10, 64)
+		}
+	}
+	return io, true
+}
+
+// Since is the I/O between before and io.
+func (io IO) Since(before IO) IO {
+	return IO{
+		ReadCalls:  io.ReadCalls - before.ReadCalls,
+		WriteCalls: io.WriteCalls - before.WriteCalls,
+		ReadBytes:  io.ReadBytes - before.ReadBytes,
+		WriteBytes: io.WriteBytes - before.WriteBytes,
+	}
+}
+
+// Amplification is moved over logical, the bytes of keys and values the
+// phase meant to move, 0 if there were none.
+func Amplification(moved uint64, logical int64) float64 {
+	if logical <= 0 {
+		return 0
+	}
+	return Round(float64(moved) / float64(logical))
+}
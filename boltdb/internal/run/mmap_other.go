@@ -0,0 +1,15 @@
+//go:build !unix
+
+package run
+
+import "os"
+
+// Map reads the file at path whole, there is no mmap to map it with.
+func Map(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+// Unmap has nothing to do, the garbage collector frees what Map read.
+func Unmap(data []byte) error {
+	return nil
+}
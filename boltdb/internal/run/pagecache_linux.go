@@ -0,0 +1,60 @@
+package run
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"unsafe"
+)
+
+// DropCache asks the kernel to evict path's pages from the page cache.
+// Pages a process has mapped stay, so mapped, the caller's own mapping of
+// the file if it has one, is let go of first. Needs no root, but only
+// clean pages are dropped and other processes' mappings keep theirs.
+func DropCache(path string, mapped []byte) error {
+	if len(mapped) > 0 {
+		if err := unix.Madvise(mapped, unix.MADV_DONTNEED); err != nil {
+			return fmt.Errorf("drop cache: %w", err)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED); err != nil {
+		return fmt.Errorf("drop cache: %w", err)
+	}
+	return nil
+}
+
+// Cached is the fraction of path's pages in the page cache, from
+// mincore over a mapping of the whole file, which doesn't read it.
+func Cached(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.Size() == 0 {
+		return 0, err
+	}
+	data, err := unix.Mmap(int(f.Fd()), 0, int(fi.Size()), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return 0, fmt.Errorf("cached: %w", err)
+	}
+	defer unix.Munmap(data)
+	page := os.Getpagesize()
+	vec := make([]byte, (len(data)+page-1)/page)
+	_, _, errno := unix.Syscall(unix.SYS_MINCORE, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)),
+		uintptr(unsafe.Pointer(&vec[0])))
+	if errno != 0 {
+		return 0, fmt.Errorf("cached: %w", errno)
+	}
+	resident := 0
+	for _, v := range vec {
+		resident += int(v & 1)
+	}
+	return float64(resident) / float64(len(vec)), nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package run
+
+import "errors"
+
+var errPageCache = errors.New("page cache control: only supported on Linux")
+
+// DropCache needs Linux's fadvise.
+func DropCache(path string, mapped []byte) error {
+	return errPageCache
+}
+
+// Cached needs Linux's mincore.
+func Cached(path string) (float64, error) {
+	return 0, errPageCache
+}
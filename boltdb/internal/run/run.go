@@ -0,0 +1,113 @@
+// Package run holds what the phases of a benchmark run share: how they
+// give up on errors, notice they were cancelled and log their progress.
+package run
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"time"
+)
+
+// Done reports whether ctx is cancelled or past its deadline, without
+// blocking. It is cheap enough to call for every key, which is how long
+// phases stop early, leaving what they wrote consistent.
+func Done(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// Fatal logs msg and the key/value pairs in args as an error and exits.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	Exit(1)
+}
+
+var atExit []func()
+
+// AtExit registers f to run when Exit is called, for what a deferred call
+// would otherwise clean up, such as temporary files.
+func AtExit(f func()) {
+	atExit = append(atExit, f)
+}
+
+// Exit runs the AtExit funcs, last registered first, and exits.
+func Exit(code int) {
+	for i := len(atExit) - 1; i >= 0; i-- {
+		atExit[i]()
+	}
+	os.Exit(code)
+}
+
+// ProgressEvery is how often a Progress logs, 0 for never.
+var ProgressEvery = 10 * time.Second
+
+// Progress logs how far a long phase has got every ProgressEvery, with
+// the rate since the previous line and the time left at that rate.
+type Progress struct {
+	name     string
+	total    int
+	last     time.Time
+	lastDone int
+	// checked is done/4096 when the clock was last read
+	checked int
+}
+
+// NewProgress returns nil, which Update ignores, when progress logging is
+// off. total is 0 when it isn't known up front.
+func NewProgress(name string, total int) *Progress {
+	if ProgressEvery <= 0 {
+		return nil
+	}
+	return &Progress{name: name, total: total, last: time.Now()}
+}
+
+// Update records that done items are finished. It only reads the clock
+// once done passes a multiple of 4096, so per key loops can call it
+// freely and loops over batches of any size still log.
+func (p *Progress) Update(done int) {
+	if p == nil || done/4096 == p.checked {
+		return
+	}
+	p.checked = done / 4096
+	now := time.Now()
+	elapsed := now.Sub(p.last)
+	if elapsed < ProgressEvery {
+		return
+	}
+	rate := float64(done-p.lastDone) / elapsed.Seconds()
+	p.last, p.lastDone = now, done
+	if p.total <= 0 {
+		slog.Info("progress", "phase", p.name, "done", done, "per_sec", math.Round(rate))
+		return
+	}
+	eta := time.Duration(float64(p.total-done) / rate * float64(time.Second))
+	slog.Info("progress", "phase", p.name, "pct", Round(100*float64(done)/float64(p.total)),
+		"done", done, "total", p.total, "per_sec", math.Round(rate), "eta", eta.Round(time.Second))
+}
+
+// Ratio is how many times longer a took than b.
+func Ratio(a, b time.Duration) float64 {
+	return Round(float64(a) / float64(b))
+}
+
+// Round keeps two decimals, plenty for a report.
+func Round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+// Percentile is the duration below which p of ds fall, 0 to 1, sorting
+// ds in place. It is 0 for no ds.
+func Percentile(ds []time.Duration, p float64) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	slices.Sort(ds)
+	return ds[min(len(ds)-1, int(p*float64(len(ds))))]
+}
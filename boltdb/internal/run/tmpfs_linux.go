@@ -0,0 +1,22 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const tmpfsMagic = 0x01021994
+
+// TmpfsDir creates a directory on a RAM-backed tmpfs, Linux's /dev/shm,
+// for the caller to remove once done: until then its files hold memory.
+func TmpfsDir() (string, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs("/dev/shm", &fs); err != nil {
+		return "", fmt.Errorf("tmpfs: %w", err)
+	}
+	if fs.Type != tmpfsMagic {
+		return "", fmt.Errorf("tmpfs: /dev/shm is not a tmpfs mount")
+	}
+	return os.MkdirTemp("/dev/shm", "boltdb-")
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package run
+
+import "errors"
+
+// TmpfsDir needs Linux's /dev/shm.
+func TmpfsDir() (string, error) {
+	return "", errors.New("tmpfs: only supported on Linux")
+}
@@ -0,0 +1,79 @@
+package run
+
+import (
+	"math"
+	"runtime/metrics"
+	"time"
+)
+
+// Usage is what a phase took from the runtime besides time: the peak live
+// heap and goroutine count seen while it ran, and the time goroutines
+// spent runnable but waiting for a thread, the scheduler's overhead.
+type Usage struct {
+	PeakHeap       uint64
+	PeakGoroutines int
+	SchedWait      time.Duration
+}
+
+var usageMetrics = []metrics.Sample{
+	{Name: "/memory/classes/heap/objects:bytes"},
+	{Name: "/sched/goroutines:goroutines"},
+	{Name: "/sched/latencies:seconds"},
+}
+
+// Watch samples the heap and goroutines every interval until the
+// returned func is called, which returns the Usage since Watch. Peaks
+// between samples are missed.
+func Watch(interval time.Duration) (stop func() Usage) {
+	samples := make([]metrics.Sample, len(usageMetrics))
+	copy(samples, usageMetrics)
+	metrics.Read(samples)
+	waitBefore := schedWait(samples[2].Value.Float64Histogram())
+	var u Usage
+	peak := func() {
+		u.PeakHeap = max(u.PeakHeap, samples[0].Value.Uint64())
+		u.PeakGoroutines = max(u.PeakGoroutines, int(samples[1].Value.Uint64()))
+	}
+	peak()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				metrics.Read(samples[:2])
+				peak()
+			}
+		}
+	}()
+	return func() Usage {
+		close(done)
+		<-stopped
+		metrics.Read(samples)
+		peak()
+		u.SchedWait = time.Duration((schedWait(samples[2].Value.Float64Histogram()) - waitBefore) * float64(time.Second))
+		return u
+	}
+}
+
+// schedWait adds up a scheduling latency histogram, each count at the
+// middle of its bucket, or its finite edge.
+func schedWait(h *metrics.Float64Histogram) float64 {
+	var total float64
+	for i, n := range h.Counts {
+		lo, hi := h.Buckets[i], h.Buckets[i+1]
+		switch {
+		case math.IsInf(lo, -1):
+			lo = hi
+		case math.IsInf(hi, 1):
+			hi = lo
+		}
+		total += float64(n) * (lo + hi) / 2
+	}
+	return total
+}
@@ -0,0 +1,172 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// Open opens path, which is a file, "-" for stdin, an http(s) URL or
+// an s3://bucket/key URL of a public object, retrying failed downloads
+// up to retries times. name is the part of path whose extension gives
+// the format.
+func Open(path string, retries int) (r io.ReadCloser, name string, err error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), path, nil
+	}
+	u, err := url.Parse(path)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "s3") {
+		f, err := os.Open(path)
+		return f, path, err
+	}
+	if u.Scheme == "s3" {
+		// unsigned, so only public objects
+		u = &url.URL{Scheme: "https", Host: u.Host + ".s3.amazonaws.com", Path: u.Path}
+	}
+	return &httpReader{url: u.String(), max: retries}, u.Path, nil
+}
+
+// httpReader streams a URL, and when the connection fails part way
+// through retries up to max times with exponential backoff, asking the
+// server to resume where it left off.
+type httpReader struct {
+	url    string
+	body   io.ReadCloser
+	offset int64
+	// retries since the last successful read
+	retries int
+	max     int
+}
+
+func (h *httpReader) Read(p []byte) (int, error) {
+	for {
+		if h.body == nil {
+			err := h.open()
+			if err != nil {
+				if h.retry(err) {
+					continue
+				}
+				return 0, err
+			}
+		}
+		n, err := h.body.Read(p)
+		h.offset += int64(n)
+		if n > 0 {
+			h.retries = 0
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		h.body.Close()
+		h.body = nil
+		if n > 0 {
+			return n, nil
+		}
+		if !h.retry(err) {
+			return 0, err
+		}
+	}
+}
+
+// retry reports whether to try again after err, sleeping first.
+func (h *httpReader) retry(err error) bool {
+	if h.retries >= h.max {
+		return false
+	}
+	backoff := time.Duration(1<<h.retries) * 100 * time.Millisecond
+	h.retries++
+	slog.Warn("retrying download", "url", h.url, "offset", h.offset, "err", err, "backoff", backoff)
+	time.Sleep(backoff)
+	return true
+}
+
+func (h *httpReader) open() error {
+	req, err := http.NewRequest("GET", h.url, nil)
+	if err != nil {
+		return err
+	}
+	if h.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", h.offset))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && h.offset > 0:
+	case resp.StatusCode == http.StatusOK:
+		// no range support, skip what was already read
+		_, err = io.CopyN(io.Discard, resp.Body, h.offset)
+		if err != nil {
+			resp.Body.Close()
+			return err
+		}
+	default:
+		resp.Body.Close()
+		return fmt.Errorf("get %s: %s", h.url, resp.Status)
+	}
+	h.body = resp.Body
+	return nil
+}
+
+func (h *httpReader) Close() error {
+	if h.body == nil {
+		return nil
+	}
+	return h.body.Close()
+}
+
+// compressedExt matches the extensions decompress handles, to see past
+// them to the format.
+var compressedExt = regexp.MustCompile(`\.(gz|zst|zstd)$`)
+
+// Decompress wraps r in a gzip or zstd reader if its first bytes are the
+// magic number of either, so importers read compressed files as is.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		d, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return io.NopCloser(br), nil
+}
+
+// hashingReader counts and hashes what is read through it.
+// total, if set, counts the bytes of every file being loaded.
+type hashingReader struct {
+	r     io.Reader
+	h     hash.Hash
+	n     int64
+	total *atomic.Int64
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.h.Write(p[:n])
+	h.n += int64(n)
+	if h.total != nil {
+		h.total.Add(int64(n))
+	}
+	return n, err
+}
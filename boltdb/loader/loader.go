@@ -0,0 +1,271 @@
+// Package loader fills a storage.Store from graph files, local or
+// downloaded and optionally compressed, several at once. It is what the
+// boltdb load command runs, for programs that want the same ingestion
+// without shelling out to it.
+//
+// Like the rest of this module, malformed input rows are logged and exit
+// the process rather than being returned as errors.
+package loader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/jogo/goplayground/boltdb/graph"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options configures Load, the zero value loads one file at a time by
+// its extension.
+type Options struct {
+	// Format is csv, jsonl, tsv (an edge list), graphml, parquet (an edge
+	// table) or pbf (OpenStreetMap roads), default the file's extension
+	Format string
+	// Rows is how csv rows are read: kv (key then value items, the
+	// default) or edges (node,neighbor[,weight])
+	Rows string
+	// Header skips the first row of csv files
+	Header bool
+	// Columns are the parquet source, destination and optional weight
+	// columns, default src,dst,weight
+	Columns string
+	// Retries is how many times to retry a failed download
+	Retries int
+	// Workers is how many files are parsed at once, default 1
+	Workers int
+	// Progress, if set, is called every ProgressEvery (default a
+	// second) and after each file. Calls are serialized.
+	Progress      func(Progress)
+	ProgressEvery time.Duration
+}
+
+// Progress is where a Load has got to.
+type Progress struct {
+	// Files of Total have been loaded
+	Files, Total int
+	// Rows were parsed from the loaded files
+	Rows int64
+	// Bytes were read from all files so far, as stored
+	Bytes   int64
+	Elapsed time.Duration
+	// Done is the file just loaded, nil for a periodic update
+	Done *File
+}
+
+// File is what loading one input produced.
+type File struct {
+	storage.Source
+	Rows int
+	Took time.Duration
+}
+
+// Result is what a Load got through, Files sorted by path.
+type Result struct {
+	Files []File
+	Rows  int64
+	Took  time.Duration
+}
+
+// coordsWriter is implemented by stores that keep node positions, such
+// as storage.Bolt.
+type coordsWriter interface {
+	WriteCoords(kind string, coords map[string][2]float64)
+}
+
+// Load parses every input of source, see Inputs and Open, into s and
+// flushes it. Once ctx is done the files being parsed stop early, no
+// more are started and Load returns what was loaded with ctx's error.
+func Load(ctx context.Context, source string, s storage.Store, opts Options) (Result, error) {
+	if source == "-" && opts.Format == "" {
+		return Result{}, fmt.Errorf("loading stdin needs a format")
+	}
+	if opts.Workers == 0 {
+		opts.Workers = 1
+	}
+	if opts.Workers < 1 {
+		return Result{}, fmt.Errorf("need at least one worker, not %d", opts.Workers)
+	}
+	if opts.Columns == "" {
+		opts.Columns = "src,dst,weight"
+	}
+	if opts.Rows == "" {
+		opts.Rows = "kv"
+	}
+	if opts.ProgressEvery == 0 {
+		opts.ProgressEvery = time.Second
+	}
+	paths, err := Inputs(source)
+	if err != nil {
+		return Result{}, err
+	}
+
+	start := time.Now()
+	as := graph.NewAppendStore(s)
+	var res Result
+	var mu sync.Mutex
+	var bytes atomic.Int64
+	progress := func(done *File) {
+		// called with mu held
+		if opts.Progress != nil {
+			opts.Progress(Progress{Files: len(res.Files), Total: len(paths), Rows: res.Rows,
+				Bytes: bytes.Load(), Elapsed: time.Since(start), Done: done})
+		}
+	}
+	stop := make(chan struct{})
+	if opts.Progress != nil {
+		go func() {
+			t := time.NewTicker(opts.ProgressEvery)
+			defer t.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-t.C:
+					mu.Lock()
+					progress(nil)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	todo := make(chan string)
+	errs := make(chan error, len(paths))
+	var workers sync.WaitGroup
+	for w := 0; w < min(opts.Workers, len(paths)); w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for path := range todo {
+				f, err := loadFile(ctx, path, as, opts, &bytes)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				mu.Lock()
+				res.Files = append(res.Files, f)
+				res.Rows += int64(f.Rows)
+				progress(&f)
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, path := range paths {
+		select {
+		case todo <- path:
+		case <-ctx.Done():
+			break feed
+		case err = <-errs:
+			break feed
+		}
+	}
+	close(todo)
+	workers.Wait()
+	close(stop)
+	s.Flush()
+	res.Took = time.Since(start)
+	sort.Slice(res.Files, func(i, j int) bool {
+		return res.Files[i].Path < res.Files[j].Path
+	})
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+			err = ctx.Err()
+		}
+	}
+	return res, err
+}
+
+// Inputs expands source into the inputs to load: every file in a
+// directory, the matches of a glob, or else just source.
+func Inputs(source string) ([]string, error) {
+	if strings.ContainsAny(source, "*?[") {
+		paths, err := filepath.Glob(source)
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("%s matches no files", source)
+		}
+		return paths, nil
+	}
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		// not a directory, Open will complain if it is nothing else
+		return []string{source}, nil
+	}
+	var paths []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+			paths = append(paths, filepath.Join(source, e.Name()))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("directory %s has no files", source)
+	}
+	return paths, nil
+}
+
+// loadFile parses one input into s, coordinates go straight to the
+// underlying store if it keeps them, counting what it reads in bytes.
+func loadFile(ctx context.Context, path string, s *graph.AppendStore, opts Options, bytes *atomic.Int64) (File, error) {
+	f, name, err := Open(path, opts.Retries)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+	hr := &hashingReader{r: f, h: sha256.New(), total: bytes}
+	in, err := Decompress(hr)
+	if err != nil {
+		return File{}, fmt.Errorf("%s: %w", path, err)
+	}
+	defer in.Close()
+	start := time.Now()
+	format := opts.Format
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(compressedExt.ReplaceAllString(name, "")), ".")
+	}
+	var rows int
+	switch format {
+	case "csv":
+		rows = graph.LoadCSV(ctx, in, s, opts.Rows, opts.Header)
+	case "jsonl", "ndjson":
+		rows = graph.LoadJSONL(ctx, in, s)
+	case "tsv", "txt", "edgelist":
+		rows = graph.LoadEdgeList(ctx, in, s)
+	case "graphml":
+		rows = graph.LoadGraphML(ctx, in, s)
+	case "parquet":
+		rows = graph.LoadParquet(ctx, in, s, opts.Columns)
+	case "pbf":
+		var coords map[string][2]float64
+		rows, coords = graph.LoadOSM(ctx, in, s)
+		if cw, ok := s.Store.(coordsWriter); ok {
+			cw.WriteCoords(storage.LatLon, coords)
+		}
+	default:
+		return File{}, fmt.Errorf("%s: unknown format %q", path, format)
+	}
+	took := time.Since(start)
+	// hash all of it, parsers may stop short of the end
+	if !run.Done(ctx) {
+		_, err = io.Copy(io.Discard, hr)
+		if err != nil {
+			return File{}, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	src := storage.Source{Path: path, Format: format, Bytes: hr.n, SHA256: hex.EncodeToString(hr.h.Sum(nil))}
+	return File{Source: src, Rows: rows, Took: took}, nil
+}
@@ -0,0 +1,124 @@
+package loader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecompress(t *testing.T) {
+	const input = "0\t1\n1\t0\n"
+	var gz, zst bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(input))
+	zw.Close()
+	enc, err := zstd.NewWriter(&zst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc.Write([]byte(input))
+	enc.Close()
+	for name, data := range map[string][]byte{"plain": []byte(input), "gzip": gz.Bytes(), "zstd": zst.Bytes(), "empty": nil} {
+		r, err := Decompress(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		want := input
+		if data == nil {
+			want = ""
+		}
+		if err != nil || string(got) != want {
+			t.Errorf("%s: read %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestHTTPReaderResumes(t *testing.T) {
+	body := strings.Repeat("0\t1\n", 10000)
+	dropped := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !dropped {
+			// send half, then hang up
+			dropped = true
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write([]byte(body[:len(body)/2]))
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "data.tsv", time.Time{}, strings.NewReader(body))
+	}))
+	defer srv.Close()
+	r, name, err := Open(srv.URL+"/data.tsv?x=1", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if name != "/data.tsv" {
+		t.Errorf("name %q, want /data.tsv", name)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || string(got) != body {
+		t.Errorf("read %d bytes, %v, want %d", len(got), err, len(body))
+	}
+	if !dropped {
+		t.Error("connection never dropped")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{"a.tsv": "0\t1\n1\t2\n", "b.jsonl": `{"key":"3","value":["0"]}` + "\n"} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := storage.NewMap()
+	var files []string
+	res, err := Load(context.Background(), dir, s, Options{Workers: 2, Progress: func(p Progress) {
+		if p.Done != nil {
+			files = append(files, p.Done.Path)
+		}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 2 || res.Rows != 3 || len(files) != 2 {
+		t.Errorf("loaded %d files and %d rows, %d progress calls", len(res.Files), res.Rows, len(files))
+	}
+	if res.Files[0].Path != filepath.Join(dir, "a.tsv") || res.Files[0].SHA256 == "" {
+		t.Errorf("first file %+v", res.Files[0])
+	}
+	for key, want := range map[string][]string{"0": {"1"}, "1": {"2"}, "3": {"0"}} {
+		got, err := s.Get(key)
+		if err != nil || !storage.SameValue(got, want) {
+			t.Errorf("%s = %q, %v, want %q", key, got, err, want)
+		}
+	}
+
+	_, err = Load(context.Background(), filepath.Join(dir, "*.xml"), s, Options{})
+	if err == nil {
+		t.Error("glob matching nothing loaded")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err = Load(ctx, dir, storage.NewMap(), Options{})
+	if err != context.Canceled {
+		t.Errorf("cancelled load returned %v after %d files", err, len(res.Files))
+	}
+}
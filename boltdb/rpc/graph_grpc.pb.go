@@ -0,0 +1,171 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: graph.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Graph_Neighbors_FullMethodName    = "/goplayground.boltdb.graph.Graph/Neighbors"
+	Graph_ShortestPath_FullMethodName = "/goplayground.boltdb.graph.Graph/ShortestPath"
+)
+
+// GraphClient is the client API for Graph service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Graph answers lookups against a graph loaded into a boltdb store.
+type GraphClient interface {
+	// Neighbors returns a node's adjacency list, NOT_FOUND if it isn't
+	// stored.
+	Neighbors(ctx context.Context, in *NeighborsRequest, opts ...grpc.CallOption) (*NeighborsResponse, error)
+	// ShortestPath runs A* between two nodes, NOT_FOUND if either end is
+	// missing or no path joins them.
+	ShortestPath(ctx context.Context, in *ShortestPathRequest, opts ...grpc.CallOption) (*ShortestPathResponse, error)
+}
+
+type graphClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGraphClient(cc grpc.ClientConnInterface) GraphClient {
+	return &graphClient{cc}
+}
+
+func (c *graphClient) Neighbors(ctx context.Context, in *NeighborsRequest, opts ...grpc.CallOption) (*NeighborsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(NeighborsResponse)
+	err := c.cc.Invoke(ctx, Graph_Neighbors_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *graphClient) ShortestPath(ctx context.Context, in *ShortestPathRequest, opts ...grpc.CallOption) (*ShortestPathResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ShortestPathResponse)
+	err := c.cc.Invoke(ctx, Graph_ShortestPath_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GraphServer is the server API for Graph service.
+// All implementations must embed UnimplementedGraphServer
+// for forward compatibility.
+//
+// Graph answers lookups against a graph loaded into a boltdb store.
+type GraphServer interface {
+	// Neighbors returns a node's adjacency list, NOT_FOUND if it isn't
+	// stored.
+	Neighbors(context.Context, *NeighborsRequest) (*NeighborsResponse, error)
+	// ShortestPath runs A* between two nodes, NOT_FOUND if either end is
+	// missing or no path joins them.
+	ShortestPath(context.Context, *ShortestPathRequest) (*ShortestPathResponse, error)
+	mustEmbedUnimplementedGraphServer()
+}
+
+// UnimplementedGraphServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedGraphServer struct{}
+
+func (UnimplementedGraphServer) Neighbors(context.Context, *NeighborsRequest) (*NeighborsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Neighbors not implemented")
+}
+func (UnimplementedGraphServer) ShortestPath(context.Context, *ShortestPathRequest) (*ShortestPathResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ShortestPath not implemented")
+}
+func (UnimplementedGraphServer) mustEmbedUnimplementedGraphServer() {}
+func (UnimplementedGraphServer) testEmbeddedByValue()               {}
+
+// UnsafeGraphServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GraphServer will
+// result in compilation errors.
+type UnsafeGraphServer interface {
+	mustEmbedUnimplementedGraphServer()
+}
+
+func RegisterGraphServer(s grpc.ServiceRegistrar, srv GraphServer) {
+	// If the following call panics, it indicates UnimplementedGraphServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Graph_ServiceDesc, srv)
+}
+
+func _Graph_Neighbors_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NeighborsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GraphServer).Neighbors(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Graph_Neighbors_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GraphServer).Neighbors(ctx, req.(*NeighborsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Graph_ShortestPath_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ShortestPathRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GraphServer).ShortestPath(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Graph_ShortestPath_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GraphServer).ShortestPath(ctx, req.(*ShortestPathRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Graph_ServiceDesc is the grpc.ServiceDesc for Graph service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Graph_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "goplayground.boltdb.graph.Graph",
+	HandlerType: (*GraphServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Neighbors",
+			Handler:    _Graph_Neighbors_Handler,
+		},
+		{
+			MethodName: "ShortestPath",
+			Handler:    _Graph_ShortestPath_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "graph.proto",
+}
@@ -0,0 +1,78 @@
+// Package rpc serves neighbor and shortest path queries against a loaded
+// graph over gRPC, see graph.proto, so other services and languages can
+// query it without linking these packages.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative graph.proto
+
+import (
+	"context"
+	"errors"
+	"github.com/jogo/goplayground/boltdb/graph"
+	"github.com/jogo/goplayground/boltdb/search"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements GraphServer on top of a store, usually read-only and
+// cached.
+type Server struct {
+	UnimplementedGraphServer
+	r search.Reader
+	h search.Heuristic
+	// Find answers ShortestPath, search.Find if nil
+	Find search.Func
+}
+
+// NewServer answers from r, searching with h.
+func NewServer(r search.Reader, h search.Heuristic) *Server {
+	return &Server{r: r, h: h}
+}
+
+func (s *Server) Neighbors(ctx context.Context, req *NeighborsRequest) (*NeighborsResponse, error) {
+	if req.Node == "" {
+		return nil, status.Error(codes.InvalidArgument, "want a node")
+	}
+	value, err := s.r.Get(req.Node)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &NeighborsResponse{Node: req.Node, Edges: make([]*Edge, len(value))}
+	for i, edge := range value {
+		node, weight, err := graph.ParseEdge(edge)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		resp.Edges[i] = &Edge{Node: node, Weight: weight}
+	}
+	return resp, nil
+}
+
+func (s *Server) ShortestPath(ctx context.Context, req *ShortestPathRequest) (*ShortestPathResponse, error) {
+	if req.From == "" || req.To == "" {
+		return nil, status.Error(codes.InvalidArgument, "want from and to")
+	}
+	find := s.Find
+	if find == nil {
+		find = search.Find
+	}
+	path, expanded, err := find(ctx, s.r, req.From, req.To, s.h)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ShortestPathResponse{Path: path, Expanded: int64(expanded)}, nil
+}
+
+// toStatus maps the store's and the search's errors to gRPC codes.
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, search.ErrNoPath):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
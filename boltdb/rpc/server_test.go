@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"context"
+	"github.com/jogo/goplayground/boltdb/graph"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	m := storage.NewMap()
+	m.Writer("a", []string{graph.FormatEdge("b", "2"), graph.FormatEdge("c", "")})
+	m.Writer("b", []string{graph.FormatEdge("d", "1")})
+	m.Writer("c", []string{graph.FormatEdge("d", "5")})
+	m.Writer("d", nil)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	RegisterGraphServer(srv, NewServer(m, func(a, b string) float64 { return 0 }))
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewGraphClient(conn)
+	ctx := context.Background()
+
+	n, err := client.Neighbors(ctx, &NeighborsRequest{Node: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type edge struct {
+		node   string
+		weight float64
+	}
+	var edges []edge
+	for _, e := range n.Edges {
+		edges = append(edges, edge{e.Node, e.Weight})
+	}
+	if want := []edge{{"b", 2}, {"c", 1}}; !reflect.DeepEqual(edges, want) {
+		t.Errorf("neighbors of a %v, want %v", edges, want)
+	}
+
+	p, err := client.ShortestPath(ctx, &ShortestPathRequest{From: "a", To: "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "d"}; !reflect.DeepEqual(p.Path, want) {
+		t.Errorf("path %v, want %v", p.Path, want)
+	}
+
+	for _, tt := range []struct {
+		call func() error
+		code codes.Code
+	}{
+		{func() error { _, err := client.Neighbors(ctx, &NeighborsRequest{Node: "x"}); return err }, codes.NotFound},
+		{func() error { _, err := client.Neighbors(ctx, &NeighborsRequest{}); return err }, codes.InvalidArgument},
+		{func() error { _, err := client.ShortestPath(ctx, &ShortestPathRequest{From: "d", To: "a"}); return err }, codes.NotFound},
+	} {
+		if got := status.Code(tt.call()); got != tt.code {
+			t.Errorf("got %s, want %s", got, tt.code)
+		}
+	}
+}
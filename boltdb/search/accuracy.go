@@ -0,0 +1,114 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jogo/goplayground/boltdb/graph"
+	"math"
+)
+
+// Accuracy compares a heuristic's estimates to the true distances left
+// from the nodes of shortest paths to their targets.
+type Accuracy struct {
+	Queries, Nodes int
+	// Over counts estimates above the true distance, each one a chance
+	// for AStar to return a path that isn't shortest
+	Over    int
+	MaxOver float64
+	// MeanRatio and MinRatio are of estimate to true distance, over the
+	// nodes not at their target. Close to 1 the heuristic guides A*
+	// well, close to 0 A* expands about as much as Dijkstra.
+	MeanRatio, MinRatio float64
+}
+
+// CheckHeuristic finds the shortest path of each pair, from to to, by
+// Dijkstra, which doesn't trust h, and compares h at every node of it to
+// the rest of the path's length. Pairs without a path are skipped.
+func CheckHeuristic(ctx context.Context, r Reader, pairs [][2]string, h Heuristic) (Accuracy, error) {
+	a := Accuracy{MinRatio: math.Inf(1)}
+	dijkstra := func(string, string) float64 { return 0 }
+	sum, ratios := 0.0, 0
+	for _, pair := range pairs {
+		from, to := pair[0], pair[1]
+		path, _, err := AStar(ctx, r, from, to, dijkstra, nil, 0)
+		if err != nil {
+			if errors.Is(err, ErrNoPath) {
+				continue
+			}
+			return a, err
+		}
+		costs, err := stepCosts(r, path)
+		if err != nil {
+			return a, err
+		}
+		a.Queries++
+		left := 0.0
+		for i := len(path) - 1; i >= 0; i-- {
+			if i < len(path)-1 {
+				left += costs[i]
+			}
+			est := h(path[i], to)
+			a.Nodes++
+			// a little slack for float sums of weights
+			if est > left+1e-9 {
+				a.Over++
+				a.MaxOver = max(a.MaxOver, est-left)
+			}
+			if left > 0 {
+				ratio := est / left
+				sum += ratio
+				ratios++
+				a.MinRatio = min(a.MinRatio, ratio)
+			}
+		}
+	}
+	if ratios > 0 {
+		a.MeanRatio = sum / float64(ratios)
+	} else {
+		a.MinRatio = 0
+	}
+	return a, nil
+}
+
+// PathCost is the length of path, the sum of its edges' weights.
+func PathCost(r Reader, path []string) (float64, error) {
+	if len(path) < 2 {
+		return 0, nil
+	}
+	costs, err := stepCosts(r, path)
+	if err != nil {
+		return 0, err
+	}
+	cost := 0.0
+	for _, c := range costs {
+		cost += c
+	}
+	return cost, nil
+}
+
+// stepCosts are the weights of the edges between the nodes of path, the
+// lightest where there are several.
+func stepCosts(r Reader, path []string) ([]float64, error) {
+	costs := make([]float64, len(path)-1)
+	for i := range costs {
+		neighbors, err := r.Get(path[i])
+		if err != nil {
+			return nil, err
+		}
+		costs[i] = math.Inf(1)
+		for _, edge := range neighbors {
+			next, weight, err := graph.ParseEdge(edge)
+			if err != nil {
+				return nil, err
+			}
+			if next == path[i+1] {
+				costs[i] = min(costs[i], weight)
+			}
+		}
+		if math.IsInf(costs[i], 1) {
+			return nil, fmt.Errorf("no edge from %s to %s", path[i], path[i+1])
+		}
+	}
+	return costs, nil
+}
@@ -0,0 +1,216 @@
+// Package search finds shortest paths through graphs stored as
+// adjacency lists, see package graph.
+package search
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jogo/goplayground/boltdb/graph"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/jogo/goplayground/boltdb/storage"
+)
+
+// Reader is where the search reads adjacency lists from
+type Reader interface {
+	Get(key string) ([]string, error)
+}
+
+// Lister is a Reader that can hand out a node's neighbors one at a time,
+// so a hub's adjacency list needn't be held in memory whole, see
+// storage.WithBlockSize.
+type Lister interface {
+	Reader
+	EachItem(key string, fn func(item string) error) error
+}
+
+// IntoReader is a Reader that can decode an adjacency list into a slice
+// the search reuses from one expansion to the next, see
+// storage.IntoReader.
+type IntoReader interface {
+	Reader
+	GetInto(key string, dst *[]string) error
+}
+
+// Heuristic estimates the distance between two nodes, for A* to find
+// shortest paths it must never overestimate.
+type Heuristic func(a, b string) float64
+
+// ErrNoPath is returned when every node reachable from the start was
+// expanded without finding the target.
+var ErrNoPath = errors.New("no path")
+
+type openItem struct {
+	id   string
+	f, g float64
+}
+
+// openSet is a min-heap of nodes to expand ordered by f, ties broken
+// towards larger g, i.e. nodes closer to the target.
+type openSet []openItem
+
+func (o openSet) Len() int { return len(o) }
+func (o openSet) Less(i, j int) bool {
+	if o[i].f == o[j].f {
+		return o[i].g > o[j].g
+	}
+	return o[i].f < o[j].f
+}
+func (o openSet) Swap(i, j int)       { o[i], o[j] = o[j], o[i] }
+func (o *openSet) Push(x interface{}) { *o = append(*o, x.(openItem)) }
+func (o *openSet) Pop() interface{} {
+	old := *o
+	item := old[len(old)-1]
+	*o = old[:len(old)-1]
+	return item
+}
+
+// Closed is A*'s set of expanded nodes, each with the node it was reached
+// from. It is most of a search's memory, an implementation can keep it
+// elsewhere, see storage.Spill.
+type Closed interface {
+	Add(id, from string) error
+	// Get returns the node id was reached from, ok is false if id
+	// wasn't expanded
+	Get(id string) (from string, ok bool, err error)
+}
+
+// closedMap is the Closed AStar keeps in memory
+type closedMap map[string]string
+
+func (c closedMap) Add(id, from string) error {
+	c[id] = from
+	return nil
+}
+
+func (c closedMap) Get(id string) (string, bool, error) {
+	from, ok := c[id]
+	return from, ok, nil
+}
+
+// AStar finds a shortest path from from to to, reading adjacency lists
+// of graph.FormatEdge entries from r. If prefetch is set it is called after
+// each expansion with the depth nodes at the top of the open set, which
+// are the likely next expansions. Once ctx is done it gives up with ctx's
+// error.
+func AStar(ctx context.Context, r Reader, from, to string, h Heuristic, prefetch func(key string), depth int) (path []string, expanded int, err error) {
+	return astar(ctx, r, from, to, h, prefetch, depth, make(closedMap))
+}
+
+// AStarClosed is AStar keeping the expanded nodes in closed, only the
+// open set stays in memory.
+func AStarClosed(ctx context.Context, r Reader, from, to string, h Heuristic, closed Closed) (path []string, expanded int, err error) {
+	return astar(ctx, r, from, to, h, nil, 0, closed)
+}
+
+func astar(ctx context.Context, r Reader, from, to string, h Heuristic, prefetch func(key string), depth int, closed Closed) (path []string, expanded int, err error) {
+	r, end, err := begin(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer end()
+	open := &openSet{{id: from, f: h(from, to)}}
+	var neighbors []string
+	// g and cameFrom of the open nodes, expanded ones move to closed
+	g := map[string]float64{from: 0}
+	cameFrom := make(map[string]string)
+	for open.Len() > 0 {
+		current := heap.Pop(open).(openItem)
+		_, done, err := closed.Get(current.id)
+		if err != nil {
+			return nil, expanded, err
+		}
+		if done {
+			continue
+		}
+		if current.id == to {
+			for node := to; node != from; {
+				path = append(path, node)
+				if node == to {
+					node = cameFrom[to]
+				} else if node, _, err = closed.Get(node); err != nil {
+					return nil, expanded, err
+				}
+			}
+			path = append(path, from)
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path, expanded, nil
+		}
+		if run.Done(ctx) {
+			return nil, expanded, ctx.Err()
+		}
+		err = closed.Add(current.id, cameFrom[current.id])
+		if err != nil {
+			return nil, expanded, err
+		}
+		delete(g, current.id)
+		delete(cameFrom, current.id)
+		expanded++
+
+		relax := func(edge string) error {
+			next, weight, err := graph.ParseEdge(edge)
+			if err != nil {
+				return err
+			}
+			if _, done, err := closed.Get(next); err != nil || done {
+				return err
+			}
+			tentative := current.g + weight
+			if old, ok := g[next]; ok && old <= tentative {
+				return nil
+			}
+			g[next] = tentative
+			cameFrom[next] = current.id
+			heap.Push(open, openItem{id: next, f: tentative + h(next, to), g: tentative})
+			return nil
+		}
+		err = eachEdge(r, current.id, &neighbors, relax)
+		if err != nil {
+			return nil, expanded, fmt.Errorf("expanding %s: %w", current.id, err)
+		}
+		if prefetch != nil {
+			for i := 0; i < len(*open) && i < depth; i++ {
+				prefetch((*open)[i].id)
+			}
+		}
+	}
+	return nil, expanded, fmt.Errorf("%w from %s to %s", ErrNoPath, from, to)
+}
+
+// begin reads r through one transaction for the whole search if it can
+// begin one, see storage.ReadTxn, rather than one transaction per node
+// expanded. end closes it.
+func begin(r Reader) (txn Reader, end func(), err error) {
+	t, ok := r.(storage.TxnReader)
+	if !ok {
+		return r, func() {}, nil
+	}
+	tx, err := t.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	return tx, func() { tx.Close() }, nil
+}
+
+// eachEdge calls fn with each item of id's adjacency list, one at a time
+// if r is a Lister. An IntoReader reads the list into *buf.
+func eachEdge(r Reader, id string, buf *[]string, fn func(edge string) error) error {
+	if l, ok := r.(Lister); ok {
+		return l.EachItem(id, fn)
+	}
+	var neighbors []string
+	var err error
+	if into, ok := r.(IntoReader); ok {
+		err = into.GetInto(id, buf)
+		neighbors = *buf
+	} else {
+		neighbors, err = r.Get(id)
+	}
+	for i := 0; err == nil && i < len(neighbors); i++ {
+		err = fn(neighbors[i])
+	}
+	return err
+}
@@ -0,0 +1,335 @@
+package search
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jogo/goplayground/boltdb/graph"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"math"
+)
+
+// Func is a shortest path search, see Parse.
+type Func func(ctx context.Context, r Reader, from, to string, h Heuristic) (path []string, expanded int, err error)
+
+// Find is AStar without prefetching, as a Func.
+func Find(ctx context.Context, r Reader, from, to string, h Heuristic) (path []string, expanded int, err error) {
+	return AStar(ctx, r, from, to, h, nil, 0)
+}
+
+// ErrBudget is returned by SMAStar when no path fits in its node budget.
+var ErrBudget = errors.New("no path within the memory budget")
+
+// Parse returns the search called name: astar, or one of the variants
+// whose memory doesn't grow with the part of the graph searched, ida
+// (IDAStar) or sma (SMAStar holding at most maxNodes nodes).
+func Parse(name string, maxNodes int) (Func, error) {
+	switch name {
+	case "astar":
+		return Find, nil
+	case "ida":
+		return IDAStar, nil
+	case "sma":
+		if maxNodes < 2 {
+			return nil, fmt.Errorf("sma needs room for at least 2 nodes, not %d", maxNodes)
+		}
+		return func(ctx context.Context, r Reader, from, to string, h Heuristic) ([]string, int, error) {
+			return SMAStar(ctx, r, from, to, h, maxNodes)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown search: %q", name)
+}
+
+// IDAStar is iterative deepening A*: depth first searches bounded by f,
+// the bound raised to the smallest f that went over it each round. It
+// holds only the current path, at the cost of expanding nodes again every
+// round and once per path reaching them.
+func IDAStar(ctx context.Context, r Reader, from, to string, h Heuristic) (path []string, expanded int, err error) {
+	r, end, err := begin(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer end()
+	path = []string{from}
+	onPath := map[string]bool{from: true}
+	// dfs returns whether it found to, or else the smallest f over bound
+	var dfs func(g, bound float64) (bool, float64, error)
+	dfs = func(g, bound float64) (bool, float64, error) {
+		node := path[len(path)-1]
+		f := g + h(node, to)
+		if f > bound {
+			return false, f, nil
+		}
+		if node == to {
+			return true, f, nil
+		}
+		if run.Done(ctx) {
+			return false, 0, ctx.Err()
+		}
+		expanded++
+		neighbors, err := r.Get(node)
+		if err != nil {
+			return false, 0, fmt.Errorf("expanding %s: %w", node, err)
+		}
+		next := math.Inf(1)
+		for _, edge := range neighbors {
+			id, weight, err := graph.ParseEdge(edge)
+			if err != nil {
+				return false, 0, fmt.Errorf("expanding %s: %w", node, err)
+			}
+			if onPath[id] {
+				continue
+			}
+			path = append(path, id)
+			onPath[id] = true
+			found, over, err := dfs(g+weight, bound)
+			if found || err != nil {
+				return found, over, err
+			}
+			path = path[:len(path)-1]
+			delete(onPath, id)
+			next = min(next, over)
+		}
+		return false, next, nil
+	}
+	for bound := h(from, to); ; {
+		found, next, err := dfs(0, bound)
+		if err != nil {
+			return nil, expanded, err
+		}
+		if found {
+			return path, expanded, nil
+		}
+		if math.IsInf(next, 1) {
+			return nil, expanded, fmt.Errorf("%w from %s to %s", ErrNoPath, from, to)
+		}
+		bound = next
+	}
+}
+
+// smaNode is a node of SMAStar's search tree.
+type smaNode struct {
+	id     string
+	g, f   float64
+	depth  int
+	parent *smaNode
+	// live children, and the f of those forgotten to make room
+	children  int
+	forgotten map[string]float64
+	// positions in SMAStar's heaps, -1 when not in them
+	open, leaf int
+}
+
+// smaOpen is a min-heap of nodes to expand by f, ties towards deeper ones.
+type smaOpen []*smaNode
+
+func (o smaOpen) Len() int { return len(o) }
+func (o smaOpen) Less(i, j int) bool {
+	if o[i].f == o[j].f {
+		return o[i].depth > o[j].depth
+	}
+	return o[i].f < o[j].f
+}
+func (o smaOpen) Swap(i, j int) {
+	o[i], o[j] = o[j], o[i]
+	o[i].open, o[j].open = i, j
+}
+func (o *smaOpen) Push(x interface{}) {
+	n := x.(*smaNode)
+	n.open = len(*o)
+	*o = append(*o, n)
+}
+func (o *smaOpen) Pop() interface{} {
+	old := *o
+	n := old[len(old)-1]
+	n.open = -1
+	*o = old[:len(old)-1]
+	return n
+}
+
+// smaLeaves is a max-heap of the open nodes without live children, the
+// ones that can be forgotten, by f, ties towards shallower ones.
+type smaLeaves []*smaNode
+
+func (l smaLeaves) Len() int { return len(l) }
+func (l smaLeaves) Less(i, j int) bool {
+	if l[i].f == l[j].f {
+		return l[i].depth < l[j].depth
+	}
+	return l[i].f > l[j].f
+}
+func (l smaLeaves) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+	l[i].leaf, l[j].leaf = i, j
+}
+func (l *smaLeaves) Push(x interface{}) {
+	n := x.(*smaNode)
+	n.leaf = len(*l)
+	*l = append(*l, n)
+}
+func (l *smaLeaves) Pop() interface{} {
+	old := *l
+	n := old[len(old)-1]
+	n.leaf = -1
+	*l = old[:len(old)-1]
+	return n
+}
+
+// SMAStar is simplified memory-bounded A*: A* over a search tree of at
+// most maxNodes nodes. When it is full the worst leaf is forgotten, its f
+// kept by its parent, which goes back on the open set to grow it again if
+// everything else turns out worse. The path it finds is a shortest one if
+// it fits, longer paths are ErrBudget. Nodes reached again are only
+// skipped while a no worse copy is in the tree, a small budget can
+// expand the same node many times.
+func SMAStar(ctx context.Context, r Reader, from, to string, h Heuristic, maxNodes int) (path []string, expanded int, err error) {
+	r, end, err := begin(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer end()
+	open := &smaOpen{}
+	leaves := &smaLeaves{}
+	// the shortest copy of each node in the tree
+	best := make(map[string]*smaNode)
+	nodes := 0
+	// whether a path may have been cut short for lack of room
+	pruned := false
+
+	push := func(n *smaNode) {
+		if n.open >= 0 {
+			heap.Fix(open, n.open)
+		} else {
+			heap.Push(open, n)
+		}
+		if n.children == 0 && n.parent != nil {
+			if n.leaf >= 0 {
+				heap.Fix(leaves, n.leaf)
+			} else {
+				heap.Push(leaves, n)
+			}
+		}
+	}
+	add := func(n *smaNode) {
+		nodes++
+		if b, ok := best[n.id]; !ok || n.g < b.g {
+			best[n.id] = n
+		}
+		if p := n.parent; p != nil {
+			if p.children++; p.leaf >= 0 {
+				heap.Remove(leaves, p.leaf)
+			}
+		}
+		push(n)
+	}
+	// forget drops the worst leaf, its parent remembers its f
+	forget := func() {
+		n := heap.Pop(leaves).(*smaNode)
+		heap.Remove(open, n.open)
+		nodes--
+		if best[n.id] == n {
+			delete(best, n.id)
+		}
+		p := n.parent
+		p.children--
+		if p.forgotten == nil {
+			p.forgotten = make(map[string]float64)
+		}
+		p.forgotten[n.id] = n.f
+		// in the open set p's f is the best of its forgotten children
+		if p.open < 0 {
+			p.f = n.f
+		} else {
+			p.f = min(p.f, n.f)
+		}
+		push(p)
+		pruned = true
+	}
+
+	add(&smaNode{id: from, f: h(from, to), open: -1, leaf: -1})
+	for open.Len() > 0 {
+		current := (*open)[0]
+		if math.IsInf(current.f, 1) {
+			break
+		}
+		if current.id == to {
+			for n := current; n != nil; n = n.parent {
+				path = append(path, n.id)
+			}
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path, expanded, nil
+		}
+		if run.Done(ctx) {
+			return nil, expanded, ctx.Err()
+		}
+		heap.Pop(open)
+		if current.leaf >= 0 {
+			heap.Remove(leaves, current.leaf)
+		}
+		expanded++
+
+		neighbors, err := r.Get(current.id)
+		if err != nil {
+			return nil, expanded, fmt.Errorf("expanding %s: %w", current.id, err)
+		}
+		// the first expansion grows every child, later ones only those
+		// forgotten since
+		regrow := current.forgotten
+		current.forgotten = nil
+		// forgetting a child to make room changes current.f
+		parentF := current.f
+		for _, edge := range neighbors {
+			id, weight, err := graph.ParseEdge(edge)
+			if err != nil {
+				return nil, expanded, fmt.Errorf("expanding %s: %w", current.id, err)
+			}
+			if regrow != nil {
+				if _, ok := regrow[id]; !ok {
+					continue
+				}
+			}
+			g := current.g + weight
+			if b, ok := best[id]; ok && b.g <= g {
+				continue
+			}
+			// f never drops below the parent's, or what it was before
+			// being forgotten, h needn't be consistent
+			f := max(parentF, g+h(id, to), regrow[id])
+			if math.IsInf(f, 1) {
+				continue
+			}
+			if current.depth+1 >= maxNodes-1 && id != to {
+				// no room for anything below it
+				f = math.Inf(1)
+				pruned = true
+			}
+			if nodes == maxNodes {
+				forget()
+			}
+			add(&smaNode{id: id, g: g, f: f, depth: current.depth + 1, parent: current, open: -1, leaf: -1})
+		}
+		if current.children == 0 && current.parent != nil {
+			// a dead end, or every child was forgotten to make room
+			current.f = math.Inf(1)
+			if current.forgotten != nil {
+				current.f = minForgotten(current.forgotten)
+			}
+			push(current)
+		}
+	}
+	if pruned {
+		return nil, expanded, fmt.Errorf("%w of %d nodes from %s to %s", ErrBudget, maxNodes, from, to)
+	}
+	return nil, expanded, fmt.Errorf("%w from %s to %s", ErrNoPath, from, to)
+}
+
+func minForgotten(forgotten map[string]float64) float64 {
+	f := math.Inf(1)
+	for _, v := range forgotten {
+		f = min(f, v)
+	}
+	return f
+}
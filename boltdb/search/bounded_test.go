@@ -0,0 +1,219 @@
+package search_test
+
+import (
+	"context"
+	"errors"
+	"github.com/jogo/goplayground/boltdb/bench"
+	"github.com/jogo/goplayground/boltdb/graph"
+	"github.com/jogo/goplayground/boltdb/search"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type graphMap map[string][]string
+
+func (m graphMap) Get(key string) ([]string, error) {
+	return m[key], nil
+}
+
+// cost is the length of path, or -1 if it isn't one
+func (m graphMap) cost(path []string) float64 {
+	total := 0.0
+	for i := 1; i < len(path); i++ {
+		w := -1.0
+		for _, edge := range m[path[i-1]] {
+			if next, weight, _ := graph.ParseEdge(edge); next == path[i] && (w < 0 || weight < w) {
+				w = weight
+			}
+		}
+		if w < 0 {
+			return -1
+		}
+		total += w
+	}
+	return total
+}
+
+func TestBoundedSearch(t *testing.T) {
+	const size = 25
+	rnd := rand.New(rand.NewSource(1))
+	g := graphMap{"island": nil}
+	for i := 0; i < size; i++ {
+		key, value := bench.GridKeyValue(i, size)
+		for j, next := range value {
+			value[j] = graph.FormatEdge(next, strconv.Itoa(1+rnd.Intn(2)))
+		}
+		g[key] = value
+	}
+	h := bench.GridHeuristic(size)
+	ctx := context.Background()
+	for _, to := range []string{"24", "7", "0"} {
+		path, _, err := search.AStar(ctx, g, "0", to, h, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := g.cost(path)
+		for _, tt := range []struct {
+			name     string
+			maxNodes int
+		}{{"ida", 0}, {"sma", 12}, {"sma", 1000}} {
+			find, err := search.Parse(tt.name, tt.maxNodes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _, err := find(ctx, g, "0", to, h)
+			if err != nil {
+				t.Errorf("%s %d to %s: %v", tt.name, tt.maxNodes, to, err)
+			} else if g.cost(got) != want || got[0] != "0" || got[len(got)-1] != to {
+				t.Errorf("%s %d to %s: %v costs %v, want %v", tt.name, tt.maxNodes, to, got, g.cost(got), want)
+			}
+		}
+		spill := storage.NewSpill(t.TempDir(), 3)
+		got, _, err := search.AStarClosed(ctx, g, "0", to, h, spill)
+		spill.Close()
+		if err != nil || g.cost(got) != want {
+			t.Errorf("spilled to %s: %v costs %v, %v, want %v", to, got, g.cost(got), err, want)
+		}
+	}
+
+	if _, _, err := search.SMAStar(ctx, g, "0", "24", h, 4); !errors.Is(err, search.ErrBudget) {
+		t.Errorf("path longer than the budget: %v, want ErrBudget", err)
+	}
+	for _, name := range []string{"ida", "sma"} {
+		find, _ := search.Parse(name, 1000)
+		if _, _, err := find(ctx, g, "0", "island", h); !errors.Is(err, search.ErrNoPath) {
+			t.Errorf("%s to an island: %v, want ErrNoPath", name, err)
+		}
+	}
+}
+
+func TestCheckHeuristic(t *testing.T) {
+	const size = 25
+	g := graphMap{}
+	for i := 0; i < size; i++ {
+		key, value := bench.GridKeyValue(i, size)
+		for j, next := range value {
+			value[j] = graph.FormatEdge(next, "1")
+		}
+		g[key] = value
+	}
+	pairs := [][2]string{{"0", "24"}, {"12", "3"}, {"0", "island"}}
+	ctx := context.Background()
+	a, err := search.CheckHeuristic(ctx, g, pairs, bench.GridHeuristic(size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// manhattan distance is exact on the unweighted grid
+	if a.Queries != 2 || a.Over != 0 || a.MeanRatio != 1 || a.MinRatio != 1 {
+		t.Errorf("grid heuristic: %+v, want 2 exact queries", a)
+	}
+	double := func(a, b string) float64 { return 2 * bench.GridHeuristic(size)(a, b) }
+	a, err = search.CheckHeuristic(ctx, g, pairs, double)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Over != a.Nodes-a.Queries || a.MaxOver != 8 || a.MinRatio != 2 {
+		t.Errorf("doubled heuristic: %+v, want every node but the targets over", a)
+	}
+}
+
+func TestCoordsHeuristic(t *testing.T) {
+	const size = 25
+	g := graphMap{}
+	coords := map[string][2]float64{}
+	for i := 0; i < size; i++ {
+		key, value := bench.GridKeyValue(i, size)
+		for j, next := range value {
+			value[j] = graph.FormatEdge(next, "1")
+		}
+		g[key] = value
+		coords[key] = bench.GridCoords(i, size)
+	}
+	ctx := context.Background()
+	pairs := [][2]string{{"0", "24"}, {"12", "3"}, {"0", "4"}}
+	h, missing := search.CoordsHeuristic(storage.XY, coords, len(g))
+	if missing != 0 || h == nil {
+		t.Fatalf("grid heuristic: %d missing", missing)
+	}
+	a, err := search.CheckHeuristic(ctx, g, pairs, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the straight line is under the manhattan distance but along a row
+	if a.Queries != 3 || a.Over != 0 || a.MinRatio >= 1 {
+		t.Errorf("euclidean on the grid: %+v, want 3 queries, none over", a)
+	}
+
+	// a road of two stretches, weighted as graph.LoadOSM weighs them
+	pos := map[string][2]float64{"a": {52.52, 13.40}, "b": {52.53, 13.42}, "c": {52.50, 13.45}}
+	road := graphMap{}
+	for _, e := range [][2]string{{"a", "b"}, {"b", "c"}} {
+		meters := float32(graph.Haversine(pos[e[0]], pos[e[1]]))
+		road[e[0]] = append(road[e[0]], graph.FormatEdge(e[1], strconv.FormatFloat(float64(meters), 'g', -1, 32)))
+	}
+	road["c"] = nil
+	h, _ = search.CoordsHeuristic(storage.LatLon, pos, len(road))
+	a, err = search.CheckHeuristic(ctx, road, [][2]string{{"a", "c"}, {"b", "c"}}, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Queries != 2 || a.Over != 0 || a.MinRatio < 0.5 {
+		t.Errorf("great circle on the road: %+v, want 2 queries, none over", a)
+	}
+	if h, _ := search.CoordsHeuristic("polar", pos, len(road)); h != nil {
+		t.Error("heuristic for an unknown kind")
+	}
+
+	// v has no position: estimated at 0 it is expanded first by way of
+	// the heavy edge from s, and isn't reopened when the light way
+	// through u turns up
+	part := graphMap{
+		"s": {"v:6", "u:1"},
+		"u": {"v:1"},
+		"v": {"t:8"},
+		"t": {},
+	}
+	xy := map[string][2]float64{"s": {0, 0}, "u": {1, 0}, "t": {10, 0}}
+	path, _, err := search.AStar(ctx, part, "s", "t", search.Euclidean(xy), nil, 0)
+	if err != nil || part.cost(path) != 14 {
+		t.Fatalf("euclidean without v's position found %v, cost %v, %v, want the cost 14 path", path, part.cost(path), err)
+	}
+	h, missing = search.CoordsHeuristic(storage.XY, xy, len(part))
+	if missing != 1 || h != nil {
+		t.Fatalf("heuristic %v with %d missing, want none with 1 missing", h != nil, missing)
+	}
+	path, _, err = search.AStar(ctx, part, "s", "t", func(a, b string) float64 { return 0 }, nil, 0)
+	if err != nil || part.cost(path) != 10 {
+		t.Errorf("without the heuristic found %v, cost %v, %v, want the cost 10 path", path, part.cost(path), err)
+	}
+}
+
+func TestDistances(t *testing.T) {
+	g := graphMap{
+		"a": {"b:2", "c:5"},
+		"b": {"c:1", "d:4"},
+		"c": {"d:1"},
+		"d": {},
+		"e": {"a"},
+	}
+	ctx := context.Background()
+	got := make(map[string]float64)
+	record := func(node string, d float64) error {
+		got[node] = d
+		return nil
+	}
+	if _, err := search.Distances(ctx, g, "a", nil, record); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]float64{"a": 0, "b": 2, "c": 3, "d": 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("from a to every node: %v, want %v", got, want)
+	}
+	clear(got)
+	expanded, err := search.Distances(ctx, g, "a", []string{"b"}, record)
+	if err != nil || expanded != 1 || got["b"] != 2 {
+		t.Errorf("from a to b: %v after %d expansions, %v, want b at 2 after 1", got, expanded, err)
+	}
+}
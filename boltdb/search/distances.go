@@ -0,0 +1,68 @@
+package search
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"github.com/jogo/goplayground/boltdb/graph"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+)
+
+// Distances settles the nodes reachable from from in order of their
+// distance, Dijkstra's algorithm, calling settled with each, from itself
+// first at 0. It stops once every node of targets is settled, or if
+// targets is nil once every reachable one is, so one pass fills a row of
+// a distance table however many targets it has. Once ctx is done it
+// gives up with ctx's error.
+func Distances(ctx context.Context, r Reader, from string, targets []string, settled func(node string, d float64) error) (expanded int, err error) {
+	r, end, err := begin(r)
+	if err != nil {
+		return 0, err
+	}
+	defer end()
+	left := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		left[t] = true
+	}
+	open := &openSet{{id: from}}
+	// g of the open nodes, done the settled ones
+	g := map[string]float64{from: 0}
+	done := make(map[string]bool)
+	var neighbors []string
+	for open.Len() > 0 {
+		current := heap.Pop(open).(openItem)
+		if done[current.id] {
+			continue
+		}
+		if run.Done(ctx) {
+			return expanded, ctx.Err()
+		}
+		done[current.id] = true
+		delete(g, current.id)
+		delete(left, current.id)
+		if err := settled(current.id, current.g); err != nil {
+			return expanded, err
+		}
+		if targets != nil && len(left) == 0 {
+			return expanded, nil
+		}
+		expanded++
+		err = eachEdge(r, current.id, &neighbors, func(edge string) error {
+			next, weight, err := graph.ParseEdge(edge)
+			if err != nil || done[next] {
+				return err
+			}
+			tentative := current.g + weight
+			if old, ok := g[next]; ok && old <= tentative {
+				return nil
+			}
+			g[next] = tentative
+			heap.Push(open, openItem{id: next, f: tentative, g: tentative})
+			return nil
+		})
+		if err != nil {
+			return expanded, fmt.Errorf("expanding %s: %w", current.id, err)
+		}
+	}
+	return expanded, nil
+}
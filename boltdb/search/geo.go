@@ -0,0 +1,63 @@
+package search
+
+import (
+	"github.com/jogo/goplayground/boltdb/graph"
+	"github.com/jogo/goplayground/boltdb/storage"
+	"math"
+)
+
+// slack shrinks the coordinate heuristics under the edge weights they
+// bound, which are stored as float32 and may round a little below the
+// distance they were computed from.
+const slack = 1 - 1e-6
+
+// Euclidean is the straight line distance between the storage.XY
+// positions of two nodes, admissible when no edge weighs less than the
+// distance between its ends, as on the grid. Nodes without a position
+// are estimated at 0, which makes it inconsistent, see CoordsHeuristic.
+func Euclidean(coords map[string][2]float64) Heuristic {
+	return func(a, b string) float64 {
+		p, okA := coords[a]
+		q, okB := coords[b]
+		if !okA || !okB {
+			return 0
+		}
+		return math.Hypot(p[0]-q[0], p[1]-q[1]) * slack
+	}
+}
+
+// GreatCircle is the haversine distance in meters between the
+// storage.LatLon positions of two nodes, admissible for roads weighted by
+// their length, see graph.LoadOSM. Nodes without a position are
+// estimated at 0, which makes it inconsistent, see CoordsHeuristic.
+func GreatCircle(coords map[string][2]float64) Heuristic {
+	return func(a, b string) float64 {
+		p, okA := coords[a]
+		q, okB := coords[b]
+		if !okA || !okB {
+			return 0
+		}
+		return graph.Haversine(p, q) * slack
+	}
+}
+
+// CoordsHeuristic is the heuristic for positions of kind of the nodes of
+// a graph of nodes keys, nil for a kind it doesn't know or if missing of
+// them have no position. Estimating those at 0 and the rest by distance
+// would be inconsistent, and as A* never reopens a node it has expanded
+// it could return a path that isn't the shortest, so callers fall back to
+// 0 everywhere.
+func CoordsHeuristic(kind string, coords map[string][2]float64, nodes int) (h Heuristic, missing int) {
+	switch kind {
+	case storage.XY:
+		h = Euclidean(coords)
+	case storage.LatLon:
+		h = GreatCircle(coords)
+	default:
+		return nil, 0
+	}
+	if missing = nodes - len(coords); missing > 0 {
+		return nil, missing
+	}
+	return h, 0
+}
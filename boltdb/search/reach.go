@@ -0,0 +1,45 @@
+package search
+
+import (
+	"context"
+	"github.com/RoaringBitmap/roaring/v2"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+)
+
+// Neighbors returns the neighbors of a node as a bitmap, nil for a node
+// without an adjacency list.
+type Neighbors func(id uint32) (*roaring.Bitmap, error)
+
+// Reach finds the nodes within hops of from, every node reachable from it
+// if hops is 0, breadth first a whole frontier at a time: the next
+// frontier is the union of the frontier's neighbors less the nodes already
+// reached, each one bitmap operation. frontiers holds the size of each,
+// from's own first. Once ctx is done it gives up with ctx's error.
+func Reach(ctx context.Context, neighbors Neighbors, from uint32, hops int) (reached *roaring.Bitmap, frontiers []uint64, err error) {
+	reached = roaring.BitmapOf(from)
+	frontier := roaring.BitmapOf(from)
+	for hop := 0; !frontier.IsEmpty() && (hops == 0 || hop < hops); hop++ {
+		frontiers = append(frontiers, frontier.GetCardinality())
+		next := roaring.New()
+		it := frontier.Iterator()
+		for it.HasNext() {
+			if run.Done(ctx) {
+				return nil, nil, ctx.Err()
+			}
+			b, err := neighbors(it.Next())
+			if err != nil {
+				return nil, nil, err
+			}
+			if b != nil {
+				next.Or(b)
+			}
+		}
+		next.AndNot(reached)
+		reached.Or(next)
+		frontier = next
+	}
+	if !frontier.IsEmpty() {
+		frontiers = append(frontiers, frontier.GetCardinality())
+	}
+	return reached, frontiers, nil
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/jogo/goplayground/boltdb/codec"
+	"sync"
+)
+
+// Alloc is how Bolt allocates the buffers it encodes flat values into and
+// the values it decodes.
+type Alloc int
+
+const (
+	// AllocDefault lets the codec allocate
+	AllocDefault Alloc = iota
+	// AllocPool encodes into buffers from a sync.Pool, put back once
+	// their batch is committed
+	AllocPool
+	// AllocArena is AllocPool, and decodes a value's items out of one
+	// string, see codec.UnmarshalArena
+	AllocArena
+)
+
+// ParseAlloc accepts "default", or empty for it, "pool" or "arena".
+func ParseAlloc(s string) (Alloc, error) {
+	if s == "" {
+		return AllocDefault, nil
+	}
+	for a := AllocDefault; a <= AllocArena; a++ {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid alloc %q, want default, pool or arena", s)
+}
+
+func (a Alloc) String() string {
+	switch a {
+	case AllocPool:
+		return "pool"
+	case AllocArena:
+		return "arena"
+	}
+	return "default"
+}
+
+// encodePool holds *[]byte so putting one back doesn't allocate.
+var encodePool = sync.Pool{New: func() any { return new([]byte) }}
+
+// marshal encodes value the way Alloc says.
+func (mybolt *Bolt) marshal(value []string) ([]byte, error) {
+	if mybolt.Alloc == AllocDefault {
+		return mybolt.Codec.Marshal(value)
+	}
+	buf := encodePool.Get().(*[]byte)
+	data, err := codec.MarshalAppend(mybolt.Codec, (*buf)[:0], value)
+	if err != nil {
+		encodePool.Put(buf)
+		return nil, err
+	}
+	return data, nil
+}
+
+// release puts the buffers of a committed batch back in the pool.
+func (mybolt *Bolt) release(batch []Entry) {
+	if mybolt.Alloc == AllocDefault || mybolt.schema != FlatSchema {
+		return
+	}
+	for i := range batch {
+		if batch[i].encoded != nil {
+			buf := batch[i].encoded
+			batch[i].encoded = nil
+			encodePool.Put(&buf)
+		}
+	}
+}
+
+// unmarshal decodes data into dst the way Alloc says, or interned.
+func (mybolt *Bolt) unmarshal(data []byte, dst []string) ([]string, error) {
+	if mybolt.Interner != nil {
+		return codec.UnmarshalInterned(mybolt.Codec, data, dst, mybolt.Interner)
+	}
+	if mybolt.Alloc == AllocArena {
+		return codec.UnmarshalArena(mybolt.Codec, data, dst)
+	}
+	return mybolt.Codec.UnmarshalInto(data, dst)
+}
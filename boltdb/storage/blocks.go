@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+)
+
+// BlocksBucket holds the FlatSchema values of more items than the block
+// size, see WithBlockSize, each block encoded on its own under key +
+// 0x00 + its big-endian number, like the edges of SplitSchema. The key's
+// value in Bucket is empty, which no codec produces.
+var BlocksBucket = []byte("blocks")
+
+var ErrRawBlocks = errors.New("raw values of keys stored in blocks")
+
+// blocksMarker is the value in Bucket of a key stored in blocks.
+var blocksMarker = []byte{}
+
+// WithBlockSize stores flat values of more than n items in blocks of n
+// items, so reading a hub's neighbors with EachItem takes one block at a
+// time instead of the whole value. 0, the default, stores values whole.
+func WithBlockSize(n int) Option {
+	return func(o *options) {
+		if n < 0 {
+			run.Fatal("block size must not be negative", "blocksize", n)
+		}
+		o.blockSize = n
+	}
+}
+
+// encodeBlocks encodes value a block at a time.
+func (mybolt *Bolt) encodeBlocks(value []string) ([][]byte, error) {
+	var blocks [][]byte
+	for len(value) > 0 {
+		n := min(len(value), mybolt.blockSize)
+		data, err := mybolt.Codec.Marshal(value[:n])
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, data)
+		value = value[n:]
+	}
+	return blocks, nil
+}
+
+// blockWriter replaces the blocks of keys within one transaction.
+type blockWriter struct {
+	mybolt *Bolt
+	tx     *bolt.Tx
+	// nil until there are blocks, so dbs without any pay nothing for them
+	b *bolt.Bucket
+}
+
+func (mybolt *Bolt) blockWriter(tx *bolt.Tx) *blockWriter {
+	w := &blockWriter{mybolt: mybolt, tx: tx}
+	if w.b = tx.Bucket(w.name()); w.b != nil {
+		w.b.FillPercent = mybolt.fillPercent
+	}
+	return w
+}
+
+func (w *blockWriter) name() []byte {
+	if w.mybolt.staging {
+		return stagingBucket(BlocksBucket)
+	}
+	return BlocksBucket
+}
+
+// put replaces the blocks of k, dropping them for nil blocks.
+func (w *blockWriter) put(k []byte, blocks [][]byte) error {
+	if w.b == nil {
+		if blocks == nil {
+			return nil
+		}
+		var err error
+		w.b, err = w.tx.CreateBucket(w.name())
+		if err != nil {
+			return err
+		}
+		w.b.FillPercent = w.mybolt.fillPercent
+	}
+	prefix := edgePrefix(k)
+	c := w.b.Cursor()
+	for bk, _ := c.Seek(prefix); bk != nil && bytes.HasPrefix(bk, prefix); bk, _ = c.Seek(prefix) {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	for i, block := range blocks {
+		if err := w.b.Put(edgeKey(k, i), block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachBlock decodes the blocks of k in order, calling fn with each.
+// block is reused between calls.
+func (mybolt *Bolt) eachBlock(tx *bolt.Tx, k []byte, fn func(block []string) error) error {
+	b := tx.Bucket(BlocksBucket)
+	if b == nil {
+		return fmt.Errorf("key %x: stored in blocks, but there are none", k)
+	}
+	var block []string
+	prefix := edgePrefix(k)
+	c := b.Cursor()
+	for bk, data := c.Seek(prefix); bk != nil && bytes.HasPrefix(bk, prefix); bk, data = c.Next() {
+		var err error
+		block, err = mybolt.unmarshal(data, block)
+		if err != nil {
+			return fmt.Errorf("key %x block %x: %s", k, bk[len(prefix):], err)
+		}
+		if err := fn(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBlocks decodes the blocks of k into dst[:0].
+func (mybolt *Bolt) readBlocks(tx *bolt.Tx, k []byte, dst []string) ([]string, error) {
+	value := dst[:0]
+	err := mybolt.eachBlock(tx, k, func(block []string) error {
+		value = append(value, block...)
+		return nil
+	})
+	return value, err
+}
+
+// EachItem calls fn with the items of key's value in order, for values
+// stored in blocks decoding one block at a time, for SplitSchema one edge
+// at a time from a cursor over its edge keys. fn runs inside a read
+// transaction.
+func (mybolt *Bolt) EachItem(key string, fn func(item string) error) error {
+	mybolt.mu.Lock()
+	value, buffered := mybolt.buffer[key]
+	deleted := mybolt.deletes[key]
+	mybolt.mu.Unlock()
+	if deleted {
+		return ErrNotFound
+	}
+	if buffered {
+		for _, item := range value {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	k, err := mybolt.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	return mybolt.Db.View(func(tx *bolt.Tx) error {
+		return mybolt.eachItemTx(tx, k, nil, fn)
+	})
+}
+
+// eachItemTx is EachItem of the encoded key k within tx. A value stored
+// whole is decoded into (*buf)[:0], reusing its backing array, unless buf
+// is nil.
+func (mybolt *Bolt) eachItemTx(tx *bolt.Tx, k []byte, buf *[]string, fn func(item string) error) error {
+	if mybolt.schema == SplitSchema {
+		// one range scan of the edges, the node record is only
+		// needed to tell a node without any from a missing one
+		prefix := edgePrefix(k)
+		c := tx.Bucket(EdgesBucket).Cursor()
+		edges := 0
+		for ek, v := c.Seek(prefix); ek != nil && bytes.HasPrefix(ek, prefix); ek, v = c.Next() {
+			edges++
+			if err := fn(string(v)); err != nil {
+				return err
+			}
+		}
+		if edges == 0 && tx.Bucket(NodesBucket).Get(k) == nil {
+			return ErrNotFound
+		}
+		return nil
+	}
+	data := tx.Bucket(Bucket).Get(k)
+	if data == nil {
+		return ErrNotFound
+	}
+	if len(data) == 0 {
+		return mybolt.eachBlock(tx, k, func(block []string) error {
+			return eachItem(block, fn)
+		})
+	}
+	var dst []string
+	if buf != nil {
+		dst = (*buf)[:0]
+	}
+	value, err := mybolt.unmarshal(data, dst)
+	if err != nil {
+		return err
+	}
+	if buf != nil {
+		*buf = value
+	}
+	return eachItem(value, fn)
+}
+
+// eachItem calls fn with each of items until it fails.
+func eachItem(items []string, fn func(item string) error) error {
+	for _, item := range items {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
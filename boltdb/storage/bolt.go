@@ -0,0 +1,714 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/jogo/goplayground/boltdb/codec"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"log/slog"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Key layouts for Bolt
+const (
+	// FlatSchema stores each key's whole value as one encoded blob in Bucket
+	FlatSchema = "flat"
+	// SplitSchema stores node metadata in NodesBucket and one record per
+	// edge in EdgesBucket, keyed by node + 0x00 + edge index, so a node's
+	// edges are contiguous and can be read with a prefix scan.
+	SplitSchema = "split"
+)
+
+// Key encodings for Bolt
+const (
+	// StringKeys stores keys as given, e.g. decimal strings
+	StringKeys = "string"
+	// Uint64Keys stores numeric keys as 8 byte big-endian integers, which
+	// are shorter and sort in numeric order
+	Uint64Keys = "uint64"
+)
+
+// SyncPolicy is the number of flushes between fsyncs of the bolt file.
+// Flushes in between are committed with NoSync. The file is always
+// synced before Close. Each fsync is a Checkpoint.
+type SyncPolicy int
+
+const (
+	SyncAtClose    SyncPolicy = 0
+	SyncEveryFlush SyncPolicy = 1
+)
+
+// ParseSyncPolicy accepts "flush", "close" or a number of flushes.
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "flush":
+		return SyncEveryFlush, nil
+	case "close":
+		return SyncAtClose, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid sync policy %q, want flush, close or N > 0", s)
+	}
+	return SyncPolicy(n), nil
+}
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncEveryFlush:
+		return "flush"
+	case SyncAtClose:
+		return "close"
+	}
+	return strconv.Itoa(int(p))
+}
+
+// Bucket holds the values of FlatSchema
+var Bucket = []byte("MyBucket")
+
+// buckets used by SplitSchema
+var (
+	NodesBucket = []byte("nodes")
+	EdgesBucket = []byte("edges")
+)
+
+// DefaultPath is the file NewBolt creates.
+const DefaultPath = "my.db"
+
+// Bolt is the bolt backend. Writes are buffered and committed BatchSize
+// at a time.
+type Bolt struct {
+	Db *bolt.DB
+	// mu guards the buffered writes and is held while they are committed,
+	// so a Get after a Writer sees the value whichever goroutine flushes
+	mu        sync.Mutex
+	buffer    map[string][]string
+	deletes   map[string]bool
+	BatchSize int
+	schema    string
+	keys      string
+	sync      SyncPolicy
+	// fillPercent is set on the buckets written to, see WithFillPercent
+	fillPercent float64
+	// blockSize is the most items a flat value is stored whole with, see
+	// WithBlockSize
+	blockSize int
+	// staging writes go to the staging buckets of an Upgrade
+	staging bool
+	// limiter admits writes, see WithWriteLimit
+	limiter *limiter
+	Codec   codec.Codec
+	// Alloc is how flat values are encoded and decoded, see Alloc
+	Alloc Alloc
+	// Interner, if set, interns the items of every value read
+	Interner *codec.Interner
+	// UnsafeKeys has EncodeKey hand out string keys' own bytes instead
+	// of a copy, see keyBytes, and turns bolt's keys back into strings
+	// without copying them where the string doesn't outlive the key
+	UnsafeKeys bool
+	// optional write-ahead log, see WAL
+	WAL     *WAL
+	flushes int
+	// PageStats logs bolt's page and timing stats for every flush
+	PageStats bool
+	// CrashAfter exits without closing after this many flushes, for
+	// crash tests
+	CrashAfter int
+	// SampleFraction of every flush is read back and compared right
+	// after committing, drawn with Seed, see sampleBatch
+	SampleFraction float64
+	Seed           int64
+	sampler        *rand.Rand
+	sampled        int
+	// spaces are the namespaces besides NodesSpace, see Namespace
+	spaces map[string]*boltSpace
+	// duplicates is the duplicate policy, see WithDuplicates, with the
+	// count of duplicates so far and the buffered keys that replaced a
+	// Delete rather than a value
+	duplicates     string
+	duplicateCount int
+	replaced       map[string]bool
+	// committed are the entries flushed since the db was opened, unsynced
+	// those since the last Checkpoint, see CheckpointStats
+	committed   int
+	unsynced    unsyncedRange
+	checkpoints CheckpointStats
+}
+
+func init() {
+	Register("bolt", func(spec, path string, def Layout, opts ...Option) Store {
+		l := ParseLayout(spec, def)
+		b := WrapBolt(freshFile(path, newOptions(opts).tuning), l.Schema, l.Keys, opts...)
+		b.Codec = NewCodec(l.Codec)
+		return b
+	})
+}
+
+// Option configures a Bolt made by NewBolt, WrapBolt or Open. Other
+// backends take the ones that apply to them.
+type Option func(*options)
+
+type options struct {
+	path        string
+	batchSize   int
+	sync        SyncPolicy
+	fillPercent float64
+	blockSize   int
+	tuning      Tuning
+	writeLimit  WriteLimit
+	duplicates  string
+	retry       Retry
+}
+
+// WithPath is the file NewBolt creates, DefaultPath by default.
+func WithPath(path string) Option {
+	return func(o *options) { o.path = path }
+}
+
+// WithBatchSize is the number of buffered writes committed at a time.
+func WithBatchSize(n int) Option {
+	return func(o *options) {
+		if n < 1 {
+			run.Fatal("batch size must be at least 1", "batch", n)
+		}
+		o.batchSize = n
+	}
+}
+
+// WithSyncPolicy is when to fsync the file, SyncAtClose by default.
+func WithSyncPolicy(sync SyncPolicy) Option {
+	return func(o *options) { o.sync = sync }
+}
+
+// WithFillPercent is how full bolt packs pages before splitting them.
+// Its default of 0.5 suits random inserts, sequential loads can use up
+// to 1.
+func WithFillPercent(f float64) Option {
+	return func(o *options) {
+		if f < 0.1 || f > 1 {
+			run.Fatal("fill percent must be between 0.1 and 1", "fill", f)
+		}
+		o.fillPercent = f
+	}
+}
+
+// Tuning is the settings of bolt itself a run can change, in the report
+// alongside its results. FreelistType, NoFreelistSync and PageSize are
+// bbolt's, this bolt has none of them.
+type Tuning struct {
+	// NoGrowSync skips growing the file ahead of the pages written and
+	// the fsync after it
+	NoGrowSync bool `json:"no_grow_sync,omitempty"`
+	// InitialMmapSize maps that many bytes up front, so a growing file
+	// isn't remapped, which waits for every read transaction, until then
+	InitialMmapSize int `json:"initial_mmap_size,omitempty"`
+	// AllocSize is how far past the pages written the file grows at a
+	// time once the mapping is bigger than it, 0 for bolt's 16MB
+	AllocSize int `json:"alloc_size,omitempty"`
+}
+
+// WithTuning sets bolt's own settings, see Tuning. InitialMmapSize only
+// applies to NewBolt and Open, WrapBolt gets a db already open.
+func WithTuning(t Tuning) Option {
+	return func(o *options) { o.tuning = t }
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		path: DefaultPath,
+		// If batch is too things slow down
+		batchSize:   10000,
+		sync:        SyncAtClose,
+		fillPercent: bolt.DefaultFillPercent,
+		duplicates:  Overwrite,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// NewBolt creates a fresh bolt file, at DefaultPath unless WithPath says
+// otherwise.
+func NewBolt(schema, keys string, opts ...Option) *Bolt {
+	o := newOptions(opts)
+	return WrapBolt(freshFile(o.path, o.tuning), schema, keys, opts...)
+}
+
+// WrapBolt wraps an already open db, e.g. one being recovered. WithPath
+// doesn't apply.
+func WrapBolt(db *bolt.DB, schema, keys string, opts ...Option) *Bolt {
+	if schema != FlatSchema && schema != SplitSchema {
+		run.Fatal("unknown schema", "schema", schema)
+	}
+	if keys != StringKeys && keys != Uint64Keys {
+		run.Fatal("unknown key encoding", "keys", keys)
+	}
+	o := newOptions(opts)
+	b := Bolt{
+		Db:          db,
+		buffer:      make(map[string][]string),
+		deletes:     make(map[string]bool),
+		BatchSize:   o.batchSize,
+		schema:      schema,
+		keys:        keys,
+		sync:        o.sync,
+		fillPercent: o.fillPercent,
+		blockSize:   o.blockSize,
+		limiter:     newLimiter(o.writeLimit),
+		duplicates:  o.duplicates,
+		replaced:    make(map[string]bool),
+		Codec:       codec.JSON{},
+	}
+	// bolt fsyncs on every commit unless told not to, the policy decides
+	// when Flush syncs instead
+	db.NoSync = o.sync != SyncEveryFlush
+	if o.tuning.NoGrowSync {
+		db.NoGrowSync = true
+	}
+	if o.tuning.AllocSize > 0 {
+		db.AllocSize = o.tuning.AllocSize
+	}
+	return &b
+}
+
+// FreshFile opens an empty bolt file at path, removing any old one.
+func FreshFile(path string) *bolt.DB {
+	return freshFile(path, Tuning{})
+}
+
+func freshFile(path string, t Tuning) *bolt.DB {
+	// make sure we start from a fresh file every time
+	os.Remove(path)
+	return openFile(path, t)
+}
+
+// OpenFile opens path, creating the file and buckets if needed.
+func OpenFile(path string) *bolt.DB {
+	return openFile(path, Tuning{})
+}
+
+func openFile(path string, t Tuning) *bolt.DB {
+	db, err := bolt.Open(path, 0600, &bolt.Options{NoGrowSync: t.NoGrowSync, InitialMmapSize: t.InitialMmapSize})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+
+	// create buckets
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{Bucket, NodesBucket, EdgesBucket, MetaBucket, CoordsBucket} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return fmt.Errorf("create bucket: %s", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+
+	return db
+}
+
+// Schema is FlatSchema or SplitSchema.
+func (mybolt *Bolt) Schema() string {
+	return mybolt.schema
+}
+
+// Keys is StringKeys or Uint64Keys.
+func (mybolt *Bolt) Keys() string {
+	return mybolt.keys
+}
+
+// Sampled is how many keys SampleFraction read back so far.
+func (mybolt *Bolt) Sampled() int {
+	return mybolt.sampled
+}
+
+// WriteLimited is what WithWriteLimit let through so far, ok is false
+// without a limit.
+func (mybolt *Bolt) WriteLimited() (s LimitStats, ok bool) {
+	if mybolt.limiter == nil {
+		return s, false
+	}
+	return mybolt.limiter.read(), true
+}
+
+func (mybolt *Bolt) Writer(key string, value []string) {
+	mybolt.write(key, value, false)
+}
+
+// write buffers value for key, under the duplicate policy unless it is a
+// rewrite.
+func (mybolt *Bolt) write(key string, value []string, rewrite bool) {
+	if mybolt.limiter != nil {
+		// outside the lock, reads of the buffer go on while it waits
+		n := len(key)
+		for _, item := range value {
+			n += len(item)
+		}
+		mybolt.limiter.wait(n)
+	}
+	mybolt.mu.Lock()
+	defer mybolt.mu.Unlock()
+	if mybolt.duplicates != Overwrite {
+		held, ok := mybolt.buffer[key]
+		switch {
+		case ok && !rewrite:
+			value = mybolt.duplicate(key, held, value)
+		case !ok && (rewrite || mybolt.deletes[key]):
+			// a rewrite of a key not buffered was read from disk
+			mybolt.replaced[key] = true
+		}
+	}
+	delete(mybolt.deletes, key)
+	mybolt.buffer[key] = value
+	if len(mybolt.buffer)+len(mybolt.deletes) > mybolt.BatchSize {
+		mybolt.flush()
+	}
+}
+
+// Delete removes key with the next Flush.
+func (mybolt *Bolt) Delete(key string) {
+	mybolt.mu.Lock()
+	defer mybolt.mu.Unlock()
+	delete(mybolt.buffer, key)
+	mybolt.deletes[key] = true
+	if len(mybolt.buffer)+len(mybolt.deletes) > mybolt.BatchSize {
+		mybolt.flush()
+	}
+}
+
+func (mybolt *Bolt) Flush() {
+	mybolt.mu.Lock()
+	defer mybolt.mu.Unlock()
+	mybolt.flush()
+}
+
+// flush is Flush with mu held.
+func (mybolt *Bolt) flush() {
+	if len(mybolt.buffer)+len(mybolt.deletes) == 0 {
+		// nothing to commit, and a read-only db couldn't
+		return
+	}
+	if mybolt.duplicates != Overwrite {
+		mybolt.resolveDuplicates()
+		clear(mybolt.replaced)
+	}
+	batch := make([]Entry, 0, len(mybolt.buffer)+len(mybolt.deletes))
+	for key, value := range mybolt.buffer {
+		entry, err := mybolt.Encode(key, value)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		batch = append(batch, entry)
+	}
+	for key := range mybolt.deletes {
+		k, err := mybolt.EncodeKey(key)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		batch = append(batch, Entry{name: key, key: k, deleted: true})
+	}
+	mybolt.Commit(batch)
+	mybolt.buffer = make(map[string][]string)
+	mybolt.deletes = make(map[string]bool)
+}
+
+// Entry is a key/value on its way to disk, see Encode.
+type Entry struct {
+	name    string
+	key     []byte
+	value   []string
+	encoded []byte
+	// blocks replace encoded for values stored in blocks
+	blocks  [][]byte
+	deleted bool
+}
+
+// Encode converts a key/value to its on disk form. It doesn't touch the
+// db, so a loading pipeline can run it on several goroutines at once.
+func (mybolt *Bolt) Encode(key string, value []string) (Entry, error) {
+	k, err := mybolt.EncodeKey(key)
+	if err != nil {
+		return Entry{}, err
+	}
+	entry := Entry{name: key, key: k, value: value}
+	switch {
+	case mybolt.schema != FlatSchema:
+	case mybolt.blockSize > 0 && len(value) > mybolt.blockSize:
+		entry.blocks, err = mybolt.encodeBlocks(value)
+	default:
+		entry.encoded, err = mybolt.marshal(value)
+	}
+	return entry, err
+}
+
+// Commit writes a batch of encoded entries in one transaction. It
+// bypasses the write buffer, so only a caller that has the db to itself
+// while loading, like a pipeline, may call it.
+func (mybolt *Bolt) Commit(batch []Entry) {
+	if mybolt.WAL != nil {
+		err := mybolt.WAL.append(batch)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+	}
+	// Bolt only splits nodes on commit, so inserting a batch of adjacent
+	// keys (e.g. uint64 keys) in random order turns every Put into a
+	// large memmove. Stable, so the last write of a key wins.
+	sort.SliceStable(batch, func(i, j int) bool {
+		return bytes.Compare(batch[i].key, batch[j].key) < 0
+	})
+	var before bolt.Stats
+	if mybolt.PageStats {
+		before = mybolt.Db.Stats()
+	}
+	start := time.Now()
+	err := mybolt.Db.Update(func(tx *bolt.Tx) error {
+		if mybolt.schema == SplitSchema {
+			return mybolt.flushSplit(tx, batch)
+		}
+		b := mybolt.bucket(tx, Bucket)
+		blocks := mybolt.blockWriter(tx)
+		for _, entry := range batch {
+			var err error
+			switch {
+			case entry.deleted:
+				err = b.Delete(entry.key)
+			case entry.blocks != nil:
+				err = b.Put(entry.key, blocksMarker)
+			default:
+				err = b.Put(entry.key, entry.encoded)
+			}
+			if err == nil {
+				err = blocks.put(entry.key, entry.blocks)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+
+	mybolt.flushes++
+	mybolt.trackUnsynced(batch)
+	if mybolt.SampleFraction > 0 {
+		mybolt.sampleBatch(batch)
+	}
+	mybolt.release(batch)
+	if mybolt.PageStats {
+		after := mybolt.Db.Stats()
+		diff := after.Sub(&before)
+		slog.Info("flush", append([]any{"n", mybolt.flushes, "entries", len(batch), "took", time.Since(start)},
+			TxStats(diff.TxStats)...)...)
+	}
+	if mybolt.sync > SyncEveryFlush && mybolt.flushes%int(mybolt.sync) == 0 {
+		mybolt.Checkpoint()
+	}
+	if mybolt.CrashAfter > 0 && mybolt.flushes >= mybolt.CrashAfter {
+		slog.Warn("simulating crash", "flushes", mybolt.flushes)
+		os.Exit(3)
+	}
+}
+
+// sampleBatch reads back a random SampleFraction of a just committed
+// batch and compares it to what was written, to catch encoding or
+// batching bugs early in a long load rather than at the end.
+func (mybolt *Bolt) sampleBatch(batch []Entry) {
+	if mybolt.sampler == nil {
+		mybolt.sampler = rand.New(rand.NewSource(mybolt.Seed))
+	}
+	err := mybolt.Db.View(func(tx *bolt.Tx) error {
+		for i, entry := range batch {
+			// only the last of several writes of a key is stored
+			if i+1 < len(batch) && bytes.Equal(entry.key, batch[i+1].key) {
+				continue
+			}
+			if mybolt.sampler.Float64() >= mybolt.SampleFraction {
+				continue
+			}
+			mybolt.sampled++
+			stored, err := mybolt.GetKey(tx, entry.key)
+			if entry.deleted {
+				if err != ErrNotFound {
+					return fmt.Errorf("flush %d: deleted key %s reads back as %q, %v", mybolt.flushes, entry.name, stored, err)
+				}
+				continue
+			}
+			if err != nil || !SameValue(stored, entry.value) {
+				return fmt.Errorf("flush %d: key %s reads back as %q, %v, wrote %q", mybolt.flushes, entry.name, stored, err, entry.value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		run.Fatal("read-after-write sample failed", "err", err)
+	}
+}
+
+// Checkpoint fsyncs the bolt file, after which the write-ahead log, if
+// any, is no longer needed. What it made durable is recorded in the file,
+// see ReadCheckpoint.
+func (mybolt *Bolt) Checkpoint() {
+	err := mybolt.recordCheckpoint()
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	start := time.Now()
+	err = mybolt.Db.Sync()
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	mybolt.checkpoints.Synced += time.Since(start)
+	if mybolt.WAL != nil {
+		err = mybolt.WAL.Reset()
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+	}
+}
+
+// Close syncs and closes the db, whatever the sync policy.
+func (mybolt *Bolt) Close() {
+	mybolt.mu.Lock()
+	defer mybolt.mu.Unlock()
+	mybolt.Checkpoint()
+	if mybolt.WAL != nil {
+		mybolt.WAL.f.Close()
+	}
+	err := mybolt.Db.Close()
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+}
+
+// bucket is tx's bucket name, set up for writing.
+func (mybolt *Bolt) bucket(tx *bolt.Tx, name []byte) *bolt.Bucket {
+	if mybolt.staging {
+		name = stagingBucket(name)
+	}
+	b := tx.Bucket(name)
+	b.FillPercent = mybolt.fillPercent
+	return b
+}
+
+func (mybolt *Bolt) flushSplit(tx *bolt.Tx, batch []Entry) error {
+	nodes := mybolt.bucket(tx, NodesBucket)
+	edges := mybolt.bucket(tx, EdgesBucket)
+	for _, entry := range batch {
+		var err error
+		if entry.deleted {
+			err = nodes.Delete(entry.key)
+		} else {
+			err = nodes.Put(entry.key, []byte(strconv.Itoa(len(entry.value))))
+		}
+		if err != nil {
+			return err
+		}
+		// drop edges left over from a previous write of this node
+		prefix := edgePrefix(entry.key)
+		c := edges.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		for i, edge := range entry.value {
+			err = edges.Put(edgeKey(entry.key, i), []byte(edge))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func edgePrefix(key []byte) []byte {
+	prefix := make([]byte, len(key)+1)
+	copy(prefix, key)
+	return prefix
+}
+
+func edgeKey(key []byte, i int) []byte {
+	k := make([]byte, len(key)+5)
+	copy(k, key)
+	binary.BigEndian.PutUint32(k[len(key)+1:], uint32(i))
+	return k
+}
+
+// EncodeKey converts key to its on disk form, which must not be
+// modified.
+func (mybolt *Bolt) EncodeKey(key string) ([]byte, error) {
+	if mybolt.UnsafeKeys && mybolt.keys != Uint64Keys {
+		return keyBytes(key), nil
+	}
+	return mybolt.AppendKey(nil, key)
+}
+
+// AppendKey is EncodeKey appending to dst, so read paths can reuse
+// a buffer.
+func (mybolt *Bolt) AppendKey(dst []byte, key string) ([]byte, error) {
+	if mybolt.keys != Uint64Keys {
+		return append(dst, key...), nil
+	}
+	id, err := strconv.ParseUint(key, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("key %q is not a uint64: %s", key, err)
+	}
+	return binary.BigEndian.AppendUint64(dst, id), nil
+}
+
+// IntKey builds the on disk key for the numeric key i without going
+// through a decimal string when the encoding doesn't need one.
+func (mybolt *Bolt) IntKey(i int) []byte {
+	return mybolt.AppendIntKey(nil, i)
+}
+
+func (mybolt *Bolt) AppendIntKey(dst []byte, i int) []byte {
+	if mybolt.keys == Uint64Keys {
+		return binary.BigEndian.AppendUint64(dst, uint64(i))
+	}
+	return strconv.AppendInt(dst, int64(i), 10)
+}
+
+// DecodeKey is the inverse of EncodeKey.
+func (mybolt *Bolt) DecodeKey(k []byte) string {
+	if mybolt.keys == Uint64Keys && len(k) == 8 {
+		return strconv.FormatUint(binary.BigEndian.Uint64(k), 10)
+	}
+	return string(k)
+}
+
+// decodeKeyTransient is DecodeKey for a string that is dropped before k
+// is, which with UnsafeKeys isn't copied.
+func (mybolt *Bolt) decodeKeyTransient(k []byte) string {
+	if mybolt.UnsafeKeys && mybolt.keys != Uint64Keys {
+		return keyString(k)
+	}
+	return mybolt.DecodeKey(k)
+}
+
+// Count returns the number of keys written.
+func (mybolt *Bolt) Count() (n int, err error) {
+	name := Bucket
+	if mybolt.schema == SplitSchema {
+		name = NodesBucket
+	}
+	err = mybolt.Db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(name).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
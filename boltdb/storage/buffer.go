@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"sync"
+)
+
+// writeBuffer batches writes the way Bolt does for the optional
+// backends: they are committed size at a time, with mu held so a Get
+// that misses the buffer finds them committed. A failed commit is tried
+// again as retry says before the process exits, so commit must be safe
+// to repeat.
+type writeBuffer struct {
+	mu      sync.Mutex
+	puts    map[string][]string
+	deletes map[string]bool
+	size    int
+	retry   Retry
+	commit  func(puts map[string][]string, deletes map[string]bool) error
+}
+
+func newWriteBuffer(opts []Option, commit func(puts map[string][]string, deletes map[string]bool) error) *writeBuffer {
+	o := newOptions(opts)
+	return &writeBuffer{
+		puts:    make(map[string][]string),
+		deletes: make(map[string]bool),
+		size:    o.batchSize,
+		retry:   o.retry,
+		commit:  commit,
+	}
+}
+
+func (w *writeBuffer) Writer(key string, value []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.deletes, key)
+	w.puts[key] = value
+	if len(w.puts)+len(w.deletes) > w.size {
+		w.flush()
+	}
+}
+
+func (w *writeBuffer) Delete(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.puts, key)
+	w.deletes[key] = true
+	if len(w.puts)+len(w.deletes) > w.size {
+		w.flush()
+	}
+}
+
+func (w *writeBuffer) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flush()
+}
+
+func (w *writeBuffer) flush() {
+	if len(w.puts)+len(w.deletes) == 0 {
+		return
+	}
+	err := w.retry.do("flush", func() error { return w.commit(w.puts, w.deletes) })
+	if err != nil {
+		run.Fatal(err.Error(), "keys", len(w.puts)+len(w.deletes))
+	}
+	w.puts = make(map[string][]string)
+	w.deletes = make(map[string]bool)
+}
+
+// buffered returns key's uncommitted value, if it has one, or
+// ErrNotFound if it is about to be deleted.
+func (w *writeBuffer) buffered(key string) (value []string, ok bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if value, ok := w.puts[key]; ok {
+		return value, true, nil
+	}
+	if w.deletes[key] {
+		return nil, true, ErrNotFound
+	}
+	return nil, false, nil
+}
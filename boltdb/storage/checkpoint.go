@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"time"
+)
+
+// checkpointKey holds the last Checkpoint in MetaBucket.
+var checkpointKey = []byte("checkpoint")
+
+// Checkpoint is what a checkpoint made durable, recorded in the file as
+// part of it: everything up to its flush, the last of them holding keys
+// First to Last. A crash loses at most the flushes after the last
+// checkpoint found, which a write-ahead log brings back, see Recover.
+type Checkpoint struct {
+	// Flushes and Keys are the flushes since the db was opened and the
+	// entries they committed
+	Flushes int `json:"flushes"`
+	Keys    int `json:"keys"`
+	// Batches are the flushes since the previous checkpoint, holding
+	// Unsynced entries from First to Last in key order
+	Batches  int       `json:"batches"`
+	Unsynced int       `json:"unsynced"`
+	First    string    `json:"first"`
+	Last     string    `json:"last"`
+	Time     time.Time `json:"time"`
+}
+
+// CheckpointStats are the checkpoints of a Bolt since it was opened, how
+// long their fsyncs took, and the most entries one made durable, what a
+// crash just before it would have lost without a write-ahead log.
+type CheckpointStats struct {
+	Checkpoints int
+	Synced      time.Duration
+	MaxUnsynced int
+}
+
+// trackUnsynced tracks the flushes since the last checkpoint. mu is held.
+func (mybolt *Bolt) trackUnsynced(batch []Entry) {
+	mybolt.committed += len(batch)
+	if len(batch) == 0 {
+		return
+	}
+	p := &mybolt.unsynced
+	p.Batches++
+	p.Unsynced += len(batch)
+	// Commit sorted the batch
+	first, last := batch[0], batch[len(batch)-1]
+	if p.firstKey == nil || bytes.Compare(first.key, p.firstKey) < 0 {
+		p.firstKey, p.First = bytes.Clone(first.key), first.name
+	}
+	if p.lastKey == nil || bytes.Compare(last.key, p.lastKey) > 0 {
+		p.lastKey, p.Last = bytes.Clone(last.key), last.name
+	}
+}
+
+// unsyncedRange is a Checkpoint in the making, with the encoded keys of
+// its range to compare.
+type unsyncedRange struct {
+	Checkpoint
+	firstKey, lastKey []byte
+}
+
+// recordCheckpoint writes the checkpoint about to be synced, if anything
+// was flushed since the last, in a transaction of its own that the sync
+// makes durable with the flushes. Under SyncEveryFlush each flush is its
+// own checkpoint and none is recorded.
+func (mybolt *Bolt) recordCheckpoint() error {
+	if mybolt.unsynced.Batches == 0 || mybolt.sync == SyncEveryFlush || mybolt.Db.IsReadOnly() {
+		return nil
+	}
+	c := mybolt.unsynced.Checkpoint
+	c.Flushes, c.Keys, c.Time = mybolt.flushes, mybolt.committed, time.Now()
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	err = mybolt.Db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(MetaBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put(checkpointKey, data)
+	})
+	if err != nil {
+		return err
+	}
+	mybolt.checkpoints.Checkpoints++
+	mybolt.checkpoints.MaxUnsynced = max(mybolt.checkpoints.MaxUnsynced, c.Unsynced)
+	mybolt.unsynced = unsyncedRange{}
+	return nil
+}
+
+// Checkpoints are the checkpoints so far.
+func (mybolt *Bolt) Checkpoints() CheckpointStats {
+	return mybolt.checkpoints
+}
+
+// ReadCheckpoint returns the last checkpoint recorded in the file, ok is
+// false if there is none.
+func ReadCheckpoint(db *bolt.DB) (c Checkpoint, ok bool, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(MetaBucket)
+		if b == nil {
+			return nil
+		}
+		data := b.Get(checkpointKey)
+		if data == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(data, &c)
+	})
+	return c, ok, err
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"github.com/boltdb/bolt"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+)
+
+// Compact copies every bucket of src into dst, a db without buckets,
+// key by key in sorted order with pages packed full, as bbolt's compact
+// command does. Bolt never gives the pages freed by deletes and updates
+// back to the filesystem and leaves the pages it splits half empty, the
+// copy has neither. dst commits every txMaxSize bytes of keys and values,
+// 0 for one transaction. Once ctx is done it stops and returns ctx's
+// error, dst then holds part of src.
+func Compact(ctx context.Context, dst, src *bolt.DB, txMaxSize int64) error {
+	tx, err := dst.Begin(true)
+	if err != nil {
+		return err
+	}
+	// tx changes with every commit
+	defer func() { tx.Rollback() }()
+	var size int64
+	err = walk(src, func(path [][]byte, k, v []byte, seq uint64) error {
+		if run.Done(ctx) {
+			return ctx.Err()
+		}
+		n := int64(len(k) + len(v))
+		if txMaxSize > 0 && size+n > txMaxSize {
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			tx, err = dst.Begin(true)
+			if err != nil {
+				return err
+			}
+			size = 0
+		}
+		size += n
+
+		if len(path) == 0 {
+			b, err := tx.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			return b.SetSequence(seq)
+		}
+		b := tx.Bucket(path[0])
+		for _, name := range path[1:] {
+			b = b.Bucket(name)
+		}
+		// keys arrive in order, nothing will be inserted between them
+		b.FillPercent = 1
+		if v == nil {
+			nested, err := b.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			return nested.SetSequence(seq)
+		}
+		return b.Put(k, v)
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// walk calls fn for every bucket and key of db, depth first in key order,
+// with the names of the buckets it is in. Buckets have a nil v.
+func walk(db *bolt.DB, fn func(path [][]byte, k, v []byte, seq uint64) error) error {
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return walkBucket(b, nil, name, nil, b.Sequence(), fn)
+		})
+	})
+}
+
+func walkBucket(b *bolt.Bucket, path [][]byte, k, v []byte, seq uint64, fn func(path [][]byte, k, v []byte, seq uint64) error) error {
+	err := fn(path, k, v, seq)
+	if err != nil || v != nil {
+		return err
+	}
+	// copied, siblings would share the backing array
+	path = append(path[:len(path):len(path)], k)
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nested := b.Bucket(k)
+			return walkBucket(nested, path, k, nil, nested.Sequence(), fn)
+		}
+		return walkBucket(b, path, k, v, 0, fn)
+	})
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/boltdb/bolt"
+	"math"
+	"sort"
+)
+
+// DistancesBucket holds distance tables, see WriteDistances, keyed by
+// source + 0x00 + target as given rather than encoded like the graph's
+// keys, so an Upgrade leaves them as they are. Values are big-endian
+// float64s.
+var DistancesBucket = []byte("distances")
+
+func distanceKey(source, target string) []byte {
+	k := make([]byte, 0, len(source)+1+len(target))
+	k = append(k, source...)
+	k = append(k, 0)
+	return append(k, target...)
+}
+
+// WriteDistances replaces the distances stored from source with dist, a
+// batch per transaction.
+func (mybolt *Bolt) WriteDistances(source string, dist map[string]float64) error {
+	prefix := distanceKey(source, "")
+	err := mybolt.Db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(DistancesBucket)
+		if err != nil {
+			return err
+		}
+		c := b.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	targets := make([]string, 0, len(dist))
+	for t := range dist {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	for len(targets) > 0 {
+		n := min(len(targets), mybolt.BatchSize)
+		err := mybolt.Db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(DistancesBucket)
+			b.FillPercent = mybolt.fillPercent
+			for _, t := range targets[:n] {
+				// bolt holds on to values until the commit
+				v := binary.BigEndian.AppendUint64(nil, math.Float64bits(dist[t]))
+				if err := b.Put(distanceKey(source, t), v); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		targets = targets[n:]
+	}
+	return nil
+}
+
+// ReadDistances returns the distances stored from source, empty if none
+// were.
+func (mybolt *Bolt) ReadDistances(source string) (map[string]float64, error) {
+	dist := make(map[string]float64)
+	prefix := distanceKey(source, "")
+	err := mybolt.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(DistancesBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			dist[string(k[len(prefix):])] = math.Float64frombits(binary.BigEndian.Uint64(v))
+		}
+		return nil
+	})
+	return dist, err
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+)
+
+// The duplicate policies, what a Bolt does when Writer writes a key it
+// already holds, buffered or committed, as edge-list inputs often repeat
+// a node.
+const (
+	// Overwrite keeps the last value written, the default
+	Overwrite = "overwrite"
+	// Merge keeps the value held and appends the items of the new one it
+	// lacks, joining a node's adjacency list split across an input
+	Merge = "merge"
+	// Reject exits, for inputs that mustn't repeat a key
+	Reject = "error"
+)
+
+// WithDuplicates sets the duplicate policy, Overwrite, Merge or Reject.
+// Under the last two each flush first looks up the buffered keys on disk,
+// and until then Get sees only what was buffered of a merged value. A
+// key written after its Delete isn't a duplicate. Commit and Rewrite
+// bypass the policy.
+func WithDuplicates(policy string) Option {
+	return func(o *options) {
+		switch policy {
+		case Overwrite, Merge, Reject:
+		default:
+			run.Fatal("unknown duplicate policy, want overwrite, merge or error", "duplicates", policy)
+		}
+		o.duplicates = policy
+	}
+}
+
+// Rewriter is a Store a key can be written to again on purpose, its new
+// value replacing the one held whatever the duplicate policy, as when
+// the edges of a node met again later in an edge list are appended.
+type Rewriter interface {
+	Rewrite(key string, value []string)
+}
+
+// Rewrite writes value to key past the duplicate policy, value being
+// what Get returned for key with changes.
+func (mybolt *Bolt) Rewrite(key string, value []string) {
+	mybolt.write(key, value, true)
+}
+
+// Duplicates is how many writes were of a key already held, counted
+// unless the policy is Overwrite.
+func (mybolt *Bolt) Duplicates() int {
+	mybolt.mu.Lock()
+	defer mybolt.mu.Unlock()
+	return mybolt.duplicateCount
+}
+
+// duplicate resolves a write of value to a key held with value held,
+// returning the value to keep. mu is held.
+func (mybolt *Bolt) duplicate(key string, held, value []string) []string {
+	mybolt.duplicateCount++
+	if mybolt.duplicates == Reject {
+		run.Fatal("duplicate key", "key", key, "held", held, "value", value)
+	}
+	seen := make(map[string]bool, len(held))
+	merged := append(make([]string, 0, len(held)+len(value)), held...)
+	for _, item := range held {
+		seen[item] = true
+	}
+	for _, item := range value {
+		if !seen[item] {
+			seen[item] = true
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+// resolveDuplicates applies the policy to the buffered keys already on
+// disk, before flush commits them. mu is held.
+func (mybolt *Bolt) resolveDuplicates() {
+	err := mybolt.Db.View(func(tx *bolt.Tx) error {
+		for key, value := range mybolt.buffer {
+			if mybolt.replaced[key] {
+				continue
+			}
+			held, err := mybolt.GetTx(tx, key)
+			if err == ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			mybolt.buffer[key] = mybolt.duplicate(key, held, value)
+		}
+		return nil
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+}
@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("faulty", func(spec, path string, def Layout, opts ...Option) Store {
+		f, inner := parseFaulty(spec)
+		return NewFaulty(Open(inner, path, def, opts...), f)
+	})
+}
+
+// ErrInjected is the transient error a Faulty store fails reads with.
+var ErrInjected = errors.New("injected fault")
+
+// Faults are the failures to inject, each a fraction of the operations.
+// Every decision comes from one source seeded with Seed, so a
+// single-threaded run fails the same way every time.
+type Faults struct {
+	Seed int64
+	// SpikeRate of operations first sleep for Spike
+	SpikeRate float64
+	Spike     time.Duration
+	// ErrorRate of reads fail with ErrInjected
+	ErrorRate float64
+	// ShortWriteRate of file writes, e.g. the WAL's, write only part of
+	// their bytes and fail with io.ErrShortWrite
+	ShortWriteRate float64
+}
+
+// ParseFaults reads faults written as comma separated settings, e.g.
+// "spike=0.01:50ms,error=0.001,short=0.0001,seed=2".
+func ParseFaults(s string) (Faults, error) {
+	f := Faults{Seed: 1}
+	for _, opt := range strings.Split(s, ",") {
+		if err := f.set(opt); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// set applies one setting of ParseFaults.
+func (f *Faults) set(opt string) error {
+	name, value, _ := strings.Cut(opt, "=")
+	var err error
+	switch name {
+	case "seed":
+		f.Seed, err = strconv.ParseInt(value, 10, 64)
+	case "error":
+		f.ErrorRate, err = parseRate(value)
+	case "short":
+		f.ShortWriteRate, err = parseRate(value)
+	case "spike":
+		rate, d, ok := strings.Cut(value, ":")
+		if !ok {
+			return fmt.Errorf("want spike=rate:duration, not %q", opt)
+		}
+		f.SpikeRate, err = parseRate(rate)
+		if err == nil {
+			f.Spike, err = time.ParseDuration(d)
+		}
+	default:
+		return fmt.Errorf("unknown fault %q, want spike, error, short or seed", opt)
+	}
+	if err != nil {
+		return fmt.Errorf("fault %q: %w", opt, err)
+	}
+	return nil
+}
+
+func parseRate(s string) (float64, error) {
+	rate, err := strconv.ParseFloat(s, 64)
+	if err == nil && (rate < 0 || rate > 1) {
+		err = fmt.Errorf("rate %v isn't between 0 and 1", rate)
+	}
+	return rate, err
+}
+
+// parseFaulty splits a spec like faulty/error=0.01/spike=0.001:20ms/bolt/split
+// into the faults and the spec of the store they are injected into, bolt
+// by default.
+func parseFaulty(spec string) (f Faults, inner string) {
+	f.Seed = 1
+	parts := strings.Split(spec, "/")[1:]
+	for len(parts) > 0 && strings.Contains(parts[0], "=") {
+		if err := f.set(parts[0]); err != nil {
+			run.Fatal(err.Error(), "spec", spec)
+		}
+		parts = parts[1:]
+	}
+	inner = strings.Join(parts, "/")
+	if inner == "" {
+		inner = "bolt"
+	}
+	return f, inner
+}
+
+// injector makes the decisions of Faults and counts what it injected.
+type injector struct {
+	Faults
+	mu                          sync.Mutex
+	rnd                         *rand.Rand
+	spikes, failed, shortWrites int
+}
+
+func newInjector(f Faults) *injector {
+	return &injector{Faults: f, rnd: rand.New(rand.NewSource(f.Seed))}
+}
+
+// spike sleeps for SpikeRate of the calls.
+func (in *injector) spike() {
+	in.mu.Lock()
+	hit := in.SpikeRate > 0 && in.rnd.Float64() < in.SpikeRate
+	if hit {
+		in.spikes++
+	}
+	in.mu.Unlock()
+	if hit {
+		time.Sleep(in.Spike)
+	}
+}
+
+// fail is ErrInjected for ErrorRate of the calls, after any spike.
+func (in *injector) fail() error {
+	in.spike()
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.ErrorRate > 0 && in.rnd.Float64() < in.ErrorRate {
+		in.failed++
+		return ErrInjected
+	}
+	return nil
+}
+
+// short is how many of n bytes to write, less than n for
+// ShortWriteRate of the calls.
+func (in *injector) short(n int) int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if n > 0 && in.ShortWriteRate > 0 && in.rnd.Float64() < in.ShortWriteRate {
+		in.shortWrites++
+		return in.rnd.Intn(n)
+	}
+	return n
+}
+
+// Injected is how many spikes, errors and short writes were injected.
+func (in *injector) Injected() (spikes, failed, shortWrites int) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.spikes, in.failed, in.shortWrites
+}
+
+// Faulty is a Store injecting Faults into another: latency spikes
+// before any operation and ErrInjected from reads, so the callers'
+// error handling and timeouts can be tested without failing hardware.
+// Writers have no way to report errors, so writes only get spikes.
+type Faulty struct {
+	Store
+	*injector
+}
+
+func NewFaulty(s Store, f Faults) *Faulty {
+	return &Faulty{Store: s, injector: newInjector(f)}
+}
+
+func (f *Faulty) Get(key string) ([]string, error) {
+	if err := f.fail(); err != nil {
+		return nil, fmt.Errorf("get %s: %w", key, err)
+	}
+	return f.Store.Get(key)
+}
+
+func (f *Faulty) Writer(key string, value []string) {
+	f.spike()
+	f.Store.Writer(key, value)
+}
+
+func (f *Faulty) Delete(key string) {
+	f.spike()
+	f.Store.Delete(key)
+}
+
+func (f *Faulty) Flush() {
+	f.spike()
+	f.Store.Flush()
+}
+
+func (f *Faulty) Iterate(fn func(key string, value []string) error) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.Store.Iterate(fn)
+}
+
+func (f *Faulty) Close() {
+	Close(f.Store)
+}
+
+// Begin begins a transaction of the wrapped store whose reads fail the
+// same way.
+func (f *Faulty) Begin() (ReadTxn, error) {
+	if err := f.fail(); err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	txn, err := Begin(f.Store)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyTxn{ReadTxn: txn, injector: f.injector}, nil
+}
+
+type faultyTxn struct {
+	ReadTxn
+	*injector
+}
+
+func (t *faultyTxn) Get(key string) ([]string, error) {
+	if err := t.fail(); err != nil {
+		return nil, fmt.Errorf("get %s: %w", key, err)
+	}
+	return t.ReadTxn.Get(key)
+}
+
+// Writer returns w with the faults' spikes and short writes injected,
+// e.g. to tear the WAL's frames, see WAL.Inject.
+func (f Faults) Writer(w io.Writer) io.Writer {
+	return &faultyWriter{w: w, injector: newInjector(f)}
+}
+
+type faultyWriter struct {
+	w io.Writer
+	*injector
+}
+
+func (fw *faultyWriter) Write(p []byte) (int, error) {
+	fw.spike()
+	n := fw.short(len(p))
+	written, err := fw.w.Write(p[:n])
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	return written, err
+}
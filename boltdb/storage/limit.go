@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// WriteLimit caps how fast a Bolt admits writes, in keys and in bytes of
+// keys and values a second, so a load can run in the background of
+// searches served from the same disk. A zero rate is no limit.
+type WriteLimit struct {
+	KeysPerSec  float64 `json:"keys_per_sec,omitempty"`
+	BytesPerSec float64 `json:"bytes_per_sec,omitempty"`
+}
+
+// WithWriteLimit has Writer wait for l to admit each write.
+func WithWriteLimit(l WriteLimit) Option {
+	return func(o *options) { o.writeLimit = l }
+}
+
+// limitBurst is how far ahead of its rate a limiter lets writes run
+// after a pause.
+const limitBurst = 100 * time.Millisecond
+
+// limiter is a token bucket for each rate of a WriteLimit. A write takes
+// its tokens at once, going into debt if there aren't enough, and waits
+// until the rate has paid the debt back, so concurrent writers queue up
+// behind each other. Debts too small to sleep for accurately are left to
+// grow.
+type limiter struct {
+	limit WriteLimit
+	// now and sleep are the clock, time.Now and time.Sleep but in tests
+	now   func() time.Time
+	sleep func(time.Duration)
+
+	mu          sync.Mutex
+	keys, bytes float64
+	first, last time.Time
+	// end is when the last write admitted is done waiting
+	end   time.Time
+	stats LimitStats
+}
+
+// LimitStats are what a WriteLimit let through: the keys and bytes, over
+// how long from the first write until the last was done waiting, and how
+// long writers waited for it. BurstKeys and BurstBytes were let through
+// in the Burst before any writer had to wait, ahead of the rates, none if
+// no writer ever waited.
+type LimitStats struct {
+	Keys       int
+	Bytes      int64
+	Took       time.Duration
+	Waited     time.Duration
+	BurstKeys  int
+	BurstBytes int64
+	Burst      time.Duration
+}
+
+// KeysPerSec and BytesPerSec are the rates achieved after the burst, those
+// the limit held the writers to.
+func (s LimitStats) KeysPerSec() float64 {
+	return s.rate(float64(s.Keys-s.BurstKeys), float64(s.Keys))
+}
+func (s LimitStats) BytesPerSec() float64 {
+	return s.rate(float64(s.Bytes-s.BurstBytes), float64(s.Bytes))
+}
+
+// rate is after, let through after the burst, a second, or all over Took
+// if no time went by after the burst, and 0 if none went by at all.
+func (s LimitStats) rate(after, all float64) float64 {
+	if s.Took > s.Burst {
+		return after / (s.Took - s.Burst).Seconds()
+	}
+	if s.Took > 0 {
+		return all / s.Took.Seconds()
+	}
+	return 0
+}
+
+func newLimiter(l WriteLimit) *limiter {
+	if l.KeysPerSec <= 0 && l.BytesPerSec <= 0 {
+		return nil
+	}
+	return &limiter{limit: l, now: time.Now, sleep: time.Sleep}
+}
+
+// wait admits a write of a key and n bytes.
+func (l *limiter) wait(n int) {
+	l.mu.Lock()
+	now := l.now()
+	if l.first.IsZero() {
+		l.first, l.last, l.end = now, now, now
+		l.keys = l.limit.KeysPerSec * limitBurst.Seconds()
+		l.bytes = l.limit.BytesPerSec * limitBurst.Seconds()
+	}
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	var debt float64
+	if rate := l.limit.KeysPerSec; rate > 0 {
+		l.keys = min(l.keys+elapsed*rate, rate*limitBurst.Seconds()) - 1
+		debt = max(debt, -l.keys/rate)
+	}
+	if rate := l.limit.BytesPerSec; rate > 0 {
+		l.bytes = min(l.bytes+elapsed*rate, rate*limitBurst.Seconds()) - float64(n)
+		debt = max(debt, -l.bytes/rate)
+	}
+	d := time.Duration(debt * float64(time.Second))
+	if d < time.Millisecond {
+		d = 0
+	}
+	if d > 0 && l.stats.Waited == 0 {
+		l.stats.BurstKeys, l.stats.BurstBytes, l.stats.Burst = l.stats.Keys, l.stats.Bytes, now.Sub(l.first)
+	}
+	if end := now.Add(d); end.After(l.end) {
+		l.end = end
+	}
+	l.stats.Keys++
+	l.stats.Bytes += int64(n)
+	l.stats.Waited += d
+	l.mu.Unlock()
+	l.sleep(d)
+}
+
+// read returns the stats so far.
+func (l *limiter) read() LimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.stats
+	s.Took = l.end.Sub(l.first)
+	return s
+}
@@ -0,0 +1,181 @@
+//go:build lmdb
+
+package storage
+
+import (
+	"github.com/bmatsuo/lmdb-go/lmdb"
+	"github.com/jogo/goplayground/boltdb/codec"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"os"
+)
+
+func init() {
+	Register("lmdb", func(spec, path string, def Layout, opts ...Option) Store {
+		return NewLMDB(path, NewCodec(ParseCodec(spec, def)), opts...)
+	})
+}
+
+// LMDB is the backend bolt was modeled on: a copy-on-write B+tree in one
+// memory mapped file, but written in C and called through cgo.
+type LMDB struct {
+	*writeBuffer
+	env   *lmdb.Env
+	dbi   lmdb.DBI
+	Codec codec.Codec
+}
+
+// NewLMDB creates a fresh LMDB file at path. Commits are synced
+// according to WithSyncPolicy, every N flushes counting as at close.
+func NewLMDB(path string, c codec.Codec, opts ...Option) *LMDB {
+	os.Remove(path)
+	os.Remove(path + "-lock")
+	env, err := lmdb.NewEnv()
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	// only reserves address space, the file grows as needed
+	err = env.SetMapSize(1 << 40)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	// NoTLS ties read transactions to the transaction rather than the
+	// thread, which a goroutine doesn't keep, see Begin
+	flags := uint(lmdb.NoSubdir | lmdb.NoTLS)
+	if newOptions(opts).sync != SyncEveryFlush {
+		flags |= lmdb.NoSync
+	}
+	err = env.Open(path, flags, 0600)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	l := &LMDB{env: env, Codec: c}
+	err = env.Update(func(txn *lmdb.Txn) (err error) {
+		l.dbi, err = txn.OpenRoot(0)
+		return err
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	l.writeBuffer = newWriteBuffer(opts, l.commit)
+	return l
+}
+
+func (l *LMDB) commit(puts map[string][]string, deletes map[string]bool) error {
+	return l.env.Update(func(txn *lmdb.Txn) error {
+		for key := range deletes {
+			err := txn.Del(l.dbi, []byte(key), nil)
+			if err != nil && !lmdb.IsNotFound(err) {
+				return err
+			}
+		}
+		for key, value := range puts {
+			data, err := l.Codec.Marshal(value)
+			if err != nil {
+				return err
+			}
+			err = txn.Put(l.dbi, []byte(key), data, 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (l *LMDB) Get(key string) (value []string, err error) {
+	if value, ok, err := l.buffered(key); ok {
+		return value, err
+	}
+	err = l.env.View(func(txn *lmdb.Txn) error {
+		value, err = l.getTxn(txn, key)
+		return err
+	})
+	return value, err
+}
+
+// getTxn is Get's read of key within txn.
+func (l *LMDB) getTxn(txn *lmdb.Txn, key string) ([]string, error) {
+	txn.RawRead = true
+	data, err := txn.Get(l.dbi, []byte(key))
+	if lmdb.IsNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l.Codec.Unmarshal(data)
+}
+
+// Begin begins a read-only transaction the returned ReadTxn's reads
+// share. The env is opened with NoTLS, so it can move between threads
+// with the goroutine using it.
+func (l *LMDB) Begin() (ReadTxn, error) {
+	txn, err := l.env.BeginTxn(nil, lmdb.Readonly)
+	if err != nil {
+		return nil, err
+	}
+	return &lmdbTxn{l: l, txn: txn}, nil
+}
+
+type lmdbTxn struct {
+	l   *LMDB
+	txn *lmdb.Txn
+}
+
+func (t *lmdbTxn) Get(key string) ([]string, error) {
+	if value, ok, err := t.l.buffered(key); ok {
+		return value, err
+	}
+	return t.l.getTxn(t.txn, key)
+}
+
+func (t *lmdbTxn) Close() error {
+	t.txn.Abort()
+	return nil
+}
+
+// Iterate visits the keys in key order, leaving out the namespaces kept
+// under prefixes, see In.
+func (l *LMDB) Iterate(fn func(key string, value []string) error) error {
+	return skipNamespaces(l.iterateAll, fn)
+}
+
+// iterateAll is Iterate with the keys of every namespace.
+func (l *LMDB) iterateAll(fn func(key string, value []string) error) error {
+	l.Flush()
+	return l.env.View(func(txn *lmdb.Txn) error {
+		txn.RawRead = true
+		c, err := txn.OpenCursor(l.dbi)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		for {
+			k, v, err := c.Get(nil, nil, lmdb.Next)
+			if lmdb.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			value, err := l.Codec.Unmarshal(v)
+			if err != nil {
+				return err
+			}
+			err = fn(string(k), value)
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// Close flushes, syncs and closes the file.
+func (l *LMDB) Close() {
+	l.Flush()
+	err := l.env.Sync(true)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	l.env.Close()
+}
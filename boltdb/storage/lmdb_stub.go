@@ -0,0 +1,7 @@
+//go:build !lmdb
+
+package storage
+
+func init() {
+	registerStub("lmdb")
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"github.com/jogo/goplayground/boltdb/codec"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"sync"
+)
+
+// Map is the in-memory baseline backend.
+type Map struct {
+	mu sync.RWMutex
+	db map[string][]string
+	// Interner, if set, interns the items of every value written
+	Interner *codec.Interner
+	// spaces are the namespaces besides NodesSpace, see Namespace
+	spaces map[string]*Map
+}
+
+func init() {
+	Register("map", func(spec, path string, def Layout, opts ...Option) Store {
+		if spec != "map" {
+			run.Fatal("map takes no options", "spec", spec)
+		}
+		return NewMap()
+	})
+}
+
+func NewMap() *Map {
+	m := Map{
+		db: make(map[string][]string),
+	}
+	return &m
+}
+
+// Writer keeps value, with its items replaced by their interned copies
+// if m has an Interner.
+func (m *Map) Writer(key string, value []string) {
+	if m.Interner != nil {
+		for i, s := range value {
+			value[i] = m.Interner.String(s)
+		}
+	}
+	m.mu.Lock()
+	m.db[key] = value
+	m.mu.Unlock()
+}
+
+func (m *Map) Flush() {
+}
+
+func (m *Map) Delete(key string) {
+	m.mu.Lock()
+	delete(m.db, key)
+	m.mu.Unlock()
+}
+
+// Iterate visits a snapshot of the keys in no particular order, so fn
+// may call back into m.
+func (m *Map) Iterate(fn func(key string, value []string) error) error {
+	m.mu.RLock()
+	snapshot := make(map[string][]string, len(m.db))
+	for key, value := range m.db {
+		snapshot[key] = value
+	}
+	m.mu.RUnlock()
+	for key, value := range snapshot {
+		err := fn(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Map) Get(key string) ([]string, error) {
+	m.mu.RLock()
+	value, ok := m.db[key]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
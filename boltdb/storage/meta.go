@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"math"
+	"sort"
+	"time"
+)
+
+// CoordsBucket maps node keys to their position, a big-endian float64
+// pair such as latitude and longitude.
+var CoordsBucket = []byte("coords")
+
+// The kinds of positions in CoordsBucket, recorded under coordsKey so a
+// search knows which distance bounds its edges.
+const (
+	// LatLon is latitude and longitude in degrees, for edges weighted by
+	// their length in meters, as imported roads are
+	LatLon = "latlon"
+	// XY is a position on a plane in the units of the edge weights, as
+	// the grid's column and row
+	XY = "xy"
+)
+
+// WriteCoords stores the positions of nodes in CoordsBucket, all of one
+// kind, LatLon or XY.
+func (mybolt *Bolt) WriteCoords(kind string, coords map[string][2]float64) {
+	err := mybolt.Db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(MetaBucket).Put(coordsKey, []byte(kind))
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	type entry struct {
+		key   []byte
+		value [16]byte
+	}
+	entries := make([]entry, 0, len(coords))
+	for node, c := range coords {
+		k, err := mybolt.EncodeKey(node)
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		e := entry{key: k}
+		binary.BigEndian.PutUint64(e.value[:8], math.Float64bits(c[0]))
+		binary.BigEndian.PutUint64(e.value[8:], math.Float64bits(c[1]))
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	for len(entries) > 0 {
+		n := min(len(entries), mybolt.BatchSize)
+		err := mybolt.Db.Update(func(tx *bolt.Tx) error {
+			b := mybolt.bucket(tx, CoordsBucket)
+			for _, e := range entries[:n] {
+				if err := b.Put(e.key, e.value[:]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			run.Fatal(err.Error())
+		}
+		entries = entries[n:]
+	}
+}
+
+// ReadCoords returns the kind of positions in CoordsBucket and the
+// positions of every node that has one, none if the db has none.
+func (mybolt *Bolt) ReadCoords() (kind string, coords map[string][2]float64, err error) {
+	return mybolt.readCoords(func(b *bolt.Bucket, add func(k, v []byte) error) error {
+		return b.ForEach(add)
+	})
+}
+
+// CoordsOf is ReadCoords of just nodes, those of them with a position.
+func (mybolt *Bolt) CoordsOf(nodes []string) (kind string, coords map[string][2]float64, err error) {
+	return mybolt.readCoords(func(b *bolt.Bucket, add func(k, v []byte) error) error {
+		for _, node := range nodes {
+			k, err := mybolt.EncodeKey(node)
+			if err != nil {
+				return err
+			}
+			if v := b.Get(k); v != nil {
+				if err := add(k, v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// readCoords decodes the positions visit adds from CoordsBucket.
+func (mybolt *Bolt) readCoords(visit func(b *bolt.Bucket, add func(k, v []byte) error) error) (kind string, coords map[string][2]float64, err error) {
+	err = mybolt.Db.View(func(tx *bolt.Tx) error {
+		meta, b := tx.Bucket(MetaBucket), tx.Bucket(CoordsBucket)
+		if meta == nil || b == nil || meta.Get(coordsKey) == nil {
+			return nil
+		}
+		kind = string(meta.Get(coordsKey))
+		coords = make(map[string][2]float64)
+		return visit(b, func(k, v []byte) error {
+			if len(v) != 16 {
+				return fmt.Errorf("coordinates of %q: %d bytes, want 16", mybolt.DecodeKey(k), len(v))
+			}
+			coords[mybolt.DecodeKey(k)] = [2]float64{
+				math.Float64frombits(binary.BigEndian.Uint64(v[:8])),
+				math.Float64frombits(binary.BigEndian.Uint64(v[8:])),
+			}
+			return nil
+		})
+	})
+	return kind, coords, err
+}
+
+// MetaBucket holds one metadata record, under metadataKey, describing how
+// the rest of the file was written.
+var (
+	MetaBucket  = []byte("meta")
+	metadataKey = []byte("dataset")
+	manifestKey = []byte("manifest")
+	coordsKey   = []byte("coords")
+)
+
+// FormatVersion changes whenever the file layout changes in a way the
+// flags recorded in metadata don't capture, see Upgrade. Version 2 can
+// record an upgrade in progress, which version 1 readers would miss.
+const FormatVersion = 2
+
+type Metadata struct {
+	Version int       `json:"version"`
+	Schema  string    `json:"schema"`
+	Keys    string    `json:"keys"`
+	Codec   string    `json:"codec"`
+	Dataset string    `json:"dataset"`
+	Size    int       `json:"size"`
+	Created time.Time `json:"created"`
+	// Shard of Shards, when the file is one of a Sharded store's
+	Shard  int `json:"shard,omitempty"`
+	Shards int `json:"shards,omitempty"`
+	// Upgrade is set while an Upgrade rewrites the file, which holds a
+	// mix of both layouts until it finishes
+	Upgrade *Upgrading `json:"upgrade,omitempty"`
+}
+
+// Upgrading is an Upgrade in progress.
+type Upgrading struct {
+	Schema string `json:"schema"`
+	Keys   string `json:"keys"`
+	Codec  string `json:"codec"`
+	// Staged is set once every key is in the staging buckets
+	Staged bool `json:"staged"`
+}
+
+// Layout is the layout the file was written with.
+func (m Metadata) Layout() Layout {
+	return Layout{Schema: m.Schema, Keys: m.Keys, Codec: m.Codec}
+}
+
+var ErrNoMetadata = errors.New("no metadata, the db predates it or wasn't written by this tool")
+
+// WriteMetadata records the layout mybolt writes with and the dataset
+// about to be loaded.
+func (mybolt *Bolt) WriteMetadata(codecName, dataset string, size int) {
+	err := putMetadata(mybolt.Db, Metadata{
+		Version: FormatVersion,
+		Schema:  mybolt.schema,
+		Keys:    mybolt.keys,
+		Codec:   codecName,
+		Dataset: dataset,
+		Size:    size,
+		Created: time.Now().UTC(),
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	// the write-ahead log doesn't cover it, so sync now for recovery
+	err = mybolt.Db.Sync()
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+}
+
+// WriteShard records, after WriteMetadata, that mybolt is shard of
+// shards.
+func (mybolt *Bolt) WriteShard(shard, shards int) {
+	m, err := ReadMetadata(mybolt.Db)
+	if err == nil {
+		m.Shard, m.Shards = shard, shards
+		err = putMetadata(mybolt.Db, m)
+	}
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+}
+
+func putMetadata(db *bolt.DB, m Metadata) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(MetaBucket).Put(metadataKey, data)
+	})
+}
+
+func ReadMetadata(db *bolt.DB) (m Metadata, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(MetaBucket)
+		if b == nil {
+			return ErrNoMetadata
+		}
+		data := b.Get(metadataKey)
+		if data == nil {
+			return ErrNoMetadata
+		}
+		return json.Unmarshal(data, &m)
+	})
+	return m, err
+}
+
+// Manifest records the inputs a db was loaded from.
+type Manifest struct {
+	Importer int `json:"importer"`
+	// Revision is the version control revision the binary was built from
+	Revision string   `json:"revision,omitempty"`
+	Sources  []Source `json:"sources"`
+}
+
+type Source struct {
+	Path   string `json:"path"`
+	Format string `json:"format"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+func (mybolt *Bolt) WriteManifest(m Manifest) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	err = mybolt.Db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(MetaBucket).Put(manifestKey, data)
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+}
+
+// ReadManifest returns false if the db wasn't loaded from files.
+func ReadManifest(db *bolt.DB) (m Manifest, ok bool, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(MetaBucket)
+		if b == nil {
+			return nil
+		}
+		data := b.Get(manifestKey)
+		if data == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(data, &m)
+	})
+	return m, ok, err
+}
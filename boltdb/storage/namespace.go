@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/jogo/goplayground/boltdb/codec"
+	"strings"
+)
+
+// The namespaces a store keeps apart, each a keyspace of its own.
+// NodesSpace, the adjacency lists, is the store itself, the others hold
+// what has grown up around the graph: edge records, landmark distances
+// and metadata.
+const (
+	NodesSpace     = "nodes"
+	EdgesSpace     = "edges"
+	LandmarksSpace = "landmarks"
+	MetadataSpace  = "metadata"
+)
+
+// Namespaced is a Store keeping namespaces natively, e.g. as buckets.
+type Namespaced interface {
+	Store
+	// Namespace is the store of ns, the Store itself for NodesSpace. Its
+	// writes are flushed on their own, and it is closed with the Store.
+	Namespace(ns string) Store
+}
+
+// In is the ns namespace of s: natively if s is Namespaced, otherwise
+// s's keys under a prefix that s's own Iterate skips.
+func In(s Store, ns string) Store {
+	if ns == NodesSpace {
+		return s
+	}
+	if n, ok := s.(Namespaced); ok {
+		return n.Namespace(ns)
+	}
+	return &prefixed{Store: s, prefix: nsMarker + ns + nsMarker}
+}
+
+// nsMarker brackets the namespace a prefixed key belongs to. Node keys
+// don't start with it, and unlike NUL Postgres text can hold it.
+const nsMarker = "\x1f"
+
+// skipNamespaces is the Iterate of a backend that keeps namespaces by
+// prefix: iterateAll without the prefixed keys.
+func skipNamespaces(iterateAll func(fn func(key string, value []string) error) error, fn func(key string, value []string) error) error {
+	return iterateAll(func(key string, value []string) error {
+		if strings.HasPrefix(key, nsMarker) {
+			return nil
+		}
+		return fn(key, value)
+	})
+}
+
+// prefixed is a namespace kept as prefixed keys of another store.
+type prefixed struct {
+	Store
+	prefix string
+}
+
+func (p *prefixed) Writer(key string, value []string) {
+	p.Store.Writer(p.prefix+key, value)
+}
+
+func (p *prefixed) Delete(key string) {
+	p.Store.Delete(p.prefix + key)
+}
+
+func (p *prefixed) Get(key string) ([]string, error) {
+	return p.Store.Get(p.prefix + key)
+}
+
+// Iterate visits every key of the store to find the namespace's.
+func (p *prefixed) Iterate(fn func(key string, value []string) error) error {
+	all, ok := p.Store.(interface {
+		iterateAll(fn func(key string, value []string) error) error
+	})
+	if !ok {
+		return p.Store.Iterate(fn)
+	}
+	return all.iterateAll(func(key string, value []string) error {
+		if !strings.HasPrefix(key, p.prefix) {
+			return nil
+		}
+		return fn(key[len(p.prefix):], value)
+	})
+}
+
+// Close leaves the store open for its other namespaces.
+func (p *prefixed) Close() {
+	p.Flush()
+}
+
+// Namespace is a store of m's own, kept until m is dropped.
+func (m *Map) Namespace(ns string) Store {
+	if ns == NodesSpace {
+		return m
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	space, ok := m.spaces[ns]
+	if !ok {
+		if m.spaces == nil {
+			m.spaces = make(map[string]*Map)
+		}
+		space = NewMap()
+		space.Interner = m.Interner
+		m.spaces[ns] = space
+	}
+	return space
+}
+
+// Namespace is ns's bucket, "ns." and its name. Its keys are the strings
+// themselves and its values binary encoded, whatever the layout of
+// NodesSpace, so that Upgrade can leave namespaces be.
+func (mybolt *Bolt) Namespace(ns string) Store {
+	if ns == NodesSpace {
+		return mybolt
+	}
+	mybolt.mu.Lock()
+	defer mybolt.mu.Unlock()
+	space, ok := mybolt.spaces[ns]
+	if !ok {
+		if mybolt.spaces == nil {
+			mybolt.spaces = make(map[string]*boltSpace)
+		}
+		space = &boltSpace{db: mybolt.Db, name: []byte("ns." + ns)}
+		space.writeBuffer = newWriteBuffer([]Option{WithBatchSize(mybolt.BatchSize)}, space.commit)
+		mybolt.spaces[ns] = space
+	}
+	return space
+}
+
+// boltSpace is a namespace of a Bolt.
+type boltSpace struct {
+	*writeBuffer
+	db   *bolt.DB
+	name []byte
+}
+
+func (s *boltSpace) commit(puts map[string][]string, deletes map[string]bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(s.name)
+		if err != nil {
+			return err
+		}
+		for key, value := range puts {
+			data, err := codec.Binary{}.Marshal(value)
+			if err == nil {
+				err = b.Put([]byte(key), data)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for key := range deletes {
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltSpace) Get(key string) (value []string, err error) {
+	value, ok, err := s.buffered(key)
+	if ok {
+		return value, err
+	}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.name)
+		var data []byte
+		if b != nil {
+			data = b.Get([]byte(key))
+		}
+		if data == nil {
+			return ErrNotFound
+		}
+		value, err = codec.Binary{}.Unmarshal(data)
+		return err
+	})
+	return value, err
+}
+
+// Iterate flushes pending writes and visits the keys in order.
+func (s *boltSpace) Iterate(fn func(key string, value []string) error) error {
+	s.Flush()
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.name)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			value, err := codec.Binary{}.Unmarshal(v)
+			if err == nil {
+				err = fn(string(k), value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close leaves the db open for the other namespaces.
+func (s *boltSpace) Close() {
+	s.Flush()
+}
+
+// Namespace is ns sharded the same way as NodesSpace.
+func (s *Sharded) Namespace(ns string) Store {
+	if ns == NodesSpace {
+		return s
+	}
+	shards := make([]Store, len(s.shards))
+	for i, shard := range s.shards {
+		shards[i] = In(shard, ns)
+	}
+	return NewSharded(shards...)
+}
+
+// Namespace is ns of the wrapped store with the same faults injected.
+func (f *Faulty) Namespace(ns string) Store {
+	if ns == NodesSpace {
+		return f
+	}
+	return &Faulty{Store: In(f.Store, ns), injector: f.injector}
+}
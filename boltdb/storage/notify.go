@@ -0,0 +1,26 @@
+package storage
+
+// Notifier calls OnChange hooks with every key written or deleted through
+// it, so a cache in front of the same store, e.g. cache.Cache.Invalidate
+// or cache.Paths.Invalidate, can drop what the write made stale. Hooks
+// run after the write is handed to the store, not once it is flushed.
+type Notifier struct {
+	Store
+	OnChange []func(key string)
+}
+
+func (n *Notifier) Writer(key string, value []string) {
+	n.Store.Writer(key, value)
+	n.notify(key)
+}
+
+func (n *Notifier) Delete(key string) {
+	n.Store.Delete(key)
+	n.notify(key)
+}
+
+func (n *Notifier) notify(key string) {
+	for _, fn := range n.OnChange {
+		fn(key)
+	}
+}
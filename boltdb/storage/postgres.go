@@ -0,0 +1,171 @@
+//go:build postgres
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jogo/goplayground/boltdb/codec"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	Register("postgres", func(spec, path string, def Layout, opts ...Option) Store {
+		// empty means the PG* environment variables
+		return NewPostgres(os.Getenv("DATABASE_URL"), tableName(path), NewCodec(ParseCodec(spec, def)), opts...)
+	})
+}
+
+var nonIdentifier = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// tableName is a table named after the file a path would create.
+func tableName(path string) string {
+	return "boltdb_" + nonIdentifier.ReplaceAllString(strings.ToLower(filepath.Base(path)), "_")
+}
+
+// Postgres is the networked SQL backend, one key/value table with the
+// key as primary key, so a B+tree again but behind a server and a query
+// planner.
+type Postgres struct {
+	*writeBuffer
+	pool *pgxpool.Pool
+	// table is quoted, ready for a query
+	table string
+	Codec codec.Codec
+}
+
+// NewPostgres connects to the database at url and creates table afresh.
+func NewPostgres(url, table string, c codec.Codec, opts ...Option) *Postgres {
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	p := &Postgres{pool: pool, table: pgx.Identifier{table}.Sanitize(), Codec: c}
+	_, err = pool.Exec(ctx, "DROP TABLE IF EXISTS "+p.table)
+	if err == nil {
+		_, err = pool.Exec(ctx, "CREATE TABLE "+p.table+" (key bytea PRIMARY KEY, value bytea NOT NULL)")
+	}
+	if err != nil {
+		run.Fatal(err.Error(), "table", table)
+	}
+	p.writeBuffer = newWriteBuffer(opts, p.commit)
+	return p
+}
+
+func (p *Postgres) commit(puts map[string][]string, deletes map[string]bool) error {
+	batch := &pgx.Batch{}
+	for key := range deletes {
+		batch.Queue("DELETE FROM "+p.table+" WHERE key = $1", []byte(key))
+	}
+	for key, value := range puts {
+		data, err := p.Codec.Marshal(value)
+		if err != nil {
+			return err
+		}
+		batch.Queue("INSERT INTO "+p.table+" (key, value) VALUES ($1, $2) "+
+			"ON CONFLICT (key) DO UPDATE SET value = excluded.value", []byte(key), data)
+	}
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+func (p *Postgres) Get(key string) ([]string, error) {
+	return p.get(p.pool, key, p.retry)
+}
+
+// get is Get querying q, the pool or a ReadTxn's transaction, retrying
+// as retry says. A failed query aborts its transaction, so only reads off
+// the pool are retried.
+func (p *Postgres) get(q interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}, key string, retry Retry) ([]string, error) {
+	if value, ok, err := p.buffered(key); ok {
+		return value, err
+	}
+	var data []byte
+	err := retry.do("get", func() error {
+		err := q.QueryRow(context.Background(), "SELECT value FROM "+p.table+" WHERE key = $1", []byte(key)).Scan(&data)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p.Codec.Unmarshal(data)
+}
+
+// Begin begins a read-only repeatable read transaction the returned
+// ReadTxn's reads share, holding one of the pool's connections until
+// Close.
+func (p *Postgres) Begin() (ReadTxn, error) {
+	tx, err := p.pool.BeginTx(context.Background(), pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &postgresTxn{p: p, tx: tx}, nil
+}
+
+type postgresTxn struct {
+	p  *Postgres
+	tx pgx.Tx
+}
+
+func (t *postgresTxn) Get(key string) ([]string, error) {
+	return t.p.get(t.tx, key, Retry{})
+}
+
+func (t *postgresTxn) Close() error {
+	return t.tx.Rollback(context.Background())
+}
+
+// Iterate visits the keys in key order, leaving out the namespaces kept
+// under prefixes, see In.
+func (p *Postgres) Iterate(fn func(key string, value []string) error) error {
+	return skipNamespaces(p.iterateAll, fn)
+}
+
+// iterateAll is Iterate with the keys of every namespace.
+func (p *Postgres) iterateAll(fn func(key string, value []string) error) error {
+	p.Flush()
+	rows, err := p.pool.Query(context.Background(), "SELECT key, value FROM "+p.table+" ORDER BY key")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k, data []byte
+		err = rows.Scan(&k, &data)
+		if err != nil {
+			return err
+		}
+		value, err := p.Codec.Unmarshal(data)
+		if err != nil {
+			return err
+		}
+		err = fn(string(k), value)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Close flushes and disconnects.
+func (p *Postgres) Close() {
+	p.Flush()
+	p.pool.Close()
+}
@@ -0,0 +1,7 @@
+//go:build !postgres
+
+package storage
+
+func init() {
+	registerStub("postgres")
+}
@@ -0,0 +1,146 @@
+//go:build redis
+
+package storage
+
+import (
+	"context"
+	"github.com/jogo/goplayground/boltdb/codec"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/redis/go-redis/v9"
+	"os"
+	"strings"
+)
+
+func init() {
+	Register("redis", func(spec, path string, def Layout, opts ...Option) Store {
+		addr := os.Getenv("REDIS_ADDR")
+		if addr == "" {
+			addr = "localhost:6379"
+		}
+		return NewRedis(addr, path+":", NewCodec(ParseCodec(spec, def)), opts...)
+	})
+}
+
+// Redis is the networked in-memory backend, for a feel of what a round
+// trip per Get costs against bolt's memory mapped reads. Keys are stored
+// under a prefix so several stores can share a server.
+type Redis struct {
+	*writeBuffer
+	client *redis.Client
+	prefix string
+	Codec  codec.Codec
+}
+
+// NewRedis connects to the server at addr and deletes any keys left
+// under prefix.
+func NewRedis(addr, prefix string, c codec.Codec, opts ...Option) *Redis {
+	r := &Redis{
+		client: redis.NewClient(&redis.Options{Addr: addr}),
+		prefix: prefix,
+		Codec:  c,
+	}
+	ctx := context.Background()
+	var stale []string
+	err := r.scan(ctx, func(k string) error {
+		stale = append(stale, k)
+		return nil
+	})
+	for len(stale) > 0 && err == nil {
+		n := min(len(stale), 1000)
+		err = r.client.Del(ctx, stale[:n]...).Err()
+		stale = stale[n:]
+	}
+	if err != nil {
+		run.Fatal(err.Error(), "addr", addr)
+	}
+	r.writeBuffer = newWriteBuffer(opts, r.commit)
+	return r
+}
+
+// scan calls fn with every server key under r's prefix, once each.
+func (r *Redis) scan(ctx context.Context, fn func(k string) error) error {
+	// SCAN may return a key more than once
+	seen := make(map[string]bool)
+	iter := r.client.Scan(ctx, 0, globEscaper.Replace(r.prefix)+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		k := iter.Val()
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		err := fn(k)
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+func (r *Redis) commit(puts map[string][]string, deletes map[string]bool) error {
+	ctx := context.Background()
+	pipe := r.client.TxPipeline()
+	for key := range deletes {
+		pipe.Del(ctx, r.prefix+key)
+	}
+	for key, value := range puts {
+		data, err := r.Codec.Marshal(value)
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, r.prefix+key, data, 0)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *Redis) Get(key string) ([]string, error) {
+	if value, ok, err := r.buffered(key); ok {
+		return value, err
+	}
+	var data []byte
+	err := r.retry.do("get", func() (err error) {
+		data, err = r.client.Get(context.Background(), r.prefix+key).Bytes()
+		if err == redis.Nil {
+			return ErrNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.Codec.Unmarshal(data)
+}
+
+// Iterate visits the keys in no particular order, skipping any deleted
+// while it runs and the namespaces kept under prefixes, see In.
+func (r *Redis) Iterate(fn func(key string, value []string) error) error {
+	return skipNamespaces(r.iterateAll, fn)
+}
+
+// iterateAll is Iterate with the keys of every namespace.
+func (r *Redis) iterateAll(fn func(key string, value []string) error) error {
+	r.Flush()
+	ctx := context.Background()
+	return r.scan(ctx, func(k string) error {
+		data, err := r.client.Get(ctx, k).Bytes()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		value, err := r.Codec.Unmarshal(data)
+		if err != nil {
+			return err
+		}
+		return fn(strings.TrimPrefix(k, r.prefix), value)
+	})
+}
+
+// Close flushes and disconnects, the server decides when to persist.
+func (r *Redis) Close() {
+	r.Flush()
+	r.client.Close()
+}
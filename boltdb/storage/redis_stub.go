@@ -0,0 +1,7 @@
+//go:build !redis
+
+package storage
+
+func init() {
+	registerStub("redis")
+}
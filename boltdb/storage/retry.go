@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Retry is how the networked backends, redis and postgres, retry a
+// flush or a read that failed, as when the server restarts or a
+// connection drops: up to Attempts more times, waiting Backoff before
+// the first retry and twice as long before each next one, at most
+// MaxBackoff if set. The zero Retry doesn't retry. Bolt, lmdb and the
+// map fail for good or not at all and ignore it.
+type Retry struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// WithRetry sets how the networked backends retry failed flushes and
+// reads.
+func WithRetry(r Retry) Option {
+	return func(o *options) { o.retry = r }
+}
+
+// do runs op until it succeeds or has been retried r.Attempts times,
+// returning its last error. ErrNotFound is an answer, not a failure, and
+// isn't retried.
+func (r Retry) do(what string, op func() error) error {
+	backoff := r.Backoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || errors.Is(err, ErrNotFound) || attempt > r.Attempts {
+			return err
+		}
+		slog.Warn("retrying", "op", what, "err", err, "attempt", attempt, "backoff", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if r.MaxBackoff > 0 {
+			backoff = min(backoff, r.MaxBackoff)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+//go:build rocksdb
+
+package storage
+
+import (
+	"github.com/jogo/goplayground/boltdb/codec"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"github.com/linxGnu/grocksdb"
+	"os"
+)
+
+func init() {
+	Register("rocksdb", func(spec, path string, def Layout, opts ...Option) Store {
+		return NewRocksDB(path, NewCodec(ParseCodec(spec, def)), opts...)
+	})
+}
+
+// RocksDB is the LSM tree backend, through cgo. Writes go to a memtable
+// and log instead of rewriting B+tree pages, so it should load much
+// faster than bolt and read somewhat slower.
+type RocksDB struct {
+	*writeBuffer
+	db    *grocksdb.DB
+	ro    *grocksdb.ReadOptions
+	wo    *grocksdb.WriteOptions
+	Codec codec.Codec
+}
+
+// NewRocksDB creates a fresh RocksDB directory at path. Commits are
+// synced according to WithSyncPolicy, every N flushes counting as at
+// close.
+func NewRocksDB(path string, c codec.Codec, opts ...Option) *RocksDB {
+	os.RemoveAll(path)
+	o := grocksdb.NewDefaultOptions()
+	defer o.Destroy()
+	o.SetCreateIfMissing(true)
+	db, err := grocksdb.OpenDb(o, path)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	r := &RocksDB{
+		db:    db,
+		ro:    grocksdb.NewDefaultReadOptions(),
+		wo:    grocksdb.NewDefaultWriteOptions(),
+		Codec: c,
+	}
+	r.wo.SetSync(newOptions(opts).sync == SyncEveryFlush)
+	r.writeBuffer = newWriteBuffer(opts, r.commit)
+	return r
+}
+
+func (r *RocksDB) commit(puts map[string][]string, deletes map[string]bool) error {
+	wb := grocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	for key := range deletes {
+		wb.Delete([]byte(key))
+	}
+	for key, value := range puts {
+		data, err := r.Codec.Marshal(value)
+		if err != nil {
+			return err
+		}
+		wb.Put([]byte(key), data)
+	}
+	return r.db.Write(r.wo, wb)
+}
+
+func (r *RocksDB) Get(key string) ([]string, error) {
+	return r.get(r.ro, key)
+}
+
+// get is Get reading with ro, the snapshot of a ReadTxn.
+func (r *RocksDB) get(ro *grocksdb.ReadOptions, key string) ([]string, error) {
+	if value, ok, err := r.buffered(key); ok {
+		return value, err
+	}
+	s, err := r.db.Get(ro, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	defer s.Free()
+	if !s.Exists() {
+		return nil, ErrNotFound
+	}
+	return r.Codec.Unmarshal(s.Data())
+}
+
+// Begin takes a snapshot the returned ReadTxn reads, rocksdb has no read
+// transactions to begin.
+func (r *RocksDB) Begin() (ReadTxn, error) {
+	snap := r.db.NewSnapshot()
+	ro := grocksdb.NewDefaultReadOptions()
+	ro.SetSnapshot(snap)
+	return &rocksTxn{r: r, snap: snap, ro: ro}, nil
+}
+
+type rocksTxn struct {
+	r    *RocksDB
+	snap *grocksdb.Snapshot
+	ro   *grocksdb.ReadOptions
+}
+
+func (t *rocksTxn) Get(key string) ([]string, error) {
+	return t.r.get(t.ro, key)
+}
+
+func (t *rocksTxn) Close() error {
+	t.ro.Destroy()
+	t.r.db.ReleaseSnapshot(t.snap)
+	return nil
+}
+
+// Iterate visits the keys in key order, leaving out the namespaces kept
+// under prefixes, see In.
+func (r *RocksDB) Iterate(fn func(key string, value []string) error) error {
+	return skipNamespaces(r.iterateAll, fn)
+}
+
+// iterateAll is Iterate with the keys of every namespace.
+func (r *RocksDB) iterateAll(fn func(key string, value []string) error) error {
+	r.Flush()
+	it := r.db.NewIterator(r.ro)
+	defer it.Close()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		k, v := it.Key(), it.Value()
+		key := string(k.Data())
+		value, err := r.Codec.Unmarshal(v.Data())
+		k.Free()
+		v.Free()
+		if err != nil {
+			return err
+		}
+		err = fn(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// Close flushes and closes the db, syncing its log.
+func (r *RocksDB) Close() {
+	r.Flush()
+	err := r.db.FlushWAL(true)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	r.db.Close()
+	r.ro.Destroy()
+	r.wo.Destroy()
+}
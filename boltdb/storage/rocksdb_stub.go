@@ -0,0 +1,7 @@
+//go:build !rocksdb
+
+package storage
+
+func init() {
+	registerStub("rocksdb")
+}
@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sharded splits the keyspace across several stores by a hash of the
+// key, so a dataset too big for one file, or one disk, can be spread
+// over several and still be read and written through one Store. A key's
+// shard only depends on the key and the number of shards, which must be
+// given in the same order every time.
+type Sharded struct {
+	shards []Store
+}
+
+func init() {
+	Register("shards", func(spec, path string, def Layout, opts ...Option) Store {
+		n, inner := parseShards(spec)
+		paths := ShardPaths(path, n)
+		shards := make([]Store, len(paths))
+		for i, p := range paths {
+			shards[i] = Open(inner, p, def, opts...)
+		}
+		return NewSharded(shards...)
+	})
+}
+
+// parseShards splits a spec like shards/4/bolt/split into the number of
+// shards, 0 if left out, and the spec of each shard, bolt by default.
+func parseShards(spec string) (n int, inner string) {
+	parts := strings.SplitN(spec, "/", 3)[1:]
+	if len(parts) > 0 {
+		if i, err := strconv.Atoi(parts[0]); err == nil {
+			n, parts = i, parts[1:]
+		}
+	}
+	inner = strings.Join(parts, "/")
+	if inner == "" {
+		inner = "bolt"
+	}
+	if strings.HasPrefix(inner, "shards") {
+		run.Fatal("shards can't nest", "spec", spec)
+	}
+	return n, inner
+}
+
+// ShardPaths is the file of each of n shards: path split at commas, e.g.
+// /disk1/my.db,/disk2/my.db, or else path with the shard number appended.
+func ShardPaths(path string, n int) []string {
+	if strings.Contains(path, ",") {
+		paths := strings.Split(path, ",")
+		if n != 0 && n != len(paths) {
+			run.Fatal("number of shards doesn't match the paths", "shards", n, "paths", path)
+		}
+		return paths
+	}
+	if n < 1 {
+		run.Fatal("need the number of shards or a path for each", "path", path)
+	}
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("%s.%d", path, i)
+	}
+	return paths
+}
+
+func NewSharded(shards ...Store) *Sharded {
+	if len(shards) == 0 {
+		run.Fatal("need at least one shard")
+	}
+	return &Sharded{shards: shards}
+}
+
+// Shards are the stores, in order.
+func (s *Sharded) Shards() []Store {
+	return s.shards
+}
+
+// Shard is the index of the shard holding key, by its 32 bit FNV-1a
+// hash, inlined as hash/fnv would allocate on every call.
+func (s *Sharded) Shard(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % uint32(len(s.shards)))
+}
+
+func (s *Sharded) Writer(key string, value []string) {
+	s.shards[s.Shard(key)].Writer(key, value)
+}
+
+// Rewrite rewrites key in its shard, or writes it if the shard isn't a
+// Rewriter.
+func (s *Sharded) Rewrite(key string, value []string) {
+	shard := s.shards[s.Shard(key)]
+	if r, ok := shard.(Rewriter); ok {
+		r.Rewrite(key, value)
+		return
+	}
+	shard.Writer(key, value)
+}
+
+func (s *Sharded) Delete(key string) {
+	s.shards[s.Shard(key)].Delete(key)
+}
+
+// Flush flushes every shard at once, they may well be on different
+// disks.
+func (s *Sharded) Flush() {
+	var wg sync.WaitGroup
+	for _, shard := range s.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shard.Flush()
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Sharded) Get(key string) ([]string, error) {
+	return s.shards[s.Shard(key)].Get(key)
+}
+
+// GetInto reads key into *dst from its shard, see IntoReader, or Gets
+// it from a shard that can't.
+func (s *Sharded) GetInto(key string, dst *[]string) error {
+	shard := s.shards[s.Shard(key)]
+	if into, ok := shard.(IntoReader); ok {
+		return into.GetInto(key, dst)
+	}
+	value, err := shard.Get(key)
+	if err == nil {
+		*dst = value
+	}
+	return err
+}
+
+// Begin begins a ReadTxn reading each shard through a transaction of its
+// own, all begun at once so they see the shards as of the same moment.
+func (s *Sharded) Begin() (ReadTxn, error) {
+	t := &shardedTxn{s: s, txns: make([]ReadTxn, len(s.shards))}
+	for i, shard := range s.shards {
+		txn, err := Begin(shard)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.txns[i] = txn
+	}
+	return t, nil
+}
+
+// shardedTxn is Sharded's ReadTxn.
+type shardedTxn struct {
+	s    *Sharded
+	txns []ReadTxn
+}
+
+func (t *shardedTxn) Get(key string) ([]string, error) {
+	return t.txns[t.s.Shard(key)].Get(key)
+}
+
+// GetInto is Sharded.GetInto within the transactions.
+func (t *shardedTxn) GetInto(key string, dst *[]string) error {
+	txn := t.txns[t.s.Shard(key)]
+	if into, ok := txn.(IntoReader); ok {
+		return into.GetInto(key, dst)
+	}
+	value, err := txn.Get(key)
+	if err == nil {
+		*dst = value
+	}
+	return err
+}
+
+// Close ends the shards' transactions, returning the first error.
+func (t *shardedTxn) Close() error {
+	var first error
+	for _, txn := range t.txns {
+		if txn == nil {
+			continue
+		}
+		if err := txn.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Iterate visits the shards in turn, so keys come in each shard's order
+// rather than one overall order.
+func (s *Sharded) Iterate(fn func(key string, value []string) error) error {
+	for _, shard := range s.shards {
+		err := shard.Iterate(fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCoords hands each shard that keeps coordinates its nodes'.
+func (s *Sharded) WriteCoords(kind string, coords map[string][2]float64) {
+	split := make([]map[string][2]float64, len(s.shards))
+	for node, c := range coords {
+		i := s.Shard(node)
+		if split[i] == nil {
+			split[i] = make(map[string][2]float64)
+		}
+		split[i][node] = c
+	}
+	for i, shard := range s.shards {
+		cw, ok := shard.(interface {
+			WriteCoords(string, map[string][2]float64)
+		})
+		if ok && split[i] != nil {
+			cw.WriteCoords(kind, split[i])
+		}
+	}
+}
+
+// ReadCoords merges the coordinates of the shards that keep them.
+func (s *Sharded) ReadCoords() (kind string, coords map[string][2]float64, err error) {
+	for _, shard := range s.shards {
+		cr, ok := shard.(interface {
+			ReadCoords() (string, map[string][2]float64, error)
+		})
+		if !ok {
+			continue
+		}
+		k, c, err := cr.ReadCoords()
+		if err != nil {
+			return "", nil, err
+		}
+		if c == nil {
+			continue
+		}
+		if kind != "" && k != kind {
+			return "", nil, fmt.Errorf("shards disagree on coordinates, %s and %s", kind, k)
+		}
+		kind = k
+		if coords == nil {
+			coords = c
+			continue
+		}
+		for node, xy := range c {
+			coords[node] = xy
+		}
+	}
+	return kind, coords, nil
+}
+
+func (s *Sharded) Close() {
+	for _, shard := range s.shards {
+		Close(shard)
+	}
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"github.com/boltdb/bolt"
+	"os"
+	"sort"
+)
+
+var spillBucket = []byte("closed")
+
+// Spill is a search.Closed holding up to front nodes in memory and the
+// rest in a temporary bolt file, so a search's memory doesn't grow with
+// the nodes it expands. The front moves to the file whole, one
+// transaction, when it fills up. It is not safe for concurrent use.
+type Spill struct {
+	dir     string
+	front   map[string]string
+	max     int
+	db      *bolt.DB
+	spilled int
+}
+
+// NewSpill returns a Spill keeping front nodes in memory, the file is
+// only created in dir, or the default temporary directory if dir is "",
+// once they don't fit.
+func NewSpill(dir string, front int) *Spill {
+	return &Spill{dir: dir, front: make(map[string]string), max: front}
+}
+
+func (s *Spill) Add(id, from string) error {
+	if len(s.front) >= s.max {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	s.front[id] = from
+	return nil
+}
+
+func (s *Spill) Get(id string) (from string, ok bool, err error) {
+	if from, ok := s.front[id]; ok {
+		return from, true, nil
+	}
+	if s.db == nil {
+		return "", false, nil
+	}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(spillBucket).Get([]byte(id))
+		from, ok = string(v), v != nil
+		return nil
+	})
+	return from, ok, err
+}
+
+func (s *Spill) spill() error {
+	if s.db == nil {
+		f, err := os.CreateTemp(s.dir, "spill-*.db")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		s.db, err = bolt.Open(f.Name(), 0600, nil)
+		if err != nil {
+			os.Remove(f.Name())
+			return err
+		}
+		// the file is thrown away, it needn't survive a crash
+		s.db.NoSync = true
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(spillBucket)
+		if err != nil {
+			return err
+		}
+		// bolt inserts fastest in key order
+		ids := make([]string, 0, len(s.front))
+		for id := range s.front {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if err := b.Put([]byte(id), []byte(s.front[id])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.spilled += len(s.front)
+	clear(s.front)
+	return nil
+}
+
+// Spilled is the number of nodes moved to the file.
+func (s *Spill) Spilled() int {
+	return s.spilled
+}
+
+// Close removes the file.
+func (s *Spill) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	path := s.db.Path()
+	s.db.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// TxStats summarizes where a commit's time and pages went, as slog
+// key/value pairs.
+func TxStats(s bolt.TxStats) []any {
+	return []any{"pages", s.PageCount, "page_bytes", s.PageAlloc, "splits", s.Split,
+		"spills", s.Spill, "spill_time", s.SpillTime, "rebalances", s.Rebalance,
+		"rebalance_time", s.RebalanceTime, "writes", s.Write, "write_time", s.WriteTime}
+}
+
+// buckets returns the buckets holding mybolt's values.
+func (mybolt *Bolt) buckets() [][]byte {
+	if mybolt.schema == SplitSchema {
+		return [][]byte{NodesBucket, EdgesBucket}
+	}
+	return [][]byte{Bucket}
+}
+
+// PageReport prints bolt's totals for every transaction since the db was
+// opened, then how each bucket's pages are used, to account for the file
+// size.
+func (mybolt *Bolt) PageReport() {
+	stats := mybolt.Db.Stats()
+	slog.Info("bolt commits", TxStats(stats.TxStats)...)
+	slog.Info("bolt freelist", "free_pages", stats.FreePageN, "pending_pages", stats.PendingPageN,
+		"bytes", stats.FreeAlloc)
+	err := mybolt.Db.View(func(tx *bolt.Tx) error {
+		slog.Info("bolt file", "bytes", tx.Size(),
+			"pages", tx.Size()/int64(mybolt.Db.Info().PageSize), "page_size", mybolt.Db.Info().PageSize)
+		for _, name := range mybolt.buckets() {
+			b := tx.Bucket(name).Stats()
+			slog.Info("bolt bucket", "bucket", string(name), "keys", b.KeyN, "depth", b.Depth,
+				"branch_pages", b.BranchPageN, "branch_overflow", b.BranchOverflowN,
+				"branch_inuse", b.BranchInuse, "branch_alloc", b.BranchAlloc,
+				"leaf_pages", b.LeafPageN, "leaf_overflow", b.LeafOverflowN,
+				"leaf_inuse", b.LeafInuse, "leaf_alloc", b.LeafAlloc)
+		}
+		return nil
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+}
+
+// Check runs bolt's own consistency check of the page structure, then
+// decodes every value with mybolt's codec and schema. The first problems
+// found are logged.
+func (mybolt *Bolt) Check() (pageErrors, badValues, values int, err error) {
+	const maxReported = 10
+	report := func(format string, args ...interface{}) {
+		if pageErrors+badValues <= maxReported {
+			slog.Warn(fmt.Sprintf(format, args...))
+		}
+	}
+	start := time.Now()
+	err = mybolt.Db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			pageErrors++
+			report("page error: %s", err)
+		}
+		slog.Info("page check", "took", time.Since(start), "errors", pageErrors)
+
+		start = time.Now()
+		badKey := func(k []byte) bool {
+			if mybolt.keys == Uint64Keys && len(k) != 8 {
+				badValues++
+				report("key %x: not a uint64 key", k)
+				return true
+			}
+			return false
+		}
+		if mybolt.schema != SplitSchema {
+			c := tx.Bucket(Bucket).Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				values++
+				if badKey(k) {
+					continue
+				}
+				var err error
+				if len(v) == 0 {
+					_, err = mybolt.readBlocks(tx, k, nil)
+				} else {
+					_, err = mybolt.Codec.Unmarshal(v)
+				}
+				if err != nil {
+					badValues++
+					report("key %s: %s", mybolt.DecodeKey(k), err)
+				}
+			}
+			return nil
+		}
+		// every node's edge count must match its degree, and every
+		// edge must belong to a node
+		edges := tx.Bucket(EdgesBucket)
+		c := tx.Bucket(NodesBucket).Cursor()
+		linked := 0
+		for k, meta := c.First(); k != nil; k, meta = c.Next() {
+			values++
+			if badKey(k) {
+				continue
+			}
+			degree, err := strconv.Atoi(string(meta))
+			if err != nil {
+				badValues++
+				report("node %s: bad degree %q", mybolt.DecodeKey(k), meta)
+				continue
+			}
+			n := 0
+			prefix := edgePrefix(k)
+			ec := edges.Cursor()
+			for ek, _ := ec.Seek(prefix); ek != nil && bytes.HasPrefix(ek, prefix); ek, _ = ec.Next() {
+				n++
+			}
+			linked += n
+			if n != degree {
+				badValues++
+				report("node %s: degree %d but %d edges", mybolt.DecodeKey(k), degree, n)
+			}
+		}
+		if orphans := edges.Stats().KeyN - linked; orphans != 0 {
+			badValues++
+			report("%d edges without a node", orphans)
+		}
+		return nil
+	})
+	if err == nil {
+		slog.Info("decode check", "took", time.Since(start), "values", values, "undecodable", badValues)
+	}
+	return
+}
+
+// Touch walks fraction of every bucket holding values with a cursor,
+// pulling their pages into the page cache, and returns the number of
+// keys and bytes touched.
+func (mybolt *Bolt) Touch(fraction float64) (keys, touched int, err error) {
+	err = mybolt.Db.View(func(tx *bolt.Tx) error {
+		for _, name := range mybolt.buckets() {
+			b := tx.Bucket(name)
+			n := int(fraction * float64(b.Stats().KeyN))
+			c := b.Cursor()
+			for k, v := c.First(); k != nil && n > 0; k, v = c.Next() {
+				keys++
+				n--
+				touched += len(k) + len(v)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// Pages returns the page of the file holding each node's key, the first
+// page a read of the node touches, for working out how many pages a run
+// of reads needs. Under SplitSchema a node's edges are in pages of their
+// own, kept in the same order.
+func (mybolt *Bolt) Pages() (map[string]int, error) {
+	pages := make(map[string]int)
+	pageSize := mybolt.Db.Info().PageSize
+	err := mybolt.Db.View(func(tx *bolt.Tx) error {
+		// a read transaction's keys point into the mapping, which it
+		// holds still
+		mapped := mybolt.mapped()
+		return tx.Bucket(mybolt.buckets()[0]).ForEach(func(k, v []byte) error {
+			off := offset(mapped, k)
+			if off < 0 {
+				return fmt.Errorf("key %q outside the mapped file", k)
+			}
+			pages[mybolt.DecodeKey(k)] = off / pageSize
+			return nil
+		})
+	})
+	return pages, err
+}
+
+// DropCache evicts the file's pages from the page cache where the OS
+// allows, see run.DropCache, so the next reads come from the disk. Bolt
+// reads through its own mapping of the file, which is let go of too, so
+// it mustn't run next to a write.
+func (mybolt *Bolt) DropCache() error {
+	return run.DropCache(mybolt.Db.Path(), mybolt.mapped())
+}
+
+// Cached is the fraction of the file in the page cache, see run.Cached.
+func (mybolt *Bolt) Cached() (float64, error) {
+	return run.Cached(mybolt.Db.Path())
+}
@@ -0,0 +1,265 @@
+// Package storage holds the key/value backends the benchmarks compare: an
+// in-memory map and bolt, with bolt's key layouts, value codecs, write
+// batching and write-ahead log.
+//
+// Values are lists of strings, such as a node's adjacency list. Writers
+// buffer and have no way to report errors, so a failed write is logged
+// and exits the process.
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/jogo/goplayground/boltdb/codec"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// DB is the write side of a backend
+type DB interface {
+	Writer(key string, value []string)
+	Flush()
+}
+
+// Reader is the read side of a backend
+type Reader interface {
+	Get(key string) ([]string, error)
+}
+
+// IntoReader is a Reader that can decode a value into a slice the caller
+// reuses, saving a hot loop an allocation per read.
+type IntoReader interface {
+	Reader
+	// GetInto reads key's value into (*dst)[:0], reusing its backing
+	// array, and points *dst at it
+	GetInto(key string, dst *[]string) error
+}
+
+// ReadTxn is a Reader whose reads share one transaction, or snapshot, of
+// the backend until Close, so a loop of many Gets, such as A* expanding
+// its frontier, pays for one transaction rather than one per read. Writes
+// not flushed yet are read as Get reads them. Not safe for concurrent
+// use, and it must be closed: an open transaction holds back the
+// backend's writers, bolt's when it grows its file, so the goroutine
+// holding one mustn't flush writes of its own.
+type ReadTxn interface {
+	Reader
+	Close() error
+}
+
+// TxnReader is a Reader that can begin a ReadTxn.
+type TxnReader interface {
+	Reader
+	Begin() (ReadTxn, error)
+}
+
+// Begin begins a ReadTxn on r, natively if r is a TxnReader. Otherwise
+// each read of the transaction is still a transaction of its own, as for
+// the map or redis, which have none to share.
+func Begin(r Reader) (ReadTxn, error) {
+	if t, ok := r.(TxnReader); ok {
+		return t.Begin()
+	}
+	return perCall{r}, nil
+}
+
+// perCall is the ReadTxn of a Reader without transactions.
+type perCall struct{ Reader }
+
+func (perCall) Close() error { return nil }
+
+// Store is a whole backend, Iterate visits every key once.
+// Implementations are safe for concurrent use.
+type Store interface {
+	DB
+	Reader
+	Delete(key string)
+	Iterate(fn func(key string, value []string) error) error
+}
+
+var ErrNotFound = errors.New("key not found")
+
+// SameValue compares values the way they round trip through the
+// backends, which don't all tell nil from empty.
+func SameValue(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Layout is how a Bolt store lays out its keys and values: a schema, a
+// key encoding and a codec name.
+type Layout struct {
+	Schema, Keys, Codec string
+}
+
+// Backend creates an empty Store from a spec naming it, see Open.
+type Backend func(spec, path string, def Layout, opts ...Option) Store
+
+var backends = map[string]Backend{}
+
+// Register makes a backend available to Open under name. Backends that
+// need cgo or a server are only compiled in with their build tag, e.g.
+// -tags rocksdb, and otherwise register a stub saying so.
+func Register(name string, b Backend) {
+	if _, dup := backends[name]; dup {
+		panic("storage: backend registered twice: " + name)
+	}
+	backends[name] = b
+}
+
+// Backends are the registered backend names, sorted.
+func Backends() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open creates an empty backend from spec, a registered backend name
+// optionally followed by /-separated options, e.g. "map" or
+// "bolt/split/uint64/binary". Options spec leaves out come from def.
+// File backends are created at path, set up with opts.
+func Open(spec, path string, def Layout, opts ...Option) Store {
+	b, ok := backends[strings.Split(spec, "/")[0]]
+	if !ok {
+		run.Fatal("unknown backend", "spec", spec, "backends", strings.Join(Backends(), ", "))
+	}
+	return b(spec, path, def, opts...)
+}
+
+var stubs = map[string]bool{}
+
+// registerStub registers name for a backend left out of the build.
+func registerStub(name string) {
+	stubs[name] = true
+	Register(name, func(spec, path string, def Layout, opts ...Option) Store {
+		run.Fatal("backend not built in, rebuild with -tags "+name, "spec", spec)
+		return nil
+	})
+}
+
+// Built reports whether the backend name is registered and not a stub.
+func Built(name string) bool {
+	_, ok := backends[name]
+	return ok && !stubs[name]
+}
+
+// Close closes s if it holds a file or connection.
+func Close(s Store) {
+	if c, ok := s.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
+
+// ParseCodec returns the codec name of a spec for a backend whose only
+// option is the codec, e.g. "lmdb/binary".
+func ParseCodec(spec string, def Layout) string {
+	name := def.Codec
+	for _, opt := range strings.Split(spec, "/")[1:] {
+		if _, err := codec.Parse(opt); err != nil {
+			run.Fatal("unknown option", "option", opt, "spec", spec)
+		}
+		name = opt
+	}
+	return name
+}
+
+// ParseLayout returns the layout of a bolt spec for Open.
+func ParseLayout(spec string, def Layout) Layout {
+	l := def
+	for _, opt := range strings.Split(spec, "/")[1:] {
+		switch opt {
+		case FlatSchema, SplitSchema:
+			l.Schema = opt
+		case StringKeys, Uint64Keys:
+			l.Keys = opt
+		default:
+			if _, err := codec.Parse(opt); err != nil {
+				run.Fatal("unknown bolt option", "option", opt, "spec", spec)
+			}
+			l.Codec = opt
+		}
+	}
+	return l
+}
+
+// NewCodec is codec.Parse for names that were already validated.
+func NewCodec(name string) codec.Codec {
+	c, err := codec.Parse(name)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	return c
+}
+
+// Diff calls found for every key only in a ("only in a"), only in b
+// ("only in b") or in both with different values ("differs"), and
+// returns how many of each there were.
+func Diff(ctx context.Context, a, b Store, found func(kind, key string, a, b []string)) (onlyA, onlyB, differ int, err error) {
+	p := run.NewProgress("diff", 0)
+	n := 0
+	err = a.Iterate(func(key string, value []string) error {
+		if run.Done(ctx) {
+			return ctx.Err()
+		}
+		n++
+		p.Update(n)
+		other, err := b.Get(key)
+		switch {
+		case err == ErrNotFound:
+			onlyA++
+			found("only in a", key, value, nil)
+		case err != nil:
+			return err
+		case !SameValue(value, other):
+			differ++
+			found("differs", key, value, other)
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	err = b.Iterate(func(key string, value []string) error {
+		if run.Done(ctx) {
+			return ctx.Err()
+		}
+		n++
+		p.Update(n)
+		_, err := a.Get(key)
+		if err == ErrNotFound {
+			onlyB++
+			found("only in b", key, nil, value)
+			return nil
+		}
+		return err
+	})
+	return
+}
+
+// Copy writes every key/value of from into to, whatever backends they
+// are, and flushes to. size is how many keys from holds, for the
+// progress, 0 if unknown.
+func Copy(ctx context.Context, from, to Store, size int) (keys int, err error) {
+	p := run.NewProgress("copy", size)
+	err = from.Iterate(func(key string, value []string) error {
+		if run.Done(ctx) {
+			return ctx.Err()
+		}
+		to.Writer(key, value)
+		keys++
+		p.Update(keys)
+		return nil
+	})
+	if err != nil {
+		return keys, err
+	}
+	to.Flush()
+	return keys, nil
+}
@@ -0,0 +1,1144 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// testLayout fills in what backendSpecs leave out
+var testLayout = Layout{Schema: FlatSchema, Keys: StringKeys, Codec: "json"}
+
+// backendSpecs are the store configurations every backend test runs
+// against, see Open.
+var backendSpecs = []string{
+	"map",
+	"bolt/flat/string/json",
+	"bolt/flat/uint64/binary",
+	"bolt/flat/string/json+crc",
+	"bolt/split/string/binary+crc",
+	"bolt/split/uint64/json",
+	"shards/3/bolt/flat/uint64/binary",
+	"shards/2/map",
+}
+
+func init() {
+	// the optional backends, when built with their tags
+	for _, name := range []string{"lmdb", "rocksdb", "redis", "postgres"} {
+		if Built(name) {
+			backendSpecs = append(backendSpecs, name+"/json", name+"/binary+crc")
+		}
+	}
+}
+
+var valueRunes = []rune("ab0 \x00\"\\é世")
+
+func randomValue(rnd *rand.Rand) []string {
+	value := make([]string, rnd.Intn(5))
+	for i := range value {
+		r := make([]rune, rnd.Intn(6))
+		for j := range r {
+			r[j] = valueRunes[rnd.Intn(len(valueRunes))]
+		}
+		value[i] = string(r)
+	}
+	return value
+}
+
+// runOps applies n random Writer/Delete/Get/Flush/Iterate calls to s and
+// to a map standing in for the expected behavior, returning the first
+// difference. Keys come from a small space so they are often rewritten
+// and deleted.
+func runOps(s Store, rnd *rand.Rand, n int) error {
+	if mybolt, ok := s.(*Bolt); ok {
+		// flush every few operations as well as on demand
+		mybolt.BatchSize = 1 + rnd.Intn(8)
+		mybolt.UnsafeKeys = rnd.Intn(2) == 0
+	}
+	model := make(map[string][]string)
+	for op := 0; op < n; op++ {
+		key := strconv.Itoa(rnd.Intn(32))
+		switch r := rnd.Intn(100); {
+		case r < 40:
+			value := randomValue(rnd)
+			s.Writer(key, value)
+			model[key] = value
+		case r < 60:
+			s.Delete(key)
+			delete(model, key)
+		case r < 90:
+			got, err := s.Get(key)
+			want, ok := model[key]
+			if !ok {
+				if err != ErrNotFound {
+					return fmt.Errorf("op %d: get deleted %s = %q, %v", op, key, got, err)
+				}
+				continue
+			}
+			if err != nil || !SameValue(got, want) {
+				return fmt.Errorf("op %d: get %s = %q, %v, want %q", op, key, got, err, want)
+			}
+		case r < 95:
+			s.Flush()
+		default:
+			err := checkIterate(s, model)
+			if err != nil {
+				return fmt.Errorf("op %d: %s", op, err)
+			}
+		}
+	}
+	return checkIterate(s, model)
+}
+
+// checkIterate compares everything Iterate returns to model, and for bolt
+// that keys come in the order of their encoding.
+func checkIterate(s interface {
+	Iterate(fn func(key string, value []string) error) error
+}, model map[string][]string) error {
+	mybolt, ordered := s.(*Bolt)
+	seen := make(map[string]bool)
+	var last []byte
+	err := s.Iterate(func(key string, value []string) error {
+		if seen[key] {
+			return fmt.Errorf("iterate: %s twice", key)
+		}
+		seen[key] = true
+		want, ok := model[key]
+		if !ok {
+			return fmt.Errorf("iterate: deleted %s = %q", key, value)
+		}
+		if !SameValue(value, want) {
+			return fmt.Errorf("iterate: %s = %q, want %q", key, value, want)
+		}
+		if ordered {
+			k, err := mybolt.EncodeKey(key)
+			if err != nil {
+				return err
+			}
+			if last != nil && bytes.Compare(last, k) >= 0 {
+				return fmt.Errorf("iterate: %x after %x", k, last)
+			}
+			last = k
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(seen) != len(model) {
+		return fmt.Errorf("iterate: %d keys, want %d", len(seen), len(model))
+	}
+	return nil
+}
+
+func TestBackendsMatchModel(t *testing.T) {
+	for _, spec := range backendSpecs {
+		t.Run(spec, func(t *testing.T) {
+			dir := t.TempDir()
+			runs := 0
+			prop := func(seed int64) bool {
+				runs++
+				s := Open(spec, filepath.Join(dir, fmt.Sprintf("%d.db", runs)), testLayout)
+				defer Close(s)
+				err := runOps(s, rand.New(rand.NewSource(seed)), 300)
+				if err != nil {
+					t.Logf("seed %d: %s", seed, err)
+					return false
+				}
+				return true
+			}
+			err := quick.Check(prop, &quick.Config{MaxCount: 20})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// prefixOnly is a Map without namespaces of its own, keeping them the
+// way the optional backends do.
+type prefixOnly struct{ m *Map }
+
+func (p prefixOnly) Writer(key string, value []string) { p.m.Writer(key, value) }
+func (p prefixOnly) Flush()                            {}
+func (p prefixOnly) Delete(key string)                 { p.m.Delete(key) }
+func (p prefixOnly) Get(key string) ([]string, error)  { return p.m.Get(key) }
+func (p prefixOnly) Iterate(fn func(key string, value []string) error) error {
+	return skipNamespaces(p.m.Iterate, fn)
+}
+func (p prefixOnly) iterateAll(fn func(key string, value []string) error) error {
+	return p.m.Iterate(fn)
+}
+
+// TestNamespaces runs the model test in a namespace of each backend,
+// with the nodes and another namespace holding the same keys.
+func TestNamespaces(t *testing.T) {
+	specs := append([]string{"faulty/bolt", "prefixed"}, backendSpecs...)
+	for _, spec := range specs {
+		t.Run(spec, func(t *testing.T) {
+			var s Store
+			if spec == "prefixed" {
+				s = prefixOnly{NewMap()}
+			} else {
+				s = Open(spec, filepath.Join(t.TempDir(), "ns.db"), testLayout)
+				defer Close(s)
+			}
+			nodes := map[string][]string{"1": {"2"}, "2": {"1"}}
+			edges := map[string][]string{"1": {"edge"}, "3": {"only an edge"}}
+			for key, value := range nodes {
+				s.Writer(key, value)
+			}
+			for key, value := range edges {
+				In(s, EdgesSpace).Writer(key, value)
+			}
+			if err := runOps(In(s, LandmarksSpace), rand.New(rand.NewSource(1)), 300); err != nil {
+				t.Errorf("landmarks: %s", err)
+			}
+			s.Flush()
+			In(s, EdgesSpace).Flush()
+			if err := checkIterate(s, nodes); err != nil {
+				t.Errorf("nodes: %s", err)
+			}
+			if err := checkIterate(In(s, EdgesSpace), edges); err != nil {
+				t.Errorf("edges: %s", err)
+			}
+			if In(s, NodesSpace) != s {
+				t.Errorf("the nodes namespace isn't the store itself")
+			}
+		})
+	}
+}
+
+// TestBlocks runs the model test with values of more than two items
+// stored in blocks, so keys often move between blocks and whole values.
+func TestBlocks(t *testing.T) {
+	dir := t.TempDir()
+	for seed := int64(0); seed < 10; seed++ {
+		mybolt := WrapBolt(FreshFile(filepath.Join(dir, fmt.Sprintf("%d.db", seed))), FlatSchema, StringKeys, WithBlockSize(2))
+		mybolt.Codec = NewCodec("binary+crc")
+		err := runOps(mybolt, rand.New(rand.NewSource(seed)), 300)
+		if err != nil {
+			t.Fatalf("seed %d: %s", seed, err)
+		}
+		mybolt.Writer("hub", []string{"a", "b", "c", "d", "e"})
+		mybolt.Flush()
+		var items []string
+		err = mybolt.EachItem("hub", func(item string) error {
+			items = append(items, item)
+			return nil
+		})
+		if err != nil || !SameValue(items, []string{"a", "b", "c", "d", "e"}) {
+			t.Errorf("seed %d: EachItem gave %q, %v", seed, items, err)
+		}
+		if pageErrors, badValues, _, err := mybolt.Check(); err != nil || pageErrors+badValues > 0 {
+			t.Errorf("seed %d: check found %d page errors and %d bad values, %v", seed, pageErrors, badValues, err)
+		}
+		mybolt.Close()
+	}
+}
+
+func TestSplitEachItem(t *testing.T) {
+	mybolt := NewBolt(SplitSchema, Uint64Keys, WithPath(filepath.Join(t.TempDir(), "split.db")))
+	defer mybolt.Close()
+	mybolt.Writer("1", []string{"2", "3:0.5"})
+	mybolt.Writer("2", nil)
+	mybolt.Writer("3", []string{"1"})
+	mybolt.Flush()
+	for key, want := range map[string][]string{"1": {"2", "3:0.5"}, "2": nil, "3": {"1"}} {
+		var items []string
+		err := mybolt.EachItem(key, func(item string) error {
+			items = append(items, item)
+			return nil
+		})
+		if err != nil || !SameValue(items, want) {
+			t.Errorf("EachItem(%s) gave %q, %v, want %q", key, items, err, want)
+		}
+	}
+	if err := mybolt.EachItem("4", func(string) error { return nil }); err != ErrNotFound {
+		t.Errorf("EachItem of a missing node: %v, want ErrNotFound", err)
+	}
+}
+
+func TestGetInto(t *testing.T) {
+	for _, schema := range []string{FlatSchema, SplitSchema} {
+		mybolt := NewBolt(schema, Uint64Keys, WithPath(filepath.Join(t.TempDir(), "into.db")))
+		defer mybolt.Close()
+		mybolt.Writer("1", []string{"2", "3:0.5"})
+		mybolt.Writer("2", []string{"1"})
+		mybolt.Flush()
+		mybolt.Writer("3", []string{"1", "2"})
+		dst := make([]string, 0, 4)
+		for key, want := range map[string][]string{"1": {"2", "3:0.5"}, "2": {"1"}, "3": {"1", "2"}} {
+			err := mybolt.GetInto(key, &dst)
+			if err != nil || !SameValue(dst, want) {
+				t.Errorf("%s: GetInto(%s) gave %q, %v, want %q", schema, key, dst, err, want)
+			}
+			if cap(dst) != 4 {
+				t.Errorf("%s: GetInto(%s) didn't reuse dst", schema, key)
+			}
+		}
+		if err := mybolt.GetInto("4", &dst); err != ErrNotFound {
+			t.Errorf("%s: GetInto of a missing key: %v, want ErrNotFound", schema, err)
+		}
+	}
+
+	// a transaction's EachItem decodes a whole value into a slice it
+	// reuses, allocating no more than GetInto
+	mybolt := NewBolt(FlatSchema, Uint64Keys, WithPath(filepath.Join(t.TempDir(), "each.db")))
+	defer mybolt.Close()
+	mybolt.Writer("1", []string{"2", "3", "4", "5"})
+	mybolt.Flush()
+	txn, err := mybolt.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Close()
+	var dst []string
+	into := testing.AllocsPerRun(100, func() { txn.(IntoReader).GetInto("1", &dst) })
+	items := 0
+	count := func(string) error { items++; return nil }
+	lister := txn.(interface {
+		EachItem(key string, fn func(item string) error) error
+	})
+	each := testing.AllocsPerRun(100, func() { lister.EachItem("1", count) })
+	if items != 4*101 || each > into {
+		t.Errorf("EachItem visited %d items, %v allocations a read, GetInto %v", items, each, into)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	for _, spec := range []string{"map", "bolt/split/uint64/binary"} {
+		s := Open(spec, filepath.Join(dir, "snap.db"), testLayout)
+		rnd := rand.New(rand.NewSource(1))
+		model := make(map[string][]string)
+		for i := 0; i < 300; i++ {
+			key := strconv.Itoa(rnd.Intn(1000))
+			model[key] = randomValue(rnd)
+			s.Writer(key, model[key])
+		}
+		s.Writer("1000", nil)
+		model["1000"] = nil
+		s.Flush()
+		path := filepath.Join(dir, "graph.snap")
+		keys, _, err := WriteSnapshot(s, path)
+		Close(s)
+		if err != nil || keys != len(model) {
+			t.Fatalf("%s: wrote %d keys, %v, want %d", spec, keys, err, len(model))
+		}
+		snap, err := OpenSnapshot(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkIterate(snap, model); err != nil {
+			t.Errorf("%s: %v", spec, err)
+		}
+		for key, want := range model {
+			var items []string
+			err := snap.EachItem(key, func(item string) error {
+				items = append(items, item)
+				return nil
+			})
+			if err != nil || !SameValue(items, want) {
+				t.Errorf("%s: EachItem(%q) gave %q, %v, want %q", spec, key, items, err, want)
+			}
+		}
+		if _, err := snap.Get("1001"); err != ErrNotFound {
+			t.Errorf("%s: Get of a missing key: %v, want ErrNotFound", spec, err)
+		}
+		snap.Close()
+	}
+	if _, err := OpenSnapshot(filepath.Join(dir, "snap.db")); err == nil {
+		t.Error("opened a bolt file as a snapshot")
+	}
+}
+
+func TestReadTxn(t *testing.T) {
+	for _, spec := range append([]string{"faulty/bolt/split"}, backendSpecs...) {
+		t.Run(spec, func(t *testing.T) {
+			// mapped up front, bolt can't grow the file past an open
+			// transaction of the same goroutine
+			s := Open(spec, filepath.Join(t.TempDir(), "txn.db"), testLayout,
+				WithTuning(Tuning{InitialMmapSize: 1 << 20}))
+			defer Close(s)
+			s.Writer("1", []string{"x"})
+			s.Writer("2", []string{"y", "z"})
+			s.Flush()
+			txn, err := Begin(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Writer("1", []string{"w"})
+			s.Flush()
+			s.Writer("3", []string{"v"})
+			want := map[string][]string{"1": {"w"}, "2": {"y", "z"}, "3": {"v"}}
+			// the backends with transactions still see 1 as of Begin
+			if !strings.Contains(spec, "map") && !strings.HasPrefix(spec, "redis") {
+				want["1"] = []string{"x"}
+			}
+			for key, value := range want {
+				got, err := txn.Get(key)
+				if err != nil || !SameValue(got, value) {
+					t.Errorf("txn Get(%s) gave %q, %v, want %q", key, got, err, value)
+				}
+			}
+			if _, err := txn.Get("4"); err != ErrNotFound {
+				t.Errorf("txn Get of a missing key: %v, want ErrNotFound", err)
+			}
+			if err := txn.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := s.Get("1"); err != nil || !SameValue(got, []string{"w"}) {
+				t.Errorf("Get(1) after the txn gave %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestDropCache(t *testing.T) {
+	mybolt := NewBolt(FlatSchema, StringKeys, WithPath(filepath.Join(t.TempDir(), "my.db")))
+	defer mybolt.Close()
+	mybolt.Writer("key", []string{"a"})
+	mybolt.Flush()
+	fi, err := os.Stat(mybolt.Db.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bolt's mapping covers the file, or DropCache leaves pages mapped
+	if mapped := mybolt.mapped(); int64(len(mapped)) < fi.Size() {
+		t.Fatalf("mapped %d bytes of a %d byte file", len(mapped), fi.Size())
+	}
+	if runtime.GOOS != "linux" {
+		t.Skip("page cache control needs Linux")
+	}
+	if err := mybolt.DropCache(); err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := mybolt.Cached(); err != nil || cached < 0 || cached > 1 {
+		t.Errorf("cached %v, %v", cached, err)
+	}
+	if _, err := mybolt.Get("key"); err != nil {
+		t.Errorf("get after dropping the cache: %v", err)
+	}
+}
+
+func TestUnsafeKeys(t *testing.T) {
+	mybolt := WrapBolt(FreshFile(filepath.Join(t.TempDir(), "my.db")), FlatSchema, StringKeys)
+	defer mybolt.Close()
+	mybolt.Codec = NewCodec("binary")
+	mybolt.UnsafeKeys = true
+	// constant keys are in read-only memory, a write through one faults
+	mybolt.Writer("key", []string{"a"})
+	mybolt.Flush()
+	value, err := mybolt.Get("key")
+	if err != nil || !SameValue(value, []string{"a"}) {
+		t.Fatalf("get key = %q, %v", value, err)
+	}
+	key := strconv.Itoa(12345)
+	if n := testing.AllocsPerRun(100, func() { mybolt.EncodeKey(key) }); n != 0 {
+		t.Errorf("EncodeKey allocates %v times", n)
+	}
+	k, _ := mybolt.EncodeKey(key)
+	if n := testing.AllocsPerRun(100, func() { mybolt.decodeKeyTransient(k) }); n != 0 || mybolt.decodeKeyTransient(k) != key {
+		t.Errorf("decodeKeyTransient allocates %v times", n)
+	}
+}
+
+// The write-ahead log is read back after crashes, so a damaged frame
+// that still passes its checksum must not panic either.
+func FuzzWALBatch(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{1, 1, 'a', 2, 1, 'b'})
+	f.Add([]byte{1, 1, 'a', 0})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0x0f})
+	f.Add([]byte{1, 1, 'a', 0xff, 0xff, 0xff, 0xff, 0x0f})
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		decodeWALBatch(payload)
+	})
+}
+
+func TestRetry(t *testing.T) {
+	retry := Retry{Attempts: 3, Backoff: time.Microsecond, MaxBackoff: time.Millisecond}
+	errDown := errors.New("connection refused")
+	for _, c := range []struct {
+		failures, calls int
+		err             error
+	}{
+		{0, 1, nil},
+		{3, 4, nil},
+		{4, 4, errDown},
+	} {
+		calls := 0
+		err := retry.do("get", func() error {
+			calls++
+			if calls <= c.failures {
+				return errDown
+			}
+			return nil
+		})
+		if err != c.err || calls != c.calls {
+			t.Errorf("%d failures: %d calls, %v, want %d calls, %v", c.failures, calls, err, c.calls, c.err)
+		}
+	}
+
+	calls := 0
+	err := retry.do("get", func() error {
+		calls++
+		return ErrNotFound
+	})
+	if err != ErrNotFound || calls != 1 {
+		t.Errorf("a missing key was read %d times: %v", calls, err)
+	}
+	if err := (Retry{}).do("get", func() error { return errDown }); err != errDown {
+		t.Errorf("the zero Retry returned %v", err)
+	}
+
+	// every other commit fails
+	committed := NewMap()
+	commits := 0
+	w := newWriteBuffer([]Option{WithBatchSize(10), WithRetry(retry)}, func(puts map[string][]string, deletes map[string]bool) error {
+		if commits++; commits%2 == 1 {
+			return errDown
+		}
+		for key, value := range puts {
+			committed.Writer(key, value)
+		}
+		return nil
+	})
+	for i := 0; i < 100; i++ {
+		w.Writer(strconv.Itoa(i), []string{"x"})
+	}
+	w.Flush()
+	for i := 0; i < 100; i++ {
+		if _, err := committed.Get(strconv.Itoa(i)); err != nil {
+			t.Errorf("key %d: %v", i, err)
+		}
+	}
+}
+
+// TestConcurrentAccess runs writers, readers and iterators against each
+// backend at once. Each writer owns a range of keys, so it can check that
+// it reads back its own writes, while everyone else checks that whatever
+// they see was written by the key's owner. Run it with make race: bolt's
+// unsafe page casts trip the pointer checks -race turns on, so those
+// have to be off.
+func TestConcurrentAccess(t *testing.T) {
+	const writers, readers, iterators = 4, 4, 2
+	const keysPerWriter, ops = 50, 500
+	owner := func(key string, value []string) error {
+		i, err := strconv.Atoi(key)
+		if err != nil {
+			return err
+		}
+		if len(value) != 2 || value[0] != strconv.Itoa(i/keysPerWriter) {
+			return fmt.Errorf("%s = %q, not written by writer %d", key, value, i/keysPerWriter)
+		}
+		return nil
+	}
+	for _, spec := range backendSpecs {
+		t.Run(spec, func(t *testing.T) {
+			s := Open(spec, filepath.Join(t.TempDir(), "concurrent.db"), testLayout)
+			defer Close(s)
+			if mybolt, ok := s.(*Bolt); ok {
+				mybolt.BatchSize = 16
+			}
+
+			models := make([]map[string][]string, writers)
+			errs := make(chan error, writers+readers+iterators)
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				models[w] = make(map[string][]string)
+				wg.Add(1)
+				go func(w int, model map[string][]string) {
+					defer wg.Done()
+					rnd := rand.New(rand.NewSource(int64(w)))
+					for op := 0; op < ops; op++ {
+						key := strconv.Itoa(w*keysPerWriter + rnd.Intn(keysPerWriter))
+						if rnd.Intn(4) == 0 {
+							s.Delete(key)
+							delete(model, key)
+						} else {
+							value := []string{strconv.Itoa(w), strconv.Itoa(op)}
+							s.Writer(key, value)
+							model[key] = value
+						}
+						got, err := s.Get(key)
+						want, ok := model[key]
+						if (ok && (err != nil || !SameValue(got, want))) || (!ok && err != ErrNotFound) {
+							errs <- fmt.Errorf("writer %d: get %s = %q, %v, want %q", w, key, got, err, want)
+							return
+						}
+					}
+				}(w, models[w])
+			}
+			for r := 0; r < readers; r++ {
+				wg.Add(1)
+				go func(r int) {
+					defer wg.Done()
+					rnd := rand.New(rand.NewSource(int64(writers + r)))
+					for op := 0; op < ops; op++ {
+						key := strconv.Itoa(rnd.Intn(writers * keysPerWriter))
+						value, err := s.Get(key)
+						if err == ErrNotFound {
+							continue
+						}
+						if err == nil {
+							err = owner(key, value)
+						}
+						if err != nil {
+							errs <- fmt.Errorf("reader %d: %s", r, err)
+							return
+						}
+					}
+				}(r)
+			}
+			for i := 0; i < iterators; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for pass := 0; pass < 5; pass++ {
+						seen := make(map[string]bool)
+						err := s.Iterate(func(key string, value []string) error {
+							if seen[key] {
+								return fmt.Errorf("%s twice", key)
+							}
+							seen[key] = true
+							return owner(key, value)
+						})
+						if err != nil {
+							errs <- fmt.Errorf("iterator %d: %s", i, err)
+							return
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			all := make(map[string][]string)
+			for _, model := range models {
+				for key, value := range model {
+					all[key] = value
+				}
+			}
+			err := checkIterate(s, all)
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a, b := NewMap(), NewMap()
+	a.Writer("same", []string{"1"})
+	b.Writer("same", []string{"1"})
+	a.Writer("changed", []string{"1", "2"})
+	b.Writer("changed", []string{"2", "1"})
+	a.Writer("gone", nil)
+	b.Writer("new", []string{"3"})
+	got := map[string]string{}
+	onlyA, onlyB, differ, err := Diff(context.Background(), a, b, func(kind, key string, _, _ []string) {
+		got[key] = kind
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if onlyA != 1 || onlyB != 1 || differ != 1 {
+		t.Errorf("only in a %d, only in b %d, differ %d, want 1 each", onlyA, onlyB, differ)
+	}
+	want := map[string]string{"changed": "differs", "gone": "only in a", "new": "only in b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("found %v, want %v", got, want)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	from := Open("map", "", Layout{})
+	for i := 0; i < 500; i++ {
+		from.Writer(strconv.Itoa(i), []string{strconv.Itoa(i + 1), strconv.Itoa(i + 2)})
+	}
+	to := Open("bolt/split/uint64/binary", filepath.Join(t.TempDir(), "to.db"), Layout{Schema: FlatSchema, Keys: StringKeys, Codec: "json"})
+	defer Close(to)
+	keys, err := Copy(context.Background(), from, to, 500)
+	if err != nil || keys != 500 {
+		t.Fatalf("copied %d keys, %v", keys, err)
+	}
+	if b := to.(*Bolt); b.Schema() != SplitSchema || b.Keys() != Uint64Keys {
+		t.Errorf("laid out %s/%s, want the spec's", b.Schema(), b.Keys())
+	}
+	onlyA, onlyB, differ, err := Diff(context.Background(), from, to, func(kind, key string, a, b []string) {})
+	if err != nil || onlyA+onlyB+differ > 0 {
+		t.Errorf("copy differs: %d only in a, %d only in b, %d differ, %v", onlyA, onlyB, differ, err)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	m := NewMap()
+	m.Writer("1", []string{"2"})
+	failures := func(f Faults) []int {
+		faulty := NewFaulty(m, f)
+		var failed []int
+		for i := 0; i < 1000; i++ {
+			if _, err := faulty.Get("1"); errors.Is(err, ErrInjected) {
+				failed = append(failed, i)
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return failed
+	}
+	f, err := ParseFaults("error=0.1,seed=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := failures(f), failures(f)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("the same seed failed different Gets")
+	}
+	if len(a) < 50 || len(a) > 150 {
+		t.Errorf("%d of 1000 Gets failed at a 0.1 error rate", len(a))
+	}
+	if _, err := ParseFaults("spike=0.1"); err == nil {
+		t.Error("spike without a duration accepted")
+	}
+
+	path := filepath.Join(t.TempDir(), "wal")
+	w := OpenWAL(path)
+	defer w.f.Close()
+	if err := w.append([]Entry{{name: "1", value: []string{"2"}}}); err != nil {
+		t.Fatal(err)
+	}
+	w.Inject(Faults{Seed: 1, ShortWriteRate: 1})
+	if err := w.append([]Entry{{name: "2", value: []string{"1"}}}); err != io.ErrShortWrite {
+		t.Fatalf("append through a short write: %v, want io.ErrShortWrite", err)
+	}
+	batches, err := ReplayWAL(path, func([]Entry) {})
+	if err != nil || batches != 1 {
+		t.Errorf("replayed %d batches (%v) of a log torn after the first", batches, err)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	var changed []string
+	n := &Notifier{Store: NewMap(), OnChange: []func(string){func(key string) { changed = append(changed, key) }}}
+	n.Writer("a", []string{"1"})
+	n.Writer("b", nil)
+	n.Delete("a")
+	if want := []string{"a", "b", "a"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("notified %v, want %v", changed, want)
+	}
+	if _, err := n.Get("a"); err != ErrNotFound {
+		t.Errorf("deleted key: %v, want ErrNotFound", err)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	s := NewSpill(t.TempDir(), 2)
+	defer s.Close()
+	s.Add("a", "")
+	for i, id := range []string{"b", "c", "d", "e"} {
+		s.Add(id, string(rune('a'+i)))
+	}
+	if s.Spilled() != 4 {
+		t.Errorf("spilled %d, want 4", s.Spilled())
+	}
+	for id, want := range map[string]string{"a": "", "b": "a", "e": "d"} {
+		if from, ok, err := s.Get(id); err != nil || !ok || from != want {
+			t.Errorf("Get(%s) = %q, %v, %v, want %q", id, from, ok, err, want)
+		}
+	}
+	if _, ok, err := s.Get("z"); err != nil || ok {
+		t.Errorf("Get of a node never added: %v, %v", ok, err)
+	}
+}
+
+func TestShardPaths(t *testing.T) {
+	for _, tt := range []struct {
+		spec, path string
+		inner      string
+		paths      []string
+	}{
+		{"shards/2", "my.db", "bolt", []string{"my.db.0", "my.db.1"}},
+		{"shards/bolt/split", "a/my.db,b/my.db", "bolt/split", []string{"a/my.db", "b/my.db"}},
+		{"shards/2/map", "a,b", "map", []string{"a", "b"}},
+	} {
+		n, inner := parseShards(tt.spec)
+		paths := ShardPaths(tt.path, n)
+		if inner != tt.inner || !reflect.DeepEqual(paths, tt.paths) {
+			t.Errorf("%s at %s: got %s at %v, want %s at %v", tt.spec, tt.path, inner, paths, tt.inner, tt.paths)
+		}
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upgrade.db")
+	b := NewBolt(FlatSchema, StringKeys, WithPath(path), WithBatchSize(7))
+	want := map[string][]string{}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		want[key] = []string{strconv.Itoa(i + 1), "x"}
+		b.Writer(key, want[key])
+	}
+	b.Flush()
+	b.WriteMetadata("json", "repeat", len(want))
+	b.WriteCoords(XY, map[string][2]float64{"42": {1, 2}})
+	// as written before format version 2
+	m, _ := ReadMetadata(b.Db)
+	m.Version = 1
+	if err := putMetadata(b.Db, m); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m, err := Upgrade(ctx, b.Db, "bolt/split/uint64/binary", WithBatchSize(7))
+	if err != context.Canceled || m.Upgrade == nil || m.Version != FormatVersion {
+		t.Fatalf("cancelled upgrade: %v, %+v", err, m)
+	}
+	// finishes the one in progress, then the spec has nothing left to do
+	m, err = Upgrade(context.Background(), b.Db, "bolt/binary", WithBatchSize(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Upgrade != nil || m.Layout() != (Layout{SplitSchema, Uint64Keys, "binary"}) {
+		t.Fatalf("upgraded to %+v", m)
+	}
+	if stored, _ := ReadMetadata(b.Db); !reflect.DeepEqual(stored, m) {
+		t.Errorf("stored metadata %+v, want %+v", stored, m)
+	}
+
+	u := WrapBolt(b.Db, m.Schema, m.Keys)
+	u.Codec = NewCodec(m.Codec)
+	if err := checkIterate(u, want); err != nil {
+		t.Error(err)
+	}
+	err = b.Db.View(func(tx *bolt.Tx) error {
+		for _, name := range upgradedBuckets {
+			if tx.Bucket(stagingBucket(name)) != nil {
+				t.Errorf("staging bucket %s left behind", stagingBucket(name))
+			}
+		}
+		if tx.Bucket(Bucket).Stats().KeyN != 0 {
+			t.Error("flat bucket not emptied")
+		}
+		if tx.Bucket(CoordsBucket).Get(u.IntKey(42)) == nil {
+			t.Error("coords key not re-encoded")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// and back
+	m, err = Upgrade(context.Background(), b.Db, "bolt/flat/string/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkIterate(b, want); err != nil {
+		t.Error(err)
+	}
+	b.Writer("x", nil)
+	b.Flush()
+	m, err = Upgrade(context.Background(), b.Db, "bolt/uint64")
+	if err == nil || m.Upgrade != nil {
+		t.Errorf("upgraded a non-numeric key to uint64: %v, %+v", err, m)
+	}
+	b.Close()
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	b := NewBolt(SplitSchema, Uint64Keys, WithPath(filepath.Join(dir, "src.db")), WithBatchSize(100))
+	for i := 0; i < 5000; i++ {
+		b.Writer(strconv.Itoa(i), []string{strconv.Itoa(i + 1), strconv.Itoa(i + 2)})
+	}
+	b.Flush()
+	for i := 0; i < 5000; i += 2 {
+		b.Delete(strconv.Itoa(i))
+	}
+	b.Flush()
+	b.WriteMetadata("json", "repeat", 2500)
+
+	dst, err := bolt.Open(filepath.Join(dir, "dst.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if err := Compact(context.Background(), dst, b.Db, 4096); err != nil {
+		t.Fatal(err)
+	}
+	c := WrapBolt(dst, SplitSchema, Uint64Keys)
+	onlyA, onlyB, differ, err := Diff(context.Background(), b, c, func(kind, key string, a, b []string) {})
+	if err != nil || onlyA+onlyB+differ > 0 {
+		t.Errorf("compacted copy differs: %d only in a, %d only in b, %d differ, %v", onlyA, onlyB, differ, err)
+	}
+	if m, err := ReadMetadata(dst); err != nil || m.Size != 2500 {
+		t.Errorf("metadata %+v, %v", m, err)
+	}
+	var before, after int64
+	b.Db.View(func(tx *bolt.Tx) error { before = tx.Size(); return nil })
+	dst.View(func(tx *bolt.Tx) error { after = tx.Size(); return nil })
+	if after >= before {
+		t.Errorf("compacted to %d bytes from %d", after, before)
+	}
+	b.Close()
+}
+
+func TestCoords(t *testing.T) {
+	dir := t.TempDir()
+	var shards []Store
+	for i := 0; i < 2; i++ {
+		shards = append(shards, NewBolt(SplitSchema, Uint64Keys, WithPath(filepath.Join(dir, strconv.Itoa(i)+".db")), WithBatchSize(3)))
+	}
+	s := NewSharded(shards...)
+	defer s.Close()
+	if kind, coords, err := s.ReadCoords(); kind != "" || coords != nil || err != nil {
+		t.Fatalf("no coordinates read as %q, %v, %v", kind, coords, err)
+	}
+	want := map[string][2]float64{}
+	for i := 0; i < 10; i++ {
+		want[strconv.Itoa(i)] = [2]float64{float64(i % 4), -float64(i) / 3}
+	}
+	s.WriteCoords(XY, want)
+	kind, coords, err := s.ReadCoords()
+	if err != nil || kind != XY || !reflect.DeepEqual(coords, want) {
+		t.Errorf("read %q, %v, %v, want %v", kind, coords, err, want)
+	}
+	// metadata written after the coordinates keeps their kind
+	b := shards[0].(*Bolt)
+	b.WriteMetadata("json", "import", 5)
+	if kind, _, _ := b.ReadCoords(); kind != XY {
+		t.Errorf("kind %q after WriteMetadata", kind)
+	}
+	b.WriteCoords(LatLon, map[string][2]float64{"0": {1, 2}})
+	if _, _, err := s.ReadCoords(); err == nil {
+		t.Error("shards of different kinds merged")
+	}
+}
+
+func TestDistances(t *testing.T) {
+	b := NewBolt(FlatSchema, Uint64Keys, WithPath(filepath.Join(t.TempDir(), "distances.db")), WithBatchSize(2))
+	defer b.Close()
+	if dist, err := b.ReadDistances("1"); err != nil || len(dist) != 0 {
+		t.Errorf("before any were written: %v, %v", dist, err)
+	}
+	b.WriteDistances("1", map[string]float64{"1": 0, "2": 1.5, "3": 4, "10": 7})
+	b.WriteDistances("10", map[string]float64{"10": 0})
+	b.WriteDistances("1", map[string]float64{"1": 0, "2": 2.5})
+	for source, want := range map[string]map[string]float64{
+		"1":  {"1": 0, "2": 2.5},
+		"10": {"10": 0},
+	} {
+		if dist, err := b.ReadDistances(source); err != nil || !reflect.DeepEqual(dist, want) {
+			t.Errorf("from %s: %v, %v, want %v", source, dist, err, want)
+		}
+	}
+}
+
+func TestWriteLimit(t *testing.T) {
+	// on a clock only the waits move
+	l := newLimiter(WriteLimit{KeysPerSec: 1000, BytesPerSec: 1 << 20})
+	clock := time.Unix(0, 0)
+	l.now = func() time.Time { return clock }
+	l.sleep = func(d time.Duration) { clock = clock.Add(d) }
+	for i := 0; i < 300; i++ {
+		l.wait(10)
+	}
+	// the first 100ms worth without waiting, then 200 keys at 1000/s
+	s := l.read()
+	if s.Keys != 300 || s.Bytes != 3000 || s.BurstKeys != 100 || s.BurstBytes != 1000 || s.Burst != 0 ||
+		s.Took < 199*time.Millisecond || s.Took > 201*time.Millisecond || s.Waited < 199*time.Millisecond {
+		t.Errorf("limit stats %+v", s)
+	}
+	if rate := s.KeysPerSec(); rate < 990 || rate > 1010 {
+		t.Errorf("%.0f keys/s after the burst at a limit of 1000/s, stats %+v", rate, s)
+	}
+	if rate := s.BytesPerSec(); rate < 9900 || rate > 10100 {
+		t.Errorf("%.0f bytes/s after the burst, stats %+v", rate, s)
+	}
+	// no time after the burst
+	for _, c := range []struct {
+		s    LimitStats
+		rate float64
+	}{
+		{LimitStats{Keys: 10}, 0},
+		{LimitStats{Keys: 10, Took: time.Second}, 10},
+		{LimitStats{Keys: 10, BurstKeys: 10, Took: time.Second, Burst: time.Second}, 10},
+	} {
+		if rate := c.s.KeysPerSec(); rate != c.rate {
+			t.Errorf("%+v: %v keys/s, want %v", c.s, rate, c.rate)
+		}
+	}
+
+	b := NewBolt(FlatSchema, StringKeys, WithPath(filepath.Join(t.TempDir(), "limit.db")),
+		WithWriteLimit(WriteLimit{KeysPerSec: 1000, BytesPerSec: 1 << 20}))
+	defer b.Close()
+	for i := 0; i < 150; i++ {
+		b.Writer(strconv.Itoa(i), []string{"ab"})
+	}
+	if s, ok := b.WriteLimited(); !ok || s.Keys != 150 || s.Bytes != 340+300 || s.Waited == 0 {
+		t.Errorf("bolt's limit stats %+v", s)
+	}
+	free := NewBolt(FlatSchema, StringKeys, WithPath(filepath.Join(t.TempDir(), "free.db")))
+	defer free.Close()
+	if _, ok := free.WriteLimited(); ok {
+		t.Error("stats without a limit")
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	b := NewBolt(FlatSchema, StringKeys, WithPath(filepath.Join(t.TempDir(), "dups.db")),
+		WithBatchSize(100), WithDuplicates(Merge))
+	defer b.Close()
+	get := func(key string) []string {
+		t.Helper()
+		value, err := b.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	// merged in the buffer
+	b.Writer("a", []string{"1", "2"})
+	b.Writer("a", []string{"2", "3"})
+	if value := get("a"); !reflect.DeepEqual(value, []string{"1", "2", "3"}) {
+		t.Errorf("buffered a = %v", value)
+	}
+	b.Flush()
+	// and with what was flushed
+	b.Writer("a", []string{"4", "1"})
+	b.Flush()
+	if value := get("a"); !reflect.DeepEqual(value, []string{"1", "2", "3", "4"}) {
+		t.Errorf("flushed a = %v", value)
+	}
+	// neither a write after a Delete nor a rewrite is a duplicate
+	b.Delete("a")
+	b.Writer("a", []string{"5"})
+	b.Rewrite("a", []string{"5", "6"})
+	b.Flush()
+	b.Rewrite("a", []string{"7"})
+	b.Flush()
+	if value := get("a"); !reflect.DeepEqual(value, []string{"7"}) {
+		t.Errorf("rewritten a = %v", value)
+	}
+	if n := b.Duplicates(); n != 2 {
+		t.Errorf("%d duplicates, want 2", n)
+	}
+	over := NewBolt(FlatSchema, StringKeys, WithPath(filepath.Join(t.TempDir(), "over.db")))
+	defer over.Close()
+	over.Writer("a", []string{"1"})
+	over.Writer("a", []string{"2"})
+	over.Flush()
+	if value, _ := over.Get("a"); !reflect.DeepEqual(value, []string{"2"}) || over.Duplicates() != 0 {
+		t.Errorf("overwritten a = %v, %d duplicates", value, over.Duplicates())
+	}
+}
+
+func TestPages(t *testing.T) {
+	b := NewBolt(FlatSchema, Uint64Keys, WithPath(filepath.Join(t.TempDir(), "pages.db")))
+	defer b.Close()
+	value := []string{strings.Repeat("x", 1000)}
+	for i := 0; i < 100; i++ {
+		b.Writer(strconv.Itoa(i), value)
+	}
+	b.Flush()
+	pages, err := b.Pages()
+	if err != nil || len(pages) != 100 {
+		t.Fatalf("pages of %d keys, %v", len(pages), err)
+	}
+	// a few keys a page, each page a run of them in key order
+	seen := map[int]bool{pages["0"]: true}
+	for i := 1; i < 100; i++ {
+		page := pages[strconv.Itoa(i)]
+		if page != pages[strconv.Itoa(i-1)] && seen[page] {
+			t.Errorf("key %d back on page %d", i, page)
+		}
+		seen[page] = true
+	}
+	if len(seen) < 25 {
+		t.Errorf("100 values of 1000 bytes on %d pages", len(seen))
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.db")
+	b := NewBolt(FlatSchema, Uint64Keys, WithPath(path), WithBatchSize(9), WithSyncPolicy(3))
+	// 7 flushes of 10 keys, checkpoints after the third and the sixth
+	for i := 0; i < 70; i++ {
+		b.Writer(strconv.Itoa(i), []string{"x"})
+	}
+	c, ok, err := ReadCheckpoint(b.Db)
+	want := Checkpoint{Flushes: 6, Keys: 60, Batches: 3, Unsynced: 30, First: "30", Last: "59"}
+	c.Time = time.Time{}
+	if err != nil || !ok || c != want {
+		t.Errorf("checkpoint %+v, %t, %v, want %+v", c, ok, err, want)
+	}
+	if s := b.Checkpoints(); s.Checkpoints != 2 || s.MaxUnsynced != 30 {
+		t.Errorf("checkpoint stats %+v", s)
+	}
+	// Close checkpoints the last flush
+	b.Close()
+	db := OpenFile(path)
+	defer db.Close()
+	c, ok, err = ReadCheckpoint(db)
+	if err != nil || !ok || c.Flushes != 7 || c.Batches != 1 || c.First != "60" || c.Last != "69" {
+		t.Errorf("checkpoint at close %+v, %t, %v", c, ok, err)
+	}
+}
+
+func TestScanPrefix(t *testing.T) {
+	for _, keys := range []string{StringKeys, Uint64Keys} {
+		b := NewBolt(SplitSchema, keys, WithPath(filepath.Join(t.TempDir(), keys+".db")))
+		for i := 0; i < 30; i++ {
+			b.Writer(strconv.Itoa(i), []string{strconv.Itoa(i + 1)})
+		}
+		b.Flush()
+		var got []string
+		err := b.ScanPrefix("1", 5, func(key string, value []string) error {
+			if !reflect.DeepEqual(value, []string{strconv.Itoa(mustAtoi(t, key) + 1)}) {
+				t.Errorf("%s: %s = %v", keys, key, value)
+			}
+			got = append(got, key)
+			return nil
+		})
+		// text order from the prefix on, numeric order from the key on
+		want := map[string][]string{StringKeys: {"1", "10", "11", "12", "13"}, Uint64Keys: {"1", "2", "3", "4", "5"}}[keys]
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: scanned %v, %v, want %v", keys, got, err, want)
+		}
+		n := 0
+		b.ScanPrefix("2", 0, func(string, []string) error { n++; return nil })
+		if want := map[string]int{StringKeys: 11, Uint64Keys: 28}[keys]; n != want {
+			t.Errorf("%s: %d keys from 2 on, want %d", keys, n, want)
+		}
+		b.Close()
+	}
+}
+
+func mustAtoi(t *testing.T, s string) int {
+	t.Helper()
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// keyBytes is key's bytes without a copy. Nothing may write to them,
+// strings are immutable and constant ones live in read-only memory.
+func keyBytes(key string) []byte {
+	return unsafe.Slice(unsafe.StringData(key), len(key))
+}
+
+// keyString is k as a string without a copy. It is only valid while k
+// is, for bolt's keys until the transaction ends, and only as long as
+// nothing writes to k.
+func keyString(k []byte) string {
+	return unsafe.String(unsafe.SliceData(k), len(k))
+}
+
+// mapped is bolt's read-only mapping of the file, which it doesn't
+// export. It is only valid until a write makes bolt remap the file.
+func (mybolt *Bolt) mapped() []byte {
+	ref := reflect.ValueOf(mybolt.Db).Elem().FieldByName("dataref")
+	if !ref.IsValid() || ref.Len() == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(ref.UnsafePointer()), ref.Len())
+}
+
+// offset is where b starts in mapped, or -1 if it isn't in it.
+func offset(mapped, b []byte) int {
+	if len(mapped) == 0 || len(b) == 0 {
+		return -1
+	}
+	d := uintptr(unsafe.Pointer(unsafe.SliceData(b))) - uintptr(unsafe.Pointer(unsafe.SliceData(mapped)))
+	if d >= uintptr(len(mapped)) {
+		return -1
+	}
+	return int(d)
+}
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// formatUpgrades[v] brings a file from format version v to v+1.
+var formatUpgrades = map[int]func(db *bolt.DB) error{
+	// 2 only adds Metadata.Upgrade, which 1 files never have
+	1: func(db *bolt.DB) error { return nil },
+}
+
+// stagingBucket is where an Upgrade writes name's keys in the new layout
+// before moving them back.
+func stagingBucket(name []byte) []byte {
+	return append(append([]byte(nil), name...), ".upgrade"...)
+}
+
+// the buckets an Upgrade rewrites, the rest are left as they are
+var upgradedBuckets = [][]byte{Bucket, NodesBucket, EdgesBucket, CoordsBucket}
+
+var errBatchFull = errors.New("batch full")
+
+// Upgrade rewrites db in place, without a second copy of the file, to
+// FormatVersion and to the layout of the bolt spec, e.g. bolt/uint64
+// changes string keys to uint64 ones and bolt/binary re-encodes values
+// with the binary codec. What spec leaves out stays as it is.
+//
+// Keys move a batch at a time, every commit synced, into staging buckets
+// laid out the new way and then back, with the progress recorded in the
+// metadata. An Upgrade stopped by ctx, or a crash, leaves the file for
+// the next Upgrade to finish, with any spec, and nothing else to open.
+func Upgrade(ctx context.Context, db *bolt.DB, spec string, opts ...Option) (Metadata, error) {
+	m, err := ReadMetadata(db)
+	if err != nil {
+		return m, err
+	}
+	if m.Version > FormatVersion {
+		return m, fmt.Errorf("format version %d is newer than this binary's %d", m.Version, FormatVersion)
+	}
+	for m.Version < FormatVersion {
+		err = formatUpgrades[m.Version](db)
+		if err != nil {
+			return m, fmt.Errorf("upgrade from format version %d: %w", m.Version, err)
+		}
+		m.Version++
+		err = putMetadata(db, m)
+		if err != nil {
+			return m, err
+		}
+		slog.Info("upgraded format", "version", m.Version)
+	}
+
+	resumed := m.Upgrade != nil
+	if !resumed {
+		to := ParseLayout(spec, m.Layout())
+		if to == m.Layout() {
+			return m, nil
+		}
+		err = checkUpgrade(db, m.Layout(), to)
+		if err != nil {
+			return m, err
+		}
+		m.Upgrade = &Upgrading{Schema: to.Schema, Keys: to.Keys, Codec: to.Codec}
+		err = db.Update(func(tx *bolt.Tx) error {
+			for _, name := range upgradedBuckets {
+				if _, err := tx.CreateBucketIfNotExists(stagingBucket(name)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return m, err
+		}
+		err = putMetadata(db, m)
+		if err != nil {
+			return m, err
+		}
+	} else {
+		slog.Info("resuming upgrade", "schema", m.Upgrade.Schema, "keys", m.Upgrade.Keys, "codec", m.Upgrade.Codec,
+			"staged", m.Upgrade.Staged)
+	}
+
+	// every commit is synced, a crash may lose the last batch but
+	// leaves the file whole. Blocks aren't staged, values move whole.
+	opts = append(opts, WithSyncPolicy(SyncEveryFlush), WithBlockSize(0))
+	from := WrapBolt(db, m.Schema, m.Keys, opts...)
+	from.Codec = NewCodec(m.Codec)
+	to := WrapBolt(db, m.Upgrade.Schema, m.Upgrade.Keys, opts...)
+	to.Codec = NewCodec(m.Upgrade.Codec)
+	to.staging = true
+
+	start := time.Now()
+	if !m.Upgrade.Staged {
+		err = stage(ctx, from, to, m.Size)
+		if err != nil {
+			return m, err
+		}
+		m.Upgrade.Staged = true
+		err = putMetadata(db, m)
+		if err != nil {
+			return m, err
+		}
+	}
+	err = unstage(ctx, from)
+	if err != nil {
+		return m, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range upgradedBuckets {
+			err := tx.DeleteBucket(stagingBucket(name))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return m, err
+	}
+	m.Schema, m.Keys, m.Codec = m.Upgrade.Schema, m.Upgrade.Keys, m.Upgrade.Codec
+	m.Upgrade = nil
+	err = putMetadata(db, m)
+	if err != nil {
+		return m, err
+	}
+	slog.Info("upgraded layout", "schema", m.Schema, "keys", m.Keys, "codec", m.Codec, "took", time.Since(start))
+	if resumed {
+		// now on to what spec asks for
+		return Upgrade(ctx, db, spec, opts...)
+	}
+	return m, nil
+}
+
+// checkUpgrade makes sure every key fits the new encoding before any is
+// moved, an Upgrade stuck half way couldn't be undone.
+func checkUpgrade(db *bolt.DB, from, to Layout) error {
+	if from.Keys == to.Keys || to.Keys != Uint64Keys {
+		return nil
+	}
+	name := Bucket
+	if from.Schema == SplitSchema {
+		name = NodesBucket
+	}
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(name).ForEach(func(k, v []byte) error {
+			if _, err := strconv.ParseUint(string(k), 10, 64); err != nil {
+				return fmt.Errorf("can't use uint64 keys: key %q is not a uint64", k)
+			}
+			return nil
+		})
+	})
+}
+
+// stage moves every key of from into to's staging buckets a batch at a
+// time. Each batch is written before it is deleted, so one interrupted in
+// between is simply moved again.
+func stage(ctx context.Context, from, to *Bolt, size int) error {
+	p := run.NewProgress("upgrade", size)
+	moved := 0
+	for !run.Done(ctx) {
+		var puts, deletes []Entry
+		err := from.Db.View(func(tx *bolt.Tx) error {
+			return from.Scan(tx, func(k []byte, value []string) error {
+				name := from.DecodeKey(k)
+				entry, err := to.Encode(name, value)
+				if err != nil {
+					return err
+				}
+				puts = append(puts, entry)
+				deletes = append(deletes, Entry{name: name, key: bytes.Clone(k), deleted: true})
+				if len(puts) == from.BatchSize {
+					return errBatchFull
+				}
+				return nil
+			})
+		})
+		if err != nil && err != errBatchFull {
+			return err
+		}
+		if len(puts) == 0 {
+			return moveCoords(ctx, from, to)
+		}
+		to.Commit(puts)
+		from.Commit(deletes)
+		moved += len(puts)
+		p.Update(moved)
+	}
+	return ctx.Err()
+}
+
+// moveCoords re-encodes the keys of CoordsBucket into its staging bucket.
+func moveCoords(ctx context.Context, from, to *Bolt) error {
+	for !run.Done(ctx) {
+		n := 0
+		err := from.Db.Update(func(tx *bolt.Tx) error {
+			src := tx.Bucket(CoordsBucket)
+			dst := to.bucket(tx, CoordsBucket)
+			var keys [][]byte
+			c := src.Cursor()
+			for k, v := c.First(); k != nil && len(keys) < from.BatchSize; k, v = c.Next() {
+				nk, err := to.EncodeKey(from.decodeKeyTransient(k))
+				if err != nil {
+					return err
+				}
+				if err := dst.Put(nk, bytes.Clone(v)); err != nil {
+					return err
+				}
+				keys = append(keys, bytes.Clone(k))
+			}
+			n = len(keys)
+			for _, k := range keys {
+				if err := src.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil || n == 0 {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// unstage moves the staging buckets back to the buckets of to they stand
+// in for, which stage left empty, a batch per transaction.
+func unstage(ctx context.Context, to *Bolt) error {
+	batch := to.BatchSize
+	for _, name := range upgradedBuckets {
+		for n := batch; n == batch; {
+			if run.Done(ctx) {
+				return ctx.Err()
+			}
+			n = 0
+			err := to.Db.Update(func(tx *bolt.Tx) error {
+				src := tx.Bucket(stagingBucket(name))
+				if src == nil {
+					// already dropped by an Upgrade that stopped after
+					return nil
+				}
+				dst := to.bucket(tx, name)
+				var keys [][]byte
+				c := src.Cursor()
+				for k, v := c.First(); k != nil && len(keys) < batch; k, v = c.Next() {
+					if err := dst.Put(bytes.Clone(k), bytes.Clone(v)); err != nil {
+						return err
+					}
+					keys = append(keys, bytes.Clone(k))
+				}
+				n = len(keys)
+				for _, k := range keys {
+					if err := src.Delete(k); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,351 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"strconv"
+	"sync"
+)
+
+// Buffers reused by the read path. Pointers, so Put doesn't allocate.
+var (
+	keyPool   = sync.Pool{New: func() interface{} { return new([]byte) }}
+	ValuePool = sync.Pool{New: func() interface{} { return new([]string) }}
+)
+
+var ErrRawSplit = errors.New("raw values need the flat schema")
+
+// RawView is a read transaction handing out values as stored, without
+// copying or decoding them.
+type RawView struct {
+	mybolt *Bolt
+	tx     *bolt.Tx
+	// looked up once, tx.Bucket allocates
+	b *bolt.Bucket
+}
+
+// View calls fn with a read transaction. Slices returned by the view are
+// owned by bolt and only valid until fn returns.
+func (mybolt *Bolt) View(fn func(v *RawView) error) error {
+	return mybolt.Db.View(func(tx *bolt.Tx) error {
+		return fn(&RawView{mybolt, tx, tx.Bucket(Bucket)})
+	})
+}
+
+// Get returns the stored bytes of key, only valid within the View.
+func (v *RawView) Get(key string) ([]byte, error) {
+	k, err := v.mybolt.EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return v.GetKey(k)
+}
+
+// GetKey is Get for an already encoded key.
+func (v *RawView) GetKey(k []byte) ([]byte, error) {
+	if v.mybolt.schema == SplitSchema {
+		return nil, ErrRawSplit
+	}
+	data := v.b.Get(k)
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	if len(data) == 0 {
+		return nil, ErrRawBlocks
+	}
+	return data, nil
+}
+
+// Get reads key in its own transaction, or from the writes not flushed
+// yet.
+func (mybolt *Bolt) Get(key string) (value []string, err error) {
+	mybolt.mu.Lock()
+	value, buffered := mybolt.buffer[key]
+	deleted := mybolt.deletes[key]
+	mybolt.mu.Unlock()
+	if buffered {
+		return value, nil
+	}
+	if deleted {
+		return nil, ErrNotFound
+	}
+	buf := keyPool.Get().(*[]byte)
+	defer keyPool.Put(buf)
+	*buf, err = mybolt.AppendKey((*buf)[:0], key)
+	if err != nil {
+		return nil, err
+	}
+	err = mybolt.Db.View(func(tx *bolt.Tx) error {
+		value, err = mybolt.GetKey(tx, *buf)
+		return err
+	})
+	return value, err
+}
+
+// GetInto is Get decoding into *dst, see IntoReader.
+func (mybolt *Bolt) GetInto(key string, dst *[]string) (err error) {
+	mybolt.mu.Lock()
+	value, buffered := mybolt.buffer[key]
+	deleted := mybolt.deletes[key]
+	if buffered {
+		*dst = append((*dst)[:0], value...)
+	}
+	mybolt.mu.Unlock()
+	if buffered {
+		return nil
+	}
+	if deleted {
+		return ErrNotFound
+	}
+	buf := keyPool.Get().(*[]byte)
+	defer keyPool.Put(buf)
+	*buf, err = mybolt.AppendKey((*buf)[:0], key)
+	if err != nil {
+		return err
+	}
+	return mybolt.Db.View(func(tx *bolt.Tx) error {
+		value, err := mybolt.GetKeyInto(tx, *buf, *dst)
+		if err == nil {
+			*dst = value
+		}
+		return err
+	})
+}
+
+// Begin begins a read transaction the returned ReadTxn's Gets, GetIntos
+// and EachItems all share, see ReadTxn.
+func (mybolt *Bolt) Begin() (ReadTxn, error) {
+	tx, err := mybolt.Db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &boltTxn{mybolt: mybolt, tx: tx}, nil
+}
+
+// boltTxn is Bolt's ReadTxn.
+type boltTxn struct {
+	mybolt *Bolt
+	tx     *bolt.Tx
+	// the encoded key, reused from one read to the next
+	k []byte
+	// the items EachItem decodes a whole value into, reused likewise
+	items []string
+}
+
+// pending is key's value among the writes not flushed yet, ok if it is
+// one of them, ErrNotFound if it is deleted.
+func (mybolt *Bolt) pending(key string) (value []string, ok bool, err error) {
+	mybolt.mu.Lock()
+	defer mybolt.mu.Unlock()
+	if value, ok := mybolt.buffer[key]; ok {
+		return value, true, nil
+	}
+	if mybolt.deletes[key] {
+		return nil, true, ErrNotFound
+	}
+	return nil, false, nil
+}
+
+func (t *boltTxn) Get(key string) (value []string, err error) {
+	if value, ok, err := t.mybolt.pending(key); ok {
+		return value, err
+	}
+	t.k, err = t.mybolt.AppendKey(t.k[:0], key)
+	if err != nil {
+		return nil, err
+	}
+	return t.mybolt.GetKey(t.tx, t.k)
+}
+
+// GetInto is Get decoding into *dst, see IntoReader.
+func (t *boltTxn) GetInto(key string, dst *[]string) (err error) {
+	if value, ok, err := t.mybolt.pending(key); ok {
+		*dst = append((*dst)[:0], value...)
+		return err
+	}
+	t.k, err = t.mybolt.AppendKey(t.k[:0], key)
+	if err != nil {
+		return err
+	}
+	value, err := t.mybolt.GetKeyInto(t.tx, t.k, *dst)
+	if err == nil {
+		*dst = value
+	}
+	return err
+}
+
+// EachItem is Bolt.EachItem within the transaction.
+func (t *boltTxn) EachItem(key string, fn func(item string) error) (err error) {
+	if value, ok, err := t.mybolt.pending(key); ok {
+		for i := 0; err == nil && i < len(value); i++ {
+			err = fn(value[i])
+		}
+		return err
+	}
+	// fn may read through the transaction too, so the encoded key and
+	// the items are taken out of t while it runs
+	k, items := t.k, t.items
+	t.k, t.items = nil, nil
+	k, err = t.mybolt.AppendKey(k[:0], key)
+	if err == nil {
+		err = t.mybolt.eachItemTx(t.tx, k, &items, fn)
+	}
+	t.k, t.items = k, items
+	return err
+}
+
+// Close ends the transaction, releasing the pages it kept from reuse.
+func (t *boltTxn) Close() error {
+	return t.tx.Rollback()
+}
+
+// GetTx reads back a value written by Flush, whatever the schema.
+func (mybolt *Bolt) GetTx(tx *bolt.Tx, key string) ([]string, error) {
+	k, err := mybolt.EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return mybolt.GetKey(tx, k)
+}
+
+// GetKey is GetTx for an already encoded key.
+func (mybolt *Bolt) GetKey(tx *bolt.Tx, k []byte) ([]string, error) {
+	return mybolt.GetKeyInto(tx, k, nil)
+}
+
+// GetKeyInto is GetKey decoding into dst[:0], reusing its backing array.
+func (mybolt *Bolt) GetKeyInto(tx *bolt.Tx, k []byte, dst []string) ([]string, error) {
+	if mybolt.schema != SplitSchema {
+		data := tx.Bucket(Bucket).Get(k)
+		if data == nil {
+			return nil, ErrNotFound
+		}
+		if len(data) == 0 {
+			return mybolt.readBlocks(tx, k, dst)
+		}
+		return mybolt.unmarshal(data, dst)
+	}
+	// the node record is small, use it to size the edge slice
+	meta := tx.Bucket(NodesBucket).Get(k)
+	if meta == nil {
+		return nil, ErrNotFound
+	}
+	degree, err := strconv.Atoi(string(meta))
+	if err != nil {
+		return nil, err
+	}
+	value := dst[:0]
+	if cap(value) < degree {
+		value = make([]string, 0, degree)
+	}
+	prefix := edgePrefix(k)
+	c := tx.Bucket(EdgesBucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if mybolt.Interner != nil {
+			value = append(value, mybolt.Interner.Bytes(v))
+		} else {
+			value = append(value, string(v))
+		}
+	}
+	return value, nil
+}
+
+// Iterate flushes pending writes and visits keys in the order of their
+// encoding.
+func (mybolt *Bolt) Iterate(fn func(key string, value []string) error) error {
+	mybolt.Flush()
+	return mybolt.Db.View(func(tx *bolt.Tx) error {
+		return mybolt.Scan(tx, func(k []byte, value []string) error {
+			return fn(mybolt.DecodeKey(k), value)
+		})
+	})
+}
+
+// ScanPrefix calls fn for the first n keys starting with prefix, in key
+// order, or for all of them if n is 0, seeking straight to the first.
+// uint64 keys don't sort as text, so there prefix is a whole key and the
+// n keys from it on are visited.
+func (mybolt *Bolt) ScanPrefix(prefix string, n int, fn func(key string, value []string) error) error {
+	var start []byte
+	if prefix != "" {
+		var err error
+		start, err = mybolt.AppendKey(nil, prefix)
+		if err != nil {
+			return err
+		}
+	}
+	name := Bucket
+	if mybolt.schema == SplitSchema {
+		name = NodesBucket
+	}
+	return mybolt.Db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(name).Cursor()
+		k, _ := c.Seek(start)
+		for seen := 0; k != nil && (n == 0 || seen < n); k, _ = c.Next() {
+			if mybolt.keys != Uint64Keys && !bytes.HasPrefix(k, start) {
+				break
+			}
+			value, err := mybolt.GetKey(tx, k)
+			if err != nil {
+				return fmt.Errorf("key %x: %s", k, err)
+			}
+			if err := fn(mybolt.DecodeKey(k), value); err != nil {
+				return err
+			}
+			seen++
+		}
+		return nil
+	})
+}
+
+// Scan calls fn for every key in key order. k is owned by bolt and only
+// valid until fn returns.
+func (mybolt *Bolt) Scan(tx *bolt.Tx, fn func(k []byte, value []string) error) error {
+	if mybolt.schema != SplitSchema {
+		c := tx.Bucket(Bucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var value []string
+			var err error
+			if len(v) == 0 {
+				value, err = mybolt.readBlocks(tx, k, nil)
+			} else {
+				value, err = mybolt.Codec.Unmarshal(v)
+			}
+			if err != nil {
+				return fmt.Errorf("key %x: %s", k, err)
+			}
+			err = fn(k, value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// nodes and their edges sort in the same order, so walk both
+	// buckets side by side
+	nodes := tx.Bucket(NodesBucket).Cursor()
+	edges := tx.Bucket(EdgesBucket).Cursor()
+	ek, ev := edges.First()
+	for k, meta := nodes.First(); k != nil; k, meta = nodes.Next() {
+		degree, err := strconv.Atoi(string(meta))
+		if err != nil {
+			return fmt.Errorf("key %x: %s", k, err)
+		}
+		value := make([]string, 0, degree)
+		prefix := edgePrefix(k)
+		for ek != nil && bytes.Compare(ek, prefix) < 0 {
+			ek, ev = edges.Next()
+		}
+		for ek != nil && bytes.HasPrefix(ek, prefix) {
+			value = append(value, string(ev))
+			ek, ev = edges.Next()
+		}
+		err = fn(k, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/jogo/goplayground/boltdb/internal/run"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// WAL is a minimal write-ahead log. Each flushed batch is appended as
+// one frame and fsynced before it is committed to bolt, so bolt itself
+// can run with NoSync. A frame is a 4 byte payload length, a 4 byte
+// CRC32 of the payload and the payload. A torn frame at the end of the
+// log is a batch that never made it into bolt and is ignored on replay.
+type WAL struct {
+	f   *os.File
+	out io.Writer
+	buf []byte
+}
+
+func OpenWAL(path string) *WAL {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	return &WAL{f: f, out: f}
+}
+
+// Inject makes the log's appends go through f.Writer, so short writes
+// tear frames the way a crash mid-append would.
+func (w *WAL) Inject(f Faults) {
+	w.out = f.Writer(w.f)
+}
+
+func (w *WAL) append(batch []Entry) error {
+	// payload: count, then per entry the key, the number of value
+	// strings plus one (zero for a delete) and the strings, each length
+	// prefixed with a uvarint
+	w.buf = append(w.buf[:0], make([]byte, 8)...)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(batch)))
+	for _, entry := range batch {
+		w.buf = appendWALString(w.buf, entry.name)
+		if entry.deleted {
+			w.buf = binary.AppendUvarint(w.buf, 0)
+			continue
+		}
+		w.buf = binary.AppendUvarint(w.buf, uint64(len(entry.value))+1)
+		for _, s := range entry.value {
+			w.buf = appendWALString(w.buf, s)
+		}
+	}
+	payload := w.buf[8:]
+	binary.BigEndian.PutUint32(w.buf[0:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(w.buf[4:], crc32.ChecksumIEEE(payload))
+	_, err := w.out.Write(w.buf)
+	if err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+func appendWALString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// Reset empties the log, only call it once bolt has been synced.
+func (w *WAL) Reset() error {
+	err := w.f.Truncate(0)
+	if err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+var errWALCorrupt = errors.New("corrupt wal frame")
+
+// ReplayWAL calls fn with every complete batch in the log at path.
+func ReplayWAL(path string, fn func(batch []Entry)) (batches int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	header := make([]byte, 8)
+	for {
+		_, err = io.ReadFull(r, header)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return batches, nil
+		}
+		if err != nil {
+			return batches, err
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[0:]))
+		_, err = io.ReadFull(r, payload)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return batches, nil
+		}
+		if err != nil {
+			return batches, err
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			// torn write, nothing after this point was committed
+			return batches, nil
+		}
+		batch, err := decodeWALBatch(payload)
+		if err != nil {
+			return batches, err
+		}
+		fn(batch)
+		batches++
+	}
+}
+
+// decodeWALBatch returns the entries of a frame, only name, value and
+// deleted are set.
+func decodeWALBatch(payload []byte) ([]Entry, error) {
+	count, payload, err := readWALUvarint(payload)
+	if err != nil {
+		return nil, err
+	}
+	// every entry takes at least two bytes, don't trust count further
+	if count > uint64(len(payload)/2) {
+		return nil, errWALCorrupt
+	}
+	batch := make([]Entry, 0, count)
+	for ; count > 0; count-- {
+		var entry Entry
+		entry.name, payload, err = readWALString(payload)
+		if err != nil {
+			return nil, err
+		}
+		var n uint64
+		n, payload, err = readWALUvarint(payload)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			entry.deleted = true
+			batch = append(batch, entry)
+			continue
+		}
+		if n-1 > uint64(len(payload)) {
+			return nil, errWALCorrupt
+		}
+		entry.value = make([]string, n-1)
+		for i := range entry.value {
+			entry.value[i], payload, err = readWALString(payload)
+			if err != nil {
+				return nil, err
+			}
+		}
+		batch = append(batch, entry)
+	}
+	return batch, nil
+}
+
+func readWALUvarint(buf []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return 0, nil, errWALCorrupt
+	}
+	return v, buf[n:], nil
+}
+
+func readWALString(buf []byte) (string, []byte, error) {
+	n, buf, err := readWALUvarint(buf)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(buf)) < n {
+		return "", nil, errWALCorrupt
+	}
+	return string(buf[:n]), buf[n:], nil
+}
+
+// Recover replays the write-ahead log at walPath into mybolt, checks
+// every replayed key reads back as logged, checkpoints the log and
+// closes mybolt.
+func Recover(mybolt *Bolt, walPath string) {
+	keys := 0
+	// the last logged write of each key, to check against
+	final := make(map[string]Entry)
+	batches, err := ReplayWAL(walPath, func(batch []Entry) {
+		for _, entry := range batch {
+			if entry.deleted {
+				mybolt.Delete(entry.name)
+			} else {
+				mybolt.Writer(entry.name, entry.value)
+			}
+			final[entry.name] = entry
+		}
+		keys += len(batch)
+		mybolt.Flush()
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	slog.Info("replayed wal", "batches", batches, "keys", keys, "wal", walPath)
+
+	err = mybolt.Db.View(func(tx *bolt.Tx) error {
+		for key, entry := range final {
+			stored, err := mybolt.GetTx(tx, key)
+			if entry.deleted && err == ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("key %s: %s", key, err)
+			}
+			if entry.deleted || !SameValue(stored, entry.value) {
+				return fmt.Errorf("key %s: recovered %q, logged %q (deleted %t)", key, stored, entry.value, entry.deleted)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		run.Fatal(err.Error())
+	}
+	slog.Info("recovered keys verified")
+
+	mybolt.WAL = OpenWAL(walPath)
+	mybolt.Close()
+}